@@ -0,0 +1,214 @@
+package zap_logger
+
+import (
+	"crypto/ed25519"
+	"io"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/hinha/zap-logger/pkg/audit"
+)
+
+// auditCheckpointMessage is the message of checkpoint records.
+const auditCheckpointMessage = "audit checkpoint"
+
+// AuditConfig configures the tamper-evident audit log. Records are JSON,
+// chained by the seq, prev_hash and hash keys described in package audit,
+// and written synchronously, so none are dropped; use cmd/zapaudit to
+// verify the files.
+type AuditConfig struct {
+	// Filename is the audit log. It's rotated like Config.Filename by the
+	// MaxSize, MaxBackups, MaxAge, LocalTime and Compress fields, and the
+	// chain continues across rotated files and restarts.
+	Filename   string
+	MaxSize    int
+	MaxBackups int
+	MaxAge     int
+	LocalTime  bool
+	Compress   bool
+
+	// HMACKey or Ed25519Key, if set, sign checkpoint records, written every
+	// CheckpointInterval and every CheckpointEvery records when records were
+	// written since the last one, and on Close. KeyID names the key in
+	// checkpoints.
+	HMACKey            []byte
+	Ed25519Key         ed25519.PrivateKey
+	KeyID              string
+	CheckpointInterval time.Duration
+	CheckpointEvery    int
+}
+
+// auditWriter chains the records written by a JSON encoder and writes them
+// to a rotated file.
+type auditWriter struct {
+	out    *lumberjack.Logger
+	enc    zapcore.Encoder
+	signer *audit.Signer
+	every  int
+
+	mu     sync.Mutex
+	chain  audit.Chain
+	since  int
+	buf    []byte
+	closed bool
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// writer returns the audit writer, continuing the chain of the existing
+// files. enc encodes checkpoint records.
+func (c *AuditConfig) writer(enc zapcore.Encoder) (*auditWriter, error) {
+	chain, err := audit.Last(c.Filename)
+	if err != nil {
+		return nil, err
+	}
+	w := &auditWriter{
+		out: &lumberjack.Logger{
+			Filename:   c.Filename,
+			MaxSize:    c.MaxSize,
+			MaxBackups: c.MaxBackups,
+			MaxAge:     c.MaxAge,
+			LocalTime:  c.LocalTime,
+			Compress:   c.Compress,
+		},
+		enc:     enc,
+		every:   c.CheckpointEvery,
+		chain:   chain,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	switch {
+	case c.Ed25519Key != nil:
+		w.signer = audit.NewEd25519Signer(c.Ed25519Key, c.KeyID)
+	case c.HMACKey != nil:
+		w.signer = audit.NewHMACSigner(c.HMACKey, c.KeyID)
+	}
+	if w.signer != nil && c.CheckpointInterval > 0 {
+		go w.run(c.CheckpointInterval)
+	} else {
+		close(w.stopped)
+	}
+	return w, nil
+}
+
+// Write chains and writes a record.
+func (w *auditWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if err := w.write(p); err != nil {
+		return 0, err
+	}
+	w.since++
+	if w.every > 0 && w.since >= w.every {
+		if err := w.checkpoint(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// write chains p and writes it. w.mu must be held.
+func (w *auditWriter) write(p []byte) error {
+	next := w.chain
+	line, err := next.Append(w.buf[:0], p)
+	if err != nil {
+		return err
+	}
+	w.buf = line
+	if _, err := w.out.Write(line); err != nil {
+		return err
+	}
+	w.chain = next
+	return nil
+}
+
+// checkpoint writes a signed checkpoint of the last record if records were
+// written since the previous one. w.mu must be held.
+func (w *auditWriter) checkpoint() error {
+	if w.signer == nil || w.since == 0 {
+		return nil
+	}
+	buf, err := w.enc.EncodeEntry(zapcore.Entry{
+		Level:   zapcore.InfoLevel,
+		Time:    time.Now(),
+		Message: auditCheckpointMessage,
+	}, []zapcore.Field{zap.Any(audit.CheckpointKey, w.signer.Checkpoint(w.chain))})
+	if err != nil {
+		return err
+	}
+	defer buf.Free()
+	if err := w.write(buf.Bytes()); err != nil {
+		return err
+	}
+	w.since = 0
+	return nil
+}
+
+func (w *auditWriter) run(interval time.Duration) {
+	defer close(w.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			_ = w.checkpoint()
+			w.mu.Unlock()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Sync implements zapcore.WriteSyncer. Records are written as they come;
+// Sync commits the active file to disk.
+func (w *auditWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	// Holding w.mu keeps lumberjack from rotating the file meanwhile.
+	return syncFile(w.out.Filename)
+}
+
+// Close writes a final checkpoint and closes the file.
+func (w *auditWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.stopped
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.checkpoint()
+	if cerr := w.out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// core returns a core writing chained JSON records to the audit log, and
+// the writer to close when the logger is closed. The records bypass the
+// diode, so log calls wait for the write.
+func (c *AuditConfig) core(encCfg zapcore.EncoderConfig, lvl zap.AtomicLevel, o *sinkObserver) (zapcore.Core, io.Closer, error) {
+	enc := zapcore.NewJSONEncoder(encCfg)
+	w, err := c.writer(enc.Clone())
+	if err != nil {
+		return nil, nil, err
+	}
+	return zapcore.NewCore(enc, zapcore.AddSync(o.writer("audit", w)), lvl), w, nil
+}
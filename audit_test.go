@@ -0,0 +1,121 @@
+package zap_logger
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hinha/zap-logger/pkg/audit"
+)
+
+func TestAuditLog(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	cfg := &AuditConfig{Filename: filename, HMACKey: []byte("secret"), KeyID: "k1", CheckpointEvery: 2}
+
+	core, closer, err := cfg.core(NewProductionEncoderConfig(), zap.NewAtomicLevel(), nil)
+	require.NoError(t, err, "Unexpected error creating the audit core.")
+	logger := New(core, Config{}, addCloser(closer))
+	logger.Info("login", zap.String("user", "ann"))
+	logger.Info("grant", zap.String("role", "admin"))
+	require.NoError(t, closer.(*auditWriter).out.Rotate(), "Unexpected error rotating the audit log.")
+	logger.Warn("logout")
+	require.NoError(t, logger.Close(), "Unexpected error closing the logger.")
+
+	// The chain continues after a restart.
+	core, closer, err = cfg.core(NewProductionEncoderConfig(), zap.NewAtomicLevel(), nil)
+	require.NoError(t, err)
+	logger = New(core, Config{}, addCloser(closer))
+	logger.Info("login", zap.String("user", "bob"))
+	require.NoError(t, logger.Close())
+
+	files, err := audit.Files(filename)
+	require.NoError(t, err)
+	assert.Len(t, files, 2, "Expected a rotated file and the current one.")
+
+	v := audit.Verifier{HMACKey: []byte("secret")}
+	require.NoError(t, audit.VerifyFiles(filename, &v), "Expected the audit log to verify.")
+	assert.Equal(t, 7, v.Records, "Expected four records and three checkpoints.")
+	assert.Equal(t, 3, v.Checkpoints, "Expected checkpoints every two records and on Close.")
+	assert.Equal(t, uint64(1), v.First)
+
+	// Editing a record breaks the chain.
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(files[0], []byte(strings.Replace(string(data), `"ann"`, `"eve"`, 1)), 0o600))
+	v = audit.Verifier{}
+	err = audit.VerifyFiles(filename, &v)
+	require.Error(t, err, "Expected the edited record to be detected.")
+	assert.Contains(t, err.Error(), files[0]+":1: audit: record 1 doesn't match its hash")
+}
+
+func TestAuditSync(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	cfg := &AuditConfig{Filename: filename}
+	core, closer, err := cfg.core(NewProductionEncoderConfig(), zap.NewAtomicLevel(), nil)
+	require.NoError(t, err)
+	w := closer.(*auditWriter)
+	logger := New(core, Config{}, addCloser(closer))
+
+	require.NoError(t, w.Sync(), "Expected syncing before the file exists to succeed.")
+	logger.Info("login")
+	require.NoError(t, logger.Sync(), "Unexpected error syncing the audit file.")
+	b, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"msg":"login"`)
+
+	require.NoError(t, logger.Close())
+	assert.NoError(t, w.Sync(), "Expected Sync after Close to do nothing.")
+}
+
+func TestAuditCheckpointRecord(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	cfg := &AuditConfig{Filename: filename, HMACKey: []byte("secret")}
+	core, closer, err := cfg.core(NewProductionEncoderConfig(), zap.NewAtomicLevel(), nil)
+	require.NoError(t, err)
+	logger := New(core, Config{}, addCloser(closer))
+	logger.Info("one")
+	require.NoError(t, logger.Close())
+	_, err = closer.(*auditWriter).Write([]byte(`{"msg":"late"}`))
+	assert.Error(t, err, "Expected writes to fail after Close.")
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.Len(t, lines, 2, "Expected a checkpoint on Close.")
+	assert.Contains(t, lines[1], `"msg":"audit checkpoint","checkpoint":{"seq":1,`)
+}
+
+func TestAuditNotSampled(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	cfg := Config{
+		Audit:    &AuditConfig{Filename: filename},
+		Sampling: &zap.SamplingConfig{Initial: 1, Thereafter: 0},
+	}
+	auditCore, closer, err := cfg.Audit.core(NewProductionEncoderConfig(), zap.NewAtomicLevel(), nil)
+	require.NoError(t, err)
+	sink, logs := observer.New(zapcore.InfoLevel)
+	logger := New(cfg.tee([]zapcore.Core{sink}, auditCore), Config{}, addCloser(closer))
+	for i := 0; i < 3; i++ {
+		logger.Info("login")
+	}
+	require.NoError(t, logger.Close())
+
+	assert.Equal(t, 1, logs.Len(), "Expected the other sinks to be sampled.")
+	v := audit.Verifier{}
+	require.NoError(t, audit.VerifyFiles(filename, &v))
+	assert.Equal(t, 3, v.Records, "Expected every record in the audit log.")
+}
@@ -0,0 +1,460 @@
+package zap_logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	zapbuffer "go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/buffer"
+)
+
+var _binaryPool = zapbuffer.NewPool()
+
+// binaryFormat writes the values of a binary encoding like CBOR or
+// MessagePack.
+type binaryFormat interface {
+	// openMap and openArray start a container at the end of buf. closeMap and
+	// closeArray end it, given the offset it was started at and the number of
+	// pairs or elements written.
+	openMap(buf *buffer.Buffer)
+	closeMap(buf *buffer.Buffer, start, n int)
+	openArray(buf *buffer.Buffer)
+	closeArray(buf *buffer.Buffer, start, n int)
+
+	appendString(buf *buffer.Buffer, s string)
+	appendBytes(buf *buffer.Buffer, b []byte)
+	appendInt(buf *buffer.Buffer, i int64)
+	appendUint(buf *buffer.Buffer, u uint64)
+	appendFloat64(buf *buffer.Buffer, f float64)
+	appendFloat32(buf *buffer.Buffer, f float32)
+	appendBool(buf *buffer.Buffer, b bool)
+	appendNil(buf *buffer.Buffer)
+	appendTime(buf *buffer.Buffer, t time.Time)
+}
+
+// binaryContainer is a map or array being written.
+type binaryContainer struct {
+	start, n int
+	array    bool
+}
+
+// binaryEncoder encodes entries as a map in a binaryFormat. Each entry is
+// preceded by its length as a 4-byte big-endian integer, so files can be
+// read record by record.
+type binaryEncoder struct {
+	*zapcore.EncoderConfig
+	f   binaryFormat
+	buf *buffer.Buffer
+	// open holds the containers being written. open[0] is the entry itself,
+	// whose header is only written by EncodeEntry.
+	open []binaryContainer
+}
+
+func newBinaryEncoder(cfg zapcore.EncoderConfig, f binaryFormat) *binaryEncoder {
+	return &binaryEncoder{
+		EncoderConfig: &cfg,
+		f:             f,
+		buf:           buffer.Get(),
+		open:          make([]binaryContainer, 1),
+	}
+}
+
+// Clone implements zapcore.Encoder.
+func (enc *binaryEncoder) Clone() zapcore.Encoder {
+	clone := &binaryEncoder{
+		EncoderConfig: enc.EncoderConfig,
+		f:             enc.f,
+		buf:           buffer.Get(),
+		open:          append([]binaryContainer(nil), enc.open...),
+	}
+	clone.buf.Write(enc.buf.Bytes())
+	return clone
+}
+
+// EncodeEntry implements zapcore.Encoder.
+func (enc *binaryEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*zapbuffer.Buffer, error) {
+	final := &binaryEncoder{
+		EncoderConfig: enc.EncoderConfig,
+		f:             enc.f,
+		buf:           buffer.Get(),
+		open:          make([]binaryContainer, 1, len(enc.open)+1),
+	}
+	defer final.buf.Free()
+
+	// Length prefix, filled in once the record is complete.
+	final.buf.AppendString("\x00\x00\x00\x00")
+	final.open[0].start = final.buf.Len()
+	final.f.openMap(final.buf)
+
+	if final.TimeKey != "" {
+		final.pair(final.TimeKey)
+		final.appendTime(ent.Time)
+	}
+	if final.LevelKey != "" {
+		final.pair(final.LevelKey)
+		final.encodeValue(func(v zapcore.PrimitiveArrayEncoder) {
+			if final.EncodeLevel != nil {
+				final.EncodeLevel(ent.Level, v)
+			}
+		}, func() { final.f.appendString(final.buf, ent.Level.String()) })
+	}
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.pair(final.NameKey)
+		final.encodeValue(func(v zapcore.PrimitiveArrayEncoder) {
+			if final.EncodeName != nil {
+				final.EncodeName(ent.LoggerName, v)
+			}
+		}, func() { final.f.appendString(final.buf, ent.LoggerName) })
+	}
+	if ent.Caller.Defined {
+		if final.CallerKey != "" {
+			final.pair(final.CallerKey)
+			final.encodeValue(func(v zapcore.PrimitiveArrayEncoder) {
+				if final.EncodeCaller != nil {
+					final.EncodeCaller(ent.Caller, v)
+				}
+			}, func() { final.f.appendString(final.buf, ent.Caller.String()) })
+		}
+		if final.FunctionKey != "" {
+			final.AddString(final.FunctionKey, ent.Caller.Function)
+		}
+	}
+	if final.MessageKey != "" {
+		final.AddString(final.MessageKey, ent.Message)
+	}
+
+	offset := final.buf.Len()
+	final.buf.Write(enc.buf.Bytes())
+	final.open[0].n += enc.open[0].n
+	for _, c := range enc.open[1:] {
+		c.start += offset
+		final.open = append(final.open, c)
+	}
+	for i := range fields {
+		fields[i].AddTo(final)
+	}
+	for len(final.open) > 1 {
+		final.closeContainer()
+	}
+
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		final.AddString(final.StacktraceKey, ent.Stack)
+	}
+	final.f.closeMap(final.buf, final.open[0].start, final.open[0].n)
+
+	b := final.buf.Bytes()
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	out := _binaryPool.Get()
+	out.Write(b)
+	return out, nil
+}
+
+// pair counts a pair of the innermost container and writes its key.
+func (enc *binaryEncoder) pair(key string) {
+	enc.open[len(enc.open)-1].n++
+	enc.f.appendString(enc.buf, key)
+}
+
+// elem counts an element of the innermost container.
+func (enc *binaryEncoder) elem() {
+	enc.open[len(enc.open)-1].n++
+}
+
+func (enc *binaryEncoder) openContainer(array bool) {
+	enc.open = append(enc.open, binaryContainer{start: enc.buf.Len(), array: array})
+	if array {
+		enc.f.openArray(enc.buf)
+	} else {
+		enc.f.openMap(enc.buf)
+	}
+}
+
+func (enc *binaryEncoder) closeContainer() {
+	c := enc.open[len(enc.open)-1]
+	enc.open = enc.open[:len(enc.open)-1]
+	if c.array {
+		enc.f.closeArray(enc.buf, c.start, c.n)
+	} else {
+		enc.f.closeMap(enc.buf, c.start, c.n)
+	}
+}
+
+// encodeValue appends the value written by encode, which wraps one of the
+// EncoderConfig's encoders, or calls fallback if it wrote none.
+func (enc *binaryEncoder) encodeValue(encode func(zapcore.PrimitiveArrayEncoder), fallback func()) {
+	v := &binaryValue{enc: enc}
+	encode(v)
+	if v.n == 0 {
+		fallback()
+	}
+}
+
+func (enc *binaryEncoder) appendTime(t time.Time) {
+	enc.encodeValue(func(v zapcore.PrimitiveArrayEncoder) {
+		if enc.EncodeTime != nil {
+			enc.EncodeTime(t, v)
+		}
+	}, func() { enc.f.appendTime(enc.buf, t) })
+}
+
+func (enc *binaryEncoder) appendDuration(d time.Duration) {
+	enc.encodeValue(func(v zapcore.PrimitiveArrayEncoder) {
+		if enc.EncodeDuration != nil {
+			enc.EncodeDuration(d, v)
+		}
+	}, func() { enc.f.appendInt(enc.buf, int64(d)) })
+}
+
+// appendComplex writes complex numbers as strings like the JSON encoder.
+func (enc *binaryEncoder) appendComplex(c complex128, bitSize int) {
+	s := strconv.FormatComplex(c, 'g', -1, bitSize)
+	enc.f.appendString(enc.buf, strings.TrimSuffix(strings.TrimPrefix(s, "("), ")"))
+}
+
+// appendJSON writes a value decoded from JSON, used for reflected values.
+func (enc *binaryEncoder) appendJSON(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		enc.f.appendNil(enc.buf)
+	case bool:
+		enc.f.appendBool(enc.buf, v)
+	case string:
+		enc.f.appendString(enc.buf, v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			enc.f.appendInt(enc.buf, i)
+			return
+		}
+		f, _ := v.Float64()
+		enc.f.appendFloat64(enc.buf, f)
+	case []interface{}:
+		enc.openContainer(true)
+		for _, e := range v {
+			enc.elem()
+			enc.appendJSON(e)
+		}
+		enc.closeContainer()
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		enc.openContainer(false)
+		for _, k := range keys {
+			enc.pair(k)
+			enc.appendJSON(v[k])
+		}
+		enc.closeContainer()
+	}
+}
+
+func reflectedJSON(obj interface{}) (interface{}, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	err = dec.Decode(&v)
+	return v, err
+}
+
+func (enc *binaryEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	enc.pair(key)
+	enc.openContainer(true)
+	defer enc.closeContainer()
+	return arr.MarshalLogArray(binaryArrayEncoder{enc})
+}
+
+func (enc *binaryEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	enc.pair(key)
+	enc.openContainer(false)
+	defer enc.closeContainer()
+	return obj.MarshalLogObject(enc)
+}
+
+func (enc *binaryEncoder) AddReflected(key string, obj interface{}) error {
+	v, err := reflectedJSON(obj)
+	if err != nil {
+		return err
+	}
+	enc.pair(key)
+	enc.appendJSON(v)
+	return nil
+}
+
+func (enc *binaryEncoder) OpenNamespace(key string) {
+	enc.pair(key)
+	enc.openContainer(false)
+}
+
+func (enc *binaryEncoder) AddBinary(key string, val []byte) {
+	enc.pair(key)
+	enc.f.appendBytes(enc.buf, val)
+}
+
+func (enc *binaryEncoder) AddByteString(key string, val []byte) {
+	enc.pair(key)
+	enc.f.appendString(enc.buf, string(val))
+}
+
+func (enc *binaryEncoder) AddBool(key string, val bool) {
+	enc.pair(key)
+	enc.f.appendBool(enc.buf, val)
+}
+
+func (enc *binaryEncoder) AddComplex128(key string, val complex128) {
+	enc.pair(key)
+	enc.appendComplex(val, 128)
+}
+
+func (enc *binaryEncoder) AddComplex64(key string, val complex64) {
+	enc.pair(key)
+	enc.appendComplex(complex128(val), 64)
+}
+
+func (enc *binaryEncoder) AddDuration(key string, val time.Duration) {
+	enc.pair(key)
+	enc.appendDuration(val)
+}
+
+func (enc *binaryEncoder) AddFloat64(key string, val float64) {
+	enc.pair(key)
+	enc.f.appendFloat64(enc.buf, val)
+}
+
+func (enc *binaryEncoder) AddFloat32(key string, val float32) {
+	enc.pair(key)
+	enc.f.appendFloat32(enc.buf, val)
+}
+
+func (enc *binaryEncoder) AddInt(key string, val int)     { enc.AddInt64(key, int64(val)) }
+func (enc *binaryEncoder) AddInt32(key string, val int32) { enc.AddInt64(key, int64(val)) }
+func (enc *binaryEncoder) AddInt16(key string, val int16) { enc.AddInt64(key, int64(val)) }
+func (enc *binaryEncoder) AddInt8(key string, val int8)   { enc.AddInt64(key, int64(val)) }
+
+func (enc *binaryEncoder) AddInt64(key string, val int64) {
+	enc.pair(key)
+	enc.f.appendInt(enc.buf, val)
+}
+
+func (enc *binaryEncoder) AddString(key, val string) {
+	enc.pair(key)
+	enc.f.appendString(enc.buf, val)
+}
+
+func (enc *binaryEncoder) AddTime(key string, val time.Time) {
+	enc.pair(key)
+	enc.appendTime(val)
+}
+
+func (enc *binaryEncoder) AddUint(key string, val uint)       { enc.AddUint64(key, uint64(val)) }
+func (enc *binaryEncoder) AddUint32(key string, val uint32)   { enc.AddUint64(key, uint64(val)) }
+func (enc *binaryEncoder) AddUint16(key string, val uint16)   { enc.AddUint64(key, uint64(val)) }
+func (enc *binaryEncoder) AddUint8(key string, val uint8)     { enc.AddUint64(key, uint64(val)) }
+func (enc *binaryEncoder) AddUintptr(key string, val uintptr) { enc.AddUint64(key, uint64(val)) }
+
+func (enc *binaryEncoder) AddUint64(key string, val uint64) {
+	enc.pair(key)
+	enc.f.appendUint(enc.buf, val)
+}
+
+// binaryArrayEncoder writes the elements of an array container.
+type binaryArrayEncoder struct {
+	enc *binaryEncoder
+}
+
+func (a binaryArrayEncoder) AppendArray(arr zapcore.ArrayMarshaler) error {
+	a.enc.elem()
+	a.enc.openContainer(true)
+	defer a.enc.closeContainer()
+	return arr.MarshalLogArray(a)
+}
+
+func (a binaryArrayEncoder) AppendObject(obj zapcore.ObjectMarshaler) error {
+	a.enc.elem()
+	a.enc.openContainer(false)
+	defer a.enc.closeContainer()
+	return obj.MarshalLogObject(a.enc)
+}
+
+func (a binaryArrayEncoder) AppendReflected(val interface{}) error {
+	v, err := reflectedJSON(val)
+	if err != nil {
+		return err
+	}
+	a.enc.elem()
+	a.enc.appendJSON(v)
+	return nil
+}
+
+func (a binaryArrayEncoder) AppendBool(v bool) { a.enc.elem(); a.enc.f.appendBool(a.enc.buf, v) }
+func (a binaryArrayEncoder) AppendByteString(v []byte) {
+	a.enc.elem()
+	a.enc.f.appendString(a.enc.buf, string(v))
+}
+func (a binaryArrayEncoder) AppendComplex128(v complex128) { a.enc.elem(); a.enc.appendComplex(v, 128) }
+func (a binaryArrayEncoder) AppendComplex64(v complex64) {
+	a.enc.elem()
+	a.enc.appendComplex(complex128(v), 64)
+}
+func (a binaryArrayEncoder) AppendFloat64(v float64) {
+	a.enc.elem()
+	a.enc.f.appendFloat64(a.enc.buf, v)
+}
+func (a binaryArrayEncoder) AppendFloat32(v float32) {
+	a.enc.elem()
+	a.enc.f.appendFloat32(a.enc.buf, v)
+}
+func (a binaryArrayEncoder) AppendInt(v int)                { a.AppendInt64(int64(v)) }
+func (a binaryArrayEncoder) AppendInt64(v int64)            { a.enc.elem(); a.enc.f.appendInt(a.enc.buf, v) }
+func (a binaryArrayEncoder) AppendInt32(v int32)            { a.AppendInt64(int64(v)) }
+func (a binaryArrayEncoder) AppendInt16(v int16)            { a.AppendInt64(int64(v)) }
+func (a binaryArrayEncoder) AppendInt8(v int8)              { a.AppendInt64(int64(v)) }
+func (a binaryArrayEncoder) AppendString(v string)          { a.enc.elem(); a.enc.f.appendString(a.enc.buf, v) }
+func (a binaryArrayEncoder) AppendUint(v uint)              { a.AppendUint64(uint64(v)) }
+func (a binaryArrayEncoder) AppendUint64(v uint64)          { a.enc.elem(); a.enc.f.appendUint(a.enc.buf, v) }
+func (a binaryArrayEncoder) AppendUint32(v uint32)          { a.AppendUint64(uint64(v)) }
+func (a binaryArrayEncoder) AppendUint16(v uint16)          { a.AppendUint64(uint64(v)) }
+func (a binaryArrayEncoder) AppendUint8(v uint8)            { a.AppendUint64(uint64(v)) }
+func (a binaryArrayEncoder) AppendUintptr(v uintptr)        { a.AppendUint64(uint64(v)) }
+func (a binaryArrayEncoder) AppendDuration(v time.Duration) { a.enc.elem(); a.enc.appendDuration(v) }
+func (a binaryArrayEncoder) AppendTime(v time.Time)         { a.enc.elem(); a.enc.appendTime(v) }
+
+// binaryValue writes the value of a pair whose key was already written. It's
+// handed to the EncoderConfig's time, level, name, caller and duration
+// encoders and counts the values they append.
+type binaryValue struct {
+	enc *binaryEncoder
+	n   int
+}
+
+func (v *binaryValue) AppendBool(b bool)              { v.n++; v.enc.f.appendBool(v.enc.buf, b) }
+func (v *binaryValue) AppendByteString(b []byte)      { v.n++; v.enc.f.appendString(v.enc.buf, string(b)) }
+func (v *binaryValue) AppendComplex128(c complex128)  { v.n++; v.enc.appendComplex(c, 128) }
+func (v *binaryValue) AppendComplex64(c complex64)    { v.n++; v.enc.appendComplex(complex128(c), 64) }
+func (v *binaryValue) AppendFloat64(f float64)        { v.n++; v.enc.f.appendFloat64(v.enc.buf, f) }
+func (v *binaryValue) AppendFloat32(f float32)        { v.n++; v.enc.f.appendFloat32(v.enc.buf, f) }
+func (v *binaryValue) AppendInt(i int)                { v.AppendInt64(int64(i)) }
+func (v *binaryValue) AppendInt64(i int64)            { v.n++; v.enc.f.appendInt(v.enc.buf, i) }
+func (v *binaryValue) AppendInt32(i int32)            { v.AppendInt64(int64(i)) }
+func (v *binaryValue) AppendInt16(i int16)            { v.AppendInt64(int64(i)) }
+func (v *binaryValue) AppendInt8(i int8)              { v.AppendInt64(int64(i)) }
+func (v *binaryValue) AppendString(s string)          { v.n++; v.enc.f.appendString(v.enc.buf, s) }
+func (v *binaryValue) AppendUint(i uint)              { v.AppendUint64(uint64(i)) }
+func (v *binaryValue) AppendUint64(i uint64)          { v.n++; v.enc.f.appendUint(v.enc.buf, i) }
+func (v *binaryValue) AppendUint32(i uint32)          { v.AppendUint64(uint64(i)) }
+func (v *binaryValue) AppendUint16(i uint16)          { v.AppendUint64(uint64(i)) }
+func (v *binaryValue) AppendUint8(i uint8)            { v.AppendUint64(uint64(i)) }
+func (v *binaryValue) AppendUintptr(i uintptr)        { v.AppendUint64(uint64(i)) }
+func (v *binaryValue) AppendDuration(d time.Duration) { v.n++; v.enc.f.appendInt(v.enc.buf, int64(d)) }
+func (v *binaryValue) AppendTime(t time.Time)         { v.n++; v.enc.f.appendTime(v.enc.buf, t) }
@@ -0,0 +1,131 @@
+package zap_logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hinha/zap-logger/pkg/logdecode"
+)
+
+type binaryTestObject struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+func (o binaryTestObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("name", o.Name)
+	return enc.AddArray("tags", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+		for _, t := range o.Tags {
+			arr.AppendString(t)
+		}
+		return nil
+	}))
+}
+
+// encodeWith encodes an entry with enc after adding the context fields via
+// With, as a core would.
+func encodeWith(t *testing.T, enc zapcore.Encoder, context, fields []zap.Field) []byte {
+	enc = enc.Clone()
+	for _, f := range context {
+		f.AddTo(enc)
+	}
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       time.Unix(1700000000, 123456789),
+		LoggerName: "svc",
+		Message:    "hello",
+		Caller:     zapcore.NewEntryCaller(0, "/src/app/main.go", 42, true),
+		Stack:      "main.main\n\t/src/app/main.go:42",
+	}
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err)
+	defer buf.Free()
+	return append([]byte(nil), buf.Bytes()...)
+}
+
+func TestBinaryEncodersMatchJSON(t *testing.T) {
+	context := []zap.Field{zap.String("service", "api"), zap.Namespace("req")}
+	fields := []zap.Field{
+		zap.Bool("ok", true),
+		zap.Int("n", -300),
+		zap.Int64("big", math.MinInt64),
+		zap.Uint64("ubig", math.MaxUint64),
+		zap.Float64("f", 1.25),
+		zap.Float32("f32", 0.5),
+		zap.Float64("nan", math.NaN()),
+		zap.Complex128("c", complex(1, 2)),
+		zap.Duration("d", time.Second),
+		zap.Time("at", time.Unix(1, 0)),
+		zap.String("s", "quote \" and\nnewline ✓"),
+		zap.Binary("bin", []byte{0, 1, 2}),
+		zap.ByteString("bs", []byte("text")),
+		zap.Error(errors.New("boom")),
+		zap.Object("obj", binaryTestObject{Name: "x", Tags: []string{"a", "b"}}),
+		zap.Objects("objs", []binaryTestObject{{Name: "y"}}),
+		zap.Reflect("refl", map[string]interface{}{"b": []int{1, 2}, "a": nil}),
+		zap.Ints("ints", []int{1, -1, 1 << 40}),
+		zap.Namespace("inner"),
+		zap.String("deep", "v"),
+	}
+
+	cfg := NewProductionEncoderConfig()
+	want := encodeWith(t, zapcore.NewJSONEncoder(cfg), context, fields)
+
+	for name, enc := range map[string]zapcore.Encoder{
+		"cbor":    NewCBOREncoder(cfg),
+		"msgpack": NewMsgpackEncoder(cfg),
+	} {
+		t.Run(name, func(t *testing.T) {
+			record := encodeWith(t, enc, context, fields)
+			require.True(t, len(record) > 4)
+			assert.Equal(t, uint32(len(record)-4), binary.BigEndian.Uint32(record), "Unexpected length prefix.")
+
+			var got bytes.Buffer
+			require.NoError(t, logdecode.Convert(&got, bytes.NewReader(record), logdecode.Auto))
+			assert.JSONEq(t, string(want), got.String())
+		})
+	}
+}
+
+func TestBinaryEncoderNativeTime(t *testing.T) {
+	cfg := NewProductionEncoderConfig()
+	cfg.EncodeTime = nil
+
+	for name, enc := range map[string]zapcore.Encoder{
+		"cbor":    NewCBOREncoder(cfg),
+		"msgpack": NewMsgpackEncoder(cfg),
+	} {
+		t.Run(name, func(t *testing.T) {
+			record := encodeWith(t, enc, nil, []zap.Field{zap.Time("at", time.Unix(1, 5).UTC())})
+
+			var got bytes.Buffer
+			require.NoError(t, logdecode.Convert(&got, bytes.NewReader(record), logdecode.Auto))
+			assert.Contains(t, got.String(), `"ts":"2023-11-14T22:13:20.123456789Z"`)
+			assert.Contains(t, got.String(), `"at":"1970-01-01T00:00:01.000000005Z"`)
+		})
+	}
+}
+
+func TestLogdecodeErrors(t *testing.T) {
+	record := encodeWith(t, NewMsgpackEncoder(NewProductionEncoderConfig()), nil, nil)
+
+	var out bytes.Buffer
+	err := logdecode.Convert(&out, bytes.NewReader(record[:len(record)-3]), logdecode.Auto)
+	assert.Error(t, err, "Expected an error for a truncated file.")
+
+	_, err = logdecode.Decode(nil, record[4:len(record)-1], logdecode.Msgpack)
+	assert.Equal(t, logdecode.ErrUnexpectedEnd, err)
+
+	_, err = logdecode.Decode(nil, []byte{0x01}, logdecode.Auto)
+	assert.Error(t, err, "Expected an error for an unknown record type.")
+}
@@ -4,4 +4,9 @@ var (
 	_pool = NewPool()
 	// Get retrieves a buffer from the pool, creating one if necessary.
 	Get = _pool.Get
+	// EnableStats starts counting the use of the default pool.
+	EnableStats = _pool.EnableStats
+	// Stats reports the use of the default pool since EnableStats was
+	// called, and whether it was.
+	Stats = _pool.Stats
 )
@@ -20,24 +20,55 @@
 
 package buffer
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 // A Pool is a type-safe wrapper around a sync.Pool.
 type Pool struct {
-	p *sync.Pool
+	p     *sync.Pool
+	stats *poolStats
+}
+
+// PoolStats counts the use of a Pool.
+type PoolStats struct {
+	// Gets and Puts count the buffers taken from and returned to the pool.
+	Gets uint64 `json:"gets"`
+	Puts uint64 `json:"puts"`
+	// News counts the buffers allocated because the pool was empty.
+	News uint64 `json:"news"`
+}
+
+// poolStats holds the counters of a Pool, which are only updated once
+// enabled so the shared counters don't slow down Get and Put.
+type poolStats struct {
+	enabled uint32
+	PoolStats
 }
 
 // NewPool constructs a new Pool.
 func NewPool() Pool {
+	stats := &poolStats{}
 	return Pool{p: &sync.Pool{
 		New: func() interface{} {
+			if stats.on() {
+				atomic.AddUint64(&stats.News, 1)
+			}
 			return &Buffer{bs: make([]byte, 0, _size)}
 		},
-	}}
+	}, stats: stats}
+}
+
+func (s *poolStats) on() bool {
+	return atomic.LoadUint32(&s.enabled) != 0
 }
 
 // Get retrieves a Buffer from the pool, creating one if necessary.
 func (p Pool) Get() *Buffer {
+	if p.stats.on() {
+		atomic.AddUint64(&p.stats.Gets, 1)
+	}
 	buf := p.p.Get().(*Buffer)
 	buf.Reset()
 	buf.pool = p
@@ -45,5 +76,25 @@ func (p Pool) Get() *Buffer {
 }
 
 func (p Pool) put(buf *Buffer) {
+	if p.stats.on() {
+		atomic.AddUint64(&p.stats.Puts, 1)
+	}
 	p.p.Put(buf)
 }
+
+// EnableStats starts counting the use of the pool. The counters are off by
+// default because updating them on every Get and Put is measurable on hot
+// logging paths.
+func (p Pool) EnableStats() {
+	atomic.StoreUint32(&p.stats.enabled, 1)
+}
+
+// Stats reports the use of the pool since EnableStats was called, and
+// whether it was.
+func (p Pool) Stats() (PoolStats, bool) {
+	return PoolStats{
+		Gets: atomic.LoadUint64(&p.stats.Gets),
+		Puts: atomic.LoadUint64(&p.stats.Puts),
+		News: atomic.LoadUint64(&p.stats.News),
+	}, p.stats.on()
+}
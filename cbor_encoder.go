@@ -0,0 +1,125 @@
+package zap_logger
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/buffer"
+)
+
+// CBOR major types, see RFC 8949.
+const (
+	cborUint   = 0 << 5
+	cborNegInt = 1 << 5
+	cborBytes  = 2 << 5
+	cborText   = 3 << 5
+	cborTag    = 6 << 5
+
+	cborFalse         = 0xf4
+	cborTrue          = 0xf5
+	cborNull          = 0xf6
+	cborFloat32       = 0xfa
+	cborFloat64       = 0xfb
+	cborMapStart      = 0xbf
+	cborArrayStart    = 0x9f
+	cborBreak         = 0xff
+	cborTagTimeString = 0
+)
+
+// NewCBOREncoder creates an encoder writing each entry as a CBOR map
+// (RFC 8949), preceded by its length as a 4-byte big-endian integer. Nested
+// objects and arrays are indefinite-length, so entries are written in one
+// pass. Times fall back to RFC 3339 strings tagged as date/time when the
+// EncoderConfig has no time encoder.
+//
+// Files written with it can be converted back to JSON with the logdecode
+// package or the zapdecode command.
+func NewCBOREncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	return newBinaryEncoder(cfg, cborFormat{})
+}
+
+type cborFormat struct{}
+
+func (cborFormat) head(buf *buffer.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.AppendByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.AppendByte(major | 24)
+		buf.AppendByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.AppendByte(major | 25)
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		buf.Write(b[:])
+	case n <= math.MaxUint32:
+		buf.AppendByte(major | 26)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		buf.Write(b[:])
+	default:
+		buf.AppendByte(major | 27)
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], n)
+		buf.Write(b[:])
+	}
+}
+
+func (cborFormat) openMap(buf *buffer.Buffer)              { buf.AppendByte(cborMapStart) }
+func (cborFormat) closeMap(buf *buffer.Buffer, _, _ int)   { buf.AppendByte(cborBreak) }
+func (cborFormat) openArray(buf *buffer.Buffer)            { buf.AppendByte(cborArrayStart) }
+func (cborFormat) closeArray(buf *buffer.Buffer, _, _ int) { buf.AppendByte(cborBreak) }
+
+func (f cborFormat) appendString(buf *buffer.Buffer, s string) {
+	f.head(buf, cborText, uint64(len(s)))
+	buf.AppendString(s)
+}
+
+func (f cborFormat) appendBytes(buf *buffer.Buffer, b []byte) {
+	f.head(buf, cborBytes, uint64(len(b)))
+	buf.Write(b)
+}
+
+func (f cborFormat) appendInt(buf *buffer.Buffer, i int64) {
+	if i < 0 {
+		f.head(buf, cborNegInt, uint64(-1-i))
+		return
+	}
+	f.head(buf, cborUint, uint64(i))
+}
+
+func (f cborFormat) appendUint(buf *buffer.Buffer, u uint64) {
+	f.head(buf, cborUint, u)
+}
+
+func (cborFormat) appendFloat64(buf *buffer.Buffer, v float64) {
+	buf.AppendByte(cborFloat64)
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], math.Float64bits(v))
+	buf.Write(b[:])
+}
+
+func (cborFormat) appendFloat32(buf *buffer.Buffer, v float32) {
+	buf.AppendByte(cborFloat32)
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], math.Float32bits(v))
+	buf.Write(b[:])
+}
+
+func (cborFormat) appendBool(buf *buffer.Buffer, v bool) {
+	if v {
+		buf.AppendByte(cborTrue)
+	} else {
+		buf.AppendByte(cborFalse)
+	}
+}
+
+func (cborFormat) appendNil(buf *buffer.Buffer) { buf.AppendByte(cborNull) }
+
+func (f cborFormat) appendTime(buf *buffer.Buffer, t time.Time) {
+	f.head(buf, cborTag, cborTagTimeString)
+	f.appendString(buf, t.Format(time.RFC3339Nano))
+}
@@ -0,0 +1,112 @@
+// Command zapcrypt decrypts log files written with Config.Encryption to
+// standard output:
+//
+//	zapcrypt -key-file /etc/app/log.key app.log | jq .
+//	zapcrypt -key-env APP_LOG_KEY app-2024-01-02T10-00-00.000.log | zapdecode
+//
+// Several keys can be given to read files sealed with older master keys.
+// It reads standard input when no file is given. A file that doesn't end
+// with the final frame of its data key, such as the file still being
+// written or one cut by a crash, is an error unless -allow-truncated is set.
+// -genkey prints a new random master key in hex.
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/hinha/zap-logger/pkg/cryptlog"
+)
+
+// keyFlags collects repeated key flags.
+type keyFlags struct {
+	keys *[][]byte
+	src  func(string) cryptlog.KeySource
+}
+
+func (f keyFlags) String() string { return "" }
+
+func (f keyFlags) Set(v string) error {
+	key, err := f.src(v).Key()
+	if err != nil {
+		return err
+	}
+	*f.keys = append(*f.keys, key)
+	return nil
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("zapcrypt: ")
+
+	var keys [][]byte
+	flag.Var(keyFlags{&keys, func(v string) cryptlog.KeySource { return cryptlog.FileKey(v) }},
+		"key-file", "file holding a master key (repeatable)")
+	flag.Var(keyFlags{&keys, func(v string) cryptlog.KeySource { return cryptlog.EnvKey(v) }},
+		"key-env", "environment variable holding a master key (repeatable)")
+	allowTruncated := flag.Bool("allow-truncated", false, "accept files missing their final frame")
+	genkey := flag.Bool("genkey", false, "print a new master key and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: zapcrypt -key-file file [flags] [file ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *genkey {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(hex.EncodeToString(key))
+		return
+	}
+	if len(keys) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	if flag.NArg() == 0 {
+		if err := decrypt(out, os.Stdin, keys, *allowTruncated); err != nil {
+			out.Flush()
+			log.Fatal(err)
+		}
+		return
+	}
+	for _, name := range flag.Args() {
+		if err := decryptFile(out, name, keys, *allowTruncated); err != nil {
+			out.Flush()
+			log.Fatal(err)
+		}
+	}
+}
+
+func decryptFile(w io.Writer, name string, keys [][]byte, allowTruncated bool) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := decrypt(w, file, keys, allowTruncated); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+func decrypt(w io.Writer, r io.Reader, keys [][]byte, allowTruncated bool) error {
+	cr, err := cryptlog.NewReader(r, keys...)
+	if err != nil {
+		return err
+	}
+	cr.AllowTruncated = allowTruncated
+	_, err = io.Copy(w, cr)
+	return err
+}
@@ -0,0 +1,72 @@
+// Command zapdecode converts log files written by the "cbor" and "msgpack"
+// encodings to JSON lines on standard output:
+//
+//	zapdecode app.log | jq .
+//
+// It reads standard input when no file is given. The format of each record
+// is detected unless -format says otherwise.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/hinha/zap-logger/pkg/logdecode"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("zapdecode: ")
+
+	format := flag.String("format", "auto", "record format: auto, cbor or msgpack")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: zapdecode [flags] [file ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var f logdecode.Format
+	switch *format {
+	case "auto":
+		f = logdecode.Auto
+	case "cbor":
+		f = logdecode.CBOR
+	case "msgpack":
+		f = logdecode.Msgpack
+	default:
+		log.Fatalf("unknown format %q", *format)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	if flag.NArg() == 0 {
+		if err := logdecode.Convert(out, os.Stdin, f); err != nil {
+			out.Flush()
+			log.Fatal(err)
+		}
+		return
+	}
+	for _, name := range flag.Args() {
+		if err := convertFile(out, name, f); err != nil {
+			out.Flush()
+			log.Fatal(err)
+		}
+	}
+}
+
+func convertFile(w io.Writer, name string, f logdecode.Format) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := logdecode.Convert(w, file, f); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
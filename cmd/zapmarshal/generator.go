@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const defaultMask = "[REDACTED]"
+
+// generator turns parsed struct declarations into MarshalLogObject methods.
+type generator struct {
+	mask string
+
+	buf     bytes.Buffer
+	imports map[string]bool
+	// named maps the package's type names to their declarations, so fields
+	// of local types can be resolved to their underlying type.
+	named map[string]ast.Expr
+	// structs maps the package's struct type names to their declarations,
+	// so embedded structs can be inlined.
+	structs map[string]*ast.StructType
+	// marshaled holds the types a method is generated for.
+	marshaled map[string]bool
+	// errorString is set once an error field is logged through
+	// zapmarshalError, which is then generated too.
+	errorString bool
+}
+
+// errorHelper is generated along with the marshalers logging errors. Error
+// methods with a pointer receiver panic on a nil pointer stored in an error;
+// zap.Error logs those as "<nil>" too.
+const errorHelper = `
+// zapmarshalError returns err.Error(), or "<nil>" if err holds a nil pointer
+// whose Error method panics.
+func zapmarshalError(err error) (s string) {
+	defer func() {
+		if p := recover(); p != nil {
+			if v := reflect.ValueOf(err); v.Kind() == reflect.Ptr && v.IsNil() {
+				s = "<nil>"
+				return
+			}
+			s = fmt.Sprintf("PANIC=%v", p)
+		}
+	}()
+	return err.Error()
+}
+`
+
+// logTag is the parsed form of a `log:"name,omitempty,redact"` tag.
+type logTag struct {
+	name      string
+	omitEmpty bool
+	redact    bool
+	skip      bool
+}
+
+func parseLogTag(field *ast.Field) logTag {
+	if field.Tag == nil {
+		return logTag{}
+	}
+	raw, ok := reflect.StructTag(strings.Trim(field.Tag.Value, "`")).Lookup("log")
+	if !ok {
+		return logTag{}
+	}
+	if raw == "-" {
+		return logTag{skip: true}
+	}
+	parts := strings.Split(raw, ",")
+	tag := logTag{name: parts[0]}
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "omitempty":
+			tag.omitEmpty = true
+		case "redact":
+			tag.redact = true
+		}
+	}
+	return tag
+}
+
+func hasLogTags(st *ast.StructType) bool {
+	for _, f := range st.Fields.List {
+		if f.Tag != nil && strings.Contains(f.Tag.Value, `log:"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// generate parses the non-test Go files of dir and returns the formatted
+// source of the marshalers along with the package name.
+func (g *generator) generate(dir string, typeNames []string) ([]byte, string, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && !strings.HasSuffix(fi.Name(), "_zapmarshal.go")
+	}, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(pkgs) != 1 {
+		return nil, "", fmt.Errorf("expected one package in %s, found %d", dir, len(pkgs))
+	}
+
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+
+	g.named = make(map[string]ast.Expr)
+	g.structs = make(map[string]*ast.StructType)
+	structs := g.structs
+	fileNames := make([]string, 0, len(pkg.Files))
+	for name := range pkg.Files {
+		fileNames = append(fileNames, name)
+	}
+	sort.Strings(fileNames)
+
+	var order []string
+	for _, name := range fileNames {
+		for _, decl := range pkg.Files[name].Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				g.named[ts.Name.Name] = ts.Type
+				if st, ok := ts.Type.(*ast.StructType); ok {
+					structs[ts.Name.Name] = st
+					order = append(order, ts.Name.Name)
+				}
+			}
+		}
+	}
+
+	if len(typeNames) == 0 {
+		for _, name := range order {
+			if hasLogTags(structs[name]) {
+				typeNames = append(typeNames, name)
+			}
+		}
+	}
+	if len(typeNames) == 0 {
+		return nil, "", fmt.Errorf("no struct types with log tags found in %s", filepath.Clean(dir))
+	}
+
+	g.marshaled = make(map[string]bool)
+	for _, name := range typeNames {
+		if _, ok := structs[name]; !ok {
+			return nil, "", fmt.Errorf("struct type %s not found in %s", name, filepath.Clean(dir))
+		}
+		g.marshaled[name] = true
+	}
+
+	g.imports = map[string]bool{"go.uber.org/zap/zapcore": true}
+	g.errorString = false
+	var body bytes.Buffer
+	for _, name := range typeNames {
+		g.buf.Reset()
+		g.marshaler(name, structs[name])
+		body.Write(g.buf.Bytes())
+	}
+	if g.errorString {
+		g.imports["fmt"] = true
+		g.imports["reflect"] = true
+		body.WriteString(errorHelper)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by zapmarshal. DO NOT EDIT.\n\npackage %s\n\n", pkg.Name)
+	out.WriteString("import (\n")
+	imports := make([]string, 0, len(g.imports))
+	for imp := range g.imports {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	// Standard library packages first, as goimports groups them.
+	sort.SliceStable(imports, func(i, j int) bool {
+		return !strings.Contains(imports[i], ".") && strings.Contains(imports[j], ".")
+	})
+	for i, imp := range imports {
+		if i > 0 && strings.Contains(imp, ".") && !strings.Contains(imports[i-1], ".") {
+			out.WriteString("\n")
+		}
+		fmt.Fprintf(&out, "\t%q\n", imp)
+	}
+	out.WriteString(")\n")
+	out.Write(body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, "", fmt.Errorf("formatting generated code: %v", err)
+	}
+	return src, pkg.Name, nil
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) marshaler(name string, st *ast.StructType) {
+	g.printf("\n// MarshalLogObject implements zapcore.ObjectMarshaler.\n")
+	g.printf("func (v *%s) MarshalLogObject(enc zapcore.ObjectEncoder) error {\n", name)
+	// zap.Object calls it on nil pointers too.
+	g.printf("if v == nil {\nreturn nil\n}\n")
+	g.fields("v", st, map[string]bool{name: true})
+	g.printf("return nil\n}\n")
+}
+
+// fields writes the statements encoding the fields of st, the struct
+// reached by expr. Untagged embedded structs of the package are inlined,
+// as encoding/json does; inlining holds the structs being inlined, so
+// recursive embedding stops.
+func (g *generator) fields(expr string, st *ast.StructType, inlining map[string]bool) {
+	for _, field := range st.Fields.List {
+		tag := parseLogTag(field)
+		if tag.skip {
+			continue
+		}
+		if len(field.Names) == 0 {
+			g.embedded(expr, field, tag, inlining)
+			continue
+		}
+		for _, ident := range field.Names {
+			if !ident.IsExported() {
+				continue
+			}
+			key := tag.name
+			if key == "" {
+				key = snakeCase(ident.Name)
+			}
+			g.field(key, expr+"."+ident.Name, field.Type, tag)
+		}
+	}
+}
+
+// embedded writes the statements encoding an embedded field. A local
+// struct without a tag name has its fields inlined, behind a nil check if
+// it's embedded by pointer; other embedded types are logged like a field
+// named after the type.
+func (g *generator) embedded(expr string, field *ast.Field, tag logTag, inlining map[string]bool) {
+	typ, ptr := field.Type, false
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ, ptr = star.X, true
+	}
+	var name string
+	switch t := typ.(type) {
+	case *ast.Ident:
+		name = t.Name
+	case *ast.SelectorExpr:
+		name = t.Sel.Name
+	default:
+		return
+	}
+	expr += "." + name
+
+	if st, ok := g.structs[name]; ok && tag.name == "" && !tag.redact && !inlining[name] {
+		if _, local := typ.(*ast.Ident); local {
+			inlining[name] = true
+			defer delete(inlining, name)
+			if ptr {
+				g.printf("if %s != nil {\n", expr)
+				defer g.printf("}\n")
+			}
+			g.fields(expr, st, inlining)
+			return
+		}
+	}
+	if !ast.IsExported(name) && tag.name == "" {
+		return
+	}
+	key := tag.name
+	if key == "" {
+		key = snakeCase(name)
+	}
+	g.field(key, expr, field.Type, tag)
+}
+
+// field writes the statements encoding one struct field.
+func (g *generator) field(key, expr string, typ ast.Expr, tag logTag) {
+	kind := g.kindOf(typ)
+	cond := ""
+	if tag.omitEmpty {
+		cond = kind.nonZero(expr)
+	}
+
+	var stmt string
+	if tag.redact {
+		stmt = fmt.Sprintf("enc.AddString(%q, %q)", key, g.mask)
+	} else {
+		stmt = kind.encode(g, key, expr)
+		if kind.nilable && cond == "" {
+			// Nil errors and pointers would panic or log noise.
+			cond = expr + " != nil"
+		}
+	}
+
+	if kind.object || kind.objectPtr {
+		if !tag.redact {
+			stmt = "if err := " + stmt + "; err != nil {\nreturn err\n}"
+		}
+	}
+	if cond != "" {
+		g.printf("if %s {\n%s\n}\n", cond, stmt)
+		return
+	}
+	g.printf("%s\n", stmt)
+}
+
+// fieldKind describes how a field type is encoded.
+type fieldKind struct {
+	// method is the ObjectEncoder method for basic types, e.g. "AddString".
+	method string
+	// conv converts the field to the method's argument type, e.g. "string"
+	// for a local type declared as `type Status string`.
+	conv string
+	// zero is the zero literal of basic types, used by omitempty.
+	zero string
+
+	time, err, object, objectPtr, slice, nilable bool
+}
+
+var basicKinds = map[string]fieldKind{
+	"string":     {method: "AddString", zero: `""`},
+	"bool":       {method: "AddBool", zero: "false"},
+	"int":        {method: "AddInt", zero: "0"},
+	"int8":       {method: "AddInt8", zero: "0"},
+	"int16":      {method: "AddInt16", zero: "0"},
+	"int32":      {method: "AddInt32", zero: "0"},
+	"rune":       {method: "AddInt32", zero: "0"},
+	"int64":      {method: "AddInt64", zero: "0"},
+	"uint":       {method: "AddUint", zero: "0"},
+	"uint8":      {method: "AddUint8", zero: "0"},
+	"byte":       {method: "AddUint8", zero: "0"},
+	"uint16":     {method: "AddUint16", zero: "0"},
+	"uint32":     {method: "AddUint32", zero: "0"},
+	"uint64":     {method: "AddUint64", zero: "0"},
+	"uintptr":    {method: "AddUintptr", zero: "0"},
+	"float32":    {method: "AddFloat32", zero: "0"},
+	"float64":    {method: "AddFloat64", zero: "0"},
+	"complex64":  {method: "AddComplex64", zero: "0"},
+	"complex128": {method: "AddComplex128", zero: "0"},
+}
+
+func (g *generator) kindOf(typ ast.Expr) fieldKind {
+	switch t := typ.(type) {
+	case *ast.Ident:
+		if k, ok := basicKinds[t.Name]; ok {
+			return k
+		}
+		if t.Name == "error" {
+			return fieldKind{err: true, nilable: true}
+		}
+		if g.marshaled[t.Name] {
+			return fieldKind{object: true}
+		}
+		switch underlying := g.named[t.Name].(type) {
+		case *ast.Ident:
+			if k, ok := basicKinds[underlying.Name]; ok {
+				k.conv = underlying.Name
+				return k
+			}
+		case *ast.ArrayType, *ast.MapType:
+			// Slices and maps keep their omitempty length check; named
+			// byte slices are converted for AddByteString.
+			k := g.kindOf(underlying)
+			if k.method != "" {
+				k.conv = "[]byte"
+			}
+			return k
+		case *ast.InterfaceType:
+			return fieldKind{nilable: true}
+		}
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok && pkg.Name == "time" {
+			switch t.Sel.Name {
+			case "Time":
+				return fieldKind{time: true}
+			case "Duration":
+				return fieldKind{method: "AddDuration", zero: "0"}
+			}
+		}
+	case *ast.StarExpr:
+		if ident, ok := t.X.(*ast.Ident); ok && g.marshaled[ident.Name] {
+			return fieldKind{objectPtr: true, nilable: true}
+		}
+		return fieldKind{nilable: true}
+	case *ast.ArrayType:
+		if ident, ok := t.Elt.(*ast.Ident); ok && t.Len == nil && (ident.Name == "byte" || ident.Name == "uint8") {
+			return fieldKind{method: "AddByteString", slice: true}
+		}
+		return fieldKind{slice: t.Len == nil}
+	case *ast.MapType:
+		return fieldKind{slice: true}
+	case *ast.InterfaceType:
+		return fieldKind{nilable: true}
+	}
+	return fieldKind{}
+}
+
+func (k fieldKind) encode(g *generator, key, expr string) string {
+	switch {
+	case k.method != "":
+		if k.conv != "" {
+			expr = k.conv + "(" + expr + ")"
+		}
+		return fmt.Sprintf("enc.%s(%q, %s)", k.method, key, expr)
+	case k.time:
+		return fmt.Sprintf("enc.AddTime(%q, %s)", key, expr)
+	case k.err:
+		g.errorString = true
+		return fmt.Sprintf("enc.AddString(%q, zapmarshalError(%s))", key, expr)
+	case k.object:
+		return fmt.Sprintf("enc.AddObject(%q, &%s)", key, expr)
+	case k.objectPtr:
+		return fmt.Sprintf("enc.AddObject(%q, %s)", key, expr)
+	}
+	// Everything else goes through zap.Any, which only falls back to
+	// reflection for types it has no dedicated field for.
+	g.imports["go.uber.org/zap"] = true
+	return fmt.Sprintf("zap.Any(%q, %s).AddTo(enc)", key, expr)
+}
+
+// nonZero returns the condition under which an omitempty field is logged.
+func (k fieldKind) nonZero(expr string) string {
+	switch {
+	case k.slice:
+		return "len(" + expr + ") > 0"
+	case k.nilable:
+		return expr + " != nil"
+	case k.time:
+		return "!" + expr + ".IsZero()"
+	case k.zero != "":
+		return expr + " != " + k.zero
+	}
+	return ""
+}
+
+// snakeCase converts a Go identifier to snake_case, keeping initialisms
+// together: CreatedAt -> created_at, UserID -> user_id, HTTPCode -> http_code.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
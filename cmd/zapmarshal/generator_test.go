@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateGolden(t *testing.T) {
+	dir := filepath.Join("testdata", "domain")
+	g := generator{mask: defaultMask}
+	src, pkg, err := g.generate(dir, []string{"User", "Address", "Order", "Event"})
+	require.NoError(t, err, "Unexpected error generating marshalers.")
+	assert.Equal(t, "domain", pkg)
+
+	golden, err := os.ReadFile(filepath.Join(dir, "domain_zapmarshal.go.golden"))
+	require.NoError(t, err)
+	assert.Equal(t, string(golden), string(src), "Generated code differs from the golden file.")
+}
+
+// TestGoldenCompiles builds the golden file into its package and runs the
+// package's tests, which log nil pointers and nil errors through it.
+func TestGoldenCompiles(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Builds the golden package with the go command.")
+	}
+	dir, err := filepath.Abs(filepath.Join("testdata", "domain"))
+	require.NoError(t, err)
+	overlay, err := json.Marshal(map[string]map[string]string{"Replace": {
+		filepath.Join(dir, "domain_zapmarshal.go"): filepath.Join(dir, "domain_zapmarshal.go.golden"),
+	}})
+	require.NoError(t, err)
+	overlayFile := filepath.Join(t.TempDir(), "overlay.json")
+	require.NoError(t, os.WriteFile(overlayFile, overlay, 0o600))
+
+	out, err := exec.Command("go", "test", "-count=1", "-overlay", overlayFile, "./testdata/domain").CombinedOutput()
+	assert.NoError(t, err, "Expected the golden file to compile and pass:\n%s", out)
+}
+
+func TestGenerateTaggedTypesByDefault(t *testing.T) {
+	g := generator{mask: "***"}
+	src, _, err := g.generate(filepath.Join("testdata", "domain"), nil)
+	require.NoError(t, err)
+	assert.Contains(t, string(src), "func (v *User) MarshalLogObject")
+	assert.Contains(t, string(src), "func (v *Address) MarshalLogObject")
+	assert.NotContains(t, string(src), "func (v *plain) MarshalLogObject", "plain has no log tags.")
+	assert.Contains(t, string(src), `enc.AddString("zip", "***")`)
+}
+
+func TestGenerateUnknownType(t *testing.T) {
+	g := generator{mask: defaultMask}
+	_, _, err := g.generate(filepath.Join("testdata", "domain"), []string{"Missing"})
+	assert.Error(t, err)
+}
+
+func TestSnakeCase(t *testing.T) {
+	for in, want := range map[string]string{
+		"Name":      "name",
+		"CreatedAt": "created_at",
+		"UserID":    "user_id",
+		"HTTPCode":  "http_code",
+		"A":         "a",
+	} {
+		assert.Equal(t, want, snakeCase(in), "snakeCase(%q)", in)
+	}
+}
@@ -0,0 +1,69 @@
+// Command zapmarshal generates zapcore.ObjectMarshaler implementations for
+// struct types, so they can be logged with zap.Object without reflection.
+//
+// Typical use is a go:generate directive next to the types:
+//
+//	//go:generate go run github.com/hinha/zap-logger/cmd/zapmarshal -type=user,order
+//
+// Fields are logged under the snake_case form of their name unless a log tag
+// says otherwise:
+//
+//	Email string `log:"email,omitempty,redact"`
+//	Token string `log:"-"`
+//
+// The tag name replaces the key, omitempty skips zero values and redact writes
+// the mask instead of the value. Fields tagged "-" and unexported fields are
+// never logged. The fields of embedded structs declared in the package are
+// inlined unless the embedded field has a tag name. A nil receiver logs an
+// empty object, and an error holding a nil pointer logs "<nil>", as
+// zap.Error does.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("zapmarshal: ")
+
+	var (
+		typeNames = flag.String("type", "", "comma-separated list of type names; all structs with log tags if empty")
+		output    = flag.String("output", "", "output file name; default <dir>/<package>_zapmarshal.go")
+		mask      = flag.String("mask", defaultMask, "replacement written for redacted fields")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: zapmarshal [flags] [directory]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	var types []string
+	if *typeNames != "" {
+		types = strings.Split(*typeNames, ",")
+	}
+
+	g := generator{mask: *mask}
+	src, pkg, err := g.generate(dir, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := *output
+	if name == "" {
+		name = filepath.Join(dir, pkg+"_zapmarshal.go")
+	}
+	if err := os.WriteFile(name, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,55 @@
+package domain
+
+import "time"
+
+//go:generate go run github.com/hinha/zap-logger/cmd/zapmarshal
+
+type Status string
+
+type User struct {
+	Name      string
+	Email     string    `log:"email,omitempty,redact"`
+	Password  string    `log:"-"`
+	CreatedAt time.Time `log:",omitempty"`
+	UserID    int64
+	Status    Status
+	Address   *Address
+	Tags      []string `log:"labels,omitempty"`
+	LastErr   error    `log:"last_error"`
+	internal  string
+}
+
+type Address struct {
+	City    string `log:"city"`
+	ZipCode string `log:"zip,redact"`
+}
+
+type Order struct {
+	ID       uint64
+	Owner    User
+	Total    float64 `log:",omitempty"`
+	Timeout  time.Duration
+	Metadata map[string]string
+}
+
+type plain struct {
+	A int
+}
+
+type Labels []string
+
+type Raw []byte
+
+type Timestamps struct {
+	CreatedBy string
+	UpdatedAt time.Time `log:",omitempty"`
+}
+
+type Event struct {
+	Timestamps
+	*Address
+	Kind   string
+	Labels Labels `log:",omitempty"`
+	Body   Raw    `log:",omitempty"`
+	Owner  *User  `log:"owner,omitempty"`
+}
@@ -0,0 +1,31 @@
+package domain
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// pathError has a pointer receiver, so a nil *pathError in an error panics.
+type pathError struct{ path string }
+
+func (e *pathError) Error() string { return "bad path " + e.path }
+
+func TestNilReceiver(t *testing.T) {
+	enc := zapcore.NewMapObjectEncoder()
+	zap.Object("user", (*User)(nil)).AddTo(enc)
+	if user := enc.Fields["user"]; len(user.(map[string]interface{})) != 0 {
+		t.Errorf("Expected a nil *User to log an empty object, have %v.", user)
+	}
+}
+
+func TestTypedNilError(t *testing.T) {
+	var err *pathError
+	enc := zapcore.NewMapObjectEncoder()
+	zap.Object("user", &User{Name: "jane", LastErr: err}).AddTo(enc)
+	user := enc.Fields["user"].(map[string]interface{})
+	if got := user["last_error"]; got != "<nil>" {
+		t.Errorf(`Expected a nil *pathError to log "<nil>", have %v.`, got)
+	}
+}
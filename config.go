@@ -1,12 +1,17 @@
 package zap_logger
 
 import (
-	"gopkg.in/natefinch/lumberjack.v2"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/pkg/httpsink"
+	"github.com/hinha/zap-logger/pkg/wal"
 )
 
 type Config struct {
@@ -23,10 +28,18 @@ type Config struct {
 	// DisableCaller stops annotating logs with the calling function's file
 	// name and line number. By default, all logs are annotated.
 	DisableCaller bool
-	// Encoding sets the logger's encoding. Valid values are "json" and
-	// "console" and "all", as well as any third-party encodings registered via
-	// RegisterEncoder.
+	// Encoding sets the logger's encoding and, with it, its sinks. "console"
+	// writes to standard output, "all" writes JSON to Filename and to
+	// standard output, and "json", "logfmt", "ecs", "gcp", "cbor", "msgpack"
+	// or any third-party encoding registered via RegisterEncoder writes to
+	// Filename in that encoding. The binary "cbor" and "msgpack" files can be
+	// converted back to JSON with cmd/zapdecode.
 	Encoding string
+	// ConsoleEncoding is the encoding used for standard output when Encoding
+	// is "console" or "all". It defaults to "console"; set it to "logfmt" for
+	// logfmt, "gcp" for Cloud Logging structured logs or "pretty" for colored
+	// development output on standard output.
+	ConsoleEncoding string
 	// MaxSize is the maximum size in megabytes of the log file before it gets
 	// rotated. It defaults to 100 megabytes.
 	MaxSize int
@@ -55,7 +68,53 @@ type Config struct {
 	// based on age.
 	MaxAge int
 
+	// Encryption, if set, encrypts the file written for Encoding. See
+	// EncryptionConfig.
+	Encryption *EncryptionConfig
+
+	// CrashDump, if set, writes the stacks of all goroutines to a file next
+	// to Filename, such as app-goroutines-2006-01-02T15-04-05.000.txt, before
+	// a Fatal entry exits.
+	CrashDump bool
+
 	Interval time.Duration
+
+	// Syslog, if set, also sends entries to syslog. See SyslogConfig.
+	Syslog *SyslogConfig
+
+	// GELF, if set, also sends entries to Graylog. See GELFConfig.
+	GELF *GELFConfig
+
+	// HTTP, if set, also posts entries as JSON records in batches to an HTTP
+	// endpoint such as Loki or Elasticsearch. See package httpsink.
+	HTTP *httpsink.Config
+	// HTTPWAL, if set, keeps the records of HTTP in a write-ahead log on
+	// disk until the sink accepts them. See package wal.
+	HTTPWAL *wal.Options
+	// HTTPDiodeSize is the number of records held for HTTP while the sink is
+	// busy, DefaultDiodeSize if zero.
+	HTTPDiodeSize int
+
+	// Fluent, if set, also sends entries to a Fluentd or Fluent Bit forward
+	// input, tagged by logger name. See FluentConfig.
+	Fluent *FluentConfig
+
+	// OTLP, if set, also exports entries as OpenTelemetry log records to an
+	// OTLP/HTTP endpoint. See OTLPConfig.
+	OTLP *OTLPConfig
+
+	// Audit, if set, also writes entries to a tamper-evident, hash-chained
+	// audit log. See AuditConfig.
+	Audit *AuditConfig
+
+	// Sampling, if set, caps the entries logged per second, as with zap's
+	// Config.Sampling. A nil Sampling disables sampling. The audit log is
+	// never sampled.
+	Sampling *zap.SamplingConfig
+
+	// Metrics, if set, counts the entries written and dropped per level and
+	// sink. See Metrics.
+	Metrics *Metrics
 }
 
 // NewProductionEncoderConfig returns an opinionated EncoderConfig for
@@ -121,25 +180,73 @@ func NewDevelopmentEncoderConfig() zapcore.EncoderConfig {
 // NewDevelopmentConfig is a reasonable development logging configuration.
 // Logging is enabled at DebugLevel and above.
 //
-// It enables development mode (which makes DPanicLevel logs panic), uses a
-// console encoder, writes to standard error, and disables sampling.
+// It enables development mode (which makes DPanicLevel logs panic), uses the
+// pretty encoder, writes to standard output, and disables sampling.
 // Stack traces are automatically included on logs of WarnLevel and above.
 func NewDevelopmentConfig() Config {
 	return Config{
-		Level:         zap.NewAtomicLevelAt(zap.DebugLevel),
-		Development:   true,
-		Encoding:      "console",
-		EncoderConfig: NewDevelopmentEncoderConfig(),
-		MaxSize:       10, // 10MB
-		MaxBackups:    1,
-		LocalTime:     false,
-		Compress:      false,
-		Filename:      "app.log",
-		MaxAge:        30,
-		Interval:      time.Duration(5) * time.Microsecond,
+		Level:           zap.NewAtomicLevelAt(zap.DebugLevel),
+		Development:     true,
+		Encoding:        "console",
+		ConsoleEncoding: "pretty",
+		EncoderConfig:   NewDevelopmentEncoderConfig(),
+		MaxSize:         10, // 10MB
+		MaxBackups:      1,
+		LocalTime:       false,
+		Compress:        false,
+		Filename:        "app.log",
+		MaxAge:          30,
+		Interval:        time.Duration(5) * time.Microsecond,
+	}
+}
+
+// encodings returns the encodings of the file and standard output sinks
+// selected by Encoding. An empty name leaves the sink out.
+func (c Config) encodings() (file, console string) {
+	console = c.ConsoleEncoding
+	if console == "" {
+		console = "console"
+	}
+	switch c.Encoding {
+	case "all":
+		return "json", console
+	case "console":
+		return "", console
+	}
+	if isRegisteredEncoder(c.Encoding) {
+		return c.Encoding, ""
+	}
+	return "", console
+}
+
+// encoder builds the named encoder, falling back to the console encoder if
+// a third-party constructor fails.
+func (c Config) encoder(name string) zapcore.Encoder {
+	enc, err := newEncoder(name, c.EncoderConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v NewLogger error: %v\n", time.Now().UTC(), err)
+		return zapcore.NewConsoleEncoder(c.EncoderConfig)
+	}
+	return enc
+}
+
+// fileEncoder builds the named encoder for the log file. The pretty encoder
+// is never colored there, whatever standard output is.
+func (c Config) fileEncoder(name string) zapcore.Encoder {
+	if name == "pretty" {
+		return newPrettyEncoder(c.EncoderConfig, false)
+	}
+	return c.encoder(name)
+}
+
+// filename returns the log file, defaulting like lumberjack does.
+func (c Config) filename() string {
+	if c.Filename == "" {
+		return filepath.Join(os.TempDir(), filepath.Base(os.Args[0])+"-lumberjack.log")
 	}
+	return c.Filename
 }
 
-func (c Config) writer() (io.Writer, *lumberjack.Logger) {
-	return newWriter(c.Filename, c.MaxAge, c.MaxSize, c.MaxBackups, c.LocalTime, c.Interval)
+func (c Config) writer(o *sinkObserver) (io.Writer, rotator, error) {
+	return newWriter(c.Filename, c.MaxAge, c.MaxSize, c.MaxBackups, c.LocalTime, c.Interval, c.Encryption, o)
 }
@@ -19,6 +19,7 @@ func newMemCtx() *inmemCtx {
 		mapCtx: make(map[interface{}]context.Context),
 		mu:     &sync.RWMutex{},
 		caller: make(map[interface{}]zapcore.EntryCaller),
+		values: _defaultValueEncoder,
 	}
 }
 
@@ -26,6 +27,7 @@ type inmemCtx struct {
 	mapCtx mapCtx
 	mu     *sync.RWMutex
 	caller callerCtx
+	values *valueEncoder
 }
 
 // Set sets the context entries associated with key to the
@@ -67,7 +69,7 @@ func (c *inmemCtx) Prune() {
 }
 
 func (c *inmemCtx) data() *contextField {
-	return &contextField{c.mapCtx, c.caller, c.mu}
+	return &contextField{c.mapCtx, c.caller, c.mu, c.values}
 }
 
 // Len Gets length key of map Context
@@ -77,6 +79,7 @@ type contextField struct {
 	data   mapCtx
 	caller callerCtx
 	mu     *sync.RWMutex
+	values *valueEncoder
 }
 
 func (c *contextField) MarshalLogObject(enc zapcore.ObjectEncoder) error {
@@ -98,7 +101,7 @@ func (c *contextField) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 			continue
 		}
 
-		cf := contextFieldValue{value: ctxValue, caller: c.caller[key]}
+		cf := contextFieldValue{value: ctxValue, caller: c.caller[key], enc: c.values}
 		if err := enc.AddObject(ctxKey, cf); err != nil {
 			continue
 		}
@@ -1,6 +1,7 @@
 package zap_logger
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"time"
@@ -9,7 +10,8 @@ import (
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
 
-	"github.com/hinha/zap-logger/pkg/diode"
+	"github.com/hinha/zap-logger/pkg/httpsink"
+	"github.com/hinha/zap-logger/pkg/wal"
 )
 
 const (
@@ -18,7 +20,12 @@ const (
 	bufferSizeDebug = 1024
 )
 
-func newWriter(filename string, days, size, backups int, local bool, interval time.Duration) (io.Writer, *lumberjack.Logger) {
+// DefaultDiodeSize is the number of records a network sink holds while its
+// destination is slow or unreachable. Records beyond it are dropped and
+// reported to the error output.
+const DefaultDiodeSize = 4096
+
+func newWriter(filename string, days, size, backups int, local bool, interval time.Duration, enc *EncryptionConfig, o *sinkObserver) (io.Writer, rotator, error) {
 	lg := &lumberjack.Logger{
 		Filename:   filename,
 		MaxSize:    size,
@@ -27,36 +34,89 @@ func newWriter(filename string, days, size, backups int, local bool, interval ti
 		LocalTime:  local,
 	}
 
-	d := diode.NewWriter(lg, bufferSize, interval, func(missed int) {
-		// fmt.Printf("Dropped %d messages\n", missed)
-	})
-	return d, lg
+	var file logFile = lg
+	if o != nil {
+		if rotations := o.metrics.rotationCounter(); rotations != nil {
+			file = newMeteredFile(lg, lg, rotations)
+		}
+	}
+	var (
+		w   io.Writer = file
+		rot rotator   = file
+	)
+	if enc != nil {
+		ef, err := newEncryptedFile(lg, file, enc)
+		if err != nil {
+			return nil, nil, err
+		}
+		w, rot = ef, ef
+	}
+	return newDiode(w, bufferSize, interval, "file", o), rot, nil
 }
 
-func getStdout(interval time.Duration) io.Writer {
-	w := diode.NewWriter(os.Stdout, bufferSize, interval, func(missed int) {
-		// fmt.Printf("Dropped %d messages\n", missed)
-	})
-	return w
+// newNetworkDiode puts the network writer of sink behind a diode of size
+// records, DefaultDiodeSize if zero, with the write-ahead log of opts between
+// them if opts is set, so that records survive an unreachable destination or
+// a restart. w is closed on error.
+func newNetworkDiode(w io.Writer, opts *wal.Options, size int, interval time.Duration, sink string, o *sinkObserver) (io.WriteCloser, error) {
+	if opts != nil {
+		l, err := wal.Open(*opts)
+		if err != nil {
+			if c, ok := w.(io.Closer); ok {
+				_ = c.Close()
+			}
+			return nil, err
+		}
+		w = wal.NewWriter(l, w, 0)
+	}
+	if size <= 0 {
+		size = DefaultDiodeSize
+	}
+	return newDiode(w, size, interval, sink, o), nil
 }
 
-func jsonEncoder(w io.Writer, debug bool, cfg zapcore.EncoderConfig, lvl zap.AtomicLevel) zapcore.Core {
+func getStdout(interval time.Duration, o *sinkObserver) io.Writer {
+	return newDiode(os.Stdout, bufferSize, interval, "console", o)
+}
+
+func fileCore(enc zapcore.Encoder, w io.Writer, debug bool, lvl zap.AtomicLevel) zapcore.Core {
 	if debug {
-		return zapcore.NewCore(zapcore.NewJSONEncoder(cfg), &zapcore.BufferedWriteSyncer{
+		return zapcore.NewCore(enc, &zapcore.BufferedWriteSyncer{
 			WS:   zapcore.AddSync(w),
 			Size: bufferSizeDebug,
 		}, lvl)
 	}
-	return zapcore.NewCore(zapcore.NewJSONEncoder(cfg), &zapcore.BufferedWriteSyncer{
+	return zapcore.NewCore(enc, &zapcore.BufferedWriteSyncer{
 		WS:   zapcore.AddSync(w),
 		Size: bufferSize,
 	}, lvl)
 }
 
-func consoleEncoder(w io.Writer, cfg zapcore.EncoderConfig, lvl zap.AtomicLevel) zapcore.Core {
+func consoleCore(enc zapcore.Encoder, w io.Writer, lvl zap.AtomicLevel) zapcore.Core {
 	return zapcore.NewCore(
-		zapcore.NewConsoleEncoder(cfg),
+		enc,
 		zapcore.AddSync(w),
 		lvl,
 	)
 }
+
+// httpCore returns a core posting records encoded by enc through a diode of
+// diodeSize records to an HTTP endpoint, and the diode to close when the
+// logger is closed. Errors of the sink go to stderr unless cfg has an
+// ErrorHandler.
+func httpCore(cfg httpsink.Config, walOpts *wal.Options, diodeSize int, enc zapcore.Encoder, lvl zap.AtomicLevel, interval time.Duration, sink string, o *sinkObserver) (zapcore.Core, io.Closer, error) {
+	if cfg.ErrorHandler == nil {
+		cfg.ErrorHandler = func(err error) {
+			fmt.Fprintf(os.Stderr, "%v http sink error: %v\n", time.Now().UTC(), err)
+		}
+	}
+	s, err := httpsink.New(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := newNetworkDiode(s, walOpts, diodeSize, interval, sink, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	return zapcore.NewCore(enc, zapcore.AddSync(d), lvl), d, nil
+}
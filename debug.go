@@ -0,0 +1,282 @@
+package zap_logger
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/buffer"
+	"github.com/hinha/zap-logger/internal/logfiles"
+	"github.com/hinha/zap-logger/pkg/diode"
+)
+
+// DebugInfo describes a Logger and the state of its sinks, see
+// DebugSnapshot. It marshals to JSON.
+type DebugInfo struct {
+	Name            string `json:"name,omitempty"`
+	Level           string `json:"level"`
+	Development     bool   `json:"development"`
+	Caller          bool   `json:"caller"`
+	Encoding        string `json:"encoding,omitempty"`
+	ConsoleEncoding string `json:"console_encoding,omitempty"`
+	// Levels are the levels of the loggers derived with Named from the same
+	// Logger, by name.
+	Levels map[string]string `json:"levels,omitempty"`
+	// Sinks are the outputs configured by NewLogger; a Logger built by New
+	// has none.
+	Sinks []SinkInfo `json:"sinks"`
+	// Files are the log files on disk, backups first.
+	Files []FileInfo `json:"files,omitempty"`
+	// BufferPool is the use of package buffer's default pool, shared by the
+	// process. It's only counted once buffer.EnableStats was called, as
+	// PublishExpvar does.
+	BufferPool *buffer.PoolStats `json:"buffer_pool,omitempty"`
+}
+
+// SinkInfo describes a sink.
+type SinkInfo struct {
+	Name string `json:"name"`
+	// Target is the file, address or URL written to.
+	Target string `json:"target,omitempty"`
+	// DiodeCapacity, DiodePending and DiodeDropped describe the diode in
+	// front of the sink, if any: its size, the records waiting in it and the
+	// ones it has dropped.
+	DiodeCapacity int    `json:"diode_capacity,omitempty"`
+	DiodePending  uint64 `json:"diode_pending"`
+	DiodeDropped  uint64 `json:"diode_dropped"`
+	// LastError is the last write the sink failed, if any.
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// FileInfo describes a log file.
+type FileInfo struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// DebugSnapshot describes the Logger's configuration and the current state
+// of its sinks, to debug a misconfigured service. It isn't named Debug, which
+// logs.
+func (log *ZapLogger) DebugSnapshot() DebugInfo {
+	info := DebugInfo{
+		Name:            log.name,
+		Level:           log.Level().String(),
+		Development:     log.development,
+		Caller:          log.addCaller,
+		Encoding:        log.config.Encoding,
+		ConsoleEncoding: log.config.ConsoleEncoding,
+		Sinks:           []SinkInfo{},
+		Levels:          log.named.levels(),
+	}
+	if stats, ok := buffer.Stats(); ok {
+		info.BufferPool = &stats
+	}
+	if log.sinks == nil {
+		return info
+	}
+
+	log.sinks.mu.Lock()
+	sinks := append([]*sinkState(nil), log.sinks.sinks...)
+	log.sinks.mu.Unlock()
+	for _, st := range sinks {
+		si := SinkInfo{
+			Name:         st.name,
+			Target:       log.config.sinkTarget(st.name),
+			DiodeDropped: atomic.LoadUint64(&st.dropped),
+		}
+		if st.diode != nil {
+			si.DiodeCapacity = st.capacity
+			si.DiodePending = st.diode.Pending()
+		}
+		st.mu.Lock()
+		if st.lastErr != nil {
+			t := st.lastErrTime
+			si.LastError, si.LastErrorTime = st.lastErr.Error(), &t
+		}
+		st.mu.Unlock()
+		info.Sinks = append(info.Sinks, si)
+
+		if st.name == "file" || st.name == "audit" {
+			info.Files = append(info.Files, logFiles(si.Target)...)
+		}
+	}
+	return info
+}
+
+// PublishExpvar publishes the DebugSnapshot of the Logger under name with
+// package expvar, served at /debug/vars, and enables the buffer pool
+// counters it reports. Like expvar.Publish, it panics if name is already in
+// use.
+func (log *ZapLogger) PublishExpvar(name string) {
+	buffer.EnableStats()
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return log.DebugSnapshot()
+	}))
+}
+
+// sinkTarget returns where the sink of Config named name writes.
+func (c Config) sinkTarget(name string) string {
+	switch name {
+	case "file":
+		return c.filename()
+	case "syslog":
+		return c.Syslog.Address
+	case "gelf":
+		return c.GELF.Address
+	case "http":
+		return c.HTTP.URL
+	case "fluent":
+		return c.Fluent.Address
+	case "otlp":
+		if c.OTLP.Endpoint == "" {
+			return DefaultOTLPEndpoint
+		}
+		return c.OTLP.Endpoint
+	case "audit":
+		return c.Audit.Filename
+	}
+	return ""
+}
+
+func logFiles(filename string) []FileInfo {
+	names, err := logfiles.List(filename)
+	if err != nil {
+		return nil
+	}
+	files := make([]FileInfo, 0, len(names))
+	for _, name := range names {
+		if fi, err := os.Stat(name); err == nil {
+			files = append(files, FileInfo{Path: name, Size: fi.Size()})
+		}
+	}
+	return files
+}
+
+// namedLoggers records the loggers derived with Named from a Logger, the
+// last one of each name, so DebugSnapshot can report their levels.
+type namedLoggers struct {
+	mu    sync.Mutex
+	cores map[string]zapcore.Core
+}
+
+func (n *namedLoggers) add(name string, core zapcore.Core) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.cores == nil {
+		n.cores = make(map[string]zapcore.Core)
+	}
+	n.cores[name] = core
+}
+
+// levels returns the level of each logger by name, or nil if there are none.
+func (n *namedLoggers) levels() map[string]string {
+	if n == nil {
+		return nil
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.cores) == 0 {
+		return nil
+	}
+	levels := make(map[string]string, len(n.cores))
+	for name, core := range n.cores {
+		levels[name] = zapcore.LevelOf(core).String()
+	}
+	return levels
+}
+
+// addSinkObserver sets the observer of the sinks built by NewLogger.
+func addSinkObserver(o *sinkObserver) Option {
+	return optionFunc(func(log *Logger) {
+		log.sinks = o
+		o.errorOutput = func() zapcore.WriteSyncer { return log.errorOutput }
+	})
+}
+
+// sinkObserver follows the sinks of a Logger built by NewLogger, for its
+// Metrics and DebugSnapshot.
+type sinkObserver struct {
+	metrics *Metrics
+	// errorOutput returns where diodes report dropped records.
+	errorOutput func() zapcore.WriteSyncer
+
+	mu    sync.Mutex
+	sinks []*sinkState
+}
+
+// sinkState is what DebugSnapshot reports of a sink.
+type sinkState struct {
+	name     string
+	diode    *diode.Writer
+	capacity int
+	dropped  uint64
+
+	mu          sync.Mutex
+	lastErr     error
+	lastErrTime time.Time
+}
+
+func (o *sinkObserver) add(name string) *sinkState {
+	st := &sinkState{name: name}
+	o.mu.Lock()
+	o.sinks = append(o.sinks, st)
+	o.mu.Unlock()
+	return st
+}
+
+// writer registers the sink and returns w, counting for it.
+func (o *sinkObserver) writer(sink string, w io.Writer) io.Writer {
+	if o == nil {
+		return w
+	}
+	return o.wrap(o.add(sink), w)
+}
+
+func (o *sinkObserver) wrap(st *sinkState, w io.Writer) io.Writer {
+	mw := &meteredWriter{w: w, state: st}
+	if o.metrics != nil {
+		mw.bytes = o.metrics.bytes.counter(st.name)
+		mw.errors = o.metrics.writeErrors.counter(st.name)
+	}
+	return mw
+}
+
+// newDiode puts the writer of sink behind a diode of size records,
+// following it with o if set. Dropped records are reported to the error
+// output of o's Logger.
+func newDiode(w io.Writer, size int, interval time.Duration, sink string, o *sinkObserver) diode.Writer {
+	if o == nil {
+		return diode.NewWriter(w, size, interval, nil)
+	}
+	st := o.add(sink)
+	st.capacity = size
+	alert := o.metrics.alerter(sink)
+	d := diode.NewWriter(o.wrap(st, w), size, interval, func(missed int) {
+		atomic.AddUint64(&st.dropped, uint64(missed))
+		alert(missed)
+		o.reportDrops(sink, missed)
+	})
+	st.diode = &d
+	return d
+}
+
+// reportDrops writes the records the diode of sink dropped to the error
+// output.
+func (o *sinkObserver) reportDrops(sink string, missed int) {
+	out := zapcore.WriteSyncer(zapcore.Lock(os.Stderr))
+	if o.errorOutput != nil {
+		out = o.errorOutput()
+	}
+	fmt.Fprintf(out, "%v %s sink dropped %d records: its diode is full\n", time.Now().UTC(), sink, missed)
+	out.Sync()
+}
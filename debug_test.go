@@ -0,0 +1,123 @@
+package zap_logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/buffer"
+	"github.com/hinha/zap-logger/pkg/httpsink"
+)
+
+func TestDebugSnapshot(t *testing.T) {
+	cfg := Config{
+		Encoding: "json",
+		Filename: filepath.Join(t.TempDir(), "app.log"),
+		Level:    zap.NewAtomicLevelAt(zap.WarnLevel),
+		HTTP:     &httpsink.Config{URL: "http://collector/logs"},
+	}
+	obs := &sinkObserver{}
+	w, _, err := cfg.writer(obs)
+	require.NoError(t, err)
+	defer w.(io.Closer).Close()
+	bad := newDiode(failingWriter{}, DefaultDiodeSize, 0, "http", obs)
+	defer bad.Close()
+
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"}), zapcore.AddSync(w), cfg.Level)
+	log := New(core, cfg, addSinkObserver(obs)).Named("api")
+	log.Warn("hi")
+	require.NoError(t, log.Sync())
+	_, err = bad.Write([]byte("lost\n"))
+	require.NoError(t, err)
+	require.NoError(t, bad.Sync())
+
+	info := log.DebugSnapshot()
+	assert.Equal(t, "api", info.Name)
+	assert.Equal(t, "warn", info.Level)
+	assert.Equal(t, map[string]string{"api": "warn"}, info.Levels)
+	log.Named("db")
+	assert.Equal(t, map[string]string{"api": "warn", "api.db": "warn"}, log.DebugSnapshot().Levels,
+		"Expected the levels of the loggers derived with Named.")
+	assert.Equal(t, "json", info.Encoding)
+	require.Len(t, info.Sinks, 2)
+
+	file := info.Sinks[0]
+	assert.Equal(t, SinkInfo{Name: "file", Target: cfg.Filename, DiodeCapacity: bufferSize}, file)
+	assert.Equal(t, []FileInfo{{Path: cfg.Filename, Size: int64(len(`{"msg":"hi"}` + "\n"))}}, info.Files)
+
+	http := info.Sinks[1]
+	assert.Equal(t, "http://collector/logs", http.Target)
+	assert.Equal(t, DefaultDiodeSize, http.DiodeCapacity)
+	assert.Equal(t, "disk full", http.LastError)
+	assert.NotNil(t, http.LastErrorTime)
+
+	b, err := json.Marshal(info)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"last_error":"disk full"`)
+
+	log.PublishExpvar("zap_logger_debug_snapshot_test")
+	assert.Contains(t, expvar.Get("zap_logger_debug_snapshot_test").String(), `"name":"api"`)
+	buffer.Get().Free()
+	require.NotNil(t, log.DebugSnapshot().BufferPool, "Expected PublishExpvar to enable the buffer pool counters.")
+	assert.NotZero(t, log.DebugSnapshot().BufferPool.Gets)
+
+	plain := New(core, Config{}).DebugSnapshot()
+	assert.Empty(t, plain.Sinks, "Expected no sinks for a Logger built by New.")
+}
+
+// blockedWriter blocks writes until its channel is closed.
+type blockedWriter chan struct{}
+
+func (w blockedWriter) Write(p []byte) (int, error) {
+	<-w
+	return len(p), nil
+}
+
+func TestDiodeDropsReported(t *testing.T) {
+	obs := &sinkObserver{}
+	w := make(blockedWriter)
+	d := newDiode(w, 2, 0, "gelf", obs)
+	defer d.Close()
+	var errOut bytes.Buffer
+	New(zapcore.NewNopCore(), Config{}, addSinkObserver(obs), ErrorOutput(zapcore.AddSync(&errOut)))
+
+	for i := 0; i < 20; i++ {
+		_, err := d.Write([]byte("x"))
+		require.NoError(t, err)
+	}
+	close(w)
+	require.NoError(t, d.Sync())
+	assert.Contains(t, errOut.String(), "gelf sink dropped", "Expected dropped records to be reported to the error output.")
+}
+
+func TestNetworkDiodeSize(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	obs := &sinkObserver{}
+	lvl := zap.NewAtomicLevel()
+	syslog := &SyslogConfig{Network: "udp", Address: conn.LocalAddr().String(), DiodeSize: 64}
+	_, closer, err := syslog.core(lvl, 0, obs)
+	require.NoError(t, err)
+	defer closer.Close()
+	gelf := &GELFConfig{Address: conn.LocalAddr().String()}
+	_, closer, err = gelf.core(zapcore.EncoderConfig{}, lvl, 0, obs)
+	require.NoError(t, err)
+	defer closer.Close()
+
+	cfg := Config{Syslog: syslog, GELF: gelf}
+	info := New(zapcore.NewNopCore(), cfg, addSinkObserver(obs)).DebugSnapshot()
+	require.Len(t, info.Sinks, 2)
+	assert.Equal(t, 64, info.Sinks[0].DiodeCapacity, "Expected the configured diode size.")
+	assert.Equal(t, DefaultDiodeSize, info.Sinks[1].DiodeCapacity, "Expected the default diode size.")
+}
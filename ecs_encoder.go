@@ -0,0 +1,168 @@
+package zap_logger
+
+import (
+	"strings"
+
+	"go.uber.org/zap"
+	zapbuffer "go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// ECSVersion is the Elastic Common Schema version records written by the
+// "ecs" encoder conform to.
+const ECSVersion = "1.6.0"
+
+// ECSContextFields maps context keys to the ECS fields their values are
+// logged under by the "ecs" encoder. Keys are matched on the last segment
+// of the context key, ignoring case, '_' and '-', so "traceID", "trace_id"
+// and "trace-id" all match "traceid". Context values without a mapping stay
+// in the "context" object.
+var ECSContextFields = map[string]string{
+	"traceid":       "trace.id",
+	"spanid":        "span.id",
+	"transactionid": "transaction.id",
+	"requestid":     "http.request.id",
+	"userid":        "user.id",
+	"username":      "user.name",
+	"servicename":   "service.name",
+	"sessionid":     "session.id",
+	"clientip":      "client.ip",
+}
+
+// NewECSEncoderConfig returns an EncoderConfig producing Elastic Common
+// Schema field names. Use it with the "ecs" encoding, which adds ecs.version,
+// nests errors and maps context values into ECS fields.
+func NewECSEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:        "@timestamp",
+		LevelKey:       "log.level",
+		NameKey:        "log.logger",
+		CallerKey:      "log.origin",
+		FunctionKey:    zapcore.OmitKey,
+		MessageKey:     "message",
+		StacktraceKey:  "error.stack_trace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.NanosDurationEncoder,
+		EncodeCaller:   ecsCallerEncoder,
+	}
+}
+
+// ECSService adds service.name, and service.version if it isn't empty, to
+// every record.
+func ECSService(name, version string) Option {
+	fields := []zap.Field{zap.String("service.name", name)}
+	if version != "" {
+		fields = append(fields, zap.String("service.version", version))
+	}
+	return Fields(fields...)
+}
+
+// ecsCallerEncoder writes the caller as a log.origin object holding
+// file.name, file.line and function. Encoders that can't append objects get
+// the short caller instead.
+func ecsCallerEncoder(caller zapcore.EntryCaller, enc zapcore.PrimitiveArrayEncoder) {
+	if arr, ok := enc.(zapcore.ArrayEncoder); ok {
+		_ = arr.AppendObject(ecsOrigin(caller))
+		return
+	}
+	zapcore.ShortCallerEncoder(caller, enc)
+}
+
+type ecsOrigin zapcore.EntryCaller
+
+func (o ecsOrigin) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	path := zapcore.EntryCaller(o).TrimmedPath()
+	if i := strings.LastIndexByte(path, ':'); i >= 0 {
+		path = path[:i]
+	}
+	_ = enc.AddObject("file", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+		enc.AddString("name", path)
+		enc.AddInt("line", o.Line)
+		return nil
+	}))
+	if o.Function != "" {
+		enc.AddString("function", o.Function)
+	}
+	return nil
+}
+
+// NewECSEncoder creates a JSON encoder writing Elastic Common Schema
+// records; pair it with NewECSEncoderConfig. On top of the JSON encoder it
+// adds ecs.version, logs "error" fields as error.message and moves
+// context values listed in ECSContextFields to their ECS fields.
+func NewECSEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	enc := &ecsEncoder{Encoder: zapcore.NewJSONEncoder(cfg)}
+	enc.Encoder.AddString("ecs.version", ECSVersion)
+	return enc
+}
+
+type ecsEncoder struct {
+	zapcore.Encoder
+}
+
+// Clone implements zapcore.Encoder.
+func (enc *ecsEncoder) Clone() zapcore.Encoder {
+	return &ecsEncoder{Encoder: enc.Encoder.Clone()}
+}
+
+// EncodeEntry implements zapcore.Encoder.
+func (enc *ecsEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*zapbuffer.Buffer, error) {
+	if !ecsRewrites(fields) {
+		return enc.Encoder.EncodeEntry(ent, fields)
+	}
+	final := enc.Clone().(*ecsEncoder)
+	for i := range fields {
+		fields[i].AddTo(final)
+	}
+	return final.Encoder.EncodeEntry(ent, nil)
+}
+
+func ecsRewrites(fields []zapcore.Field) bool {
+	for _, f := range fields {
+		if f.Key == "error" || f.Key == "context" {
+			return true
+		}
+	}
+	return false
+}
+
+// AddString implements zapcore.ObjectEncoder. ECS defines error as an
+// object, so string errors become error.message.
+func (enc *ecsEncoder) AddString(key, val string) {
+	if key == "error" {
+		_ = enc.Encoder.AddObject(key, zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("message", val)
+			return nil
+		}))
+		return
+	}
+	enc.Encoder.AddString(key, val)
+}
+
+// AddObject implements zapcore.ObjectEncoder. The "context" object added by
+// the *Ctx methods is split into the ECS fields of ECSContextFields and a
+// "context" object with the remaining values.
+func (enc *ecsEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	if key != "context" {
+		return enc.Encoder.AddObject(key, obj)
+	}
+
+	moved, rest, err := splitContext(obj, func(ctxKey string) (string, bool) {
+		field, ok := ECSContextFields[normalizeContextKey(ctxKey)]
+		return field, ok
+	})
+	if err != nil {
+		return err
+	}
+	for _, m := range moved {
+		if err := enc.Encoder.AddReflected(m.key, m.value); err != nil {
+			return err
+		}
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	return enc.Encoder.AddReflected(key, rest)
+}
@@ -0,0 +1,94 @@
+package zap_logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withECSLogger(opts []Option, f func(*Logger, *bytes.Buffer)) {
+	buf := &bytes.Buffer{}
+	core := zapcore.NewCore(NewECSEncoder(NewECSEncoderConfig()), zapcore.AddSync(buf), zap.DebugLevel)
+	f(New(core, Config{}, append([]Option{AddCaller()}, opts...)...), buf)
+}
+
+func decodeECS(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record), "Expected a JSON record.")
+	return record
+}
+
+func TestECSEncoder(t *testing.T) {
+	withECSLogger([]Option{ECSService("checkout", "1.2.3")}, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Named("http").Error("failed", zap.Error(errors.New("boom")))
+
+		record := decodeECS(t, buf)
+		assert.Contains(t, record, "@timestamp")
+		assert.Equal(t, "error", record["log.level"])
+		assert.Equal(t, "http", record["log.logger"])
+		assert.Equal(t, "failed", record["message"])
+		assert.Equal(t, ECSVersion, record["ecs.version"])
+		assert.Equal(t, "checkout", record["service.name"])
+		assert.Equal(t, "1.2.3", record["service.version"])
+		assert.Equal(t, map[string]interface{}{"message": "boom"}, record["error"])
+
+		origin, ok := record["log.origin"].(map[string]interface{})
+		require.True(t, ok, "Expected log.origin to be an object.")
+		file := origin["file"].(map[string]interface{})
+		assert.Equal(t, "module/ecs_encoder_test.go", file["name"])
+		assert.NotZero(t, file["line"])
+	})
+}
+
+func TestECSEncoderStacktrace(t *testing.T) {
+	withECSLogger([]Option{AddStacktrace(zap.ErrorLevel)}, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Error("failed")
+		stack, ok := decodeECS(t, buf)["error.stack_trace"].(string)
+		require.True(t, ok, "Expected error.stack_trace.")
+		assert.Contains(t, stack, "TestECSEncoderStacktrace")
+	})
+}
+
+func TestECSEncoderContext(t *testing.T) {
+	type ctxKey string
+	const (
+		traceID ctxKey = "trace_id"
+		spanID  ctxKey = "SpanID"
+		tenant  ctxKey = "tenant"
+	)
+
+	ctx := context.WithValue(context.TODO(), traceID, "4bf92f3577b34da6")
+	ctx = context.WithValue(ctx, spanID, "00f067aa0ba902b7")
+	ctx = context.WithValue(ctx, tenant, "acme")
+	fieldOpts := opts(AddContext(func(ctx context.Context, log *ZapLogger) {
+		log.Ctx.Set(traceID, ctx)
+		log.Ctx.Set(spanID, ctx)
+		log.Ctx.Set(tenant, ctx)
+	}))
+
+	withECSLogger(fieldOpts, func(logger *Logger, buf *bytes.Buffer) {
+		logger.InfoCtx(ctx, "ctx")
+
+		record := decodeECS(t, buf)
+		assert.Equal(t, "4bf92f3577b34da6", record["trace.id"])
+		assert.Equal(t, "00f067aa0ba902b7", record["span.id"])
+		rest, ok := record["context"].(map[string]interface{})
+		require.True(t, ok, "Expected unmapped values to stay in context.")
+		assert.Len(t, rest, 1)
+		assert.Contains(t, rest, "zap_logger.ctxKey.tenant")
+	})
+}
+
+func TestNormalizeContextKey(t *testing.T) {
+	for _, key := range []string{"traceid", "pkg.key.traceID", "pkg.key.trace_id", "trace-id"} {
+		assert.Equal(t, "traceid", normalizeContextKey(key), "Unexpected normalized key for %q.", key)
+	}
+}
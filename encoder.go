@@ -0,0 +1,132 @@
+package zap_logger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	errNoEncoderNameSpecified = errors.New("no encoder name specified")
+
+	_encoderNameToConstructor = map[string]func(zapcore.EncoderConfig) (zapcore.Encoder, error){
+		"console": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return zapcore.NewConsoleEncoder(encoderConfig), nil
+		},
+		"json": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return zapcore.NewJSONEncoder(encoderConfig), nil
+		},
+		"logfmt": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return NewLogfmtEncoder(encoderConfig), nil
+		},
+		"ecs": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return NewECSEncoder(encoderConfig), nil
+		},
+		"gcp": newGCPEncoderFromEnv,
+		"pretty": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return NewPrettyEncoder(encoderConfig), nil
+		},
+		"cbor": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return NewCBOREncoder(encoderConfig), nil
+		},
+		"msgpack": func(encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+			return NewMsgpackEncoder(encoderConfig), nil
+		},
+	}
+	_encoderMutex sync.RWMutex
+)
+
+// RegisterEncoder registers an encoder constructor, which the Config struct
+// can then reference. By default, the "json", "console", "logfmt", "ecs",
+// "gcp", "pretty", "cbor" and "msgpack" encoders are registered.
+//
+// Attempting to register an encoder whose name is already taken returns an
+// error.
+func RegisterEncoder(name string, constructor func(zapcore.EncoderConfig) (zapcore.Encoder, error)) error {
+	_encoderMutex.Lock()
+	defer _encoderMutex.Unlock()
+	if name == "" {
+		return errNoEncoderNameSpecified
+	}
+	if _, ok := _encoderNameToConstructor[name]; ok {
+		return fmt.Errorf("encoder already registered for name %q", name)
+	}
+	_encoderNameToConstructor[name] = constructor
+	return nil
+}
+
+func newEncoder(name string, encoderConfig zapcore.EncoderConfig) (zapcore.Encoder, error) {
+	_encoderMutex.RLock()
+	defer _encoderMutex.RUnlock()
+	if name == "" {
+		return nil, errNoEncoderNameSpecified
+	}
+	constructor, ok := _encoderNameToConstructor[name]
+	if !ok {
+		return nil, fmt.Errorf("no encoder registered for name %q", name)
+	}
+	return constructor(encoderConfig)
+}
+
+func isRegisteredEncoder(name string) bool {
+	_encoderMutex.RLock()
+	defer _encoderMutex.RUnlock()
+	_, ok := _encoderNameToConstructor[name]
+	return ok
+}
+
+// movedValue is a context value an encoder logs under its own key.
+type movedValue struct {
+	key   string
+	value interface{}
+}
+
+// splitContext marshals the "context" object added by the *Ctx methods and
+// splits its values using field, which returns the key a value should be
+// logged under instead. Moved values are returned bare, without their caller,
+// in context key order; the others are returned as logged.
+func splitContext(obj zapcore.ObjectMarshaler, field func(ctxKey string) (string, bool)) ([]movedValue, map[string]interface{}, error) {
+	values := zapcore.NewMapObjectEncoder()
+	if err := obj.MarshalLogObject(values); err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]string, 0, len(values.Fields))
+	for ctxKey := range values.Fields {
+		keys = append(keys, ctxKey)
+	}
+	sort.Strings(keys)
+
+	var moved []movedValue
+	rest := make(map[string]interface{}, len(values.Fields))
+	for _, ctxKey := range keys {
+		v := values.Fields[ctxKey]
+		key, ok := field(ctxKey)
+		if !ok {
+			rest[ctxKey] = v
+			continue
+		}
+		// Context values are logged as {"caller":...,"value":...}.
+		if m, ok := v.(map[string]interface{}); ok {
+			if v, ok = m["value"]; !ok {
+				continue
+			}
+		}
+		moved = append(moved, movedValue{key: key, value: v})
+	}
+	return moved, rest, nil
+}
+
+// normalizeContextKey reduces a context key like "pkg.ctxKey.Trace_ID" to
+// "traceid", so encoders can match context values by name.
+func normalizeContextKey(key string) string {
+	if i := strings.LastIndexByte(key, '.'); i >= 0 {
+		key = key[i+1:]
+	}
+	key = strings.ToLower(key)
+	return strings.NewReplacer("_", "", "-", "").Replace(key)
+}
@@ -0,0 +1,135 @@
+package zap_logger
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.uber.org/multierr"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/hinha/zap-logger/pkg/cryptlog"
+)
+
+const megabyte = 1024 * 1024
+
+// EncryptionConfig encrypts the log file with AES-256-GCM, see package
+// cryptlog. Every file, including the backups made by rotation, gets its own
+// data key sealed with the master key. cmd/zapcrypt decrypts the files.
+type EncryptionConfig struct {
+	// Key provides the master key, e.g. cryptlog.FileKey("/etc/app/log.key")
+	// or cryptlog.EnvKey("APP_LOG_KEY").
+	Key cryptlog.KeySource
+	// ChunkSize is the largest plaintext of a frame. It defaults to
+	// cryptlog.DefaultChunkSize.
+	ChunkSize int
+}
+
+// rotator is the file sink rotated by Sync.
+type rotator interface {
+	Rotate() error
+}
+
+// logFile is the lumberjack file of the file sink, or a meteredFile in front
+// of it.
+type logFile interface {
+	io.WriteCloser
+	rotator
+}
+
+// encryptedFile encrypts writes to a lumberjack file. It rotates the file
+// itself, before lumberjack would, so that each file starts with a new data
+// key and ends with the final frame of the previous one.
+type encryptedFile struct {
+	file logFile
+	max  int64
+
+	mu   sync.Mutex
+	w    *cryptlog.Writer
+	size int64
+}
+
+// newEncryptedFile returns an encryptedFile writing to file, which is lg or
+// wraps it.
+func newEncryptedFile(lg *lumberjack.Logger, file logFile, c *EncryptionConfig) (*encryptedFile, error) {
+	f := &encryptedFile{file: file, max: maxFileSize(lg)}
+	w, err := cryptlog.NewWriter(file, c.Key, c.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	f.w = w
+
+	name := lumberjackFilename(lg)
+	if !cryptlog.IsEncrypted(name) {
+		// Don't append encrypted records to a plain text file.
+		if err := file.Rotate(); err != nil {
+			return nil, err
+		}
+	} else if info, err := os.Stat(name); err == nil {
+		f.size = info.Size()
+	}
+	return f, nil
+}
+
+// Write encrypts p and writes it to the file, rotating it first if p and
+// the final frame wouldn't fit.
+func (f *encryptedFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := int64(f.w.Size(len(p)))
+	if f.size > 0 && f.size+n+cryptlog.FinalFrameSize >= f.max {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+		n = int64(f.w.Size(len(p)))
+	}
+	if _, err := f.w.Write(p); err != nil {
+		return 0, err
+	}
+	f.size += n
+	return len(p), nil
+}
+
+// Rotate moves the file to a backup and starts a new data key.
+func (f *encryptedFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rotate()
+}
+
+func (f *encryptedFile) rotate() error {
+	if err := f.w.Close(); err != nil {
+		return err
+	}
+	if err := f.file.Rotate(); err != nil {
+		return err
+	}
+	f.size = 0
+	return nil
+}
+
+// Close writes the final frame of the current data key and closes the file.
+// Later writes reopen it with a new data key.
+func (f *encryptedFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return multierr.Append(f.w.Close(), f.file.Close())
+}
+
+// maxFileSize returns the size at which lumberjack rotates file.
+func maxFileSize(file *lumberjack.Logger) int64 {
+	if file.MaxSize == 0 {
+		// lumberjack's default.
+		return 100 * megabyte
+	}
+	return int64(file.MaxSize) * megabyte
+}
+
+// lumberjackFilename returns the name of the file lumberjack writes.
+func lumberjackFilename(file *lumberjack.Logger) string {
+	if file.Filename == "" {
+		return filepath.Join(os.TempDir(), filepath.Base(os.Args[0])+"-lumberjack.log")
+	}
+	return file.Filename
+}
@@ -0,0 +1,85 @@
+package zap_logger
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/hinha/zap-logger/pkg/cryptlog"
+)
+
+func decryptLog(t *testing.T, name string, key []byte) string {
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	r, err := cryptlog.NewReader(f, key)
+	require.NoError(t, err)
+	plain, err := io.ReadAll(r)
+	require.NoError(t, err, "Unexpected error decrypting %s.", name)
+	return string(plain)
+}
+
+func TestEncryptedFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "app.log")
+	require.NoError(t, os.WriteFile(filename, []byte(`{"msg":"plain text"}`+"\n"), 0o600))
+	key := bytes.Repeat([]byte{7}, 32)
+
+	lj := &lumberjack.Logger{Filename: filename, MaxSize: 1}
+	defer lj.Close()
+	var rotations uint64
+	f, err := newEncryptedFile(lj, newMeteredFile(lj, lj, &rotations), &EncryptionConfig{Key: cryptlog.StaticKey(key)})
+	require.NoError(t, err, "Unexpected error creating the encrypted file.")
+
+	var want strings.Builder
+	record := strings.Repeat("x", 100<<10)
+	for i := 0; i < 25; i++ {
+		line := fmt.Sprintf(`{"n":%d,"pad":"%s"}`+"\n", i, record)
+		want.WriteString(line)
+		_, err := f.Write([]byte(line))
+		require.NoError(t, err, "Unexpected error writing.")
+	}
+	require.NoError(t, f.Rotate())
+	_, err = f.Write([]byte("after rotate\n"))
+	require.NoError(t, err)
+	want.WriteString("after rotate\n")
+	require.NoError(t, f.Close(), "Unexpected error closing the file.")
+
+	backups, err := filepath.Glob(filepath.Join(dir, "app-*.log"))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(backups), 4, "Expected the plain file and the encrypted ones to be rotated.")
+	assert.Equal(t, uint64(len(backups)), rotations, "Expected every rotation to be counted, the plain text file's too.")
+
+	// Backup names sort by rotation time; the first is the plain text file.
+	plain, err := os.ReadFile(backups[0])
+	require.NoError(t, err)
+	assert.Equal(t, `{"msg":"plain text"}`+"\n", string(plain), "Expected the plain text file to be moved aside.")
+
+	var got strings.Builder
+	for _, name := range append(backups[1:], filename) {
+		info, err := os.Stat(name)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, info.Size(), int64(megabyte), "Expected %s to stay within MaxSize.", name)
+		got.WriteString(decryptLog(t, name, key))
+	}
+	assert.Equal(t, want.String(), got.String(), "Expected every file to decrypt on its own, in order.")
+
+	// Reopening appends to the encrypted file with a new data key.
+	f, err = newEncryptedFile(lj, lj, &EncryptionConfig{Key: cryptlog.StaticKey(key)})
+	require.NoError(t, err)
+	_, err = f.Write([]byte("restarted\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, "after rotate\nrestarted\n", decryptLog(t, filename, key))
+
+	_, err = newEncryptedFile(lj, lj, &EncryptionConfig{Key: cryptlog.EnvKey("ZAP_LOGGER_TEST_UNSET_KEY")})
+	assert.Error(t, err, "Expected an error without a key.")
+}
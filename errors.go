@@ -0,0 +1,190 @@
+package zap_logger
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/buffer"
+)
+
+// maxErrorChain bounds the depth and the number of causes walked by
+// ErrorChain.
+const maxErrorChain = 32
+
+// ErrorChain is like zap.Error, but it also logs the causes err wraps and
+// the stack trace recorded where it was created:
+//
+//	"error": {
+//		"message": "save user: connection refused",
+//		"type": "*fmt.wrapError",
+//		"causes": [{"message": "connection refused", "type": "*errors.fundamental"}],
+//		"stacktrace": "main.connect\n\t/app/db.go:42\n..."
+//	}
+//
+// Causes are found with Unwrap() error and Unwrap() []error, depth first.
+// The stack trace is the one of the innermost error with a StackTrace method,
+// as added by github.com/pkg/errors.
+func ErrorChain(err error) zap.Field {
+	return NamedErrorChain("error", err)
+}
+
+// NamedErrorChain is ErrorChain with a key other than "error".
+func NamedErrorChain(key string, err error) zap.Field {
+	if err == nil {
+		return zap.Skip()
+	}
+	return zap.Object(key, errorChain{err})
+}
+
+type stackTracer interface {
+	StackTrace() errors.StackTrace
+}
+
+type errorChain struct {
+	err error
+}
+
+// cause is an error of the chain, below the logged one.
+type cause struct {
+	err error
+}
+
+func (c cause) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("message", errorMessage(c.err))
+	enc.AddString("type", fmt.Sprintf("%T", c.err))
+	return nil
+}
+
+type causes []cause
+
+func (cs causes) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, c := range cs {
+		if err := enc.AppendObject(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e errorChain) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("message", errorMessage(e.err))
+	enc.AddString("type", fmt.Sprintf("%T", e.err))
+
+	var (
+		chain   causes
+		tracer  stackTracer
+		visited = make(map[error]bool)
+		seen    int
+	)
+	if st, ok := e.err.(stackTracer); ok {
+		tracer = st
+	}
+	var walk func(parent error, depth int)
+	walk = func(parent error, depth int) {
+		if depth == maxErrorChain {
+			return
+		}
+		for _, err := range unwrap(parent) {
+			if err == nil || seen == maxErrorChain {
+				return
+			}
+			seen++
+			// Errors unwrapping to themselves, or to an error already seen,
+			// would be walked forever.
+			if reflect.TypeOf(err).Comparable() {
+				if visited[err] {
+					continue
+				}
+				visited[err] = true
+			}
+			if st, ok := err.(stackTracer); ok {
+				tracer = st
+			}
+			// Skip wrappers that only add a stack trace.
+			if errorMessage(err) != errorMessage(parent) {
+				chain = append(chain, cause{err})
+			}
+			walk(err, depth+1)
+		}
+	}
+	if reflect.TypeOf(e.err).Comparable() {
+		visited[e.err] = true
+	}
+	walk(e.err, 0)
+
+	if len(chain) > 0 {
+		if err := enc.AddArray("causes", chain); err != nil {
+			return err
+		}
+	}
+	if tracer != nil {
+		if s := formatErrorStack(tracer.StackTrace()); s != "" {
+			enc.AddString("stacktrace", s)
+		}
+	}
+	return nil
+}
+
+// errorMessage returns err.Error(), or "<nil>" for a nil pointer whose
+// Error method panics, as zap.Error does.
+func errorMessage(err error) (msg string) {
+	defer func() {
+		if r := recover(); r != nil {
+			if v := reflect.ValueOf(err); v.Kind() == reflect.Ptr && v.IsNil() {
+				msg = "<nil>"
+				return
+			}
+			msg = fmt.Sprintf("PANIC=%v", r)
+		}
+	}()
+	return err.Error()
+}
+
+// unwrap returns the errors err wraps, or none if Unwrap panics on a nil
+// pointer.
+func unwrap(err error) (errs []error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errs = nil
+		}
+	}()
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		return e.Unwrap()
+	case interface{ Unwrap() error }:
+		if cause := e.Unwrap(); cause != nil {
+			return []error{cause}
+		}
+	}
+	return nil
+}
+
+// formatErrorStack formats a pkg/errors stack trace like the stack traces of
+// log entries.
+func formatErrorStack(trace errors.StackTrace) string {
+	if len(trace) == 0 {
+		return ""
+	}
+	pcs := make([]uintptr, len(trace))
+	for i, f := range trace {
+		// A Frame is the program counter runtime.Callers returned.
+		pcs[i] = uintptr(f)
+	}
+	stack := newStacktrace(pcs)
+	defer stack.Free()
+
+	buffer := buffer.Get()
+	defer buffer.Free()
+
+	stackfmt := newStackFormatter(buffer)
+	frame, more := stack.Next()
+	stackfmt.FormatFrame(frame)
+	if more {
+		stackfmt.FormatStack(stack)
+	}
+	return buffer.String()
+}
@@ -0,0 +1,101 @@
+package zap_logger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type multiError []error
+
+func (m multiError) Error() string   { return "multiple errors" }
+func (m multiError) Unwrap() []error { return m }
+
+type selfError struct{ msg string }
+
+func (e *selfError) Error() string { return e.msg }
+func (e *selfError) Unwrap() error { return e }
+
+func newRootError() error {
+	return pkgerrors.New("connection refused")
+}
+
+func TestErrorChain(t *testing.T) {
+	root := newRootError()
+	err := fmt.Errorf("save user: %w", pkgerrors.Wrap(root, "dial"))
+
+	enc := zapcore.NewMapObjectEncoder()
+	ErrorChain(err).AddTo(enc)
+	m, ok := enc.Fields["error"].(map[string]interface{})
+	require.True(t, ok, "Expected an error object, have %v.", enc.Fields)
+
+	assert.Equal(t, "save user: dial: connection refused", m["message"])
+	assert.Equal(t, "*fmt.wrapError", m["type"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"message": "dial: connection refused", "type": "*errors.withStack"},
+		map[string]interface{}{"message": "connection refused", "type": "*errors.fundamental"},
+	}, m["causes"], "Expected the wrappers adding only a stack to be skipped.")
+
+	stack, _ := m["stacktrace"].(string)
+	lines := strings.Split(stack, "\n")
+	require.NotEmpty(t, lines)
+	assert.Equal(t, "github.com/hinha/zap-logger.newRootError", lines[0],
+		"Expected the stack of the innermost error.")
+	assert.NotContains(t, stack, "runtime.goexit")
+}
+
+func TestErrorChainJoined(t *testing.T) {
+	err := multiError{errors.New("first"), fmt.Errorf("second: %w", errors.New("inner"))}
+
+	enc := zapcore.NewMapObjectEncoder()
+	NamedErrorChain("failure", err).AddTo(enc)
+	m := enc.Fields["failure"].(map[string]interface{})
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"message": "first", "type": "*errors.errorString"},
+		map[string]interface{}{"message": "second: inner", "type": "*fmt.wrapError"},
+		map[string]interface{}{"message": "inner", "type": "*errors.errorString"},
+	}, m["causes"])
+	assert.NotContains(t, m, "stacktrace")
+
+	assert.Equal(t, zap.Skip(), ErrorChain(nil))
+}
+
+func TestErrorChainSelfUnwrap(t *testing.T) {
+	err := fmt.Errorf("outer: %w", &selfError{"loop"})
+
+	enc := zapcore.NewMapObjectEncoder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ErrorChain(err).AddTo(enc)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the walk of a self-unwrapping error to terminate.")
+	}
+	m := enc.Fields["error"].(map[string]interface{})
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"message": "loop", "type": "*zap_logger.selfError"},
+	}, m["causes"])
+}
+
+func TestErrorChainTypedNilCause(t *testing.T) {
+	var cause *nilError
+	err := fmt.Errorf("outer: %w", cause)
+
+	enc := zapcore.NewMapObjectEncoder()
+	ErrorChain(err).AddTo(enc)
+	m := enc.Fields["error"].(map[string]interface{})
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"message": "<nil>", "type": "*zap_logger.nilError"},
+	}, m["causes"])
+}
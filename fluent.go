@@ -0,0 +1,397 @@
+package zap_logger
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	zapbuffer "go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/buffer"
+	"github.com/hinha/zap-logger/pkg/logdecode"
+	"github.com/hinha/zap-logger/pkg/netsink"
+	"github.com/hinha/zap-logger/pkg/wal"
+)
+
+// FluentMode selects how the Fluent output sends buffered records, see
+// https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1.
+type FluentMode int
+
+const (
+	// FluentForward sends [tag, [[time, record], ...], option] messages.
+	FluentForward FluentMode = iota
+	// FluentPackedForward sends the entries as one binary string, which
+	// the server can pass on without decoding them.
+	FluentPackedForward
+)
+
+// Defaults used when a FluentConfig leaves the field unset.
+const (
+	DefaultFluentTag           = "zap"
+	DefaultFluentBufferSize    = 256 << 10
+	DefaultFluentMaxBufferSize = 8 << 20
+	DefaultFluentFlushInterval = time.Second
+)
+
+var _fluentPool = zapbuffer.NewPool()
+
+var errFluentBufferFull = errors.New("fluent: buffer full, record dropped")
+
+// FluentConfig configures the Fluentd or Fluent Bit forward output.
+type FluentConfig struct {
+	// Network is "tcp", "tcp+tls" or "unix". It defaults to "tcp".
+	Network string
+	// Address is the forward input's host:port or socket path.
+	Address string
+	// TLS configures "tcp+tls" connections.
+	TLS *tls.Config
+	// Tag is the tag of records; the name of the logger, if any, is appended
+	// to it after a dot. It defaults to DefaultFluentTag.
+	Tag string
+	// Mode selects Forward or PackedForward messages.
+	Mode FluentMode
+	// RequireAck asks the server to acknowledge each message, which is sent
+	// again on a new connection if the acknowledgement doesn't arrive.
+	RequireAck bool
+	// Records are buffered per tag and sent when BufferSize bytes are
+	// buffered or every FlushInterval. While the server is unreachable up to
+	// MaxBufferSize bytes are kept and newer records are dropped.
+	BufferSize    int
+	MaxBufferSize int
+	FlushInterval time.Duration
+	// WAL, if set, keeps records in a write-ahead log on disk between the
+	// diode and the connection until they are sent, so they survive an
+	// unreachable server or a restart. See package wal.
+	WAL *wal.Options
+	// DiodeSize is the number of records held while the server is slow or
+	// unreachable, DefaultDiodeSize if zero.
+	DiodeSize int
+}
+
+// NewFluentEncoder creates an encoder writing each entry as a Fluent forward
+// message in Message mode, [tag, time, record]. The record is a MessagePack
+// map encoded like the "msgpack" encoding, but without the time, which is
+// sent as an EventTime with nanoseconds. The tag is tag followed by the
+// logger name.
+func NewFluentEncoder(cfg zapcore.EncoderConfig, tag string) zapcore.Encoder {
+	if tag == "" {
+		tag = DefaultFluentTag
+	}
+	cfg.TimeKey = ""
+	return &fluentEncoder{binaryEncoder: newBinaryEncoder(cfg, msgpackFormat{}), tag: tag}
+}
+
+type fluentEncoder struct {
+	*binaryEncoder
+	tag string
+}
+
+// Clone implements zapcore.Encoder.
+func (enc *fluentEncoder) Clone() zapcore.Encoder {
+	return &fluentEncoder{binaryEncoder: enc.binaryEncoder.Clone().(*binaryEncoder), tag: enc.tag}
+}
+
+// EncodeEntry implements zapcore.Encoder.
+func (enc *fluentEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*zapbuffer.Buffer, error) {
+	record, err := enc.binaryEncoder.EncodeEntry(ent, fields)
+	if err != nil {
+		return nil, err
+	}
+	defer record.Free()
+
+	buf := buffer.Get()
+	defer buf.Free()
+	tag := enc.tag
+	if ent.LoggerName != "" {
+		tag += "." + ent.LoggerName
+	}
+	buf.AppendByte(0x93)
+	msgpackFormat{}.appendString(buf, tag)
+	appendEventTime(buf, ent.Time)
+	// Drop the length prefix of the msgpack record.
+	buf.Write(record.Bytes()[4:])
+
+	out := _fluentPool.Get()
+	out.Write(buf.Bytes())
+	return out, nil
+}
+
+// appendEventTime writes t as the EventTime extension: type 0 holding the
+// seconds and nanoseconds as 32-bit big-endian integers.
+func appendEventTime(buf *buffer.Buffer, t time.Time) {
+	buf.AppendByte(0xd7)
+	buf.AppendByte(0x00)
+	appendBigEndian(buf, uint64(t.Unix()), 4)
+	appendBigEndian(buf, uint64(t.Nanosecond()), 4)
+}
+
+// fluentWriter buffers the messages written by the Fluent encoder per tag
+// and sends them in Forward or PackedForward mode.
+type fluentWriter struct {
+	w             *netsink.Writer
+	mode          FluentMode
+	ack           bool
+	bufferSize    int
+	maxBufferSize int
+
+	mu      sync.Mutex
+	chunks  map[string]*fluentChunk
+	tags    []string
+	size    int
+	closed  bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// fluentChunk holds the [time, record] entries of a tag.
+type fluentChunk struct {
+	entries []byte
+	n       int
+}
+
+// writer returns the connection to the forward input and starts the
+// goroutine flushing it.
+func (c *FluentConfig) writer() (*fluentWriter, error) {
+	network := c.Network
+	if network == "" {
+		network = "tcp"
+	}
+	w, err := netsink.New(netsink.Config{
+		Network: network,
+		Address: c.Address,
+		TLS:     c.TLS,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fw := &fluentWriter{
+		w:             w,
+		mode:          c.Mode,
+		ack:           c.RequireAck,
+		bufferSize:    c.BufferSize,
+		maxBufferSize: c.MaxBufferSize,
+		chunks:        make(map[string]*fluentChunk),
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	if fw.bufferSize <= 0 {
+		fw.bufferSize = DefaultFluentBufferSize
+	}
+	if fw.maxBufferSize < fw.bufferSize {
+		fw.maxBufferSize = DefaultFluentMaxBufferSize
+	}
+	interval := c.FlushInterval
+	if interval <= 0 {
+		interval = DefaultFluentFlushInterval
+	}
+	go fw.run(interval)
+	return fw, nil
+}
+
+// Write buffers a message written by the Fluent encoder.
+func (w *fluentWriter) Write(p []byte) (int, error) {
+	tag, entry, err := splitFluentMessage(p)
+	if err != nil {
+		return 0, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, net.ErrClosed
+	}
+	if w.size+len(entry) > w.maxBufferSize {
+		return 0, errFluentBufferFull
+	}
+	c, ok := w.chunks[tag]
+	if !ok {
+		c = &fluentChunk{}
+		w.chunks[tag] = c
+		w.tags = append(w.tags, tag)
+	}
+	c.entries = append(c.entries, entry...)
+	c.n++
+	w.size += len(entry)
+	if w.size >= w.bufferSize {
+		// Failures keep the records buffered for the next flush.
+		_ = w.flush()
+	}
+	return len(p), nil
+}
+
+// splitFluentMessage returns the tag and the [time, record] entry of a
+// Message mode message.
+func splitFluentMessage(p []byte) (string, []byte, error) {
+	if len(p) < 2 || p[0] != 0x93 {
+		return "", nil, errors.New("fluent: not a forward message")
+	}
+	var n, off int
+	switch b := p[1]; {
+	case b&0xe0 == msgpackFixStr:
+		n, off = int(b&0x1f), 2
+	case b == msgpackStr8 && len(p) > 2:
+		n, off = int(p[2]), 3
+	case b == msgpackStr16 && len(p) > 3:
+		n, off = int(binary.BigEndian.Uint16(p[2:])), 4
+	case b == msgpackStr32 && len(p) > 5:
+		n, off = int(binary.BigEndian.Uint32(p[2:])), 6
+	default:
+		return "", nil, errors.New("fluent: message without a tag")
+	}
+	if off+n >= len(p) {
+		return "", nil, errors.New("fluent: truncated message")
+	}
+	// The entry is the rest of the message with an array header of two.
+	entry := make([]byte, 0, len(p)-off-n+1)
+	entry = append(entry, 0x92)
+	entry = append(entry, p[off+n:]...)
+	return string(p[off : off+n]), entry, nil
+}
+
+// flush sends the buffered chunks, oldest tag first, and stops at the first
+// failure. w.mu must be held.
+func (w *fluentWriter) flush() error {
+	for len(w.tags) > 0 {
+		tag := w.tags[0]
+		c := w.chunks[tag]
+		if err := w.send(tag, c); err != nil {
+			return err
+		}
+		delete(w.chunks, tag)
+		w.tags = w.tags[1:]
+		w.size -= len(c.entries)
+	}
+	return nil
+}
+
+func (w *fluentWriter) send(tag string, c *fluentChunk) error {
+	buf := buffer.Get()
+	defer buf.Free()
+	f := msgpackFormat{}
+
+	buf.AppendByte(0x93)
+	f.appendString(buf, tag)
+	if w.mode == FluentPackedForward {
+		f.appendBytes(buf, c.entries)
+	} else {
+		f.openArray(buf)
+		f.closeArray(buf, buf.Len()-5, c.n)
+		buf.Write(c.entries)
+	}
+
+	var chunk string
+	if w.ack {
+		var id [16]byte
+		_, _ = rand.Read(id[:])
+		chunk = base64.StdEncoding.EncodeToString(id[:])
+		buf.AppendByte(0x82)
+		f.appendString(buf, "chunk")
+		f.appendString(buf, chunk)
+	} else {
+		buf.AppendByte(0x81)
+	}
+	f.appendString(buf, "size")
+	f.appendInt(buf, int64(c.n))
+
+	if !w.ack {
+		_, err := w.w.Write(buf.Bytes())
+		return err
+	}
+	return w.w.Exchange(buf.Bytes(), func(r io.Reader) error {
+		return readFluentAck(r, chunk)
+	})
+}
+
+// readFluentAck reads the {"ack": chunk} response to a message.
+func readFluentAck(r io.Reader, chunk string) error {
+	var (
+		data []byte
+		b    [64]byte
+	)
+	for {
+		n, err := r.Read(b[:])
+		data = append(data, b[:n]...)
+		if js, _, derr := logdecode.DecodeValue(nil, data, logdecode.Msgpack); derr == nil {
+			var resp struct {
+				Ack string `json:"ack"`
+			}
+			if err := json.Unmarshal(js, &resp); err != nil || resp.Ack != chunk {
+				return fmt.Errorf("fluent: unexpected ack %q", js)
+			}
+			return nil
+		} else if !errors.Is(derr, logdecode.ErrUnexpectedEnd) {
+			return derr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (w *fluentWriter) run(interval time.Duration) {
+	defer close(w.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = w.Sync()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// Sync sends the buffered records.
+func (w *fluentWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush()
+}
+
+// Close sends the buffered records and closes the connection.
+func (w *fluentWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.stopped
+	w.mu.Lock()
+	err := w.flush()
+	w.mu.Unlock()
+	if cerr := w.w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// core returns a core sending records to the forward input through a
+// diode, and the diode to close when the logger is closed. encCfg
+// configures the records.
+func (c *FluentConfig) core(encCfg zapcore.EncoderConfig, lvl zap.AtomicLevel, interval time.Duration, o *sinkObserver) (zapcore.Core, io.Closer, error) {
+	w, err := c.writer()
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := newNetworkDiode(w, c.WAL, c.DiodeSize, interval, "fluent", o)
+	if err != nil {
+		return nil, nil, err
+	}
+	return zapcore.NewCore(NewFluentEncoder(encCfg, c.Tag), zapcore.AddSync(d), lvl), d, nil
+}
@@ -0,0 +1,217 @@
+package zap_logger
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hinha/zap-logger/pkg/logdecode"
+)
+
+// fakeForward is an in-process Fluent forward input. It decodes the
+// messages it receives to JSON and acknowledges those asking for it, except
+// on the first dropAcks connections, which are closed instead.
+type fakeForward struct {
+	ln       net.Listener
+	messages chan []interface{}
+	dropAcks int
+	done     chan struct{}
+}
+
+func newFakeForward(t *testing.T, dropAcks int) *fakeForward {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen.")
+	f := &fakeForward{ln: ln, messages: make(chan []interface{}, 100), dropAcks: dropAcks, done: make(chan struct{})}
+	go f.serve()
+	return f
+}
+
+func (f *fakeForward) serve() {
+	defer close(f.done)
+	for conn := 0; ; conn++ {
+		c, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.handle(c, conn < f.dropAcks)
+	}
+}
+
+func (f *fakeForward) handle(c net.Conn, dropAck bool) {
+	defer c.Close()
+	var (
+		data []byte
+		b    = make([]byte, 4096)
+	)
+	for {
+		n, err := c.Read(b)
+		data = append(data, b[:n]...)
+		for {
+			js, used, derr := logdecode.DecodeValue(nil, data, logdecode.Msgpack)
+			if derr != nil {
+				break
+			}
+			data = data[used:]
+			var msg []interface{}
+			if json.Unmarshal(js, &msg) != nil {
+				return
+			}
+			f.messages <- msg
+			option, _ := msg[len(msg)-1].(map[string]interface{})
+			if chunk, ok := option["chunk"].(string); ok {
+				if dropAck {
+					return
+				}
+				ack := []byte{0x81, 0xa3, 'a', 'c', 'k', byte(0xa0 | len(chunk))}
+				if _, err := c.Write(append(ack, chunk...)); err != nil {
+					return
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeForward) close() {
+	f.ln.Close()
+	<-f.done
+}
+
+func (f *fakeForward) next(t *testing.T) []interface{} {
+	select {
+	case msg := <-f.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a forward message.")
+		return nil
+	}
+}
+
+// fluentEntries returns the [time, record] entries of a Forward or
+// PackedForward message.
+func fluentEntries(t *testing.T, msg []interface{}) []interface{} {
+	switch entries := msg[1].(type) {
+	case []interface{}:
+		return entries
+	case string:
+		packed, err := base64.StdEncoding.DecodeString(entries)
+		require.NoError(t, err, "Expected packed entries.")
+		var out []interface{}
+		for len(packed) > 0 {
+			js, n, err := logdecode.DecodeValue(nil, packed, logdecode.Msgpack)
+			require.NoError(t, err, "Failed to decode a packed entry.")
+			var entry interface{}
+			require.NoError(t, json.Unmarshal(js, &entry))
+			out = append(out, entry)
+			packed = packed[n:]
+		}
+		return out
+	}
+	t.Fatalf("Unexpected entries %v.", msg[1])
+	return nil
+}
+
+func TestFluentEncoder(t *testing.T) {
+	enc := NewFluentEncoder(NewProductionEncoderConfig(), "app")
+	ts := time.Unix(1700000000, 123456789)
+	buf, err := enc.EncodeEntry(zapcore.Entry{Level: zapcore.WarnLevel, Time: ts, LoggerName: "db", Message: "slow"},
+		[]zap.Field{zap.Int("ms", 1200)})
+	require.NoError(t, err)
+	defer buf.Free()
+
+	js, n, err := logdecode.DecodeValue(nil, buf.Bytes(), logdecode.Msgpack)
+	require.NoError(t, err)
+	assert.Equal(t, buf.Len(), n, "Expected a single message.")
+	assert.JSONEq(t, `["app.db",{"type":0,"data":"ZVPxAAdbzRU="},{"level":"warn","logger":"db","msg":"slow","ms":1200}]`, string(js))
+
+	tag, entry, err := splitFluentMessage(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "app.db", tag)
+	assert.Equal(t, byte(0x92), entry[0], "Expected a [time, record] entry.")
+
+	_, _, err = splitFluentMessage([]byte{0x92, 0xa1, 'x'})
+	assert.Error(t, err, "Expected an error for a message that isn't [tag, time, record].")
+}
+
+func TestFluentForward(t *testing.T) {
+	for _, mode := range []FluentMode{FluentForward, FluentPackedForward} {
+		srv := newFakeForward(t, 0)
+		cfg := &FluentConfig{Address: srv.ln.Addr().String(), Tag: "app", Mode: mode, FlushInterval: time.Hour}
+		core, closer, err := cfg.core(NewProductionEncoderConfig(), zap.NewAtomicLevel(), time.Millisecond, nil)
+		require.NoError(t, err, "Unexpected error creating the Fluent core.")
+		logger := New(core, Config{}, addCloser(closer))
+
+		logger.Info("one")
+		logger.Named("http").Info("two")
+		logger.Info("three", zap.String("k", "v"))
+		require.NoError(t, logger.Close(), "Unexpected error closing the logger.")
+
+		msg := srv.next(t)
+		assert.Equal(t, "app", msg[0], "Unexpected tag.")
+		entries := fluentEntries(t, msg)
+		require.Len(t, entries, 2, "Expected the records of a tag in one message.")
+		assert.Equal(t, "one", entries[0].([]interface{})[1].(map[string]interface{})["msg"])
+		assert.Equal(t, "v", entries[1].([]interface{})[1].(map[string]interface{})["k"])
+		assert.Equal(t, map[string]interface{}{"size": float64(2)}, msg[2])
+
+		msg = srv.next(t)
+		assert.Equal(t, "app.http", msg[0], "Expected the logger name in the tag.")
+		assert.Len(t, fluentEntries(t, msg), 1)
+		srv.close()
+	}
+}
+
+func TestFluentAck(t *testing.T) {
+	srv := newFakeForward(t, 1)
+	defer srv.close()
+	cfg := &FluentConfig{Address: srv.ln.Addr().String(), RequireAck: true, FlushInterval: time.Hour}
+	core, closer, err := cfg.core(NewProductionEncoderConfig(), zap.NewAtomicLevel(), time.Millisecond, nil)
+	require.NoError(t, err, "Unexpected error creating the Fluent core.")
+	logger := New(core, Config{}, addCloser(closer))
+
+	logger.Info("acked")
+	require.NoError(t, logger.Close(), "Expected the message to be acknowledged on a new connection.")
+
+	first, second := srv.next(t), srv.next(t)
+	assert.Equal(t, first, second, "Expected the unacknowledged message to be sent again.")
+	assert.Equal(t, "zap", first[0], "Expected the default tag.")
+	assert.Contains(t, first[2], "chunk", "Expected a chunk ID.")
+}
+
+func TestFluentBuffering(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen.")
+	addr := ln.Addr().String()
+	ln.Close()
+
+	cfg := &FluentConfig{Address: addr, BufferSize: 1 << 10, MaxBufferSize: 2 << 10, FlushInterval: time.Hour}
+	w, err := cfg.writer()
+	require.NoError(t, err)
+	enc := NewFluentEncoder(NewProductionEncoderConfig(), "")
+
+	var dropped error
+	for i := 0; i < 100 && dropped == nil; i++ {
+		buf, err := enc.EncodeEntry(zapcore.Entry{Time: time.Now(), Message: "buffered while down"}, nil)
+		require.NoError(t, err)
+		_, dropped = w.Write(buf.Bytes())
+		buf.Free()
+	}
+	assert.Equal(t, errFluentBufferFull, dropped, "Expected records to be dropped once the buffer is full.")
+	assert.True(t, w.size > 1<<10 && w.size <= 2<<10, "Expected failed sends to keep records buffered, have %d bytes.", w.size)
+	assert.Error(t, w.Sync(), "Expected an error while the server is down.")
+	assert.Error(t, w.Close())
+
+	_, err = w.Write([]byte{0x93, 0xa1, 'x', 0xc0, 0x80})
+	assert.True(t, errors.Is(err, net.ErrClosed), "Expected writes to fail after Close.")
+}
@@ -0,0 +1,105 @@
+package zap_logger
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultFlushTimeout bounds the flush made before Fatal and Panic entries
+// terminate.
+const DefaultFlushTimeout = 5 * time.Second
+
+// FlushTimeout sets how long a Fatal, Panic or development DPanic entry waits
+// for the logger to be flushed before it exits or panics. Zero or a negative
+// duration skips the flush.
+func FlushTimeout(d time.Duration) Option {
+	return optionFunc(func(log *Logger) {
+		log.flushTimeout = d
+	})
+}
+
+// WithFatalHook sets the action taken once a Fatal entry is written and
+// flushed, such as zapcore.WriteThenGoexit. It must stop the control flow of
+// the caller; nil and zapcore.WriteThenNoop fall back to
+// zapcore.WriteThenFatal.
+func WithFatalHook(hook zapcore.CheckWriteHook) Option {
+	return optionFunc(func(log *Logger) {
+		log.onFatal = hook
+	})
+}
+
+// OnFatal is WithFatalHook for a zapcore.CheckWriteAction.
+func OnFatal(action zapcore.CheckWriteAction) Option {
+	return WithFatalHook(action)
+}
+
+// addSyncFile registers a log file to fsync before the logger terminates.
+func addSyncFile(name string) Option {
+	return optionFunc(func(log *Logger) {
+		log.syncFiles = append(log.syncFiles, name)
+	})
+}
+
+// terminal wraps the terminal action next so that it runs once the logger is
+// flushed.
+func (log *ZapLogger) terminal(next zapcore.CheckWriteHook) zapcore.CheckWriteHook {
+	if log.flushTimeout <= 0 {
+		return next
+	}
+	return flushHook{log: log, next: next}
+}
+
+// flushHook drains the cores, through their buffered syncers and diodes, and
+// fsyncs the log files before running the terminal action.
+type flushHook struct {
+	log  *ZapLogger
+	next zapcore.CheckWriteHook
+}
+
+func (h flushHook) OnWrite(ce *zapcore.CheckedEntry, fields []zapcore.Field) {
+	if err := h.log.flush(h.log.flushTimeout); err != nil {
+		fmt.Fprintf(h.log.errorOutput, "%v Logger flush error: %v\n", time.Now().UTC(), err)
+		h.log.errorOutput.Sync()
+	}
+	h.next.OnWrite(ce, fields)
+}
+
+// flush syncs the core and the log files, giving up after timeout. A sink
+// stuck past the timeout is left running, since the process is about to end.
+func (log *ZapLogger) flush(timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		err := log.core.Sync()
+		for _, name := range log.syncFiles {
+			err = multierr.Append(err, syncFile(name))
+		}
+		done <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("flush timed out after %v", timeout)
+	}
+}
+
+// syncFile commits the file to disk. lumberjack doesn't expose its handle,
+// but fsync applies to the file whichever descriptor it's called on.
+func syncFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
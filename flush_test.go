@@ -0,0 +1,80 @@
+package zap_logger
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+func runTerminal(f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	<-done
+}
+
+func TestFatalFlushes(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.log")
+	w, _, err := newWriter(filename, 0, 0, 0, false, time.Hour, nil, nil)
+	require.NoError(t, err)
+	defer w.(io.Closer).Close()
+
+	ws := &zapcore.BufferedWriteSyncer{WS: zapcore.AddSync(w), Size: bufferSize}
+	defer ws.Stop()
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(NewProductionEncoderConfig()), ws, zapcore.DebugLevel)
+	log := New(core, Config{}, addSyncFile(filename), WithFatalHook(zapcore.WriteThenGoexit))
+	log.Info("before")
+	runTerminal(func() { log.Fatal("last words") })
+
+	// The buffered syncer holds 128MiB and the diode polls hourly, so only
+	// the flush gets the entries to the file.
+	b, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 2, "Expected the entries to be flushed before exiting.")
+	assert.Contains(t, lines[1], `"msg":"last words"`)
+}
+
+// blockingCore is a core whose Sync hangs until unblocked.
+type blockingCore struct {
+	zapcore.Core
+	unblock chan struct{}
+}
+
+func (c blockingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c blockingCore) Sync() error {
+	<-c.unblock
+	return errors.New("late")
+}
+
+func TestFlushTimeout(t *testing.T) {
+	core := blockingCore{
+		Core:    zapcore.NewCore(zapcore.NewJSONEncoder(NewProductionEncoderConfig()), &Discarder{}, zapcore.DebugLevel),
+		unblock: make(chan struct{}),
+	}
+	defer close(core.unblock)
+	errOut := &strings.Builder{}
+	log := New(core, Config{}, FlushTimeout(10*time.Millisecond), ErrorOutput(zapcore.AddSync(errOut)),
+		OnFatal(zapcore.WriteThenGoexit))
+
+	start := time.Now()
+	runTerminal(func() { log.Fatal("stuck") })
+	assert.Less(t, time.Since(start), time.Second, "Expected the flush to give up.")
+	assert.Contains(t, errOut.String(), "flush timed out after 10ms")
+}
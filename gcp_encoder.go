@@ -0,0 +1,228 @@
+package zap_logger
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	zapbuffer "go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// Cloud Logging special fields, see
+// https://cloud.google.com/logging/docs/structured-logging.
+const (
+	gcpSourceLocationKey = "logging.googleapis.com/sourceLocation"
+	gcpTraceKey          = "logging.googleapis.com/trace"
+	gcpSpanIDKey         = "logging.googleapis.com/spanId"
+	gcpTraceSampledKey   = "logging.googleapis.com/trace_sampled"
+)
+
+// GCPContextFields maps context keys to the Cloud Logging fields their
+// values are logged under by the "gcp" encoder. Keys are matched like
+// ECSContextFields. Trace IDs are expanded to
+// projects/PROJECT_ID/traces/TRACE_ID when the project is known.
+var GCPContextFields = map[string]string{
+	"traceid":      gcpTraceKey,
+	"spanid":       gcpSpanIDKey,
+	"tracesampled": gcpTraceSampledKey,
+}
+
+// NewGCPEncoderConfig returns an EncoderConfig for Google Cloud Logging
+// structured logs, as read from standard output by Cloud Run, GKE and
+// App Engine. Use it with the "gcp" encoding.
+func NewGCPEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:        "time",
+		LevelKey:       "severity",
+		NameKey:        "logger",
+		CallerKey:      gcpSourceLocationKey,
+		FunctionKey:    zapcore.OmitKey,
+		MessageKey:     "message",
+		StacktraceKey:  "stack_trace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    gcpLevelEncoder,
+		EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeCaller:   gcpCallerEncoder,
+	}
+}
+
+// NewGCPConfig is a production configuration writing Cloud Logging
+// structured logs to standard output.
+func NewGCPConfig() Config {
+	config := NewProductionConfig()
+	config.Encoding = "console"
+	config.ConsoleEncoding = "gcp"
+	config.EncoderConfig = NewGCPEncoderConfig()
+	return config
+}
+
+// gcpLevelEncoder writes Cloud Logging severities. DPanic, Panic and Fatal
+// have no direct equivalent and map to CRITICAL, ALERT and EMERGENCY.
+func gcpLevelEncoder(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	switch l {
+	case zapcore.DebugLevel:
+		enc.AppendString("DEBUG")
+	case zapcore.InfoLevel:
+		enc.AppendString("INFO")
+	case zapcore.WarnLevel:
+		enc.AppendString("WARNING")
+	case zapcore.ErrorLevel:
+		enc.AppendString("ERROR")
+	case zapcore.DPanicLevel:
+		enc.AppendString("CRITICAL")
+	case zapcore.PanicLevel:
+		enc.AppendString("ALERT")
+	case zapcore.FatalLevel:
+		enc.AppendString("EMERGENCY")
+	default:
+		enc.AppendString("DEFAULT")
+	}
+}
+
+// gcpCallerEncoder writes the caller as a sourceLocation object. Encoders
+// that can't append objects get the short caller instead.
+func gcpCallerEncoder(caller zapcore.EntryCaller, enc zapcore.PrimitiveArrayEncoder) {
+	if arr, ok := enc.(zapcore.ArrayEncoder); ok {
+		_ = arr.AppendObject(gcpSourceLocation(caller))
+		return
+	}
+	zapcore.ShortCallerEncoder(caller, enc)
+}
+
+type gcpSourceLocation zapcore.EntryCaller
+
+func (l gcpSourceLocation) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("file", l.File)
+	// The LogEntrySourceLocation line is an int64, which is a string in JSON.
+	enc.AddString("line", strconv.Itoa(l.Line))
+	if l.Function != "" {
+		enc.AddString("function", l.Function)
+	}
+	return nil
+}
+
+// NewGCPEncoder creates a JSON encoder writing Cloud Logging structured
+// logs; pair it with NewGCPEncoderConfig. Context values listed in
+// GCPContextFields are moved to the special trace fields, with trace IDs
+// qualified by projectID. The "gcp" encoding reads the project from the
+// GOOGLE_CLOUD_PROJECT environment variable.
+func NewGCPEncoder(cfg zapcore.EncoderConfig, projectID string) zapcore.Encoder {
+	return &gcpEncoder{Encoder: zapcore.NewJSONEncoder(cfg), projectID: projectID}
+}
+
+func newGCPEncoderFromEnv(cfg zapcore.EncoderConfig) (zapcore.Encoder, error) {
+	return NewGCPEncoder(cfg, os.Getenv("GOOGLE_CLOUD_PROJECT")), nil
+}
+
+type gcpEncoder struct {
+	zapcore.Encoder
+	projectID string
+}
+
+// Clone implements zapcore.Encoder.
+func (enc *gcpEncoder) Clone() zapcore.Encoder {
+	return &gcpEncoder{Encoder: enc.Encoder.Clone(), projectID: enc.projectID}
+}
+
+// EncodeEntry implements zapcore.Encoder.
+func (enc *gcpEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*zapbuffer.Buffer, error) {
+	if !hasContextField(fields) {
+		return enc.Encoder.EncodeEntry(ent, fields)
+	}
+	final := enc.Clone().(*gcpEncoder)
+	for i := range fields {
+		fields[i].AddTo(final)
+	}
+	return final.Encoder.EncodeEntry(ent, nil)
+}
+
+func hasContextField(fields []zapcore.Field) bool {
+	for _, f := range fields {
+		if f.Key == "context" {
+			return true
+		}
+	}
+	return false
+}
+
+// AddObject implements zapcore.ObjectEncoder.
+func (enc *gcpEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	if key != "context" {
+		return enc.Encoder.AddObject(key, obj)
+	}
+
+	moved, rest, err := splitContext(obj, func(ctxKey string) (string, bool) {
+		field, ok := GCPContextFields[normalizeContextKey(ctxKey)]
+		return field, ok
+	})
+	if err != nil {
+		return err
+	}
+	for _, m := range moved {
+		if s, ok := m.value.(string); ok && m.key == gcpTraceKey && enc.projectID != "" {
+			m.value = "projects/" + enc.projectID + "/traces/" + s
+		}
+		if err := enc.Encoder.AddReflected(m.key, m.value); err != nil {
+			return err
+		}
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	return enc.Encoder.AddReflected(key, rest)
+}
+
+// HTTPRequest returns an httpRequest field describing a served request, which
+// Cloud Logging shows with the entry. Log it once the response is written.
+// A nil request is skipped.
+func HTTPRequest(r *http.Request, status int, responseSize int64, latency time.Duration) zap.Field {
+	if r == nil {
+		return zap.Skip()
+	}
+	return zap.Object("httpRequest", gcpHTTPRequest{
+		r:            r,
+		status:       status,
+		responseSize: responseSize,
+		latency:      latency,
+	})
+}
+
+type gcpHTTPRequest struct {
+	r            *http.Request
+	status       int
+	responseSize int64
+	latency      time.Duration
+}
+
+// MarshalLogObject writes the HttpRequest fields of the Cloud Logging API;
+// int64 sizes are strings and the latency is a duration like "0.25s".
+func (h gcpHTTPRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	r := h.r
+	enc.AddString("requestMethod", r.Method)
+	if r.URL != nil {
+		enc.AddString("requestUrl", r.URL.String())
+	}
+	if r.ContentLength > 0 {
+		enc.AddString("requestSize", strconv.FormatInt(r.ContentLength, 10))
+	}
+	if h.status != 0 {
+		enc.AddInt("status", h.status)
+	}
+	enc.AddString("responseSize", strconv.FormatInt(h.responseSize, 10))
+	if ua := r.UserAgent(); ua != "" {
+		enc.AddString("userAgent", ua)
+	}
+	if r.RemoteAddr != "" {
+		enc.AddString("remoteIp", r.RemoteAddr)
+	}
+	if ref := r.Referer(); ref != "" {
+		enc.AddString("referer", ref)
+	}
+	enc.AddString("latency", strconv.FormatFloat(h.latency.Seconds(), 'f', -1, 64)+"s")
+	enc.AddString("protocol", r.Proto)
+	return nil
+}
@@ -0,0 +1,124 @@
+package zap_logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withGCPLogger(projectID string, opts []Option, f func(*Logger, *bytes.Buffer)) {
+	buf := &bytes.Buffer{}
+	core := zapcore.NewCore(NewGCPEncoder(NewGCPEncoderConfig(), projectID), zapcore.AddSync(buf), zap.DebugLevel)
+	f(New(core, Config{}, append([]Option{AddCaller()}, opts...)...), buf)
+}
+
+func decodeGCP(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record), "Expected a JSON record.")
+	return record
+}
+
+func TestGCPSeverity(t *testing.T) {
+	tests := []struct {
+		level zapcore.Level
+		want  string
+	}{
+		{zapcore.DebugLevel, "DEBUG"},
+		{zapcore.InfoLevel, "INFO"},
+		{zapcore.WarnLevel, "WARNING"},
+		{zapcore.ErrorLevel, "ERROR"},
+		{zapcore.DPanicLevel, "CRITICAL"},
+		{zapcore.PanicLevel, "ALERT"},
+		{zapcore.FatalLevel, "EMERGENCY"},
+	}
+
+	for _, tt := range tests {
+		enc := zapcore.NewMapObjectEncoder()
+		_ = enc.AddArray("k", zapcore.ArrayMarshalerFunc(func(arr zapcore.ArrayEncoder) error {
+			gcpLevelEncoder(tt.level, arr)
+			return nil
+		}))
+		assert.Equal(t, []interface{}{tt.want}, enc.Fields["k"], "Unexpected severity for %v.", tt.level)
+	}
+}
+
+func TestGCPEncoder(t *testing.T) {
+	withGCPLogger("", nil, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Warn("slow")
+
+		record := decodeGCP(t, buf)
+		assert.Equal(t, "WARNING", record["severity"])
+		assert.Equal(t, "slow", record["message"])
+		_, err := time.Parse(time.RFC3339Nano, record["time"].(string))
+		assert.NoError(t, err, "Expected an RFC 3339 time.")
+
+		loc, ok := record[gcpSourceLocationKey].(map[string]interface{})
+		require.True(t, ok, "Expected sourceLocation to be an object.")
+		assert.True(t, strings.HasSuffix(loc["file"].(string), "gcp_encoder_test.go"))
+		assert.NotEmpty(t, loc["line"])
+	})
+}
+
+func TestGCPEncoderTrace(t *testing.T) {
+	type ctxKey string
+	const (
+		traceID ctxKey = "traceID"
+		spanID  ctxKey = "spanID"
+	)
+
+	ctx := context.WithValue(context.TODO(), traceID, "4bf92f3577b34da6")
+	ctx = context.WithValue(ctx, spanID, "00f067aa0ba902b7")
+	fieldOpts := opts(AddContext(func(ctx context.Context, log *ZapLogger) {
+		log.Ctx.Set(traceID, ctx)
+		log.Ctx.Set(spanID, ctx)
+	}))
+
+	withGCPLogger("my-project", fieldOpts, func(logger *Logger, buf *bytes.Buffer) {
+		logger.InfoCtx(ctx, "traced")
+
+		record := decodeGCP(t, buf)
+		assert.Equal(t, "projects/my-project/traces/4bf92f3577b34da6", record[gcpTraceKey])
+		assert.Equal(t, "00f067aa0ba902b7", record[gcpSpanIDKey])
+		assert.NotContains(t, record, "context", "Expected no unmapped context values.")
+	})
+}
+
+func TestGCPHTTPRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://example.com/orders?id=1", strings.NewReader("{}"))
+	r.Header.Set("User-Agent", "curl/8")
+
+	withGCPLogger("", nil, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Info("served", HTTPRequest(r, 201, 42, 250*time.Millisecond))
+
+		assert.Equal(t, map[string]interface{}{
+			"requestMethod": "POST",
+			"requestUrl":    "http://example.com/orders?id=1",
+			"requestSize":   "2",
+			"status":        float64(201),
+			"responseSize":  "42",
+			"userAgent":     "curl/8",
+			"remoteIp":      "192.0.2.1:1234",
+			"latency":       "0.25s",
+			"protocol":      "HTTP/1.1",
+		}, decodeGCP(t, buf)["httpRequest"])
+	})
+}
+
+func TestGCPHTTPRequestNil(t *testing.T) {
+	assert.Equal(t, zap.Skip(), HTTPRequest(nil, 500, 0, time.Second))
+
+	withGCPLogger("", nil, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Info("served", HTTPRequest(nil, 500, 0, time.Second))
+		assert.NotContains(t, decodeGCP(t, buf), "httpRequest")
+	})
+}
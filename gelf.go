@@ -0,0 +1,407 @@
+package zap_logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	zapbuffer "go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/pkg/netsink"
+	"github.com/hinha/zap-logger/pkg/wal"
+)
+
+// GELFCompression selects how GELF messages are compressed over UDP.
+type GELFCompression int
+
+const (
+	GELFNoCompression GELFCompression = iota
+	GELFGzip
+	GELFZlib
+)
+
+// DefaultGELFChunkSize is the largest UDP datagram sent when GELFConfig
+// leaves ChunkSize unset. It fits the MTU of most WAN links; 8154 suits
+// local networks.
+const DefaultGELFChunkSize = 1420
+
+// GELF chunked message layout, see
+// https://go2docs.graylog.org/current/getting_in_log_data/gelf.html.
+const (
+	gelfChunkHeaderSize = 12
+	gelfMaxChunks       = 128
+)
+
+var errGELFTooLarge = errors.New("gelf: message exceeds 128 chunks")
+
+// GELFConfig configures the Graylog output.
+type GELFConfig struct {
+	// Network is "udp", "tcp" or "tcp+tls". It defaults to "udp".
+	Network string
+	// Address is the Graylog input's host:port.
+	Address string
+	// TLS configures "tcp+tls" connections.
+	TLS *tls.Config
+	// Host is the GELF host field. It defaults to the host name.
+	Host string
+	// Compression compresses UDP messages. GELF over TCP is never
+	// compressed.
+	Compression GELFCompression
+	// ChunkSize is the largest datagram sent over UDP; bigger messages are
+	// chunked. It defaults to DefaultGELFChunkSize.
+	ChunkSize int
+	// WAL, if set, keeps records in a write-ahead log on disk between the
+	// diode and the connection until they are sent, so they survive an
+	// unreachable Graylog or a restart. See package wal.
+	WAL *wal.Options
+	// DiodeSize is the number of records held while the Graylog is slow or
+	// unreachable, DefaultDiodeSize if zero.
+	DiodeSize int
+}
+
+// NewGELFEncoder creates an encoder writing GELF 1.1 messages without a
+// line ending. The message goes to short_message, and to full_message with
+// the stack trace appended when there is one or the message spans several
+// lines. Fields become additional fields prefixed with '_', with nested
+// objects and arrays flattened into dotted names; "id" is logged as "_id_"
+// since GELF reserves "_id". cfg only provides the time and duration
+// encoders of field values.
+func NewGELFEncoder(cfg zapcore.EncoderConfig, host string) zapcore.Encoder {
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	cfg.TimeKey = ""
+	cfg.LevelKey = ""
+	cfg.NameKey = ""
+	cfg.CallerKey = ""
+	cfg.FunctionKey = ""
+	cfg.MessageKey = ""
+	cfg.StacktraceKey = ""
+	return &gelfEncoder{json: zapcore.NewJSONEncoder(cfg), host: host}
+}
+
+type gelfEncoder struct {
+	json   zapcore.Encoder
+	host   string
+	prefix string
+}
+
+// Clone implements zapcore.Encoder.
+func (enc *gelfEncoder) Clone() zapcore.Encoder {
+	return enc.clone()
+}
+
+func (enc *gelfEncoder) clone() *gelfEncoder {
+	return &gelfEncoder{json: enc.json.Clone(), host: enc.host, prefix: enc.prefix}
+}
+
+// EncodeEntry implements zapcore.Encoder.
+func (enc *gelfEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*zapbuffer.Buffer, error) {
+	final := enc.clone()
+	j := final.json
+	j.AddString("version", "1.1")
+	j.AddString("host", enc.host)
+	short, full := gelfMessages(ent)
+	j.AddString("short_message", short)
+	if full != "" {
+		j.AddString("full_message", full)
+	}
+	j.AddFloat64("timestamp", float64(ent.Time.UnixNano()/int64(time.Millisecond))/1e3)
+	j.AddInt("level", syslogSeverity(ent.Level))
+	if ent.LoggerName != "" {
+		j.AddString("_logger", ent.LoggerName)
+	}
+	if ent.Caller.Defined {
+		j.AddString("_caller", ent.Caller.TrimmedPath())
+	}
+
+	for i := range fields {
+		fields[i].AddTo(final)
+	}
+	buf, err := j.EncodeEntry(zapcore.Entry{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	buf.TrimNewline()
+	return buf, nil
+}
+
+// gelfMessages splits the message into short_message, its first line, and
+// full_message, which is only set when there is more to show.
+func gelfMessages(ent zapcore.Entry) (short, full string) {
+	short = ent.Message
+	if i := strings.IndexByte(short, '\n'); i >= 0 {
+		short, full = short[:i], ent.Message
+	}
+	if ent.Stack != "" {
+		full = ent.Message + "\n" + ent.Stack
+	}
+	return short, full
+}
+
+// name returns the additional field name for key.
+func (enc *gelfEncoder) name(key string) string {
+	name := []byte("_" + enc.prefix + key)
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-') {
+			name[i] = '_'
+		}
+	}
+	if string(name) == "_id" {
+		return "_id_"
+	}
+	return string(name)
+}
+
+func (enc *gelfEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	prefix := enc.prefix
+	defer func() { enc.prefix = prefix }()
+	return arr.MarshalLogArray(&gelfArrayEncoder{e: enc, prefix: prefix + key + "."})
+}
+
+// AddObject implements zapcore.ObjectEncoder. The "context" object added by
+// the *Ctx methods is logged as one top-level field per context value, named
+// after the last element of its key.
+func (enc *gelfEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	prefix := enc.prefix
+	defer func() { enc.prefix = prefix }()
+
+	if key == "context" {
+		enc.prefix = ""
+		moved, _, err := splitContext(obj, func(ctxKey string) (string, bool) {
+			return normalizeContextKey(ctxKey), true
+		})
+		if err != nil {
+			return err
+		}
+		for _, m := range moved {
+			if s, ok := m.value.(string); ok {
+				enc.AddString(m.key, s)
+			} else if err := enc.AddReflected(m.key, m.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	enc.prefix = prefix + key + "."
+	return obj.MarshalLogObject(enc)
+}
+
+// AddReflected implements zapcore.ObjectEncoder. Graylog only indexes
+// strings and numbers, so values are logged as their JSON text.
+func (enc *gelfEncoder) AddReflected(key string, obj interface{}) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	enc.json.AddString(enc.name(key), string(b))
+	return nil
+}
+
+func (enc *gelfEncoder) OpenNamespace(key string) { enc.prefix += key + "." }
+
+func (enc *gelfEncoder) AddBinary(k string, v []byte)          { enc.json.AddBinary(enc.name(k), v) }
+func (enc *gelfEncoder) AddByteString(k string, v []byte)      { enc.json.AddByteString(enc.name(k), v) }
+func (enc *gelfEncoder) AddBool(k string, v bool)              { enc.json.AddBool(enc.name(k), v) }
+func (enc *gelfEncoder) AddComplex128(k string, v complex128)  { enc.json.AddComplex128(enc.name(k), v) }
+func (enc *gelfEncoder) AddComplex64(k string, v complex64)    { enc.json.AddComplex64(enc.name(k), v) }
+func (enc *gelfEncoder) AddDuration(k string, v time.Duration) { enc.json.AddDuration(enc.name(k), v) }
+func (enc *gelfEncoder) AddFloat64(k string, v float64)        { enc.json.AddFloat64(enc.name(k), v) }
+func (enc *gelfEncoder) AddFloat32(k string, v float32)        { enc.json.AddFloat32(enc.name(k), v) }
+func (enc *gelfEncoder) AddInt(k string, v int)                { enc.json.AddInt(enc.name(k), v) }
+func (enc *gelfEncoder) AddInt64(k string, v int64)            { enc.json.AddInt64(enc.name(k), v) }
+func (enc *gelfEncoder) AddInt32(k string, v int32)            { enc.json.AddInt32(enc.name(k), v) }
+func (enc *gelfEncoder) AddInt16(k string, v int16)            { enc.json.AddInt16(enc.name(k), v) }
+func (enc *gelfEncoder) AddInt8(k string, v int8)              { enc.json.AddInt8(enc.name(k), v) }
+func (enc *gelfEncoder) AddString(k, v string)                 { enc.json.AddString(enc.name(k), v) }
+func (enc *gelfEncoder) AddTime(k string, v time.Time)         { enc.json.AddTime(enc.name(k), v) }
+func (enc *gelfEncoder) AddUint(k string, v uint)              { enc.json.AddUint(enc.name(k), v) }
+func (enc *gelfEncoder) AddUint64(k string, v uint64)          { enc.json.AddUint64(enc.name(k), v) }
+func (enc *gelfEncoder) AddUint32(k string, v uint32)          { enc.json.AddUint32(enc.name(k), v) }
+func (enc *gelfEncoder) AddUint16(k string, v uint16)          { enc.json.AddUint16(enc.name(k), v) }
+func (enc *gelfEncoder) AddUint8(k string, v uint8)            { enc.json.AddUint8(enc.name(k), v) }
+func (enc *gelfEncoder) AddUintptr(k string, v uintptr)        { enc.json.AddUintptr(enc.name(k), v) }
+
+// gelfArrayEncoder flattens array elements into indexed names.
+type gelfArrayEncoder struct {
+	e      *gelfEncoder
+	prefix string
+	i      int
+}
+
+func (a *gelfArrayEncoder) key() string {
+	a.e.prefix = a.prefix
+	k := strconv.Itoa(a.i)
+	a.i++
+	return k
+}
+
+func (a *gelfArrayEncoder) AppendArray(v zapcore.ArrayMarshaler) error {
+	return a.e.AddArray(a.key(), v)
+}
+func (a *gelfArrayEncoder) AppendObject(v zapcore.ObjectMarshaler) error {
+	return a.e.AddObject(a.key(), v)
+}
+func (a *gelfArrayEncoder) AppendReflected(v interface{}) error { return a.e.AddReflected(a.key(), v) }
+func (a *gelfArrayEncoder) AppendBool(v bool)                   { a.e.AddBool(a.key(), v) }
+func (a *gelfArrayEncoder) AppendByteString(v []byte)           { a.e.AddByteString(a.key(), v) }
+func (a *gelfArrayEncoder) AppendComplex128(v complex128)       { a.e.AddComplex128(a.key(), v) }
+func (a *gelfArrayEncoder) AppendComplex64(v complex64)         { a.e.AddComplex64(a.key(), v) }
+func (a *gelfArrayEncoder) AppendFloat64(v float64)             { a.e.AddFloat64(a.key(), v) }
+func (a *gelfArrayEncoder) AppendFloat32(v float32)             { a.e.AddFloat32(a.key(), v) }
+func (a *gelfArrayEncoder) AppendInt(v int)                     { a.e.AddInt(a.key(), v) }
+func (a *gelfArrayEncoder) AppendInt64(v int64)                 { a.e.AddInt64(a.key(), v) }
+func (a *gelfArrayEncoder) AppendInt32(v int32)                 { a.e.AddInt32(a.key(), v) }
+func (a *gelfArrayEncoder) AppendInt16(v int16)                 { a.e.AddInt16(a.key(), v) }
+func (a *gelfArrayEncoder) AppendInt8(v int8)                   { a.e.AddInt8(a.key(), v) }
+func (a *gelfArrayEncoder) AppendString(v string)               { a.e.AddString(a.key(), v) }
+func (a *gelfArrayEncoder) AppendUint(v uint)                   { a.e.AddUint(a.key(), v) }
+func (a *gelfArrayEncoder) AppendUint64(v uint64)               { a.e.AddUint64(a.key(), v) }
+func (a *gelfArrayEncoder) AppendUint32(v uint32)               { a.e.AddUint32(a.key(), v) }
+func (a *gelfArrayEncoder) AppendUint16(v uint16)               { a.e.AddUint16(a.key(), v) }
+func (a *gelfArrayEncoder) AppendUint8(v uint8)                 { a.e.AddUint8(a.key(), v) }
+func (a *gelfArrayEncoder) AppendUintptr(v uintptr)             { a.e.AddUintptr(a.key(), v) }
+func (a *gelfArrayEncoder) AppendDuration(v time.Duration)      { a.e.AddDuration(a.key(), v) }
+func (a *gelfArrayEncoder) AppendTime(v time.Time)              { a.e.AddTime(a.key(), v) }
+
+// gelfWriter sends GELF messages, compressing and chunking them over UDP.
+type gelfWriter struct {
+	w           *netsink.Writer
+	udp         bool
+	compression GELFCompression
+	chunkSize   int
+
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	chunk []byte
+	id    uint64
+}
+
+// writer returns the connection to the Graylog input.
+func (c *GELFConfig) writer() (*gelfWriter, error) {
+	network, framing := c.Network, netsink.NullTerminated
+	if network == "" {
+		network = "udp"
+	}
+	udp := strings.HasPrefix(network, "udp")
+	if udp {
+		framing = netsink.NoFraming
+	}
+	w, err := netsink.New(netsink.Config{
+		Network: network,
+		Address: c.Address,
+		TLS:     c.TLS,
+		Framing: framing,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gw := &gelfWriter{w: w, udp: udp, compression: c.Compression, chunkSize: c.ChunkSize}
+	if gw.chunkSize <= gelfChunkHeaderSize {
+		gw.chunkSize = DefaultGELFChunkSize
+	}
+	var seed [8]byte
+	_, _ = rand.Read(seed[:])
+	gw.id = binary.BigEndian.Uint64(seed[:])
+	return gw, nil
+}
+
+// Write sends p as one GELF message.
+func (w *gelfWriter) Write(p []byte) (int, error) {
+	if !w.udp {
+		return w.w.Write(p)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	data, err := w.compress(p)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) <= w.chunkSize {
+		if _, err := w.w.Write(data); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	size := w.chunkSize - gelfChunkHeaderSize
+	count := (len(data) + size - 1) / size
+	if count > gelfMaxChunks {
+		return 0, errGELFTooLarge
+	}
+	w.id++
+	for seq := 0; seq < count; seq++ {
+		part := data[seq*size:]
+		if len(part) > size {
+			part = part[:size]
+		}
+		w.chunk = append(w.chunk[:0], 0x1e, 0x0f, 0, 0, 0, 0, 0, 0, 0, 0, byte(seq), byte(count))
+		binary.BigEndian.PutUint64(w.chunk[2:10], w.id)
+		w.chunk = append(w.chunk, part...)
+		if _, err := w.w.Write(w.chunk); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *gelfWriter) compress(p []byte) ([]byte, error) {
+	var zw io.WriteCloser
+	switch w.compression {
+	case GELFGzip:
+		w.buf.Reset()
+		zw = gzip.NewWriter(&w.buf)
+	case GELFZlib:
+		w.buf.Reset()
+		zw = zlib.NewWriter(&w.buf)
+	default:
+		return p, nil
+	}
+	if _, err := zw.Write(p); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return w.buf.Bytes(), nil
+}
+
+// Sync implements zapcore.WriteSyncer.
+func (w *gelfWriter) Sync() error {
+	return w.w.Sync()
+}
+
+// Close closes the connection.
+func (w *gelfWriter) Close() error {
+	return w.w.Close()
+}
+
+// core returns a core sending GELF messages through a diode, and the diode
+// to close when the logger is closed. encCfg provides the encoders of field
+// values.
+func (c *GELFConfig) core(encCfg zapcore.EncoderConfig, lvl zap.AtomicLevel, interval time.Duration, o *sinkObserver) (zapcore.Core, io.Closer, error) {
+	w, err := c.writer()
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := newNetworkDiode(w, c.WAL, c.DiodeSize, interval, "gelf", o)
+	if err != nil {
+		return nil, nil, err
+	}
+	return zapcore.NewCore(NewGELFEncoder(encCfg, c.Host), zapcore.AddSync(d), lvl), d, nil
+}
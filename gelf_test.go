@@ -0,0 +1,314 @@
+package zap_logger
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hinha/zap-logger/pkg/wal"
+)
+
+func encodeGELF(t *testing.T, enc zapcore.Encoder, ent zapcore.Entry, fields ...zap.Field) map[string]interface{} {
+	buf, err := enc.EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buf.Free()
+	assert.False(t, strings.HasSuffix(buf.String(), "\n"), "GELF messages shouldn't end with a newline.")
+
+	var msg map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &msg), "Expected a JSON message.")
+	return msg
+}
+
+func TestGELFEncoder(t *testing.T) {
+	enc := NewGELFEncoder(NewProductionEncoderConfig(), "web-1")
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       time.Unix(1677906367, 890000000),
+		LoggerName: "http",
+		Message:    "slow request",
+		Caller:     zapcore.NewEntryCaller(0, "/src/pkg/handler.go", 42, true),
+	}
+
+	msg := encodeGELF(t, enc, ent,
+		zap.Int("status", 200),
+		zap.String("id", "abc"),
+		zap.String("bad key!", "v"),
+		zap.Object("user", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("name", "jane")
+			return nil
+		})),
+		zap.Strings("tags", []string{"a", "b"}),
+		zap.Reflect("m", map[string]int{"a": 1}),
+	)
+	assert.Equal(t, map[string]interface{}{
+		"version":       "1.1",
+		"host":          "web-1",
+		"short_message": "slow request",
+		"timestamp":     1677906367.89,
+		"level":         float64(4),
+		"_logger":       "http",
+		"_caller":       "pkg/handler.go:42",
+		"_status":       float64(200),
+		"_id_":          "abc",
+		"_bad_key_":     "v",
+		"_user.name":    "jane",
+		"_tags.0":       "a",
+		"_tags.1":       "b",
+		"_m":            `{"a":1}`,
+	}, msg)
+}
+
+func TestGELFMessages(t *testing.T) {
+	tests := []struct {
+		desc      string
+		ent       zapcore.Entry
+		short     string
+		full      string
+		wantsFull bool
+	}{
+		{"single line", zapcore.Entry{Message: "done"}, "done", "", false},
+		{"multiline", zapcore.Entry{Message: "failed\ndetails"}, "failed", "failed\ndetails", true},
+		{"stack", zapcore.Entry{Message: "failed", Stack: "main.main\n\t/src/main.go:7"}, "failed", "failed\nmain.main\n\t/src/main.go:7", true},
+	}
+
+	enc := NewGELFEncoder(zapcore.EncoderConfig{}, "h")
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			msg := encodeGELF(t, enc, tt.ent)
+			assert.Equal(t, tt.short, msg["short_message"])
+			full, ok := msg["full_message"]
+			assert.Equal(t, tt.wantsFull, ok, "Unexpected full_message presence.")
+			if tt.wantsFull {
+				assert.Equal(t, tt.full, full)
+			}
+		})
+	}
+}
+
+func TestGELFEncoderWith(t *testing.T) {
+	buf := &bytes.Buffer{}
+	core := zapcore.NewCore(NewGELFEncoder(NewProductionEncoderConfig(), "h"), zapcore.AddSync(buf), zap.DebugLevel)
+	type ctxKey string
+	const traceID ctxKey = "traceID"
+	ctx := context.WithValue(context.TODO(), traceID, "4bf92f35")
+	logger := New(core, Config{}, AddContext(func(ctx context.Context, log *ZapLogger) {
+		log.Ctx.Set(traceID, ctx)
+	})).With(zap.String("service", "api"), zap.Namespace("req"))
+	logger.InfoCtx(ctx, "ok", zap.Int("status", 200))
+
+	var msg map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &msg), "Expected a JSON message.")
+	assert.Equal(t, "api", msg["_service"])
+	assert.Equal(t, float64(200), msg["_req.status"])
+	assert.Equal(t, "4bf92f35", msg["_traceid"], "Expected context values as additional fields.")
+	assert.NotContains(t, msg, "_context", "Unexpected context object.")
+}
+
+// readGELF reads one datagram, reassembling chunked messages, and returns
+// the decompressed message.
+func readGELF(t *testing.T, conn net.PacketConn) ([]byte, int) {
+	var (
+		parts    [][]byte
+		received int
+		packet   = make([]byte, 65536)
+	)
+	for {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := conn.ReadFrom(packet)
+		require.NoError(t, err, "Failed to read a datagram.")
+		p := append([]byte(nil), packet[:n]...)
+		if len(p) < 2 || p[0] != 0x1e || p[1] != 0x0f {
+			return gelfDecompress(t, p), 1
+		}
+		seq, count := int(p[10]), int(p[11])
+		if parts == nil {
+			parts = make([][]byte, count)
+		}
+		parts[seq] = p[gelfChunkHeaderSize:]
+		if received++; received == count {
+			return gelfDecompress(t, bytes.Join(parts, nil)), count
+		}
+	}
+}
+
+func gelfDecompress(t *testing.T, p []byte) []byte {
+	var (
+		r   io.Reader
+		err error
+	)
+	switch {
+	case len(p) > 1 && p[0] == 0x1f && p[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(p))
+	case len(p) > 0 && p[0] == 0x78:
+		r, err = zlib.NewReader(bytes.NewReader(p))
+	default:
+		return p
+	}
+	require.NoError(t, err, "Failed to open the compressed message.")
+	out, err := io.ReadAll(r)
+	require.NoError(t, err, "Failed to decompress the message.")
+	return out
+}
+
+func TestGELFUDP(t *testing.T) {
+	tests := []struct {
+		desc        string
+		compression GELFCompression
+		message     string
+		chunked     bool
+	}{
+		{"plain", GELFNoCompression, "hello", false},
+		{"zlib", GELFZlib, "hello", false},
+		{"chunked", GELFNoCompression, strings.Repeat("x", 2000), true},
+		{"chunked gzip", GELFGzip, randomText(4000), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			require.NoError(t, err, "Failed to listen.")
+			defer conn.Close()
+
+			cfg := &GELFConfig{Address: conn.LocalAddr().String(), Host: "h", Compression: tt.compression, ChunkSize: 512}
+			core, closer, err := cfg.core(NewProductionEncoderConfig(), zap.NewAtomicLevel(), time.Millisecond, nil)
+			require.NoError(t, err, "Unexpected error creating the GELF core.")
+			logger := New(core, Config{}, addCloser(closer))
+			logger.Info(tt.message, zap.String("k", "v"))
+			require.NoError(t, logger.Close(), "Unexpected error closing the logger.")
+
+			data, chunks := readGELF(t, conn)
+			assert.Equal(t, tt.chunked, chunks > 1, "Unexpected chunking, got %d chunks.", chunks)
+			var msg map[string]interface{}
+			require.NoError(t, json.Unmarshal(data, &msg), "Expected a JSON message.")
+			assert.Equal(t, tt.message, msg["short_message"])
+			assert.Equal(t, "v", msg["_k"])
+		})
+	}
+}
+
+func TestGELFTooLarge(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen.")
+	defer conn.Close()
+
+	w, err := (&GELFConfig{Address: conn.LocalAddr().String(), ChunkSize: 100}).writer()
+	require.NoError(t, err, "Unexpected error creating the writer.")
+	defer w.Close()
+	_, err = w.Write(make([]byte, 88*gelfMaxChunks+1))
+	assert.Equal(t, errGELFTooLarge, err, "Expected an error for a message over 128 chunks.")
+}
+
+func TestGELFTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen.")
+	defer ln.Close()
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg, err := bufio.NewReader(conn).ReadString(0)
+		if err == nil {
+			messages <- msg
+		}
+	}()
+
+	cfg := &GELFConfig{Network: "tcp", Address: ln.Addr().String(), Host: "h", Compression: GELFGzip}
+	core, closer, err := cfg.core(NewProductionEncoderConfig(), zap.NewAtomicLevel(), time.Millisecond, nil)
+	require.NoError(t, err, "Unexpected error creating the GELF core.")
+	logger := New(core, Config{}, addCloser(closer))
+	logger.Error("boom")
+	require.NoError(t, logger.Close(), "Unexpected error closing the logger.")
+
+	select {
+	case msg := <-messages:
+		assert.True(t, strings.HasPrefix(msg, "{"), "TCP messages shouldn't be compressed.")
+		var decoded map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimSuffix(msg, "\x00")), &decoded))
+		assert.Equal(t, "boom", decoded["short_message"])
+		assert.Equal(t, float64(3), decoded["level"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a message.")
+	}
+}
+
+func TestGELFWAL(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen.")
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	cfg := &GELFConfig{Network: "tcp", Address: addr, Host: "h", WAL: &wal.Options{Dir: t.TempDir()}}
+	core, closer, err := cfg.core(NewProductionEncoderConfig(), zap.NewAtomicLevel(), time.Millisecond, nil)
+	require.NoError(t, err, "Unexpected error creating the GELF core.")
+	logger := New(core, Config{}, addCloser(closer))
+	logger.Info("while down")
+	require.NoError(t, logger.Close(), "Unexpected error closing the logger.")
+
+	// After a restart the record kept in the log is sent first.
+	ln, err = net.Listen("tcp", addr)
+	require.NoError(t, err, "Failed to listen again.")
+	defer ln.Close()
+	messages := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := r.ReadString(0)
+			if err != nil {
+				return
+			}
+			messages <- msg
+		}
+	}()
+
+	core, closer, err = cfg.core(NewProductionEncoderConfig(), zap.NewAtomicLevel(), time.Millisecond, nil)
+	require.NoError(t, err)
+	logger = New(core, Config{}, addCloser(closer))
+	logger.Info("back up")
+	require.NoError(t, logger.Close())
+
+	for _, want := range []string{"while down", "back up"} {
+		select {
+		case msg := <-messages:
+			assert.Contains(t, msg, `"short_message":"`+want+`"`)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q.", want)
+		}
+	}
+}
+
+// randomText returns n bytes that don't compress well.
+func randomText(n int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, n)
+	x := uint32(2463534242)
+	for i := range b {
+		x ^= x << 13
+		x ^= x >> 17
+		x ^= x << 5
+		b[i] = letters[x%uint32(len(letters))]
+	}
+	return string(b)
+}
@@ -0,0 +1,47 @@
+package zap_logger
+
+import (
+	"sync"
+)
+
+var (
+	_globalMu sync.RWMutex
+	_globalL  = NewNop()
+	_globalS  = _globalL.Sugar()
+)
+
+// L returns the global ZapLogger, which can be reconfigured with
+// ReplaceGlobals. It's safe for concurrent use.
+func L() *ZapLogger {
+	_globalMu.RLock()
+	l := _globalL
+	_globalMu.RUnlock()
+	return l
+}
+
+// S returns the global SugaredLogger, which can be reconfigured with
+// ReplaceGlobals. It's safe for concurrent use.
+func S() *SugaredLogger {
+	_globalMu.RLock()
+	s := _globalS
+	_globalMu.RUnlock()
+	return s
+}
+
+// ReplaceGlobals replaces the global ZapLogger and SugaredLogger, and returns a
+// function to restore the original values. It's safe for concurrent use.
+func ReplaceGlobals(logger *ZapLogger) func() {
+	_globalMu.Lock()
+	prev := _globalL
+	_globalL = logger
+	_globalS = logger.Sugar()
+	_globalMu.Unlock()
+	return func() { ReplaceGlobals(prev) }
+}
+
+// Sync flushes the global ZapLogger: buffered entries are pushed through the
+// diodes to their sinks and the log file is rotated, see ZapLogger.Sync. Call
+// it once on shutdown.
+func Sync() error {
+	return L().Sync()
+}
@@ -0,0 +1,76 @@
+package zap_logger
+
+import (
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceGlobals(t *testing.T) {
+	initialL := *L()
+	initialS := *S()
+
+	withLogger(t, zap.DebugLevel, nil, func(l *Logger, logs *observer.ObservedLogs) {
+		L().Info("no-op")
+		S().Info("no-op")
+		assert.Equal(t, 0, logs.Len(), "Expected initial logs to go to the no-op logger.")
+
+		defer ReplaceGlobals(l)()
+
+		L().Info("captured")
+		S().Info("captured")
+		expected := observer.LoggedEntry{
+			Entry:   zapcore.Entry{Level: zap.InfoLevel, Message: "captured"},
+			Context: []zap.Field{},
+		}
+		assert.Equal(t, []observer.LoggedEntry{expected, expected}, logs.AllUntimed(),
+			"Unexpected global log output.")
+	})
+
+	assert.Equal(t, initialL, *L(), "Expected func returned from ReplaceGlobals to restore initial L.")
+	assert.Equal(t, initialS, *S(), "Expected func returned from ReplaceGlobals to restore initial S.")
+}
+
+func TestGlobalsConcurrentUse(t *testing.T) {
+	// A fixed number of iterations, so every goroutine overlaps the others
+	// however fast they start.
+	const iterations = 100
+	var wg sync.WaitGroup
+
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				ReplaceGlobals(NewNop())()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				L().Info("")
+				S().Info("")
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestGlobalSync(t *testing.T) {
+	require.NoError(t, Sync(), "Expected syncing the default no-op logger to succeed.")
+
+	sink := &Discarder{}
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(NewProductionEncoderConfig()), sink, zap.DebugLevel)
+	defer ReplaceGlobals(New(core, Config{}))()
+
+	L().Info("flushed")
+	require.NoError(t, Sync(), "Unexpected error syncing the global logger.")
+	assert.True(t, sink.Called(), "Expected Sync to reach the global logger's sink.")
+}
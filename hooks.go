@@ -0,0 +1,180 @@
+package zap_logger
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap/zapcore"
+)
+
+// A HookFunc observes an entry once the Logger's core has written it, with
+// the fields added by With followed by those of the call. A returned error,
+// or a panic, is reported to the Logger's error output.
+type HookFunc func(ent zapcore.Entry, fields []zapcore.Field) error
+
+// Hooks runs hooks synchronously, in order, after each entry is written. A
+// failing hook doesn't stop the others nor the write. Hooks see the fields as
+// given, after the Redactor of WithRedactor if any, whichever option comes
+// first; encoding them is up to the hook.
+func Hooks(hooks ...HookFunc) Option {
+	return optionFunc(func(log *Logger) {
+		log.core = &hookCore{Core: log.core, hooks: hooks, r: log.redactor}
+	})
+}
+
+// AsyncHooks runs hooks on a goroutine, through a queue holding up to size
+// entries, so slow hooks don't hold up logging. Entries arriving while the
+// queue is full are dropped and counted. Close on the Logger runs the queued
+// entries, then stops the goroutine.
+func AsyncHooks(size int, hooks ...HookFunc) Option {
+	return optionFunc(func(log *Logger) {
+		// The error output is read when errors are reported, so an
+		// ErrorOutput option given after this one applies.
+		q := newHookQueue(size, hooks, func() zapcore.WriteSyncer { return log.errorOutput })
+		log.core = &hookCore{Core: log.core, hooks: hooks, r: log.redactor, queue: q}
+		// Clones share the slice; don't append to their backing array.
+		log.closers = append(log.closers[:len(log.closers):len(log.closers)], q)
+	})
+}
+
+// hookCore runs hooks for the entries its Core writes.
+type hookCore struct {
+	zapcore.Core
+	fields []zapcore.Field
+	hooks  []HookFunc
+	// r redacts the fields when the Redactor's core is below this one.
+	r     *Redactor
+	queue *hookQueue
+}
+
+func (c *hookCore) With(fields []zapcore.Field) zapcore.Core {
+	return &hookCore{
+		Core:   c.Core.With(fields),
+		fields: append(c.fields[:len(c.fields):len(c.fields)], fields...),
+		hooks:  c.hooks,
+		r:      c.r,
+		queue:  c.queue,
+	}
+}
+
+func (c *hookCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	// Like zap's hooks, only observe entries the wrapped core writes.
+	if downstream := c.Core.Check(ent, ce); downstream != nil {
+		return downstream.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write runs the hooks; the wrapped core writes the entry itself, as it added
+// itself in Check. Errors are reported by the CheckedEntry.
+func (c *hookCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if len(c.fields) > 0 {
+		fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	}
+	if c.r != nil {
+		fields = c.r.fields(fields)
+	}
+	if c.queue != nil {
+		c.queue.push(ent, fields)
+		return nil
+	}
+	return runHooks(c.hooks, ent, fields)
+}
+
+func runHooks(hooks []HookFunc, ent zapcore.Entry, fields []zapcore.Field) error {
+	var err error
+	for _, h := range hooks {
+		err = multierr.Append(err, runHook(h, ent, fields))
+	}
+	return err
+}
+
+func runHook(h HookFunc, ent zapcore.Entry, fields []zapcore.Field) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("hook panicked: %v", r)
+		}
+	}()
+	return h(ent, fields)
+}
+
+type hookEvent struct {
+	ent    zapcore.Entry
+	fields []zapcore.Field
+}
+
+// hookQueue runs hooks for queued entries on a goroutine.
+type hookQueue struct {
+	hooks       []HookFunc
+	errorOutput func() zapcore.WriteSyncer
+	events      chan hookEvent
+	done        chan struct{}
+	dropped     uint64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newHookQueue(size int, hooks []HookFunc, errorOutput func() zapcore.WriteSyncer) *hookQueue {
+	if size <= 0 {
+		size = 1
+	}
+	q := &hookQueue{
+		hooks:       hooks,
+		errorOutput: errorOutput,
+		events:      make(chan hookEvent, size),
+		done:        make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *hookQueue) push(ent zapcore.Entry, fields []zapcore.Field) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		atomic.AddUint64(&q.dropped, 1)
+		return
+	}
+	// The caller may reuse its slice once Write returns.
+	fields = append([]zapcore.Field(nil), fields...)
+	select {
+	case q.events <- hookEvent{ent, fields}:
+	default:
+		atomic.AddUint64(&q.dropped, 1)
+	}
+}
+
+func (q *hookQueue) run() {
+	defer close(q.done)
+	for ev := range q.events {
+		if err := runHooks(q.hooks, ev.ent, ev.fields); err != nil {
+			out := q.errorOutput()
+			fmt.Fprintf(out, "%v hook error: %v\n", ev.ent.Time, err)
+			out.Sync()
+		}
+	}
+}
+
+// Close runs the queued entries and stops the goroutine.
+func (q *hookQueue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.events)
+	q.mu.Unlock()
+
+	<-q.done
+	if n := atomic.LoadUint64(&q.dropped); n > 0 {
+		out := q.errorOutput()
+		fmt.Fprintf(out, "%v hooks dropped %d entries\n", time.Now().UTC(), n)
+		out.Sync()
+	}
+	return nil
+}
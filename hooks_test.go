@@ -0,0 +1,119 @@
+package zap_logger
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// lockedBuffer is an error output safe for the hook goroutine.
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (l *lockedBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.Write(p)
+}
+
+func (l *lockedBuffer) Sync() error { return nil }
+
+func (l *lockedBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.b.String()
+}
+
+func TestHooks(t *testing.T) {
+	counts := make(map[zapcore.Level]int)
+	var seen [][]zapcore.Field
+	count := func(ent zapcore.Entry, fields []zapcore.Field) error {
+		counts[ent.Level]++
+		seen = append(seen, fields)
+		return nil
+	}
+	failing := func(zapcore.Entry, []zapcore.Field) error { return errors.New("incident tool down") }
+	panicking := func(zapcore.Entry, []zapcore.Field) error { panic("hook bug") }
+
+	errOut := &lockedBuffer{}
+	withLogger(t, zapcore.InfoLevel, opts(ErrorOutput(errOut), Hooks(failing, panicking, count)), func(log *Logger, logs *observer.ObservedLogs) {
+		log.Debug("disabled")
+		log.With(zap.String("user", "ann")).Info("hello", zap.Int("n", 1))
+		log.Error("oops")
+
+		assert.Equal(t, 2, logs.Len(), "Expected failing hooks not to stop the writes.")
+		assert.Equal(t, map[zapcore.Level]int{zapcore.InfoLevel: 1, zapcore.ErrorLevel: 1}, counts,
+			"Expected the hooks to see only written entries, after failing ones.")
+		assert.Equal(t, []zapcore.Field{zap.String("user", "ann"), zap.Int("n", 1)}, seen[0],
+			"Expected the fields added by With first.")
+		assert.Contains(t, errOut.String(), "incident tool down")
+		assert.Contains(t, errOut.String(), "hook panicked: hook bug")
+	})
+}
+
+func TestAsyncHooks(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		forwarded []string
+	)
+	release := make(chan struct{})
+	forward := func(ent zapcore.Entry, fields []zapcore.Field) error {
+		<-release
+		if ent.Level < zapcore.ErrorLevel {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		forwarded = append(forwarded, ent.Message)
+		if ent.Message == "bad" {
+			return errors.New("rejected")
+		}
+		return nil
+	}
+
+	errOut := &lockedBuffer{}
+	fac, logs := observer.New(zapcore.DebugLevel)
+	// The error output given after AsyncHooks still applies to the hooks.
+	log := New(fac, Config{}, AsyncHooks(2, forward), ErrorOutput(errOut))
+	log.Error("first")
+	log.Error("bad")
+	log.Error("second")
+	log.Error("overflow")
+	assert.Equal(t, 4, logs.Len(), "Expected logging not to wait for the hooks.")
+
+	close(release)
+	require.NoError(t, log.Close())
+	log.Error("after close")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, forwarded)
+	assert.Equal(t, "first", forwarded[0])
+	assert.Contains(t, forwarded, "bad")
+	assert.NotContains(t, forwarded, "after close")
+	assert.Contains(t, errOut.String(), "hook error: rejected")
+	assert.Contains(t, errOut.String(), "hooks dropped")
+}
+
+func TestAsyncHooksClonesKeepTheirClosers(t *testing.T) {
+	nop := func(zapcore.Entry, []zapcore.Field) error { return nil }
+	parent := New(zapcore.NewNopCore(), Config{}, addCloser(io.NopCloser(nil)), addCloser(io.NopCloser(nil)), addCloser(io.NopCloser(nil)))
+	first := parent.WithOptions(AsyncHooks(1, nop))
+	second := parent.WithOptions(AsyncHooks(1, nop))
+
+	require.Len(t, first.closers, 4)
+	require.Len(t, second.closers, 4)
+	assert.NotSame(t, first.closers[3], second.closers[3], "Expected each clone to close its own queue.")
+	require.NoError(t, first.Close())
+	require.NoError(t, second.Close())
+}
@@ -0,0 +1,55 @@
+// Package logfiles lists the files of a log rotated by lumberjack.
+package logfiles
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeFormat is the timestamp lumberjack puts in backup file names.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// List returns the files of a log rotated by lumberjack, oldest first: the
+// backups of filename, gzipped or not, followed by filename itself if it
+// exists.
+func List(filename string) ([]string, error) {
+	dir := filepath.Dir(filename)
+	base := filepath.Base(filename)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type backup struct {
+		name string
+		ts   string
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		ts := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		ts = strings.TrimPrefix(ts, prefix)
+		if _, err := time.Parse(backupTimeFormat, ts); err != nil {
+			continue
+		}
+		backups = append(backups, backup{name: name, ts: ts})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].ts < backups[j].ts })
+
+	files := make([]string, 0, len(backups)+1)
+	for _, b := range backups {
+		files = append(files, filepath.Join(dir, b.name))
+	}
+	if _, err := os.Stat(filename); err == nil {
+		files = append(files, filename)
+	}
+	return files, nil
+}
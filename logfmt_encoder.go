@@ -0,0 +1,527 @@
+package zap_logger
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	zapbuffer "go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+var _logfmtPool = zapbuffer.NewPool()
+
+// NewLogfmtEncoder creates an encoder writing logfmt lines
+// (key=value pairs separated by spaces). Nested objects and arrays are
+// flattened with dotted keys, so {"user":{"id":1}} becomes user.id=1 and
+// {"tags":["a","b"]} becomes tags.0=a tags.1=b.
+func NewLogfmtEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	return &logfmtEncoder{
+		EncoderConfig: &cfg,
+		buf:           _logfmtPool.Get(),
+	}
+}
+
+type logfmtEncoder struct {
+	*zapcore.EncoderConfig
+	// buf comes from _logfmtPool, so EncodeEntry returns it as it is.
+	buf *zapbuffer.Buffer
+	// prefix is prepended to keys while encoding nested objects, arrays and
+	// namespaces, e.g. "user." or "tags.0.".
+	prefix string
+	// palette colors keys and values by type; nil for plain logfmt.
+	palette *colorPalette
+}
+
+func (enc *logfmtEncoder) clone() *logfmtEncoder {
+	clone := &logfmtEncoder{
+		EncoderConfig: enc.EncoderConfig,
+		buf:           _logfmtPool.Get(),
+		prefix:        enc.prefix,
+		palette:       enc.palette,
+	}
+	clone.buf.Write(enc.buf.Bytes())
+	return clone
+}
+
+// Clone implements zapcore.Encoder.
+func (enc *logfmtEncoder) Clone() zapcore.Encoder {
+	return enc.clone()
+}
+
+// EncodeEntry implements zapcore.Encoder.
+func (enc *logfmtEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*zapbuffer.Buffer, error) {
+	final := &logfmtEncoder{
+		EncoderConfig: enc.EncoderConfig,
+		buf:           _logfmtPool.Get(),
+	}
+
+	if final.TimeKey != "" {
+		final.addKey(final.TimeKey)
+		if final.EncodeTime != nil {
+			final.EncodeTime(ent.Time, logfmtValue{final})
+		} else {
+			final.appendString(ent.Time.Format(time.RFC3339Nano))
+		}
+	}
+	if final.LevelKey != "" {
+		final.addKey(final.LevelKey)
+		if final.EncodeLevel != nil {
+			final.EncodeLevel(ent.Level, logfmtValue{final})
+		} else {
+			final.appendString(ent.Level.String())
+		}
+	}
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.addKey(final.NameKey)
+		if final.EncodeName != nil {
+			final.EncodeName(ent.LoggerName, logfmtValue{final})
+		} else {
+			final.appendString(ent.LoggerName)
+		}
+	}
+	if ent.Caller.Defined {
+		if final.CallerKey != "" {
+			final.addKey(final.CallerKey)
+			if final.EncodeCaller != nil {
+				final.EncodeCaller(ent.Caller, logfmtValue{final})
+			} else {
+				final.appendString(ent.Caller.String())
+			}
+		}
+		if final.FunctionKey != "" {
+			final.AddString(final.FunctionKey, ent.Caller.Function)
+		}
+	}
+	if final.MessageKey != "" {
+		final.AddString(final.MessageKey, ent.Message)
+	}
+
+	if enc.buf.Len() > 0 {
+		final.separate()
+		final.buf.Write(enc.buf.Bytes())
+	}
+	final.prefix = enc.prefix
+	for i := range fields {
+		fields[i].AddTo(final)
+	}
+	final.prefix = ""
+
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		final.AddString(final.StacktraceKey, ent.Stack)
+	}
+	if final.LineEnding != "" {
+		final.buf.AppendString(final.LineEnding)
+	} else {
+		final.buf.AppendString(zapcore.DefaultLineEnding)
+	}
+	return final.buf, nil
+}
+
+func (enc *logfmtEncoder) separate() {
+	if enc.buf.Len() > 0 {
+		enc.buf.AppendByte(' ')
+	}
+}
+
+// addKey starts a new key=value pair.
+func (enc *logfmtEncoder) addKey(key string) {
+	enc.separate()
+	enc.paint(colorKey)
+	appendLogfmtKey(enc.buf, enc.prefix)
+	appendLogfmtKey(enc.buf, key)
+	enc.buf.AppendByte('=')
+	enc.unpaint(colorKey)
+}
+
+// paint starts the palette color c, if any.
+func (enc *logfmtEncoder) paint(c color) {
+	if enc.palette != nil && enc.palette[c] != "" {
+		enc.buf.AppendString(enc.palette[c])
+	}
+}
+
+// unpaint ends the palette color c started by paint.
+func (enc *logfmtEncoder) unpaint(c color) {
+	if enc.palette != nil && enc.palette[c] != "" {
+		enc.buf.AppendString(colorReset)
+	}
+}
+
+func (enc *logfmtEncoder) appendString(s string) {
+	appendLogfmtValue(enc.buf, s)
+}
+
+func (enc *logfmtEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	prefix := enc.prefix
+	defer func() { enc.prefix = prefix }()
+	return arr.MarshalLogArray(&logfmtArrayEncoder{enc: enc, prefix: prefix + key + "."})
+}
+
+func (enc *logfmtEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	prefix := enc.prefix
+	defer func() { enc.prefix = prefix }()
+	enc.prefix = prefix + key + "."
+	return obj.MarshalLogObject(enc)
+}
+
+func (enc *logfmtEncoder) AddBinary(key string, val []byte) {
+	enc.AddString(key, base64.StdEncoding.EncodeToString(val))
+}
+
+func (enc *logfmtEncoder) AddByteString(key string, val []byte) {
+	enc.addKey(key)
+	appendLogfmtValue(enc.buf, string(val))
+}
+
+func (enc *logfmtEncoder) AddBool(key string, val bool) {
+	enc.addKey(key)
+	enc.paint(colorBool)
+	enc.buf.AppendBool(val)
+	enc.unpaint(colorBool)
+}
+
+func (enc *logfmtEncoder) AddComplex128(key string, val complex128) {
+	enc.addKey(key)
+	enc.paint(colorNumber)
+	appendLogfmtComplex(enc.buf, val, 64)
+	enc.unpaint(colorNumber)
+}
+
+func (enc *logfmtEncoder) AddComplex64(key string, val complex64) {
+	enc.addKey(key)
+	enc.paint(colorNumber)
+	appendLogfmtComplex(enc.buf, complex128(val), 32)
+	enc.unpaint(colorNumber)
+}
+
+func (enc *logfmtEncoder) AddDuration(key string, val time.Duration) {
+	enc.addKey(key)
+	enc.paint(colorTime)
+	logfmtValue{enc}.AppendDuration(val)
+	enc.unpaint(colorTime)
+}
+
+func (enc *logfmtEncoder) AddFloat64(key string, val float64) {
+	enc.addKey(key)
+	enc.paint(colorNumber)
+	appendLogfmtFloat(enc.buf, val, 64)
+	enc.unpaint(colorNumber)
+}
+
+func (enc *logfmtEncoder) AddFloat32(key string, val float32) {
+	enc.addKey(key)
+	enc.paint(colorNumber)
+	appendLogfmtFloat(enc.buf, float64(val), 32)
+	enc.unpaint(colorNumber)
+}
+
+func (enc *logfmtEncoder) AddInt(key string, val int)     { enc.AddInt64(key, int64(val)) }
+func (enc *logfmtEncoder) AddInt32(key string, val int32) { enc.AddInt64(key, int64(val)) }
+func (enc *logfmtEncoder) AddInt16(key string, val int16) { enc.AddInt64(key, int64(val)) }
+func (enc *logfmtEncoder) AddInt8(key string, val int8)   { enc.AddInt64(key, int64(val)) }
+
+func (enc *logfmtEncoder) AddInt64(key string, val int64) {
+	enc.addKey(key)
+	enc.paint(colorNumber)
+	enc.buf.AppendInt(val)
+	enc.unpaint(colorNumber)
+}
+
+func (enc *logfmtEncoder) AddString(key, val string) {
+	enc.addKey(key)
+	appendLogfmtValue(enc.buf, val)
+}
+
+func (enc *logfmtEncoder) AddTime(key string, val time.Time) {
+	enc.addKey(key)
+	enc.paint(colorTime)
+	logfmtValue{enc}.AppendTime(val)
+	enc.unpaint(colorTime)
+}
+
+func (enc *logfmtEncoder) AddUint(key string, val uint)       { enc.AddUint64(key, uint64(val)) }
+func (enc *logfmtEncoder) AddUint32(key string, val uint32)   { enc.AddUint64(key, uint64(val)) }
+func (enc *logfmtEncoder) AddUint16(key string, val uint16)   { enc.AddUint64(key, uint64(val)) }
+func (enc *logfmtEncoder) AddUint8(key string, val uint8)     { enc.AddUint64(key, uint64(val)) }
+func (enc *logfmtEncoder) AddUintptr(key string, val uintptr) { enc.AddUint64(key, uint64(val)) }
+
+func (enc *logfmtEncoder) AddUint64(key string, val uint64) {
+	enc.addKey(key)
+	enc.paint(colorNumber)
+	enc.buf.AppendUint(val)
+	enc.unpaint(colorNumber)
+}
+
+// AddReflected flattens maps, structs and slices into dotted keys, as
+// AddObject and AddArray do. It goes through the JSON form of obj, so json
+// tags and marshalers apply; values nested deeper than logfmtMaxDepth are
+// logged as their JSON text.
+func (enc *logfmtEncoder) AddReflected(key string, obj interface{}) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return enc.addJSON(key, b, 0)
+}
+
+// logfmtMaxDepth bounds the flattening of reflected values.
+const logfmtMaxDepth = defaultValueMaxDepth
+
+// addJSON writes the compact JSON value raw under key.
+func (enc *logfmtEncoder) addJSON(key string, raw json.RawMessage, depth int) error {
+	switch raw[0] {
+	case '{', '[':
+		// Empty objects and arrays have no keys to flatten into.
+		if len(raw) == 2 || depth >= logfmtMaxDepth {
+			enc.AddString(key, string(raw))
+			return nil
+		}
+		return enc.addJSONElems(key, raw, depth)
+	case '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return err
+		}
+		enc.AddString(key, s)
+	case 't', 'f':
+		enc.AddBool(key, raw[0] == 't')
+	case 'n':
+		enc.addKey(key)
+		enc.buf.AppendString("null")
+	default:
+		enc.addKey(key)
+		enc.paint(colorNumber)
+		enc.buf.Write(raw)
+		enc.unpaint(colorNumber)
+	}
+	return nil
+}
+
+// addJSONElems writes the members of a JSON object, or the elements of a
+// JSON array, under key.
+func (enc *logfmtEncoder) addJSONElems(key string, raw json.RawMessage, depth int) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	open, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	prefix := enc.prefix
+	defer func() { enc.prefix = prefix }()
+	for i := 0; dec.More(); i++ {
+		name := strconv.Itoa(i)
+		if open == json.Delim('{') {
+			t, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			name = t.(string)
+		}
+		var elem json.RawMessage
+		if err := dec.Decode(&elem); err != nil {
+			return err
+		}
+		enc.prefix = prefix + key + "."
+		if err := enc.addJSON(name, elem, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *logfmtEncoder) OpenNamespace(key string) {
+	enc.prefix += key + "."
+}
+
+// logfmtArrayEncoder flattens array elements into indexed keys.
+type logfmtArrayEncoder struct {
+	enc    *logfmtEncoder
+	prefix string
+	i      int
+}
+
+// next returns the encoder positioned on the key of the next element.
+func (a *logfmtArrayEncoder) next() *logfmtEncoder {
+	a.enc.prefix = a.prefix
+	return a.enc
+}
+
+func (a *logfmtArrayEncoder) key() string {
+	k := strconv.Itoa(a.i)
+	a.i++
+	return k
+}
+
+func (a *logfmtArrayEncoder) AppendArray(arr zapcore.ArrayMarshaler) error {
+	return a.next().AddArray(a.key(), arr)
+}
+
+func (a *logfmtArrayEncoder) AppendObject(obj zapcore.ObjectMarshaler) error {
+	return a.next().AddObject(a.key(), obj)
+}
+
+func (a *logfmtArrayEncoder) AppendReflected(v interface{}) error {
+	return a.next().AddReflected(a.key(), v)
+}
+
+func (a *logfmtArrayEncoder) AppendBool(v bool)              { a.next().AddBool(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendByteString(v []byte)      { a.next().AddByteString(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendComplex128(v complex128)  { a.next().AddComplex128(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendComplex64(v complex64)    { a.next().AddComplex64(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendFloat64(v float64)        { a.next().AddFloat64(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendFloat32(v float32)        { a.next().AddFloat32(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendInt(v int)                { a.next().AddInt(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendInt64(v int64)            { a.next().AddInt64(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendInt32(v int32)            { a.next().AddInt32(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendInt16(v int16)            { a.next().AddInt16(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendInt8(v int8)              { a.next().AddInt8(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendString(v string)          { a.next().AddString(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendUint(v uint)              { a.next().AddUint(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendUint64(v uint64)          { a.next().AddUint64(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendUint32(v uint32)          { a.next().AddUint32(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendUint16(v uint16)          { a.next().AddUint16(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendUint8(v uint8)            { a.next().AddUint8(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendUintptr(v uintptr)        { a.next().AddUintptr(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendDuration(v time.Duration) { a.next().AddDuration(a.key(), v) }
+func (a *logfmtArrayEncoder) AppendTime(v time.Time)         { a.next().AddTime(a.key(), v) }
+
+// logfmtValue writes the value of a pair whose key was already written. It's
+// handed to the EncoderConfig's time, level, name, caller and duration
+// encoders, which expect to append exactly one value.
+type logfmtValue struct {
+	enc *logfmtEncoder
+}
+
+func (v logfmtValue) AppendBool(b bool)              { v.enc.buf.AppendBool(b) }
+func (v logfmtValue) AppendByteString(b []byte)      { appendLogfmtValue(v.enc.buf, string(b)) }
+func (v logfmtValue) AppendComplex128(c complex128)  { appendLogfmtComplex(v.enc.buf, c, 64) }
+func (v logfmtValue) AppendComplex64(c complex64)    { appendLogfmtComplex(v.enc.buf, complex128(c), 32) }
+func (v logfmtValue) AppendFloat64(f float64)        { appendLogfmtFloat(v.enc.buf, f, 64) }
+func (v logfmtValue) AppendFloat32(f float32)        { appendLogfmtFloat(v.enc.buf, float64(f), 32) }
+func (v logfmtValue) AppendInt(i int)                { v.enc.buf.AppendInt(int64(i)) }
+func (v logfmtValue) AppendInt64(i int64)            { v.enc.buf.AppendInt(i) }
+func (v logfmtValue) AppendInt32(i int32)            { v.enc.buf.AppendInt(int64(i)) }
+func (v logfmtValue) AppendInt16(i int16)            { v.enc.buf.AppendInt(int64(i)) }
+func (v logfmtValue) AppendInt8(i int8)              { v.enc.buf.AppendInt(int64(i)) }
+func (v logfmtValue) AppendString(s string)          { appendLogfmtValue(v.enc.buf, s) }
+func (v logfmtValue) AppendUint(i uint)              { v.enc.buf.AppendUint(uint64(i)) }
+func (v logfmtValue) AppendUint64(i uint64)          { v.enc.buf.AppendUint(i) }
+func (v logfmtValue) AppendUint32(i uint32)          { v.enc.buf.AppendUint(uint64(i)) }
+func (v logfmtValue) AppendUint16(i uint16)          { v.enc.buf.AppendUint(uint64(i)) }
+func (v logfmtValue) AppendUint8(i uint8)            { v.enc.buf.AppendUint(uint64(i)) }
+func (v logfmtValue) AppendUintptr(i uintptr)        { v.enc.buf.AppendUint(uint64(i)) }
+func (v logfmtValue) AppendDuration(d time.Duration) { v.appendDuration(d) }
+func (v logfmtValue) AppendTime(t time.Time)         { v.appendTime(t) }
+
+func (v logfmtValue) appendDuration(d time.Duration) {
+	cur := v.enc.buf.Len()
+	if e := v.enc.EncodeDuration; e != nil {
+		e(d, v)
+	}
+	if cur == v.enc.buf.Len() {
+		v.enc.buf.AppendInt(int64(d))
+	}
+}
+
+func (v logfmtValue) appendTime(t time.Time) {
+	cur := v.enc.buf.Len()
+	if e := v.enc.EncodeTime; e != nil {
+		e(t, v)
+	}
+	if cur == v.enc.buf.Len() {
+		v.enc.buf.AppendInt(t.UnixNano())
+	}
+}
+
+func appendLogfmtFloat(buf *zapbuffer.Buffer, f float64, bitSize int) {
+	switch {
+	case math.IsNaN(f):
+		buf.AppendString("NaN")
+	case math.IsInf(f, 1):
+		buf.AppendString("+Inf")
+	case math.IsInf(f, -1):
+		buf.AppendString("-Inf")
+	default:
+		buf.AppendFloat(f, bitSize)
+	}
+}
+
+func appendLogfmtComplex(buf *zapbuffer.Buffer, c complex128, bitSize int) {
+	buf.AppendString(strconv.FormatComplex(c, 'f', -1, bitSize*2))
+}
+
+// appendLogfmtKey writes key, replacing the bytes that would make it
+// ambiguous (spaces, '=', quotes and control characters) with '_'.
+func appendLogfmtKey(buf *zapbuffer.Buffer, key string) {
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c <= ' ' || c == '=' || c == '"' || c == 0x7f {
+			c = '_'
+		}
+		buf.AppendByte(c)
+	}
+}
+
+// appendLogfmtValue writes s, quoting and escaping it when it's empty or
+// contains spaces, '=', quotes, backslashes, control characters or invalid
+// UTF-8.
+func appendLogfmtValue(buf *zapbuffer.Buffer, s string) {
+	if !logfmtNeedsQuote(s) {
+		buf.AppendString(s)
+		return
+	}
+
+	const hex = "0123456789abcdef"
+	buf.AppendByte('"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch c {
+			case '"', '\\':
+				buf.AppendByte('\\')
+				buf.AppendByte(c)
+			case '\n':
+				buf.AppendString(`\n`)
+			case '\r':
+				buf.AppendString(`\r`)
+			case '\t':
+				buf.AppendString(`\t`)
+			default:
+				if c < ' ' || c == 0x7f {
+					buf.AppendString(`\u00`)
+					buf.AppendByte(hex[c>>4])
+					buf.AppendByte(hex[c&0xf])
+				} else {
+					buf.AppendByte(c)
+				}
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf.AppendString("\ufffd")
+		} else {
+			buf.AppendString(s[i : i+size])
+		}
+		i += size
+	}
+	buf.AppendByte('"')
+}
+
+func logfmtNeedsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c == '=' || c == '"' || c == '\\' || c == 0x7f {
+			return true
+		}
+	}
+	return !utf8.ValidString(s)
+}
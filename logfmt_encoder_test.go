@@ -0,0 +1,173 @@
+package zap_logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withLogfmtLogger(opts []Option, f func(*Logger, *bytes.Buffer)) {
+	buf := &bytes.Buffer{}
+	encCfg := NewProductionEncoderConfig()
+	encCfg.TimeKey = ""
+	core := zapcore.NewCore(NewLogfmtEncoder(encCfg), zapcore.AddSync(buf), zap.DebugLevel)
+	f(New(core, Config{}, opts...), buf)
+}
+
+type logfmtUser struct {
+	ID   int
+	Name string
+}
+
+func (u logfmtUser) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("id", u.ID)
+	enc.AddString("name", u.Name)
+	return nil
+}
+
+func TestLogfmtEncoderQuoting(t *testing.T) {
+	tests := []struct {
+		desc  string
+		field zap.Field
+		want  string
+	}{
+		{"bare", zap.String("k", "value"), `k=value`},
+		{"empty", zap.String("k", ""), `k=""`},
+		{"space", zap.String("k", "a b"), `k="a b"`},
+		{"equals", zap.String("k", "a=b"), `k="a=b"`},
+		{"quote and backslash", zap.String("k", `say "hi" \o/`), `k="say \"hi\" \\o/"`},
+		{"newline and tab", zap.String("k", "a\n\tb"), `k="a\n\tb"`},
+		{"control", zap.String("k", "\x01"), `k="\u0001"`},
+		{"invalid utf8", zap.String("k", "a\xffb"), `k="a` + "\ufffd" + `b"`},
+		{"unicode", zap.String("k", "héllo"), `k=héllo`},
+		{"key sanitized", zap.String("a b=c", "v"), `a_b_c=v`},
+		{"bool", zap.Bool("k", true), `k=true`},
+		{"int", zap.Int("k", -3), `k=-3`},
+		{"float", zap.Float64("k", 1.5), `k=1.5`},
+		{"duration", zap.Duration("k", time.Second), `k=1`},
+		{"error", zap.Error(errors.New("boom now")), `error="boom now"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			withLogfmtLogger(nil, func(logger *Logger, buf *bytes.Buffer) {
+				logger.Info("hi", tt.field)
+				assert.Equal(t, `level=info msg=hi `+tt.want+"\n", buf.String())
+			})
+		})
+	}
+}
+
+func TestLogfmtEncoderFlattens(t *testing.T) {
+	withLogfmtLogger(nil, func(logger *Logger, buf *bytes.Buffer) {
+		logger.With(zap.Namespace("req"), zap.String("id", "r1")).Info("nested",
+			zap.Object("user", logfmtUser{ID: 7, Name: "Jane Doe"}),
+			zap.Strings("tags", []string{"a", "b"}),
+			zap.Objects("users", []logfmtUser{{ID: 1, Name: "x"}}),
+		)
+		assert.Equal(t,
+			`level=info msg=nested req.id=r1 req.user.id=7 req.user.name="Jane Doe" `+
+				`req.tags.0=a req.tags.1=b req.users.0.id=1 req.users.0.name=x`+"\n",
+			buf.String())
+	})
+}
+
+func TestLogfmtEncoderFlattensReflected(t *testing.T) {
+	type address struct {
+		City string `json:"city"`
+		Zip  string `json:"-"`
+	}
+	type account struct {
+		Name    string            `json:"name"`
+		Address address           `json:"address"`
+		Roles   []string          `json:"roles"`
+		Labels  map[string]string `json:"labels"`
+		Extra   []int             `json:"extra"`
+		Parent  *account          `json:"parent"`
+	}
+	withLogfmtLogger(nil, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Info("reflected", zap.Reflect("account", account{
+			Name:    "Jane Doe",
+			Address: address{City: "Oslo", Zip: "0150"},
+			Roles:   []string{"admin"},
+			Labels:  map[string]string{"b": "2", "a": "1"},
+		}), zap.Reflect("score", 1.5), zap.Reflect("none", nil))
+		assert.Equal(t,
+			`level=info msg=reflected account.name="Jane Doe" account.address.city=Oslo `+
+				`account.roles.0=admin account.labels.a=1 account.labels.b=2 account.extra=null `+
+				`account.parent=null score=1.5 none=null`+"\n",
+			buf.String())
+	})
+
+	// Values nested deeper than the limit keep their JSON text.
+	var deep interface{} = "leaf"
+	for i := 0; i < logfmtMaxDepth+1; i++ {
+		deep = map[string]interface{}{"n": deep}
+	}
+	withLogfmtLogger(nil, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Info("deep", zap.Reflect("d", deep), zap.Reflect("empty", map[string]int{}))
+		assert.Equal(t,
+			`level=info msg=deep d`+strings.Repeat(".n", logfmtMaxDepth)+`="{\"n\":\"leaf\"}" empty={}`+"\n",
+			buf.String())
+	})
+}
+
+func TestLogfmtEncoderContext(t *testing.T) {
+	type ctxKey string
+	const traceID ctxKey = "traceid"
+
+	ctx := context.WithValue(context.TODO(), traceID, "abc")
+	fieldOpts := opts(AddContext(func(ctx context.Context, log *ZapLogger) {
+		log.Ctx.Set(traceID, ctx)
+	}))
+	withLogfmtLogger(fieldOpts, func(logger *Logger, buf *bytes.Buffer) {
+		logger.InfoCtx(ctx, "ctx")
+		assert.Contains(t, buf.String(), " context.zap_logger.ctxKey.traceid.value=abc\n",
+			"Expected context values to be flattened.")
+	})
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	assert.Error(t, RegisterEncoder("", nil), "Expected an error for an empty name.")
+	assert.Error(t, RegisterEncoder("logfmt", nil), "Expected an error for a duplicate name.")
+
+	_, err := newEncoder("nope", NewProductionEncoderConfig())
+	assert.Error(t, err, "Expected an error for an unregistered encoder.")
+
+	require.NoError(t, RegisterEncoder("test-logfmt", func(cfg zapcore.EncoderConfig) (zapcore.Encoder, error) {
+		return NewLogfmtEncoder(cfg), nil
+	}))
+	enc, err := newEncoder("test-logfmt", NewProductionEncoderConfig())
+	require.NoError(t, err)
+	assert.IsType(t, &logfmtEncoder{}, enc)
+}
+
+func TestConfigEncodings(t *testing.T) {
+	tests := []struct {
+		encoding, consoleEncoding string
+		file, console             string
+	}{
+		{"all", "", "json", "console"},
+		{"all", "logfmt", "json", "logfmt"},
+		{"console", "logfmt", "", "logfmt"},
+		{"json", "", "json", ""},
+		{"logfmt", "", "logfmt", ""},
+		{"", "", "", "console"},
+		{"unknown", "", "", "console"},
+	}
+
+	for _, tt := range tests {
+		file, console := Config{Encoding: tt.encoding, ConsoleEncoding: tt.consoleEncoding}.encodings()
+		assert.Equal(t, tt.file, file, "Unexpected file encoding for %q.", tt.encoding)
+		assert.Equal(t, tt.console, console, "Unexpected console encoding for %q.", tt.encoding)
+	}
+}
@@ -2,12 +2,14 @@ package zap_logger
 
 import (
 	"context"
+	"fmt"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"gopkg.in/natefinch/lumberjack.v2"
 	"io"
 	"os"
 	"strings"
+	"time"
 )
 
 // A ZapLogger provides fast, leveled, structured logging. All methods are safe
@@ -21,21 +23,40 @@ type ZapLogger struct {
 	development bool
 	addCaller   bool
 	onFatal     zapcore.CheckWriteHook // default is WriteThenFatal
+	// flushTimeout bounds the flush before Fatal and Panic terminate.
+	flushTimeout time.Duration
+	// syncFiles are the log files fsynced by that flush.
+	syncFiles []string
+	// crashDump is the log file next to which Fatal dumps the goroutines,
+	// if set.
+	crashDump string
 
 	name        string
 	errorOutput zapcore.WriteSyncer
 
-	addStack zapcore.LevelEnabler
+	addStack    zapcore.LevelEnabler
+	stackFramer *stackFramer
 
 	callerSkip int
 
+	recoverLevel zapcore.Level
+
 	clock zapcore.Clock
 
 	contextFunc func(ctx context.Context, log *ZapLogger)
 
+	// redactor is the Redactor of WithRedactor, applied by later hooks too.
+	redactor *Redactor
+
 	Ctx *inmemCtx
 
-	rotate *lumberjack.Logger
+	rotate rotator
+	// sinks follows the sinks built by NewLogger.
+	sinks *sinkObserver
+	// closers are the network sinks released by Close.
+	closers []io.Closer
+	// named is shared by the loggers derived from the one built by New.
+	named *namedLoggers
 }
 
 type LoggerI interface {
@@ -64,12 +85,15 @@ func New(core zapcore.Core, config Config, opts ...Option) *ZapLogger {
 	}
 
 	log := &ZapLogger{
-		config:      config,
-		core:        core,
-		errorOutput: zapcore.Lock(os.Stderr),
-		addStack:    zapcore.FatalLevel + 1,
-		clock:       zapcore.DefaultClock,
-		Ctx:         newMemCtx(),
+		config:       config,
+		core:         core,
+		errorOutput:  zapcore.Lock(os.Stderr),
+		addStack:     zapcore.FatalLevel + 1,
+		recoverLevel: zapcore.ErrorLevel,
+		flushTimeout: DefaultFlushTimeout,
+		clock:        zapcore.DefaultClock,
+		Ctx:          newMemCtx(),
+		named:        &namedLoggers{},
 	}
 
 	return log.WithOptions(opts...)
@@ -82,32 +106,123 @@ func New(core zapcore.Core, config Config, opts ...Option) *ZapLogger {
 // re-enable logging.
 func NewNop() *Logger {
 	return &Logger{
-		core:        zapcore.NewNopCore(),
-		errorOutput: zapcore.AddSync(io.Discard),
-		addStack:    zapcore.FatalLevel + 1,
-		clock:       zapcore.DefaultClock,
+		core:         zapcore.NewNopCore(),
+		errorOutput:  zapcore.AddSync(io.Discard),
+		addStack:     zapcore.FatalLevel + 1,
+		recoverLevel: zapcore.ErrorLevel,
+		clock:        zapcore.DefaultClock,
+		Ctx:          newMemCtx(),
 	}
 }
 
 func NewLogger(config Config, opts ...Option) *ZapLogger {
 	core := make([]zapcore.Core, 0)
 
-	cslEncoder := consoleEncoder(getStdout(config.Interval), config.EncoderConfig, config.Level)
-	if config.Encoding == "all" {
-		logfile, lb := config.writer()
-		opts = append(opts, addRotate(lb))
-		fileEncoder := jsonEncoder(logfile, config.Development, config.EncoderConfig, config.Level)
-		core = append(core, fileEncoder, cslEncoder)
-	} else if config.Encoding == "json" {
-		logfile, lb := config.writer()
-		opts = append(opts, addRotate(lb))
-		fileEncoder := jsonEncoder(logfile, config.Development, config.EncoderConfig, config.Level)
-		core = append(core, fileEncoder)
-	} else {
+	obs := &sinkObserver{metrics: config.Metrics}
+	opts = append(opts, addSinkObserver(obs))
+
+	fileEncoding, consoleEncoding := config.encodings()
+	if config.CrashDump {
+		opts = append(opts, addCrashDump(config.filename()))
+	}
+	if fileEncoding != "" {
+		logfile, rot, err := config.writer(obs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v NewLogger file error: %v\n", time.Now().UTC(), err)
+		} else {
+			opts = append(opts, addRotate(rot), addSyncFile(config.filename()))
+			if ef, ok := rot.(*encryptedFile); ok {
+				// Close ends the file with the final frame of its data key.
+				opts = append(opts, addCloser(ef))
+			}
+			fileEncoder := fileCore(config.fileEncoder(fileEncoding), logfile, config.Development, config.Level)
+			core = append(core, fileEncoder)
+		}
+	}
+	if consoleEncoding != "" {
+		cslEncoder := consoleCore(config.encoder(consoleEncoding), getStdout(config.Interval, obs), config.Level)
 		core = append(core, cslEncoder)
 	}
+	if config.Syslog != nil {
+		sysCore, closer, err := config.Syslog.core(config.Level, config.Interval, obs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v NewLogger syslog error: %v\n", time.Now().UTC(), err)
+		} else {
+			opts = append(opts, addCloser(closer))
+			core = append(core, sysCore)
+		}
+	}
+	if config.GELF != nil {
+		gelfCore, closer, err := config.GELF.core(config.EncoderConfig, config.Level, config.Interval, obs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v NewLogger GELF error: %v\n", time.Now().UTC(), err)
+		} else {
+			opts = append(opts, addCloser(closer))
+			core = append(core, gelfCore)
+		}
+	}
+	if config.HTTP != nil {
+		postCore, closer, err := httpCore(*config.HTTP, config.HTTPWAL, config.HTTPDiodeSize, zapcore.NewJSONEncoder(config.EncoderConfig), config.Level, config.Interval, "http", obs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v NewLogger HTTP sink error: %v\n", time.Now().UTC(), err)
+		} else {
+			opts = append(opts, addCloser(closer))
+			core = append(core, postCore)
+		}
+	}
+	if config.Fluent != nil {
+		fluentCore, closer, err := config.Fluent.core(config.EncoderConfig, config.Level, config.Interval, obs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v NewLogger Fluent error: %v\n", time.Now().UTC(), err)
+		} else {
+			opts = append(opts, addCloser(closer))
+			core = append(core, fluentCore)
+		}
+	}
+	if config.OTLP != nil {
+		otlpCore, closer, err := config.OTLP.core(config.Level, config.Interval, obs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v NewLogger OTLP error: %v\n", time.Now().UTC(), err)
+		} else {
+			opts = append(opts, addCloser(closer))
+			core = append(core, otlpCore)
+		}
+	}
+	var auditCore zapcore.Core
+	if config.Audit != nil {
+		c, closer, err := config.Audit.core(config.EncoderConfig, config.Level, obs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v NewLogger audit error: %v\n", time.Now().UTC(), err)
+		} else {
+			opts = append(opts, addCloser(closer), addSyncFile(config.Audit.Filename))
+			auditCore = c
+		}
+	}
+
+	tee := config.tee(core, auditCore)
+	if config.Metrics != nil {
+		// Ahead of the caller's options, so their hooks see the same entries.
+		opts = append([]Option{Hooks(config.Metrics.countEntry)}, opts...)
+	}
+	return New(tee, config, opts...)
+}
 
-	return New(zapcore.NewTee(core...), config, opts...)
+// tee combines the sink cores, sampled as set by Config.Sampling, with the
+// audit core, if any, which is never sampled so the audit log keeps every
+// record.
+func (c Config) tee(cores []zapcore.Core, audit zapcore.Core) zapcore.Core {
+	tee := zapcore.NewTee(cores...)
+	if s := c.Sampling; s != nil {
+		var samplerOpts []zapcore.SamplerOption
+		if hook := c.Metrics.samplerHook(s.Hook); hook != nil {
+			samplerOpts = append(samplerOpts, zapcore.SamplerHook(hook))
+		}
+		tee = zapcore.NewSamplerWithOptions(tee, time.Second, s.Initial, s.Thereafter, samplerOpts...)
+	}
+	if audit != nil {
+		tee = zapcore.NewTee(tee, audit)
+	}
+	return tee
 }
 
 // Sync wrap sync
@@ -127,6 +242,19 @@ func (log *ZapLogger) Sync() error {
 	return log.core.Sync()
 }
 
+// Close flushes buffered entries and releases the network sinks configured
+// in Config, such as syslog, GELF, HTTP, Fluent and OTLP, sending the
+// records they still hold, the audit log and the AsyncHooks queue, and ends
+// an encrypted log file with its final frame. The Logger and its children
+// must not be used afterwards.
+func (log *ZapLogger) Close() error {
+	err := log.core.Sync()
+	for _, c := range log.closers {
+		err = multierr.Append(err, c.Close())
+	}
+	return err
+}
+
 // Named  name logger
 func (log *ZapLogger) Named(s string) *ZapLogger {
 	if s == "" {
@@ -138,6 +266,7 @@ func (log *ZapLogger) Named(s string) *ZapLogger {
 	} else {
 		l.name = strings.Join([]string{l.name, s}, ".")
 	}
+	l.named.add(l.name, l.core)
 	return l
 }
 
@@ -0,0 +1,291 @@
+package zap_logger
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/hinha/zap-logger/pkg/diode"
+)
+
+// Metrics counts what a Logger built by NewLogger writes and drops, and
+// serves the counters in the Prometheus text exposition format:
+//
+//	metrics := zap_logger.NewMetrics()
+//	log := zap_logger.NewLogger(zap_logger.Config{Metrics: metrics, ...})
+//	http.Handle("/metrics", metrics)
+//
+// The counters are
+//
+//	zap_logger_entries_total{level, logger}     entries written
+//	zap_logger_sink_bytes_total{sink}           bytes handed to each sink
+//	zap_logger_sink_write_errors_total{sink}    writes the sink failed
+//	zap_logger_sink_dropped_total{sink}         entries dropped by the diode
+//	zap_logger_sampling_dropped_total{level}    entries dropped by Sampling
+//	zap_logger_rotations_total                  rotations of the log file
+//
+// Sinks are named file, console, syslog, gelf, http, fluent, otlp and audit.
+// A Metrics can be shared by several loggers.
+type Metrics struct {
+	entries     *counterVec
+	bytes       *counterVec
+	writeErrors *counterVec
+	dropped     *counterVec
+	sampled     *counterVec
+	rotations   *counterVec
+	exposition  []*counterVec
+}
+
+// NewMetrics returns a Metrics with no counts.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		entries:     newCounterVec("zap_logger_entries_total", "Log entries written, by level and logger name.", "level", "logger"),
+		bytes:       newCounterVec("zap_logger_sink_bytes_total", "Bytes handed to each sink.", "sink"),
+		writeErrors: newCounterVec("zap_logger_sink_write_errors_total", "Writes failed by each sink.", "sink"),
+		dropped:     newCounterVec("zap_logger_sink_dropped_total", "Entries dropped by the diode of each sink.", "sink"),
+		sampled:     newCounterVec("zap_logger_sampling_dropped_total", "Entries dropped by sampling, by level.", "level"),
+		rotations:   newCounterVec("zap_logger_rotations_total", "Log file rotations, by Sync or by lumberjack on reaching MaxSize."),
+	}
+	m.exposition = []*counterVec{m.entries, m.bytes, m.writeErrors, m.dropped, m.sampled, m.rotations}
+	return m
+}
+
+// ServeHTTP writes the counters in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteText(w)
+}
+
+// WriteText writes the counters in the Prometheus text format to w.
+func (m *Metrics) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, c := range m.exposition {
+		c.writeText(bw)
+	}
+	return bw.Flush()
+}
+
+// countEntry is the hook counting written entries.
+func (m *Metrics) countEntry(ent zapcore.Entry, _ []zapcore.Field) error {
+	m.entries.add(1, ent.Level.String(), ent.LoggerName)
+	return nil
+}
+
+// samplerHook counts the entries dropped by sampling, then calls next if set.
+func (m *Metrics) samplerHook(next func(zapcore.Entry, zapcore.SamplingDecision)) func(zapcore.Entry, zapcore.SamplingDecision) {
+	if m == nil {
+		return next
+	}
+	return func(ent zapcore.Entry, dec zapcore.SamplingDecision) {
+		if dec&zapcore.LogDropped != 0 {
+			m.sampled.add(1, ent.Level.String())
+		}
+		if next != nil {
+			next(ent, dec)
+		}
+	}
+}
+
+// alerter counts the entries dropped by the diode of sink.
+func (m *Metrics) alerter(sink string) diode.Alerter {
+	if m == nil {
+		return func(missed int) {}
+	}
+	dropped := m.dropped.counter(sink)
+	return func(missed int) {
+		atomic.AddUint64(dropped, uint64(missed))
+	}
+}
+
+// rotationCounter returns the counter of log file rotations, or nil.
+func (m *Metrics) rotationCounter() *uint64 {
+	if m == nil {
+		return nil
+	}
+	return m.rotations.counter()
+}
+
+// meteredWriter counts for a sink, recording its last error. It passes Sync
+// and Close through, so it can stand in for the sink behind a diode or a
+// core.
+type meteredWriter struct {
+	w      io.Writer
+	state  *sinkState
+	bytes  *uint64
+	errors *uint64
+}
+
+func (mw *meteredWriter) Write(p []byte) (int, error) {
+	n, err := mw.w.Write(p)
+	if mw.bytes != nil {
+		atomic.AddUint64(mw.bytes, uint64(n))
+	}
+	if err != nil {
+		if mw.errors != nil {
+			atomic.AddUint64(mw.errors, 1)
+		}
+		mw.state.mu.Lock()
+		mw.state.lastErr, mw.state.lastErrTime = err, time.Now()
+		mw.state.mu.Unlock()
+	}
+	return n, err
+}
+
+func (mw *meteredWriter) Sync() error {
+	if s, ok := mw.w.(zapcore.WriteSyncer); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (mw *meteredWriter) Close() error {
+	if c, ok := mw.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// meteredFile counts the rotations of a lumberjack file: those made by
+// Rotate, and those lumberjack makes itself, on opening a file already at
+// MaxSize or on reaching it. lumberjack has no hook for the latter, so after
+// each write the file at its name is compared with the one seen before: a
+// rotation moves the file to a backup and creates a new one.
+type meteredFile struct {
+	file      logFile
+	name      string
+	rotations *uint64
+
+	mu sync.Mutex
+	// info is the file last seen at name, nil if there was none.
+	info os.FileInfo
+}
+
+func newMeteredFile(lg *lumberjack.Logger, file logFile, rotations *uint64) *meteredFile {
+	mf := &meteredFile{file: file, name: lumberjackFilename(lg), rotations: rotations}
+	mf.info, _ = os.Stat(mf.name)
+	return mf
+}
+
+func (mf *meteredFile) Write(p []byte) (int, error) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	n, err := mf.file.Write(p)
+	if n > 0 && mf.replaced() {
+		atomic.AddUint64(mf.rotations, 1)
+	}
+	return n, err
+}
+
+func (mf *meteredFile) Rotate() error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+	if err := mf.file.Rotate(); err != nil {
+		return err
+	}
+	atomic.AddUint64(mf.rotations, 1)
+	mf.replaced()
+	return nil
+}
+
+func (mf *meteredFile) Close() error {
+	return mf.file.Close()
+}
+
+// replaced reports whether the file at name isn't the one seen last, and
+// remembers it.
+func (mf *meteredFile) replaced() bool {
+	info, err := os.Stat(mf.name)
+	if err != nil {
+		return false
+	}
+	last := mf.info
+	mf.info = info
+	return last != nil && !os.SameFile(last, info)
+}
+
+// counterVec is a counter family, keyed by its label values.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.RWMutex
+	values map[string]*uint64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]*uint64)}
+}
+
+// labelSep can't appear in label values written by the Logger.
+const labelSep = "\xff"
+
+// counter returns the counter for the label values, creating it if needed.
+func (c *counterVec) counter(values ...string) *uint64 {
+	key := strings.Join(values, labelSep)
+	c.mu.RLock()
+	v, ok := c.values[key]
+	c.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok = c.values[key]; !ok {
+		v = new(uint64)
+		c.values[key] = v
+	}
+	return v
+}
+
+func (c *counterVec) add(n uint64, values ...string) {
+	atomic.AddUint64(c.counter(values...), n)
+}
+
+func (c *counterVec) writeText(w *bufio.Writer) {
+	c.mu.RLock()
+	keys := make([]string, 0, len(c.values))
+	counts := make(map[string]uint64, len(c.values))
+	for k, v := range c.values {
+		keys = append(keys, k)
+		counts[k] = atomic.LoadUint64(v)
+	}
+	c.mu.RUnlock()
+	sort.Strings(keys)
+	if len(c.labels) == 0 && len(keys) == 0 {
+		// An unlabeled counter is reported from zero.
+		keys = append(keys, "")
+	}
+
+	w.WriteString("# HELP " + c.name + " " + c.help + "\n")
+	w.WriteString("# TYPE " + c.name + " counter\n")
+	for _, k := range keys {
+		w.WriteString(c.name)
+		if len(c.labels) > 0 {
+			w.WriteByte('{')
+			for i, v := range strings.Split(k, labelSep) {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				w.WriteString(c.labels[i] + `="` + labelEscaper.Replace(v) + `"`)
+			}
+			w.WriteByte('}')
+		}
+		w.WriteByte(' ')
+		w.WriteString(strconv.FormatUint(counts[k], 10))
+		w.WriteByte('\n')
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,138 @@
+package zap_logger
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+
+	var buf bytes.Buffer
+	d := newDiode(&buf, bufferSize, 0, "file", &sinkObserver{metrics: m})
+	bad := newDiode(failingWriter{}, DefaultDiodeSize, 0, "http", &sinkObserver{metrics: m})
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	tee := zapcore.NewTee(
+		zapcore.NewCore(enc, zapcore.AddSync(d), zapcore.DebugLevel),
+		zapcore.NewCore(enc, zapcore.AddSync(bad), zapcore.DebugLevel),
+	)
+	sampled := zapcore.NewSamplerWithOptions(tee, time.Hour, 2, 0, zapcore.SamplerHook(m.samplerHook(nil)))
+	log := New(sampled, Config{}, Hooks(m.countEntry))
+
+	log.Info("same")
+	log.Info("same")
+	log.Info("same")
+	log.Named(`api "v2"`).Warn("careful")
+	require.NoError(t, d.Sync())
+	require.NoError(t, bad.Sync())
+	require.NoError(t, d.Close())
+	require.NoError(t, bad.Close())
+
+	m.alerter("syslog")(3)
+	atomic.AddUint64(m.rotationCounter(), 1)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `# HELP zap_logger_entries_total Log entries written, by level and logger name.
+# TYPE zap_logger_entries_total counter
+zap_logger_entries_total{level="info",logger=""} 2
+zap_logger_entries_total{level="warn",logger="api \"v2\""} 1
+# HELP zap_logger_sink_bytes_total Bytes handed to each sink.
+# TYPE zap_logger_sink_bytes_total counter
+zap_logger_sink_bytes_total{sink="file"} `+strconv.Itoa(buf.Len())+`
+zap_logger_sink_bytes_total{sink="http"} 0
+# HELP zap_logger_sink_write_errors_total Writes failed by each sink.
+# TYPE zap_logger_sink_write_errors_total counter
+zap_logger_sink_write_errors_total{sink="file"} 0
+zap_logger_sink_write_errors_total{sink="http"} 3
+# HELP zap_logger_sink_dropped_total Entries dropped by the diode of each sink.
+# TYPE zap_logger_sink_dropped_total counter
+zap_logger_sink_dropped_total{sink="file"} 0
+zap_logger_sink_dropped_total{sink="http"} 0
+zap_logger_sink_dropped_total{sink="syslog"} 3
+# HELP zap_logger_sampling_dropped_total Entries dropped by sampling, by level.
+# TYPE zap_logger_sampling_dropped_total counter
+zap_logger_sampling_dropped_total{level="info"} 1
+# HELP zap_logger_rotations_total Log file rotations, by Sync or by lumberjack on reaching MaxSize.
+# TYPE zap_logger_rotations_total counter
+zap_logger_rotations_total 1
+`, rec.Body.String())
+}
+
+func TestMeteredFileRotations(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "app.log")
+	// An existing file at MaxSize is rotated by lumberjack when it opens it.
+	require.NoError(t, os.WriteFile(filename, bytes.Repeat([]byte("x"), megabyte), 0o600))
+
+	var rotations uint64
+	lj := &lumberjack.Logger{Filename: filename, MaxSize: 1}
+	defer lj.Close()
+	f := newMeteredFile(lj, lj, &rotations)
+	write := func(p []byte) {
+		_, err := f.Write(p)
+		require.NoError(t, err)
+		// lumberjack names backups by the millisecond.
+		time.Sleep(2 * time.Millisecond)
+	}
+	record := bytes.Repeat([]byte("y"), 400<<10)
+	for i := 0; i < 3; i++ {
+		write(record)
+	}
+	require.NoError(t, f.Rotate())
+	write([]byte("after rotate\n"))
+	// lumberjack's own Rotate, from outside the meteredFile, replaces the
+	// file too.
+	require.NoError(t, lj.Rotate())
+	time.Sleep(2 * time.Millisecond)
+	write([]byte("after lumberjack rotate\n"))
+	require.NoError(t, f.Close())
+	write([]byte("after close\n"))
+
+	backups, err := filepath.Glob(filepath.Join(dir, "app-*.log"))
+	require.NoError(t, err)
+	assert.Len(t, backups, 4, "Expected rotations on open, on reaching MaxSize and by both Rotates.")
+	assert.Equal(t, uint64(len(backups)), atomic.LoadUint64(&rotations), "Expected every rotation to be counted once.")
+}
+
+func TestMeteredFileConcurrentRotate(t *testing.T) {
+	var rotations uint64
+	lj := &lumberjack.Logger{Filename: filepath.Join(t.TempDir(), "app.log")}
+	defer lj.Close()
+	f := newMeteredFile(lj, lj, &rotations)
+
+	const rotates = 5
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rotates; i++ {
+			assert.NoError(t, f.Rotate())
+			time.Sleep(2 * time.Millisecond)
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		_, err := f.Write([]byte("record\n"))
+		require.NoError(t, err)
+	}
+	wg.Wait()
+	assert.Equal(t, uint64(rotates), atomic.LoadUint64(&rotations), "Expected writes not to count the rotations of Rotate again.")
+}
@@ -0,0 +1,181 @@
+package zap_logger
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/buffer"
+)
+
+// MessagePack type bytes, see https://github.com/msgpack/msgpack/blob/master/spec.md.
+const (
+	msgpackNil       = 0xc0
+	msgpackFalse     = 0xc2
+	msgpackTrue      = 0xc3
+	msgpackBin8      = 0xc4
+	msgpackBin16     = 0xc5
+	msgpackBin32     = 0xc6
+	msgpackExt8      = 0xc7
+	msgpackFloat32   = 0xca
+	msgpackFloat64   = 0xcb
+	msgpackUint8     = 0xcc
+	msgpackUint16    = 0xcd
+	msgpackUint32    = 0xce
+	msgpackUint64    = 0xcf
+	msgpackInt8      = 0xd0
+	msgpackInt16     = 0xd1
+	msgpackInt32     = 0xd2
+	msgpackInt64     = 0xd3
+	msgpackStr8      = 0xd9
+	msgpackStr16     = 0xda
+	msgpackStr32     = 0xdb
+	msgpackArray32   = 0xdd
+	msgpackMap32     = 0xdf
+	msgpackFixStr    = 0xa0
+	msgpackTimestamp = 0xff // ext type -1
+)
+
+// NewMsgpackEncoder creates an encoder writing each entry as a MessagePack
+// map, preceded by its length as a 4-byte big-endian integer. Maps and
+// arrays use 32-bit headers whose sizes are filled in when they're closed,
+// so entries are written in one pass. Times fall back to the timestamp
+// extension type when the EncoderConfig has no time encoder.
+//
+// Files written with it can be converted back to JSON with the logdecode
+// package or the zapdecode command.
+func NewMsgpackEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	return newBinaryEncoder(cfg, msgpackFormat{})
+}
+
+type msgpackFormat struct{}
+
+func (msgpackFormat) openMap(buf *buffer.Buffer) {
+	buf.AppendByte(msgpackMap32)
+	buf.AppendString("\x00\x00\x00\x00")
+}
+
+func (msgpackFormat) closeMap(buf *buffer.Buffer, start, n int) {
+	binary.BigEndian.PutUint32(buf.Bytes()[start+1:], uint32(n))
+}
+
+func (msgpackFormat) openArray(buf *buffer.Buffer) {
+	buf.AppendByte(msgpackArray32)
+	buf.AppendString("\x00\x00\x00\x00")
+}
+
+func (msgpackFormat) closeArray(buf *buffer.Buffer, start, n int) {
+	binary.BigEndian.PutUint32(buf.Bytes()[start+1:], uint32(n))
+}
+
+func appendBigEndian(buf *buffer.Buffer, v uint64, size int) {
+	for i := size - 1; i >= 0; i-- {
+		buf.AppendByte(byte(v >> (8 * uint(i))))
+	}
+}
+
+func (msgpackFormat) appendString(buf *buffer.Buffer, s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		buf.AppendByte(msgpackFixStr | byte(n))
+	case n <= math.MaxUint8:
+		buf.AppendByte(msgpackStr8)
+		buf.AppendByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.AppendByte(msgpackStr16)
+		appendBigEndian(buf, uint64(n), 2)
+	default:
+		buf.AppendByte(msgpackStr32)
+		appendBigEndian(buf, uint64(n), 4)
+	}
+	buf.AppendString(s)
+}
+
+func (msgpackFormat) appendBytes(buf *buffer.Buffer, b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		buf.AppendByte(msgpackBin8)
+		buf.AppendByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.AppendByte(msgpackBin16)
+		appendBigEndian(buf, uint64(n), 2)
+	default:
+		buf.AppendByte(msgpackBin32)
+		appendBigEndian(buf, uint64(n), 4)
+	}
+	buf.Write(b)
+}
+
+func (f msgpackFormat) appendInt(buf *buffer.Buffer, i int64) {
+	switch {
+	case i >= 0:
+		f.appendUint(buf, uint64(i))
+	case i >= -32:
+		buf.AppendByte(byte(i))
+	case i >= math.MinInt8:
+		buf.AppendByte(msgpackInt8)
+		buf.AppendByte(byte(i))
+	case i >= math.MinInt16:
+		buf.AppendByte(msgpackInt16)
+		appendBigEndian(buf, uint64(i), 2)
+	case i >= math.MinInt32:
+		buf.AppendByte(msgpackInt32)
+		appendBigEndian(buf, uint64(i), 4)
+	default:
+		buf.AppendByte(msgpackInt64)
+		appendBigEndian(buf, uint64(i), 8)
+	}
+}
+
+func (msgpackFormat) appendUint(buf *buffer.Buffer, u uint64) {
+	switch {
+	case u < 128:
+		buf.AppendByte(byte(u))
+	case u <= math.MaxUint8:
+		buf.AppendByte(msgpackUint8)
+		buf.AppendByte(byte(u))
+	case u <= math.MaxUint16:
+		buf.AppendByte(msgpackUint16)
+		appendBigEndian(buf, u, 2)
+	case u <= math.MaxUint32:
+		buf.AppendByte(msgpackUint32)
+		appendBigEndian(buf, u, 4)
+	default:
+		buf.AppendByte(msgpackUint64)
+		appendBigEndian(buf, u, 8)
+	}
+}
+
+func (msgpackFormat) appendFloat64(buf *buffer.Buffer, v float64) {
+	buf.AppendByte(msgpackFloat64)
+	appendBigEndian(buf, math.Float64bits(v), 8)
+}
+
+func (msgpackFormat) appendFloat32(buf *buffer.Buffer, v float32) {
+	buf.AppendByte(msgpackFloat32)
+	appendBigEndian(buf, uint64(math.Float32bits(v)), 4)
+}
+
+func (msgpackFormat) appendBool(buf *buffer.Buffer, v bool) {
+	if v {
+		buf.AppendByte(msgpackTrue)
+	} else {
+		buf.AppendByte(msgpackFalse)
+	}
+}
+
+func (msgpackFormat) appendNil(buf *buffer.Buffer) { buf.AppendByte(msgpackNil) }
+
+// appendTime writes the 96-bit timestamp extension: nanoseconds as a uint32
+// followed by seconds as an int64.
+func (msgpackFormat) appendTime(buf *buffer.Buffer, t time.Time) {
+	buf.AppendByte(msgpackExt8)
+	buf.AppendByte(12)
+	buf.AppendByte(msgpackTimestamp)
+	appendBigEndian(buf, uint64(t.Nanosecond()), 4)
+	appendBigEndian(buf, uint64(t.Unix()), 8)
+}
@@ -1,55 +1,368 @@
 package zap_logger
 
 import (
-	"go.uber.org/zap/zapcore"
+	"fmt"
 	"reflect"
-	"unsafe"
+	"sort"
+	"time"
+
+	"go.uber.org/zap/zapcore"
 )
 
 type any = interface{}
 
-func marshalInterface(value any) any {
-	valTypeof := reflect.TypeOf(value)
-	switch valTypeof.Kind() {
+const (
+	defaultValueMaxDepth = 8
+	defaultValueMaxSize  = 100
+)
+
+// A ValueEncoderFunc encodes value under key when it knows how to, and
+// reports whether it did. It lets callers teach the context value encoder
+// about their own types.
+type ValueEncoderFunc func(enc zapcore.ObjectEncoder, key string, value interface{}) (bool, error)
+
+// ValueEncoderConfig bounds the encoding of context values.
+type ValueEncoderConfig struct {
+	// MaxDepth is the deepest level of nested slices, maps and pointers that
+	// is encoded. It defaults to 8.
+	MaxDepth int
+	// MaxSize is the maximum number of elements encoded per slice or map. It
+	// defaults to 100.
+	MaxSize int
+	// Encoders are tried in order before the built-in encoding.
+	Encoders []ValueEncoderFunc
+}
+
+// WithValueEncoder configures how values read by the context extractor are
+// encoded.
+func WithValueEncoder(cfg ValueEncoderConfig) Option {
+	return optionFunc(func(log *Logger) {
+		ctx := *log.Ctx
+		ctx.values = newValueEncoder(cfg)
+		log.Ctx = &ctx
+	})
+}
+
+// valueEncoder encodes arbitrary values without unsafe and without panicking
+// on nil pointers, nil interfaces or values that cannot be addressed.
+type valueEncoder struct {
+	maxDepth int
+	maxSize  int
+	encoders []ValueEncoderFunc
+}
+
+func newValueEncoder(cfg ValueEncoderConfig) *valueEncoder {
+	ve := &valueEncoder{
+		maxDepth: cfg.MaxDepth,
+		maxSize:  cfg.MaxSize,
+		encoders: cfg.Encoders,
+	}
+	if ve.maxDepth <= 0 {
+		ve.maxDepth = defaultValueMaxDepth
+	}
+	if ve.maxSize <= 0 {
+		ve.maxSize = defaultValueMaxSize
+	}
+	return ve
+}
+
+var _defaultValueEncoder = newValueEncoder(ValueEncoderConfig{})
+
+// encode adds value to enc under key.
+func (ve *valueEncoder) encode(enc zapcore.ObjectEncoder, key string, value any, depth int) error {
+	for _, f := range ve.encoders {
+		if ok, err := f(enc, key, value); ok || err != nil {
+			return err
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return enc.AddReflected(key, nil)
+	case zapcore.ObjectMarshaler:
+		if isNilValue(v) {
+			return enc.AddReflected(key, nil)
+		}
+		return enc.AddObject(key, v)
+	case zapcore.ArrayMarshaler:
+		if isNilValue(v) {
+			return enc.AddReflected(key, nil)
+		}
+		return enc.AddArray(key, v)
+	case string:
+		enc.AddString(key, v)
+	case []byte:
+		enc.AddByteString(key, v)
+	case bool:
+		enc.AddBool(key, v)
+	case int:
+		enc.AddInt(key, v)
+	case int8:
+		enc.AddInt8(key, v)
+	case int16:
+		enc.AddInt16(key, v)
+	case int32:
+		enc.AddInt32(key, v)
+	case int64:
+		enc.AddInt64(key, v)
+	case uint:
+		enc.AddUint(key, v)
+	case uint8:
+		enc.AddUint8(key, v)
+	case uint16:
+		enc.AddUint16(key, v)
+	case uint32:
+		enc.AddUint32(key, v)
+	case uint64:
+		enc.AddUint64(key, v)
+	case uintptr:
+		enc.AddUintptr(key, v)
+	case float32:
+		enc.AddFloat32(key, v)
+	case float64:
+		enc.AddFloat64(key, v)
+	case complex64:
+		enc.AddComplex64(key, v)
+	case complex128:
+		enc.AddComplex128(key, v)
+	case time.Time:
+		enc.AddTime(key, v)
+	case *time.Time:
+		if v == nil {
+			return enc.AddReflected(key, nil)
+		}
+		enc.AddTime(key, *v)
+	case time.Duration:
+		enc.AddDuration(key, v)
+	case error:
+		enc.AddString(key, safeString(v, v.Error))
+	case fmt.Stringer:
+		enc.AddString(key, safeString(v, v.String))
+	default:
+		return ve.encodeReflected(enc, key, reflect.ValueOf(value), depth)
+	}
+	return nil
+}
+
+func (ve *valueEncoder) encodeReflected(enc zapcore.ObjectEncoder, key string, v reflect.Value, depth int) error {
+	if depth >= ve.maxDepth {
+		enc.AddString(key, "<max depth exceeded>")
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return enc.AddReflected(key, nil)
+		}
+		return ve.encodeElem(enc, key, v.Elem(), depth+1)
+	case reflect.Slice:
+		if v.IsNil() {
+			return enc.AddReflected(key, nil)
+		}
+		return enc.AddArray(key, reflectedArray{ve: ve, v: v, depth: depth + 1})
+	case reflect.Array:
+		return enc.AddArray(key, reflectedArray{ve: ve, v: v, depth: depth + 1})
+	case reflect.Map:
+		if v.IsNil() {
+			return enc.AddReflected(key, nil)
+		}
+		return enc.AddObject(key, reflectedMap{ve: ve, v: v, depth: depth + 1})
 	case reflect.Struct:
-		return value
-	case reflect.Ptr:
-		return getUnsafePtr(reflect.ValueOf(value).Elem())
+		if !v.CanInterface() {
+			enc.AddString(key, v.Type().String())
+			return nil
+		}
+		if err := enc.AddReflected(key, v.Interface()); err != nil {
+			enc.AddString(key, v.Type().String())
+		}
+		return nil
+	case reflect.Bool:
+		enc.AddBool(key, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		enc.AddInt64(key, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		enc.AddUint64(key, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		enc.AddFloat64(key, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		enc.AddComplex128(key, v.Complex())
+	case reflect.String:
+		enc.AddString(key, v.String())
 	default:
+		// Channels, functions and unsafe pointers have no meaningful encoding.
+		enc.AddString(key, v.Type().String())
 	}
 	return nil
 }
 
-func getUnsafePtr(field reflect.Value) interface{} {
-	return reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Interface()
+// encodeElem encodes a value reached through reflection, going back to the
+// type switch when the value can be turned into an interface.
+func (ve *valueEncoder) encodeElem(enc zapcore.ObjectEncoder, key string, v reflect.Value, depth int) error {
+	if !v.IsValid() {
+		return enc.AddReflected(key, nil)
+	}
+	if !v.CanInterface() {
+		return ve.encodeReflected(enc, key, v, depth)
+	}
+	if depth >= ve.maxDepth {
+		enc.AddString(key, "<max depth exceeded>")
+		return nil
+	}
+	return ve.encode(enc, key, v.Interface(), depth)
+}
+
+type reflectedArray struct {
+	ve    *valueEncoder
+	v     reflect.Value
+	depth int
+}
+
+func (ra reflectedArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	n := ra.v.Len()
+	if n > ra.ve.maxSize {
+		n = ra.ve.maxSize
+	}
+	elems := arrayElemEncoder{enc}
+	for i := 0; i < n; i++ {
+		if err := ra.ve.encodeElem(elems, "", ra.v.Index(i), ra.depth); err != nil {
+			return err
+		}
+	}
+	if more := ra.v.Len() - n; more > 0 {
+		enc.AppendString(fmt.Sprintf("<%d more>", more))
+	}
+	return nil
+}
+
+// arrayElemEncoder adapts an ArrayEncoder to the ObjectEncoder interface so
+// array elements share the value encoding of object fields. Keys are ignored.
+type arrayElemEncoder struct {
+	zapcore.ArrayEncoder
+}
+
+func (e arrayElemEncoder) AddArray(_ string, v zapcore.ArrayMarshaler) error {
+	return e.AppendArray(v)
+}
+
+func (e arrayElemEncoder) AddObject(_ string, v zapcore.ObjectMarshaler) error {
+	return e.AppendObject(v)
+}
+
+func (e arrayElemEncoder) AddBinary(_ string, v []byte)               { e.AppendByteString(v) }
+func (e arrayElemEncoder) AddByteString(_ string, v []byte)           { e.AppendByteString(v) }
+func (e arrayElemEncoder) AddBool(_ string, v bool)                   { e.AppendBool(v) }
+func (e arrayElemEncoder) AddComplex128(_ string, v complex128)       { e.AppendComplex128(v) }
+func (e arrayElemEncoder) AddComplex64(_ string, v complex64)         { e.AppendComplex64(v) }
+func (e arrayElemEncoder) AddDuration(_ string, v time.Duration)      { e.AppendDuration(v) }
+func (e arrayElemEncoder) AddFloat64(_ string, v float64)             { e.AppendFloat64(v) }
+func (e arrayElemEncoder) AddFloat32(_ string, v float32)             { e.AppendFloat32(v) }
+func (e arrayElemEncoder) AddInt(_ string, v int)                     { e.AppendInt(v) }
+func (e arrayElemEncoder) AddInt64(_ string, v int64)                 { e.AppendInt64(v) }
+func (e arrayElemEncoder) AddInt32(_ string, v int32)                 { e.AppendInt32(v) }
+func (e arrayElemEncoder) AddInt16(_ string, v int16)                 { e.AppendInt16(v) }
+func (e arrayElemEncoder) AddInt8(_ string, v int8)                   { e.AppendInt8(v) }
+func (e arrayElemEncoder) AddString(_ string, v string)               { e.AppendString(v) }
+func (e arrayElemEncoder) AddTime(_ string, v time.Time)              { e.AppendTime(v) }
+func (e arrayElemEncoder) AddUint(_ string, v uint)                   { e.AppendUint(v) }
+func (e arrayElemEncoder) AddUint64(_ string, v uint64)               { e.AppendUint64(v) }
+func (e arrayElemEncoder) AddUint32(_ string, v uint32)               { e.AppendUint32(v) }
+func (e arrayElemEncoder) AddUint16(_ string, v uint16)               { e.AppendUint16(v) }
+func (e arrayElemEncoder) AddUint8(_ string, v uint8)                 { e.AppendUint8(v) }
+func (e arrayElemEncoder) AddUintptr(_ string, v uintptr)             { e.AppendUintptr(v) }
+func (e arrayElemEncoder) AddReflected(_ string, v interface{}) error { return e.AppendReflected(v) }
+func (e arrayElemEncoder) OpenNamespace(string)                       {}
+
+type reflectedMap struct {
+	ve    *valueEncoder
+	v     reflect.Value
+	depth int
+}
+
+func (rm reflectedMap) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	keys := rm.v.MapKeys()
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = mapKeyString(k)
+	}
+	sort.Sort(mapKeys{names, keys})
+
+	n := len(keys)
+	if n > rm.ve.maxSize {
+		n = rm.ve.maxSize
+	}
+	for i := 0; i < n; i++ {
+		if err := rm.ve.encodeElem(enc, names[i], rm.v.MapIndex(keys[i]), rm.depth); err != nil {
+			return err
+		}
+	}
+	if more := len(keys) - n; more > 0 {
+		enc.AddInt("<more>", more)
+	}
+	return nil
+}
+
+type mapKeys struct {
+	names  []string
+	values []reflect.Value
+}
+
+func (mk mapKeys) Len() int           { return len(mk.names) }
+func (mk mapKeys) Less(i, j int) bool { return mk.names[i] < mk.names[j] }
+func (mk mapKeys) Swap(i, j int) {
+	mk.names[i], mk.names[j] = mk.names[j], mk.names[i]
+	mk.values[i], mk.values[j] = mk.values[j], mk.values[i]
+}
+
+func mapKeyString(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	if k.CanInterface() {
+		if s, ok := k.Interface().(fmt.Stringer); ok && !isNilValue(s) {
+			return safeString(s, s.String)
+		}
+		return fmt.Sprint(k.Interface())
+	}
+	return k.Type().String()
+}
+
+// isNilValue reports whether v holds a nil pointer, map, slice or func.
+func isNilValue(v any) bool {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Interface, reflect.Chan:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// safeString calls f, typically an Error or String method, turning panics
+// from nil receivers into "<nil>" like zap does for errors.
+func safeString(v any, f func() string) (s string) {
+	defer func() {
+		if r := recover(); r != nil {
+			if isNilValue(v) {
+				s = "<nil>"
+				return
+			}
+			s = fmt.Sprintf("<PANIC=%v>", r)
+		}
+	}()
+	return f()
 }
 
 type contextFieldValue struct {
 	value  interface{}
 	caller zapcore.EntryCaller
+	enc    *valueEncoder
 }
 
 func (c contextFieldValue) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("caller", c.caller.TrimmedPath())
-	switch ctxValue := c.value.(type) {
-	case string:
-		enc.AddString("value", ctxValue)
-	case int8:
-		enc.AddInt8("value", ctxValue)
-	case int:
-		enc.AddInt("value", ctxValue)
-	case int32:
-		enc.AddInt32("value", ctxValue)
-	case int64:
-		enc.AddInt64("value", ctxValue)
-	case bool:
-		enc.AddBool("value", ctxValue)
-	case interface{}:
-		if ctxValue != nil {
-			if err := enc.AddReflected("value", marshalInterface(ctxValue)); err != nil {
-				return err
-			}
-		}
+	ve := c.enc
+	if ve == nil {
+		ve = _defaultValueEncoder
 	}
-	return nil
+	return ve.encode(enc, "value", c.value, 0)
 }
@@ -0,0 +1,186 @@
+package zap_logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nilStringer struct{ name string }
+
+func (s *nilStringer) String() string { return s.name }
+
+type nilError struct{ msg string }
+
+func (e *nilError) Error() string { return e.msg }
+
+type node struct {
+	Name string
+	Next *node
+}
+
+func encodeContextValue(t testing.TB, ve *valueEncoder, value interface{}) string {
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeTime:     zapcore.RFC3339NanoTimeEncoder,
+	})
+	cf := contextFieldValue{value: value, enc: ve}
+	buf, err := enc.EncodeEntry(zapcore.Entry{}, []zapcore.Field{zap.Object("ctx", cf)})
+	require.NoError(t, err, "Unexpected error encoding %#v.", value)
+	defer buf.Free()
+
+	var out struct {
+		Ctx struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"ctx"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &out), "Expected valid JSON, got %s.", buf.String())
+	return string(out.Ctx.Value)
+}
+
+func TestContextFieldValueEncoding(t *testing.T) {
+	var (
+		nilPtr      *node
+		nilStr      *nilStringer
+		nilErr      *nilError
+		typedNilErr error = nilErr
+		loop              = &node{Name: "loop"}
+	)
+	loop.Next = loop
+
+	tests := []struct {
+		desc     string
+		value    interface{}
+		expected string
+	}{
+		{"nil", nil, `null`},
+		{"string", "foo", `"foo"`},
+		{"int8", int8(-8), `-8`},
+		{"int16", int16(-16), `-16`},
+		{"uint", uint(7), `7`},
+		{"uint16", uint16(16), `16`},
+		{"uint64", uint64(math.MaxUint64), `18446744073709551615`},
+		{"float32", float32(1.5), `1.5`},
+		{"float64", 2.25, `2.25`},
+		{"bool", true, `true`},
+		{"duration", time.Second, `"1s"`},
+		{"time", time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC), `"2022-01-02T03:04:05Z"`},
+		{"error", errors.New("egad"), `"egad"`},
+		{"nil pointer error", typedNilErr, `"<nil>"`},
+		{"stringer", zap.InfoLevel, `"info"`},
+		{"nil stringer", nilStr, `"<nil>"`},
+		{"nil pointer", nilPtr, `null`},
+		{"pointer to int", func() *int { i := 3; return &i }(), `3`},
+		{"slice", []int{1, 2, 3}, `[1,2,3]`},
+		{"nil slice", []string(nil), `null`},
+		{"mixed slice", []interface{}{"a", 1, nil, time.Minute}, `["a",1,null,"1m0s"]`},
+		{"map", map[string]interface{}{"b": 2, "a": []string{"x"}}, `{"a":["x"],"b":2}`},
+		{"int keyed map", map[int]bool{2: false, 1: true}, `{"1":true,"2":false}`},
+		{"nil map", map[string]int(nil), `null`},
+		{"struct", node{Name: "n"}, `{"Name":"n","Next":null}`},
+		{"cyclic struct", loop, `"zap_logger.node"`},
+		{"channel", make(chan int), `"chan int"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			assert.Equal(t, tt.expected, encodeContextValue(t, _defaultValueEncoder, tt.value))
+		})
+	}
+}
+
+func TestContextFieldValueLimits(t *testing.T) {
+	ve := newValueEncoder(ValueEncoderConfig{MaxDepth: 2, MaxSize: 2})
+
+	assert.Equal(t, `[1,2,"<1 more>"]`, encodeContextValue(t, ve, []int{1, 2, 3}))
+	assert.Equal(t, `{"a":1,"b":2,"<more>":1}`, encodeContextValue(t, ve, map[string]int{"a": 1, "b": 2, "c": 3}))
+	assert.Equal(t, `[["<max depth exceeded>"]]`, encodeContextValue(t, ve, [][][]int{{{1}}}))
+}
+
+func TestContextFieldValueCustomEncoder(t *testing.T) {
+	ve := newValueEncoder(ValueEncoderConfig{Encoders: []ValueEncoderFunc{
+		func(enc zapcore.ObjectEncoder, key string, value interface{}) (bool, error) {
+			if n, ok := value.(node); ok {
+				enc.AddString(key, "node:"+n.Name)
+				return true, nil
+			}
+			return false, nil
+		},
+	}})
+	assert.Equal(t, `"node:n"`, encodeContextValue(t, ve, node{Name: "n"}))
+	assert.Equal(t, `1`, encodeContextValue(t, ve, 1))
+}
+
+// fuzzValue builds an arbitrary value tree out of the fuzzer's input.
+func fuzzValue(data []byte, depth int) (interface{}, []byte) {
+	if len(data) == 0 {
+		return nil, data
+	}
+	kind, data := data[0], data[1:]
+	if depth > 12 {
+		kind %= 8
+	}
+	switch kind % 14 {
+	case 0:
+		return nil, data
+	case 1:
+		return string(data[:len(data)/2]), data[len(data)/2:]
+	case 2:
+		return int64(kind) - 100, data
+	case 3:
+		return uint8(kind), data
+	case 4:
+		return math.Float64frombits(uint64(kind) << 56), data
+	case 5:
+		var p *node
+		return p, data
+	case 6:
+		var e *nilError
+		return error(e), data
+	case 7:
+		return &nilStringer{name: fmt.Sprint(kind)}, data
+	case 8:
+		elem, rest := fuzzValue(data, depth+1)
+		return []interface{}{elem, elem}, rest
+	case 9:
+		elem, rest := fuzzValue(data, depth+1)
+		return map[string]interface{}{fmt.Sprint(len(rest)): elem}, rest
+	case 10:
+		elem, rest := fuzzValue(data, depth+1)
+		return &elem, rest
+	case 11:
+		return &node{Name: string(data)}, nil
+	case 12:
+		return map[interface{}]interface{}{kind: nil, "x": []byte(data)}, nil
+	default:
+		return time.Duration(kind) * time.Millisecond, data
+	}
+}
+
+func FuzzContextFieldValue(f *testing.F) {
+	for _, seed := range [][]byte{
+		nil,
+		{1, 'a', 'b'},
+		{8, 9, 10, 5},
+		{10, 10, 10, 10, 10, 10, 10, 10, 10, 10, 6},
+		{12, 0xff, 0xfe},
+		{9, 8, 2, 7, 3},
+	} {
+		f.Add(seed, uint8(3), uint8(4))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, maxDepth, maxSize uint8) {
+		value, _ := fuzzValue(data, 0)
+		ve := newValueEncoder(ValueEncoderConfig{MaxDepth: int(maxDepth), MaxSize: int(maxSize)})
+		encodeContextValue(t, ve, value)
+	})
+}
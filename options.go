@@ -3,10 +3,10 @@ package zap_logger
 import (
 	"context"
 	"fmt"
+	"io"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"gopkg.in/natefinch/lumberjack.v2"
 
 	"github.com/hinha/zap-logger/buffer"
 )
@@ -38,9 +38,16 @@ func Fields(fs ...zap.Field) Option {
 	})
 }
 
-func addRotate(lb *lumberjack.Logger) Option {
+func addRotate(r rotator) Option {
 	return optionFunc(func(log *Logger) {
-		log.rotate = lb
+		log.rotate = r
+	})
+}
+
+// addCloser registers a sink to release when the Logger is closed.
+func addCloser(c io.Closer) Option {
+	return optionFunc(func(log *Logger) {
+		log.closers = append(log.closers[:len(log.closers):len(log.closers)], c)
 	})
 }
 
@@ -54,6 +61,18 @@ func (log *Logger) WithOptions(opts ...Option) *Logger {
 	return c
 }
 
+// ErrorOutput sets the destination for errors generated by the Logger. Note
+// that this option only affects internal errors such as a failure to get the
+// caller or invalid key-value pairs passed to a SugaredLogger.
+//
+// The supplied WriteSyncer must be safe for concurrent use. zapcore.Lock is
+// the simplest way to protect files with a mutex.
+func ErrorOutput(w zapcore.WriteSyncer) Option {
+	return optionFunc(func(log *Logger) {
+		log.errorOutput = w
+	})
+}
+
 // AddCaller configures the Logger to annotate each message with the filename,
 // line number, and function name of zap's caller. See also WithCaller.
 func AddCaller() Option {
@@ -87,6 +106,15 @@ func AddStacktrace(lvl zapcore.LevelEnabler) Option {
 	})
 }
 
+// WithStackFrames makes the stack traces recorded by AddStacktrace an array
+// of {function, file, line, package} objects instead of a string, for
+// backends that index frames. See StackFrameOptions for the filters.
+func WithStackFrames(opts StackFrameOptions) Option {
+	return optionFunc(func(log *Logger) {
+		log.stackFramer = newStackFramer(opts)
+	})
+}
+
 // Development puts the logger in development mode, which makes DPanic-level
 // logs panic instead of simply logging an error.
 func Development() Option {
@@ -125,13 +153,27 @@ func (log *Logger) check(lvl zapcore.Level, msg string) *zapcore.CheckedEntry {
 		Level:      lvl,
 		Message:    msg,
 	}
-	ce := log.core.Check(ent, nil)
+	var (
+		ce     *zapcore.CheckedEntry
+		frames *stackFramesEntry
+	)
+	if log.stackFramer != nil && log.addStack.Enabled(lvl) {
+		// Structured stack traces are a field rather than ce.Stack, added on
+		// the way to the cores that picked the entry.
+		if inner := log.core.Check(ent, nil); inner != nil {
+			frames = &stackFramesEntry{Core: log.core, inner: inner}
+			ce = ce.AddCore(ent, frames)
+			frames.outer = ce
+		}
+	} else {
+		ce = log.core.Check(ent, nil)
+	}
 	willWrite := ce != nil
 
 	// Set up any required terminal behavior.
 	switch ent.Level {
 	case zapcore.PanicLevel:
-		ce = ce.After(ent, zapcore.WriteThenPanic)
+		ce = ce.After(ent, log.terminal(zapcore.WriteThenPanic))
 	case zapcore.FatalLevel:
 		onFatal := log.onFatal
 		// nil or WriteThenNoop will lead to continued execution after
@@ -148,10 +190,13 @@ func (log *Logger) check(lvl zapcore.Level, msg string) *zapcore.CheckedEntry {
 		if onFatal == nil || onFatal == zapcore.WriteThenNoop {
 			onFatal = zapcore.WriteThenFatal
 		}
-		ce = ce.After(ent, onFatal)
+		if log.crashDump != "" {
+			onFatal = crashDumpHook{filename: log.crashDump, next: onFatal}
+		}
+		ce = ce.After(ent, log.terminal(onFatal))
 	case zapcore.DPanicLevel:
 		if log.development {
-			ce = ce.After(ent, zapcore.WriteThenPanic)
+			ce = ce.After(ent, log.terminal(zapcore.WriteThenPanic))
 		}
 	}
 
@@ -199,7 +244,9 @@ func (log *Logger) check(lvl zapcore.Level, msg string) *zapcore.CheckedEntry {
 		}
 	}
 
-	if addStack {
+	if frames != nil {
+		frames.field = zap.Array(log.stackFramer.key, log.stackFramer.Frames(frame, more, stack))
+	} else if addStack {
 		buffer := buffer.Get()
 		defer buffer.Free()
 
@@ -216,3 +263,29 @@ func (log *Logger) check(lvl zapcore.Level, msg string) *zapcore.CheckedEntry {
 
 	return ce
 }
+
+// stackFramesEntry writes a checked entry of the wrapped core with the
+// structured stack trace appended to its fields. It's added to a single
+// CheckedEntry and written once.
+type stackFramesEntry struct {
+	zapcore.Core
+	inner *zapcore.CheckedEntry
+	// outer is the CheckedEntry it was added to, whose error output the
+	// wrapped cores report to.
+	outer *zapcore.CheckedEntry
+	// field holds the frames, once captured.
+	field zapcore.Field
+}
+
+func (e *stackFramesEntry) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	// The logger sets the caller and the error output after checking.
+	e.inner.Entry = ent
+	e.inner.ErrorOutput = e.outer.ErrorOutput
+	if e.field.Key != "" {
+		fields = append(fields[:len(fields):len(fields)], e.field)
+	}
+	e.inner.Write(fields...)
+	return nil
+}
+
+func (e *stackFramesEntry) Sync() error { return nil }
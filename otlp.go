@@ -0,0 +1,652 @@
+package zap_logger
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	zapbuffer "go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/pkg/httpsink"
+	"github.com/hinha/zap-logger/pkg/wal"
+)
+
+// OTLPProtocol selects how the OTLP output encodes requests.
+type OTLPProtocol int
+
+const (
+	// OTLPProtobuf posts binary protobuf, the default of OTLP/HTTP.
+	OTLPProtobuf OTLPProtocol = iota
+	// OTLPJSON posts the JSON encoding of the protobuf messages.
+	OTLPJSON
+)
+
+// DefaultOTLPEndpoint is the logs endpoint of a local collector.
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/logs"
+
+// OTLPContextFields maps context keys to the LogRecord fields their values
+// are exported as. Keys are matched like ECSContextFields. Trace and span
+// IDs must be hex strings; other context values become attributes of a
+// "context" map.
+var OTLPContextFields = map[string]string{
+	"traceid":    "trace_id",
+	"spanid":     "span_id",
+	"traceflags": "flags",
+}
+
+var _otlpPool = zapbuffer.NewPool()
+
+// OTLPConfig configures the OpenTelemetry logs output.
+type OTLPConfig struct {
+	// Endpoint is the OTLP/HTTP logs URL. It defaults to
+	// DefaultOTLPEndpoint.
+	Endpoint string
+	// Protocol selects protobuf or JSON requests.
+	Protocol OTLPProtocol
+	// Header is added to every request, e.g. for authentication.
+	Header http.Header
+	// ServiceName, ServiceVersion and HostName are the service.name,
+	// service.version and host.name resource attributes. ServiceName
+	// defaults to the executable name and HostName to the host name.
+	ServiceName    string
+	ServiceVersion string
+	HostName       string
+	// ResourceAttributes are added to the resource, e.g.
+	// {"deployment.environment": "production"}.
+	ResourceAttributes map[string]string
+	// Sink tunes batching, retries and spooling. Its URL, Header and
+	// Adapter are set from the fields above.
+	Sink httpsink.Config
+	// WAL, if set, keeps records in a write-ahead log on disk between the
+	// diode and the sink until the sink accepts them, so they survive a
+	// restart. See package wal.
+	WAL *wal.Options
+	// DiodeSize is the number of records held for the sink while it's busy,
+	// DefaultDiodeSize if zero.
+	DiodeSize int
+}
+
+// NewOTLPEncoder creates an encoder writing each entry as an OpenTelemetry
+// ScopeLogs message holding a single LogRecord, in protobuf or JSON. The
+// logger name is the instrumentation scope, the message is the body and
+// fields become attributes, with objects and arrays kept as maps and lists.
+// Context values listed in OTLPContextFields set the trace context of the
+// record, and the caller and stack trace are exported as the code.*
+// attributes. Protobuf messages have no line ending.
+func NewOTLPEncoder(protocol OTLPProtocol) zapcore.Encoder {
+	return &otlpEncoder{json: protocol == OTLPJSON}
+}
+
+// otlpEncoder keeps the fields added by With and marshals them with the
+// fields of each entry.
+type otlpEncoder struct {
+	json   bool
+	fields []zapcore.Field
+}
+
+// Clone implements zapcore.Encoder.
+func (enc *otlpEncoder) Clone() zapcore.Encoder {
+	return &otlpEncoder{json: enc.json, fields: append([]zapcore.Field(nil), enc.fields...)}
+}
+
+func (enc *otlpEncoder) add(f zapcore.Field) { enc.fields = append(enc.fields, f) }
+
+// ObjectEncoder methods, called by With, keep the fields to marshal later.
+func (enc *otlpEncoder) AddArray(k string, v zapcore.ArrayMarshaler) error {
+	enc.add(zap.Array(k, v))
+	return nil
+}
+func (enc *otlpEncoder) AddObject(k string, v zapcore.ObjectMarshaler) error {
+	enc.add(zap.Object(k, v))
+	return nil
+}
+func (enc *otlpEncoder) AddReflected(k string, v interface{}) error {
+	enc.add(zap.Reflect(k, v))
+	return nil
+}
+func (enc *otlpEncoder) AddBinary(k string, v []byte)          { enc.add(zap.Binary(k, v)) }
+func (enc *otlpEncoder) AddByteString(k string, v []byte)      { enc.add(zap.ByteString(k, v)) }
+func (enc *otlpEncoder) AddBool(k string, v bool)              { enc.add(zap.Bool(k, v)) }
+func (enc *otlpEncoder) AddComplex128(k string, v complex128)  { enc.add(zap.Complex128(k, v)) }
+func (enc *otlpEncoder) AddComplex64(k string, v complex64)    { enc.add(zap.Complex64(k, v)) }
+func (enc *otlpEncoder) AddDuration(k string, v time.Duration) { enc.add(zap.Duration(k, v)) }
+func (enc *otlpEncoder) AddFloat64(k string, v float64)        { enc.add(zap.Float64(k, v)) }
+func (enc *otlpEncoder) AddFloat32(k string, v float32)        { enc.add(zap.Float32(k, v)) }
+func (enc *otlpEncoder) AddInt(k string, v int)                { enc.add(zap.Int(k, v)) }
+func (enc *otlpEncoder) AddInt64(k string, v int64)            { enc.add(zap.Int64(k, v)) }
+func (enc *otlpEncoder) AddInt32(k string, v int32)            { enc.add(zap.Int32(k, v)) }
+func (enc *otlpEncoder) AddInt16(k string, v int16)            { enc.add(zap.Int16(k, v)) }
+func (enc *otlpEncoder) AddInt8(k string, v int8)              { enc.add(zap.Int8(k, v)) }
+func (enc *otlpEncoder) AddString(k, v string)                 { enc.add(zap.String(k, v)) }
+func (enc *otlpEncoder) AddTime(k string, v time.Time)         { enc.add(zap.Time(k, v)) }
+func (enc *otlpEncoder) AddUint(k string, v uint)              { enc.add(zap.Uint(k, v)) }
+func (enc *otlpEncoder) AddUint64(k string, v uint64)          { enc.add(zap.Uint64(k, v)) }
+func (enc *otlpEncoder) AddUint32(k string, v uint32)          { enc.add(zap.Uint32(k, v)) }
+func (enc *otlpEncoder) AddUint16(k string, v uint16)          { enc.add(zap.Uint16(k, v)) }
+func (enc *otlpEncoder) AddUint8(k string, v uint8)            { enc.add(zap.Uint8(k, v)) }
+func (enc *otlpEncoder) AddUintptr(k string, v uintptr)        { enc.add(zap.Uintptr(k, v)) }
+func (enc *otlpEncoder) OpenNamespace(k string)                { enc.add(zap.Namespace(k)) }
+
+// otlpSeverity returns the SeverityNumber of a level.
+func otlpSeverity(l zapcore.Level) int {
+	switch l {
+	case zapcore.DebugLevel:
+		return 5
+	case zapcore.InfoLevel:
+		return 9
+	case zapcore.WarnLevel:
+		return 13
+	case zapcore.ErrorLevel:
+		return 17
+	case zapcore.DPanicLevel:
+		return 18
+	case zapcore.PanicLevel:
+		return 19
+	case zapcore.FatalLevel:
+		return 21
+	}
+	return 0
+}
+
+// otlpRecord is a LogRecord and the name of its scope.
+type otlpRecord struct {
+	scope      string
+	time       time.Time
+	severity   int
+	text       string
+	body       string
+	attributes []otlpKeyValue
+	traceID    []byte
+	spanID     []byte
+	flags      uint32
+}
+
+type otlpKeyValue struct {
+	key   string
+	value interface{}
+}
+
+// EncodeEntry implements zapcore.Encoder.
+func (enc *otlpEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*zapbuffer.Buffer, error) {
+	rec := otlpRecord{
+		scope:    ent.LoggerName,
+		time:     ent.Time,
+		severity: otlpSeverity(ent.Level),
+		text:     ent.Level.CapitalString(),
+		body:     ent.Message,
+	}
+
+	attrs := zapcore.NewMapObjectEncoder()
+	if ent.Caller.Defined {
+		attrs.AddString("code.filepath", ent.Caller.File)
+		attrs.AddInt("code.lineno", ent.Caller.Line)
+		if ent.Caller.Function != "" {
+			attrs.AddString("code.function", ent.Caller.Function)
+		}
+	}
+	if ent.Stack != "" {
+		attrs.AddString("code.stacktrace", ent.Stack)
+	}
+	for _, fs := range [][]zapcore.Field{enc.fields, fields} {
+		for i := range fs {
+			if obj, ok := fs[i].Interface.(zapcore.ObjectMarshaler); ok && fs[i].Key == "context" {
+				if err := rec.addContext(attrs, obj); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			fs[i].AddTo(attrs)
+		}
+	}
+	rec.attributes = otlpKeyValues(attrs.Fields)
+
+	out := _otlpPool.Get()
+	if enc.json {
+		b, err := json.Marshal(rec.jsonScopeLogs())
+		if err != nil {
+			out.Free()
+			return nil, err
+		}
+		out.Write(b)
+		out.AppendString(zapcore.DefaultLineEnding)
+		return out, nil
+	}
+	out.Write(rec.protoScopeLogs())
+	return out, nil
+}
+
+// addContext sets the trace context from the "context" object added by the
+// *Ctx methods and adds the other values as a "context" attribute.
+func (rec *otlpRecord) addContext(attrs zapcore.ObjectEncoder, obj zapcore.ObjectMarshaler) error {
+	moved, rest, err := splitContext(obj, func(ctxKey string) (string, bool) {
+		field, ok := OTLPContextFields[normalizeContextKey(ctxKey)]
+		return field, ok
+	})
+	if err != nil {
+		return err
+	}
+	for _, m := range moved {
+		switch m.key {
+		case "trace_id":
+			if id, ok := otlpID(m.value, 16); ok {
+				rec.traceID = id
+				continue
+			}
+		case "span_id":
+			if id, ok := otlpID(m.value, 8); ok {
+				rec.spanID = id
+				continue
+			}
+		case "flags":
+			if flags, ok := otlpFlags(m.value); ok {
+				rec.flags = flags
+				continue
+			}
+		}
+		rest[m.key] = m.value
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	return attrs.AddReflected("context", rest)
+}
+
+// otlpID decodes a hex trace or span ID of size bytes.
+func otlpID(v interface{}, size int) ([]byte, bool) {
+	s, ok := v.(string)
+	if !ok || len(s) != 2*size {
+		return nil, false
+	}
+	id, err := hex.DecodeString(s)
+	return id, err == nil
+}
+
+// otlpFlags accepts W3C trace flags as a number or a hex string like "01".
+func otlpFlags(v interface{}) (uint32, bool) {
+	switch v := v.(type) {
+	case string:
+		f, err := strconv.ParseUint(v, 16, 8)
+		return uint32(f), err == nil
+	case float64:
+		return uint32(v), v >= 0 && v <= 255
+	case int:
+		return uint32(v), v >= 0 && v <= 255
+	}
+	return 0, false
+}
+
+// otlpKeyValues converts fields collected by a MapObjectEncoder to
+// attributes, sorted by key.
+func otlpKeyValues(fields map[string]interface{}) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(fields))
+	for k, v := range fields {
+		kvs = append(kvs, otlpKeyValue{key: k, value: otlpValue(v)})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].key < kvs[j].key })
+	return kvs
+}
+
+// otlpValue normalizes a field value to the AnyValue types: string, bool,
+// int64, float64, []byte, []interface{} or []otlpKeyValue. Other values are
+// converted through their JSON form.
+func otlpValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, string, bool, int64, float64, []byte:
+		return v
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint:
+		return otlpUint(uint64(v))
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
+	case uint64:
+		return otlpUint(v)
+	case uintptr:
+		return otlpUint(uint64(v))
+	case float32:
+		return float64(v)
+	case complex64, complex128:
+		return fmt.Sprint(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return v.String()
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		return otlpKeyValues(v)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = otlpValue(v[i])
+		}
+		return values
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	var generic interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&generic); err != nil {
+		return string(b)
+	}
+	return otlpValue(generic)
+}
+
+func otlpUint(u uint64) interface{} {
+	if u > math.MaxInt64 {
+		return float64(u)
+	}
+	return int64(u)
+}
+
+// Protobuf encoding of the OTLP messages, see
+// https://github.com/open-telemetry/opentelemetry-proto.
+
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+func pbAppendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func pbAppendTag(b []byte, field, wire int) []byte {
+	return pbAppendVarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func pbAppendBytes(b []byte, field int, data []byte) []byte {
+	b = pbAppendTag(b, field, pbBytes)
+	b = pbAppendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func pbAppendString(b []byte, field int, s string) []byte {
+	b = pbAppendTag(b, field, pbBytes)
+	b = pbAppendVarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func pbAppendFixed64(b []byte, field int, v uint64) []byte {
+	b = pbAppendTag(b, field, pbFixed64)
+	for i := 0; i < 8; i++ {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+func pbAppendFixed32(b []byte, field int, v uint32) []byte {
+	b = pbAppendTag(b, field, pbFixed32)
+	for i := 0; i < 4; i++ {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+// pbAnyValue encodes an AnyValue.
+func pbAnyValue(v interface{}) []byte {
+	var b []byte
+	switch v := v.(type) {
+	case string:
+		b = pbAppendString(b, 1, v)
+	case bool:
+		b = pbAppendTag(b, 2, pbVarint)
+		if v {
+			b = append(b, 1)
+		} else {
+			b = append(b, 0)
+		}
+	case int64:
+		b = pbAppendTag(b, 3, pbVarint)
+		b = pbAppendVarint(b, uint64(v))
+	case float64:
+		b = pbAppendFixed64(b, 4, math.Float64bits(v))
+	case []interface{}:
+		var arr []byte
+		for _, e := range v {
+			arr = pbAppendBytes(arr, 1, pbAnyValue(e))
+		}
+		b = pbAppendBytes(b, 5, arr)
+	case []otlpKeyValue:
+		b = pbAppendBytes(b, 6, pbKeyValues(nil, 1, v))
+	case []byte:
+		b = pbAppendBytes(b, 7, v)
+	}
+	return b
+}
+
+// pbKeyValues appends KeyValue messages as the repeated field.
+func pbKeyValues(b []byte, field int, kvs []otlpKeyValue) []byte {
+	for _, kv := range kvs {
+		var m []byte
+		m = pbAppendString(m, 1, kv.key)
+		m = pbAppendBytes(m, 2, pbAnyValue(kv.value))
+		b = pbAppendBytes(b, field, m)
+	}
+	return b
+}
+
+// protoScopeLogs encodes the record as a ScopeLogs message.
+func (rec *otlpRecord) protoScopeLogs() []byte {
+	var lr []byte
+	ts := uint64(rec.time.UnixNano())
+	lr = pbAppendFixed64(lr, 1, ts)
+	lr = pbAppendTag(lr, 2, pbVarint)
+	lr = pbAppendVarint(lr, uint64(rec.severity))
+	lr = pbAppendString(lr, 3, rec.text)
+	lr = pbAppendBytes(lr, 5, pbAnyValue(rec.body))
+	lr = pbKeyValues(lr, 6, rec.attributes)
+	if rec.flags != 0 {
+		lr = pbAppendFixed32(lr, 8, rec.flags)
+	}
+	if rec.traceID != nil {
+		lr = pbAppendBytes(lr, 9, rec.traceID)
+	}
+	if rec.spanID != nil {
+		lr = pbAppendBytes(lr, 10, rec.spanID)
+	}
+	lr = pbAppendFixed64(lr, 11, ts)
+
+	var sl []byte
+	if rec.scope != "" {
+		sl = pbAppendBytes(sl, 1, pbAppendString(nil, 1, rec.scope))
+	}
+	return pbAppendBytes(sl, 2, lr)
+}
+
+// JSON encoding, which follows the protobuf JSON mapping except for trace
+// and span IDs, which are hex.
+
+type otlpJSONKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+func jsonAnyValue(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return map[string]interface{}{"stringValue": strconv.FormatFloat(v, 'g', -1, 64)}
+		}
+		return map[string]interface{}{"doubleValue": v}
+	case []interface{}:
+		values := make([]map[string]interface{}, len(v))
+		for i := range v {
+			values[i] = jsonAnyValue(v[i])
+		}
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	case []otlpKeyValue:
+		return map[string]interface{}{"kvlistValue": map[string]interface{}{"values": jsonKeyValues(v)}}
+	case []byte:
+		return map[string]interface{}{"bytesValue": v}
+	}
+	return map[string]interface{}{}
+}
+
+func jsonKeyValues(kvs []otlpKeyValue) []otlpJSONKeyValue {
+	out := make([]otlpJSONKeyValue, len(kvs))
+	for i, kv := range kvs {
+		out[i] = otlpJSONKeyValue{Key: kv.key, Value: jsonAnyValue(kv.value)}
+	}
+	return out
+}
+
+type otlpJSONLogRecord struct {
+	TimeUnixNano         string                 `json:"timeUnixNano"`
+	ObservedTimeUnixNano string                 `json:"observedTimeUnixNano"`
+	SeverityNumber       int                    `json:"severityNumber"`
+	SeverityText         string                 `json:"severityText"`
+	Body                 map[string]interface{} `json:"body"`
+	Attributes           []otlpJSONKeyValue     `json:"attributes,omitempty"`
+	Flags                uint32                 `json:"flags,omitempty"`
+	TraceID              string                 `json:"traceId,omitempty"`
+	SpanID               string                 `json:"spanId,omitempty"`
+}
+
+type otlpJSONScopeLogs struct {
+	Scope      *otlpJSONScope      `json:"scope,omitempty"`
+	LogRecords []otlpJSONLogRecord `json:"logRecords"`
+}
+
+type otlpJSONScope struct {
+	Name string `json:"name"`
+}
+
+func (rec *otlpRecord) jsonScopeLogs() otlpJSONScopeLogs {
+	ts := strconv.FormatInt(rec.time.UnixNano(), 10)
+	sl := otlpJSONScopeLogs{LogRecords: []otlpJSONLogRecord{{
+		TimeUnixNano:         ts,
+		ObservedTimeUnixNano: ts,
+		SeverityNumber:       rec.severity,
+		SeverityText:         rec.text,
+		Body:                 jsonAnyValue(rec.body),
+		Attributes:           jsonKeyValues(rec.attributes),
+		Flags:                rec.flags,
+		TraceID:              hex.EncodeToString(rec.traceID),
+		SpanID:               hex.EncodeToString(rec.spanID),
+	}}}
+	if rec.scope != "" {
+		sl.Scope = &otlpJSONScope{Name: rec.scope}
+	}
+	return sl
+}
+
+// otlpAdapter wraps the ScopeLogs messages written by the OTLP encoder in
+// an ExportLogsServiceRequest with the configured resource.
+type otlpAdapter struct {
+	json     bool
+	resource []otlpKeyValue
+}
+
+// ContentType implements httpsink.Adapter.
+func (a *otlpAdapter) ContentType() string {
+	if a.json {
+		return "application/json"
+	}
+	return "application/x-protobuf"
+}
+
+// Encode implements httpsink.Adapter.
+func (a *otlpAdapter) Encode(w io.Writer, records []httpsink.Record) error {
+	if a.json {
+		resource, err := json.Marshal(map[string]interface{}{"attributes": jsonKeyValues(a.resource)})
+		if err != nil {
+			return err
+		}
+		b := append([]byte(`{"resourceLogs":[{"resource":`), resource...)
+		b = append(b, `,"scopeLogs":[`...)
+		for i, rec := range records {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = append(b, rec.Data...)
+		}
+		b = append(b, "]}]}"...)
+		_, err = w.Write(b)
+		return err
+	}
+
+	rl := pbAppendBytes(nil, 1, pbKeyValues(nil, 1, a.resource))
+	for _, rec := range records {
+		rl = pbAppendBytes(rl, 2, rec.Data)
+	}
+	_, err := w.Write(pbAppendBytes(nil, 1, rl))
+	return err
+}
+
+// resource returns the resource attributes.
+func (c *OTLPConfig) resource() []otlpKeyValue {
+	attrs := make(map[string]interface{}, len(c.ResourceAttributes)+3)
+	for k, v := range c.ResourceAttributes {
+		attrs[k] = v
+	}
+	attrs["service.name"] = c.ServiceName
+	if c.ServiceName == "" {
+		attrs["service.name"] = filepath.Base(os.Args[0])
+	}
+	if c.ServiceVersion != "" {
+		attrs["service.version"] = c.ServiceVersion
+	}
+	attrs["host.name"] = c.HostName
+	if c.HostName == "" {
+		attrs["host.name"], _ = os.Hostname()
+	}
+	return otlpKeyValues(attrs)
+}
+
+// core returns a core exporting records in batches through a diode, and the
+// diode to close when the logger is closed.
+func (c *OTLPConfig) core(lvl zap.AtomicLevel, interval time.Duration, o *sinkObserver) (zapcore.Core, io.Closer, error) {
+	cfg := c.Sink
+	cfg.URL = c.Endpoint
+	if cfg.URL == "" {
+		cfg.URL = DefaultOTLPEndpoint
+	}
+	if c.Header != nil {
+		cfg.Header = c.Header
+	}
+	cfg.Adapter = &otlpAdapter{json: c.Protocol == OTLPJSON, resource: c.resource()}
+	cfg.RawRecords = true
+	return httpCore(cfg, c.WAL, c.DiodeSize, NewOTLPEncoder(c.Protocol), lvl, interval, "otlp", o)
+}
@@ -0,0 +1,208 @@
+package zap_logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/hinha/zap-logger/pkg/httpsink"
+)
+
+// pbMessage decodes a protobuf message into its fields, holding a uint64
+// for numeric wire types and a []byte for length-delimited ones.
+func pbMessage(t *testing.T, b []byte) map[int][]interface{} {
+	fields := make(map[int][]interface{})
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		require.True(t, n > 0, "Malformed tag.")
+		b = b[n:]
+		field := int(tag >> 3)
+		switch tag & 7 {
+		case pbVarint:
+			v, n := binary.Uvarint(b)
+			require.True(t, n > 0, "Malformed varint.")
+			fields[field] = append(fields[field], v)
+			b = b[n:]
+		case pbFixed64:
+			fields[field] = append(fields[field], binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case pbFixed32:
+			fields[field] = append(fields[field], uint64(binary.LittleEndian.Uint32(b)))
+			b = b[4:]
+		case pbBytes:
+			l, n := binary.Uvarint(b)
+			require.True(t, n > 0 && int(l) <= len(b)-n, "Malformed length.")
+			fields[field] = append(fields[field], b[n:n+int(l)])
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("Unexpected wire type %d.", tag&7)
+		}
+	}
+	return fields
+}
+
+// pbAttributes decodes repeated KeyValue messages, keeping values encoded.
+func pbAttributes(t *testing.T, kvs []interface{}) map[string]map[int][]interface{} {
+	attrs := make(map[string]map[int][]interface{})
+	for _, kv := range kvs {
+		m := pbMessage(t, kv.([]byte))
+		attrs[string(m[1][0].([]byte))] = pbMessage(t, m[2][0].([]byte))
+	}
+	return attrs
+}
+
+func TestOTLPEncoderProtobuf(t *testing.T) {
+	enc := NewOTLPEncoder(OTLPProtobuf)
+	enc.AddString("service", "api")
+	ts := time.Unix(1700000000, 123456789)
+	buf, err := enc.EncodeEntry(zapcore.Entry{
+		Level:      zapcore.ErrorLevel,
+		Time:       ts,
+		LoggerName: "db",
+		Message:    "query failed",
+		Caller:     zapcore.NewEntryCaller(0, "db/query.go", 42, true),
+	}, []zap.Field{
+		zap.Int("rows", -3),
+		zap.Float64("ratio", 0.5),
+		zap.Bool("retry", true),
+		zap.Strings("tables", []string{"a", "b"}),
+		zap.Any("user", map[string]interface{}{"id": 7}),
+	})
+	require.NoError(t, err)
+	defer buf.Free()
+
+	scopeLogs := pbMessage(t, buf.Bytes())
+	assert.Equal(t, "db", string(pbMessage(t, scopeLogs[1][0].([]byte))[1][0].([]byte)), "Expected the logger name as scope.")
+	require.Len(t, scopeLogs[2], 1, "Expected one log record.")
+
+	rec := pbMessage(t, scopeLogs[2][0].([]byte))
+	assert.Equal(t, uint64(ts.UnixNano()), rec[1][0], "Unexpected time.")
+	assert.Equal(t, uint64(17), rec[2][0], "Unexpected severity number.")
+	assert.Equal(t, "ERROR", string(rec[3][0].([]byte)), "Unexpected severity text.")
+	assert.Equal(t, "query failed", string(pbMessage(t, rec[5][0].([]byte))[1][0].([]byte)), "Unexpected body.")
+	assert.NotContains(t, rec, 9, "Unexpected trace ID.")
+
+	attrs := pbAttributes(t, rec[6])
+	assert.Equal(t, "api", string(attrs["service"][1][0].([]byte)), "Expected fields added by With.")
+	assert.Equal(t, uint64(math.MaxUint64-2), attrs["rows"][3][0], "Expected a two's complement int.")
+	assert.Equal(t, math.Float64bits(0.5), attrs["ratio"][4][0])
+	assert.Equal(t, uint64(1), attrs["retry"][2][0])
+	assert.Len(t, pbMessage(t, attrs["tables"][5][0].([]byte))[1], 2, "Expected an array value.")
+	user := pbAttributes(t, pbMessage(t, attrs["user"][6][0].([]byte))[1])
+	assert.Equal(t, uint64(7), user["id"][3][0], "Expected reflected maps as kvlists.")
+	assert.Equal(t, "db/query.go", string(attrs["code.filepath"][1][0].([]byte)))
+	assert.Equal(t, uint64(42), attrs["code.lineno"][3][0])
+}
+
+func TestOTLPEncoderContext(t *testing.T) {
+	logs := &bytes.Buffer{}
+	core := zapcore.NewCore(NewOTLPEncoder(OTLPJSON), zapcore.AddSync(logs), zap.DebugLevel)
+
+	type ctxKey string
+	const (
+		traceID ctxKey = "traceID"
+		spanID  ctxKey = "spanID"
+		tenant  ctxKey = "tenant"
+	)
+	ctx := context.WithValue(context.TODO(), traceID, "4bf92f3577b34da6a3ce929d0e0e4736")
+	ctx = context.WithValue(ctx, spanID, "00f067aa0ba902b7")
+	ctx = context.WithValue(ctx, tenant, "acme")
+	logger := New(core, Config{}, AddContext(func(ctx context.Context, log *ZapLogger) {
+		log.Ctx.Set(traceID, ctx)
+		log.Ctx.Set(spanID, ctx)
+		log.Ctx.Set(tenant, ctx)
+	}))
+	logger.WarnCtx(ctx, "slow")
+
+	var sl otlpJSONScopeLogs
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &sl), "Expected a JSON ScopeLogs message.")
+	require.Len(t, sl.LogRecords, 1)
+	rec := sl.LogRecords[0]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", rec.TraceID, "Expected the trace ID from the context.")
+	assert.Equal(t, "00f067aa0ba902b7", rec.SpanID, "Expected the span ID from the context.")
+	assert.Equal(t, 13, rec.SeverityNumber)
+	assert.Equal(t, "WARN", rec.SeverityText)
+	require.Len(t, rec.Attributes, 1, "Expected the other context values as one attribute.")
+	assert.Equal(t, "context", rec.Attributes[0].Key)
+	assert.Contains(t, rec.Attributes[0].Value, "kvlistValue")
+}
+
+func TestOTLPExport(t *testing.T) {
+	for _, protocol := range []OTLPProtocol{OTLPProtobuf, OTLPJSON} {
+		requests := make(chan *http.Request, 10)
+		bodies := make(chan []byte, 10)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			requests <- r
+			bodies <- body
+		}))
+
+		cfg := &OTLPConfig{
+			Endpoint:           collector.URL + "/v1/logs",
+			Protocol:           protocol,
+			Header:             http.Header{"Authorization": {"Bearer token"}},
+			ServiceName:        "checkout",
+			HostName:           "web-1",
+			ResourceAttributes: map[string]string{"deployment.environment": "test"},
+			Sink:               httpsink.Config{BatchWait: time.Hour, DisableCompression: true},
+		}
+		core, closer, err := cfg.core(zap.NewAtomicLevel(), time.Millisecond, nil)
+		require.NoError(t, err, "Unexpected error creating the OTLP core.")
+		logger := New(core, Config{}, addCloser(closer))
+		logger.Info("one")
+		logger.Named("http").Info("two")
+		require.NoError(t, logger.Close(), "Unexpected error closing the logger.")
+		collector.Close()
+
+		r, body := <-requests, <-bodies
+		assert.Equal(t, "/v1/logs", r.URL.Path)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		if protocol == OTLPJSON {
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			var req struct {
+				ResourceLogs []struct {
+					Resource struct {
+						Attributes []otlpJSONKeyValue `json:"attributes"`
+					} `json:"resource"`
+					ScopeLogs []otlpJSONScopeLogs `json:"scopeLogs"`
+				} `json:"resourceLogs"`
+			}
+			require.NoError(t, json.Unmarshal(body, &req), "Expected a JSON export request.")
+			require.Len(t, req.ResourceLogs, 1)
+			assert.Equal(t, []otlpJSONKeyValue{
+				{Key: "deployment.environment", Value: map[string]interface{}{"stringValue": "test"}},
+				{Key: "host.name", Value: map[string]interface{}{"stringValue": "web-1"}},
+				{Key: "service.name", Value: map[string]interface{}{"stringValue": "checkout"}},
+			}, req.ResourceLogs[0].Resource.Attributes)
+			require.Len(t, req.ResourceLogs[0].ScopeLogs, 2, "Expected both records in one batch.")
+			assert.Equal(t, "one", req.ResourceLogs[0].ScopeLogs[0].LogRecords[0].Body["stringValue"])
+			assert.Equal(t, "http", req.ResourceLogs[0].ScopeLogs[1].Scope.Name)
+			continue
+		}
+
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		export := pbMessage(t, body)
+		require.Len(t, export[1], 1, "Expected one ResourceLogs.")
+		resourceLogs := pbMessage(t, export[1][0].([]byte))
+		resource := pbAttributes(t, pbMessage(t, resourceLogs[1][0].([]byte))[1])
+		assert.Equal(t, "checkout", string(resource["service.name"][1][0].([]byte)))
+		assert.Equal(t, "web-1", string(resource["host.name"][1][0].([]byte)))
+		require.Len(t, resourceLogs[2], 2, "Expected both records in one batch.")
+		second := pbMessage(t, resourceLogs[2][1].([]byte))
+		assert.Equal(t, "http", string(pbMessage(t, second[1][0].([]byte))[1][0].([]byte)))
+	}
+}
@@ -0,0 +1,141 @@
+package zap_logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/buffer"
+)
+
+// RecoverLevel sets the level at which Recover, RecoverRepanic and Go log
+// recovered panics. It defaults to ErrorLevel; DPanicLevel makes them panic
+// again in development.
+func RecoverLevel(lvl zapcore.Level) Option {
+	return optionFunc(func(log *Logger) {
+		log.recoverLevel = lvl
+	})
+}
+
+// Recover logs a panic with its value and stack trace and stops it. It must
+// be deferred directly:
+//
+//	defer log.Recover()
+func (log *ZapLogger) Recover() {
+	if r := recover(); r != nil {
+		log.logPanic(r)
+	}
+}
+
+// RecoverRepanic is like Recover, but it panics again with the same value
+// once the panic is logged. It must be deferred directly.
+func (log *ZapLogger) RecoverRepanic() {
+	if r := recover(); r != nil {
+		log.logPanic(r)
+		panic(r)
+	}
+}
+
+// Go runs f in a new goroutine, logging and stopping the panics it raises.
+func (log *ZapLogger) Go(f func()) {
+	go func() {
+		defer log.Recover()
+		f()
+	}()
+}
+
+// logPanic must be called by the deferred function that recovered r.
+func (log *ZapLogger) logPanic(r interface{}) {
+	// The stack of the deferred call isn't wanted; the panic's is added below.
+	l := log.clone()
+	l.addStack = zapcore.FatalLevel + 1
+	ce := l.check(log.recoverLevel, "panic recovered")
+	if ce == nil {
+		return
+	}
+	// Skip logPanic, the deferred function and the runtime's panic frames, so
+	// the stack starts where the panic was raised.
+	stack := captureStacktrace(2, stacktraceFull)
+	defer stack.Free()
+
+	frame, more := stack.Next()
+	for more && strings.HasPrefix(frame.Function, "runtime.") {
+		frame, more = stack.Next()
+	}
+	if log.addCaller {
+		ce.Caller = zapcore.EntryCaller{
+			Defined:  frame.PC != 0,
+			PC:       frame.PC,
+			File:     frame.File,
+			Line:     frame.Line,
+			Function: frame.Function,
+		}
+	}
+	if log.stackFramer != nil {
+		frames := log.stackFramer.Frames(frame, more, stack)
+		ce.Write(zap.Any("panic", r), zap.Array(log.stackFramer.key, frames))
+		return
+	}
+	ce.Stack = formatStack(frame, more, stack)
+	ce.Write(zap.Any("panic", r))
+}
+
+func formatStack(first runtime.Frame, more bool, stack *stacktrace) string {
+	buffer := buffer.Get()
+	defer buffer.Free()
+
+	stackfmt := newStackFormatter(buffer)
+	stackfmt.FormatFrame(first)
+	if more {
+		stackfmt.FormatStack(stack)
+	}
+	return buffer.String()
+}
+
+// addCrashDump makes Fatal entries dump the goroutines next to filename.
+func addCrashDump(filename string) Option {
+	return optionFunc(func(log *Logger) {
+		log.crashDump = filename
+	})
+}
+
+// crashDumpHook writes the stacks of all goroutines to a file before running
+// the Fatal hook it wraps.
+type crashDumpHook struct {
+	filename string
+	next     zapcore.CheckWriteHook
+}
+
+func (h crashDumpHook) OnWrite(ce *zapcore.CheckedEntry, fields []zapcore.Field) {
+	if err := writeCrashDump(h.filename, ce.Time); err != nil && ce.ErrorOutput != nil {
+		fmt.Fprintf(ce.ErrorOutput, "%v crash dump error: %v\n", time.Now().UTC(), err)
+		ce.ErrorOutput.Sync()
+	}
+	h.next.OnWrite(ce, fields)
+}
+
+// crashDumpName returns the dump file next to the log file filename, e.g.
+// app-goroutines-2006-01-02T15-04-05.000.txt for app.log.
+func crashDumpName(filename string, t time.Time) string {
+	prefix := strings.TrimSuffix(filename, filepath.Ext(filename))
+	return prefix + "-goroutines-" + t.UTC().Format("2006-01-02T15-04-05.000") + ".txt"
+}
+
+func writeCrashDump(filename string, t time.Time) error {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+	return os.WriteFile(crashDumpName(filename, t), buf, 0o600)
+}
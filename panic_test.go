@@ -0,0 +1,101 @@
+package zap_logger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func panicky() {
+	panic("boom")
+}
+
+func TestRecover(t *testing.T) {
+	withLogger(t, zapcore.DebugLevel, opts(WithCaller(true)), func(log *Logger, logs *observer.ObservedLogs) {
+		func() {
+			defer log.Recover()
+			panicky()
+		}()
+
+		entries := logs.AllUntimed()
+		require.Len(t, entries, 1, "Expected the panic to be logged.")
+		ent := entries[0]
+		assert.Equal(t, zapcore.ErrorLevel, ent.Level)
+		assert.Equal(t, "panic recovered", ent.Message)
+		assert.Equal(t, []zapcore.Field{zap.Any("panic", "boom")}, ent.Context)
+		assert.True(t, strings.HasPrefix(ent.Stack, "github.com/hinha/zap-logger.panicky\n"),
+			"Expected the stack to start where the panic was raised, have %q.", ent.Stack)
+		assert.Equal(t, "github.com/hinha/zap-logger.panicky", ent.Caller.Function)
+	})
+}
+
+func TestRecoverRuntimeError(t *testing.T) {
+	withLogger(t, zapcore.DebugLevel, opts(RecoverLevel(zapcore.WarnLevel)), func(log *Logger, logs *observer.ObservedLogs) {
+		func() {
+			defer log.Recover()
+			var m map[string]int
+			m["nil map"]++
+		}()
+
+		entries := logs.AllUntimed()
+		require.Len(t, entries, 1)
+		assert.Equal(t, zapcore.WarnLevel, entries[0].Level, "Expected RecoverLevel to be used.")
+		assert.True(t, strings.HasPrefix(entries[0].Stack, "github.com/hinha/zap-logger.TestRecoverRuntimeError.func1.1\n"),
+			"Expected the runtime frames to be skipped, have %q.", entries[0].Stack)
+	})
+}
+
+func TestRecoverRepanic(t *testing.T) {
+	withLogger(t, zapcore.DebugLevel, nil, func(log *Logger, logs *observer.ObservedLogs) {
+		err := errors.New("boom")
+		assert.PanicsWithValue(t, err, func() {
+			defer log.RecoverRepanic()
+			panic(err)
+		}, "Expected the panic to continue.")
+		assert.Equal(t, 1, logs.Len())
+	})
+}
+
+func TestGo(t *testing.T) {
+	withLogger(t, zapcore.DebugLevel, nil, func(log *Logger, logs *observer.ObservedLogs) {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		log.Go(func() {
+			defer wg.Done()
+			panicky()
+		})
+		wg.Wait()
+		require.Eventually(t, func() bool { return logs.Len() == 1 }, time.Second, time.Millisecond,
+			"Expected the goroutine's panic to be logged.")
+	})
+}
+
+func TestCrashDump(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "app.log")
+	withLogger(t, zapcore.DebugLevel, opts(addCrashDump(filename), WithFatalHook(zapcore.WriteThenGoexit)), func(log *Logger, logs *observer.ObservedLogs) {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			log.Fatal("fatal")
+		}()
+		<-done
+		assert.Equal(t, 1, logs.Len())
+
+		dumps, err := filepath.Glob(filepath.Join(filepath.Dir(filename), "app-goroutines-*.txt"))
+		require.NoError(t, err)
+		require.Len(t, dumps, 1, "Expected a crash dump next to the log file.")
+		dump, err := os.ReadFile(dumps[0])
+		require.NoError(t, err)
+		assert.Contains(t, string(dump), "TestCrashDump", "Expected the stacks of all goroutines.")
+	})
+}
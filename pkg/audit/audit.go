@@ -0,0 +1,369 @@
+// Package audit chains JSON log records into a tamper-evident sequence and
+// verifies such chains.
+//
+// Each record gets a "seq" number, the "prev_hash" of the record before it
+// and its own "hash", the hex SHA-256 of its canonical encoding without the
+// "hash" key. Editing, removing or reordering records breaks the chain from
+// that point on. Checkpoint records sign the hash of the record before them
+// with an HMAC or Ed25519 key, so the chain can't be recomputed without the
+// key either.
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// GenesisHash is the prev_hash of the first record of a chain.
+const GenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// Keys added to records. Records must not use them for other values;
+// Chain.Append rejects records with the seq, prev_hash or hash keys.
+const (
+	SeqKey        = "seq"
+	PrevHashKey   = "prev_hash"
+	HashKey       = "hash"
+	CheckpointKey = "checkpoint"
+)
+
+// Signature algorithms of checkpoints.
+const (
+	HMACSHA256 = "hmac-sha256"
+	Ed25519    = "ed25519"
+)
+
+var errNotObject = errors.New("audit: record is not a JSON object")
+
+// Chain is the state of a chain after its last record. The zero Chain
+// starts a new one.
+type Chain struct {
+	Seq  uint64
+	Hash string
+}
+
+// Append appends record, a JSON object optionally followed by a line
+// ending, to dst with the seq, prev_hash and hash keys added, followed by
+// a newline, and advances the chain. Records already using those keys, or
+// using a key twice, are rejected.
+func (c *Chain) Append(dst, record []byte) ([]byte, error) {
+	rec := bytes.TrimRight(record, " \t\r\n")
+	if len(rec) < 2 || rec[0] != '{' || rec[len(rec)-1] != '}' {
+		return dst, errNotObject
+	}
+	fields, err := decode(rec)
+	if err != nil {
+		return dst, err
+	}
+	for _, key := range []string{SeqKey, PrevHashKey, HashKey} {
+		if _, ok := fields[key]; ok {
+			return dst, fmt.Errorf("audit: record has reserved key %q", key)
+		}
+	}
+	prev := c.Hash
+	if prev == "" {
+		prev = GenesisHash
+	}
+	seq := c.Seq + 1
+
+	body := make([]byte, 0, len(rec)+160)
+	body = append(body, rec[:len(rec)-1]...)
+	if len(bytes.TrimSpace(body)) > 1 {
+		body = append(body, ',')
+	}
+	body = append(body, `"`+SeqKey+`":`...)
+	body = strconv.AppendUint(body, seq, 10)
+	body = append(body, `,"`+PrevHashKey+`":"`...)
+	body = append(body, prev...)
+	body = append(body, `"}`...)
+	sum, err := Hash(body)
+	if err != nil {
+		return dst, err
+	}
+
+	dst = append(dst, body[:len(body)-1]...)
+	dst = append(dst, `,"`+HashKey+`":"`...)
+	dst = append(dst, sum...)
+	dst = append(dst, "\"}\n"...)
+	c.Seq, c.Hash = seq, sum
+	return dst, nil
+}
+
+// Canonical returns the canonical encoding of a JSON object without its
+// hash key: compact, with object keys sorted, numbers as written and no
+// HTML escaping.
+func Canonical(record []byte) ([]byte, error) {
+	fields, err := decode(record)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, HashKey)
+	return canonical(fields)
+}
+
+func canonical(fields map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fields); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// decode decodes a JSON object, rejecting objects, at any depth, with a
+// key more than once: only one of the values would be hashed, so the others
+// could be edited without breaking the chain.
+func decode(record []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(record))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil || tok != json.Delim('{') {
+		return nil, errNotObject
+	}
+	fields, err := decodeObject(dec)
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// decodeObject decodes the rest of an object after its opening brace.
+func decodeObject(dec *json.Decoder) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, errNotObject
+		}
+		key, _ := tok.(string)
+		if _, ok := fields[key]; ok {
+			return nil, fmt.Errorf("audit: record has duplicate key %q", key)
+		}
+		if fields[key], err = decodeValue(dec); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, errNotObject
+	}
+	return fields, nil
+}
+
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, errNotObject
+	}
+	switch tok {
+	case json.Delim('{'):
+		return decodeObject(dec)
+	case json.Delim('['):
+		values := make([]interface{}, 0)
+		for dec.More() {
+			v, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, errNotObject
+		}
+		return values, nil
+	}
+	return tok, nil
+}
+
+// Hash returns the hex SHA-256 of the canonical encoding of record.
+func Hash(record []byte) (string, error) {
+	c, err := Canonical(record)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(c)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Checkpoint is the value of the checkpoint key of a checkpoint record. It
+// signs the seq and hash of the record before it.
+type Checkpoint struct {
+	Seq   uint64 `json:"seq"`
+	Hash  string `json:"hash"`
+	Alg   string `json:"alg"`
+	KeyID string `json:"key_id,omitempty"`
+	Sig   string `json:"sig"`
+}
+
+// checkpointMessage is the signed message of a checkpoint.
+func checkpointMessage(seq uint64, hash string) []byte {
+	return []byte("zap-audit-checkpoint:" + strconv.FormatUint(seq, 10) + ":" + hash)
+}
+
+// Signer signs checkpoints.
+type Signer struct {
+	alg   string
+	keyID string
+	sign  func(msg []byte) []byte
+}
+
+// NewHMACSigner returns a Signer using HMAC-SHA256 with key.
+func NewHMACSigner(key []byte, keyID string) *Signer {
+	return &Signer{alg: HMACSHA256, keyID: keyID, sign: func(msg []byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(msg)
+		return mac.Sum(nil)
+	}}
+}
+
+// NewEd25519Signer returns a Signer using an Ed25519 private key.
+func NewEd25519Signer(key ed25519.PrivateKey, keyID string) *Signer {
+	return &Signer{alg: Ed25519, keyID: keyID, sign: func(msg []byte) []byte {
+		return ed25519.Sign(key, msg)
+	}}
+}
+
+// Checkpoint returns a checkpoint of the last record of c.
+func (s *Signer) Checkpoint(c Chain) Checkpoint {
+	hash := c.Hash
+	if hash == "" {
+		hash = GenesisHash
+	}
+	return Checkpoint{
+		Seq:   c.Seq,
+		Hash:  hash,
+		Alg:   s.alg,
+		KeyID: s.keyID,
+		Sig:   base64.StdEncoding.EncodeToString(s.sign(checkpointMessage(c.Seq, hash))),
+	}
+}
+
+// Verifier checks records of a chain in order. Checkpoints are checked
+// against HMACKey or PublicKey for their algorithm. Without either key they
+// are counted as unverified; with one, checkpoints whose algorithm has no
+// key fail.
+type Verifier struct {
+	HMACKey   []byte
+	PublicKey ed25519.PublicKey
+
+	// Records, Checkpoints and Unverified count the records verified, the
+	// checkpoints among them whose signature was checked and the ones that
+	// couldn't be checked.
+	Records     int
+	Checkpoints int
+	Unverified  int
+	// First is the seq of the first record. It's greater than 1 when the
+	// start of the chain was removed, e.g. by log rotation.
+	First uint64
+
+	chain Chain
+}
+
+// Chain returns the state after the last verified record.
+func (v *Verifier) Chain() Chain {
+	return v.chain
+}
+
+// Verify checks that record follows the records verified before and that
+// its hash and checkpoint, if any, are valid.
+func (v *Verifier) Verify(record []byte) error {
+	fields, err := decode(record)
+	if err != nil {
+		return err
+	}
+	seq, err := numberField(fields, SeqKey)
+	if err != nil {
+		return err
+	}
+	prev, _ := fields[PrevHashKey].(string)
+	hash, _ := fields[HashKey].(string)
+	if prev == "" || hash == "" {
+		return fmt.Errorf("audit: record %d has no %s or %s", seq, PrevHashKey, HashKey)
+	}
+	delete(fields, HashKey)
+	c, err := canonical(fields)
+	if err != nil {
+		return err
+	}
+	if sum := sha256.Sum256(c); hex.EncodeToString(sum[:]) != hash {
+		return fmt.Errorf("audit: record %d doesn't match its hash, it was modified", seq)
+	}
+
+	switch {
+	case v.Records > 0 && seq != v.chain.Seq+1:
+		return fmt.Errorf("audit: record %d follows record %d, records were removed or reordered", seq, v.chain.Seq)
+	case v.Records > 0 && prev != v.chain.Hash:
+		return fmt.Errorf("audit: record %d doesn't link to the hash of record %d", seq, v.chain.Seq)
+	case v.Records == 0 && seq == 1 && prev != GenesisHash:
+		return fmt.Errorf("audit: first record doesn't link to the genesis hash")
+	}
+
+	if cp, ok := fields[CheckpointKey].(map[string]interface{}); ok {
+		if err := v.verifyCheckpoint(seq, prev, cp); err != nil {
+			return err
+		}
+	}
+	if v.Records == 0 {
+		v.First = seq
+	}
+	v.Records++
+	v.chain = Chain{Seq: seq, Hash: hash}
+	return nil
+}
+
+func (v *Verifier) verifyCheckpoint(seq uint64, prev string, fields map[string]interface{}) error {
+	cpSeq, err := numberField(fields, "seq")
+	if err != nil {
+		return fmt.Errorf("audit: checkpoint %d: %v", seq, err)
+	}
+	hash, _ := fields["hash"].(string)
+	alg, _ := fields["alg"].(string)
+	sig, err := base64.StdEncoding.DecodeString(fmt.Sprint(fields["sig"]))
+	if err != nil {
+		return fmt.Errorf("audit: checkpoint %d has a malformed signature", seq)
+	}
+	if cpSeq != seq-1 || hash != prev {
+		return fmt.Errorf("audit: checkpoint %d doesn't sign the record before it", seq)
+	}
+
+	msg := checkpointMessage(cpSeq, hash)
+	switch {
+	case alg == HMACSHA256 && v.HMACKey != nil:
+		mac := hmac.New(sha256.New, v.HMACKey)
+		mac.Write(msg)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return fmt.Errorf("audit: checkpoint %d has an invalid signature", seq)
+		}
+	case alg == Ed25519 && v.PublicKey != nil:
+		if !ed25519.Verify(v.PublicKey, msg, sig) {
+			return fmt.Errorf("audit: checkpoint %d has an invalid signature", seq)
+		}
+	case v.HMACKey != nil || v.PublicKey != nil:
+		// An attacker without the key could otherwise sign with "none".
+		return fmt.Errorf("audit: checkpoint %d has algorithm %q, no key was given for it", seq, alg)
+	default:
+		v.Unverified++
+		return nil
+	}
+	v.Checkpoints++
+	return nil
+}
+
+func numberField(fields map[string]interface{}, key string) (uint64, error) {
+	n, ok := fields[key].(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("audit: record has no %s", key)
+	}
+	v, err := strconv.ParseUint(n.String(), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("audit: malformed %s %s", key, n)
+	}
+	return v, nil
+}
@@ -0,0 +1,208 @@
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chain returns the chained lines of records.
+func chain(t *testing.T, c *Chain, records ...string) [][]byte {
+	var lines [][]byte
+	for _, rec := range records {
+		line, err := c.Append(nil, []byte(rec+"\n"))
+		require.NoError(t, err, "Unexpected error chaining %s.", rec)
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func verify(v *Verifier, lines [][]byte) error {
+	for _, line := range lines {
+		if err := v.Verify(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestChain(t *testing.T) {
+	var c Chain
+	lines := chain(t, &c, `{"msg":"login","user":"ann"}`, `{}`, `{"msg":"<b>","n":1.50}`)
+	assert.Equal(t, uint64(3), c.Seq)
+
+	var first map[string]interface{}
+	require.NoError(t, json.Unmarshal(lines[0], &first))
+	assert.Equal(t, float64(1), first[SeqKey])
+	assert.Equal(t, GenesisHash, first[PrevHashKey])
+	assert.True(t, bytes.HasSuffix(lines[0], []byte("\"}\n")), "Expected a line per record.")
+	assert.True(t, bytes.HasPrefix(lines[1], []byte(`{"seq":2,`)), "Unexpected empty record %s.", lines[1])
+
+	var v Verifier
+	require.NoError(t, verify(&v, lines), "Expected the chain to verify.")
+	assert.Equal(t, 3, v.Records)
+	assert.Equal(t, c, v.Chain())
+
+	// Key order and whitespace don't change the canonical encoding.
+	a, err := Canonical([]byte(`{"b":1, "a":{"y":"<", "x":2.0},"hash":"x"}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":{"x":2.0,"y":"<"},"b":1}`, string(a))
+
+	_, err = c.Append(nil, []byte(`["not", "an", "object"]`))
+	assert.Error(t, err, "Expected an error chaining a non-object.")
+
+	for _, rec := range []string{`{"seq":9}`, `{"msg":"x","prev_hash":"y"}`, `{"hash":"z"}`, `{"a":1,"a":2}`, `{"a":{"b":1,"b":1}}`} {
+		_, err = c.Append(nil, []byte(rec))
+		assert.Error(t, err, "Expected an error chaining %s.", rec)
+	}
+	assert.Equal(t, uint64(3), c.Seq, "Expected rejected records not to advance the chain.")
+}
+
+func TestVerifyTampering(t *testing.T) {
+	records := []string{`{"msg":"a"}`, `{"msg":"b"}`, `{"msg":"c"}`, `{"msg":"d"}`}
+	tests := []struct {
+		desc   string
+		tamper func(lines [][]byte) [][]byte
+		want   string
+	}{
+		{"edited", func(lines [][]byte) [][]byte {
+			lines[2] = bytes.Replace(lines[2], []byte(`"c"`), []byte(`"C"`), 1)
+			return lines
+		}, "record 3 doesn't match its hash"},
+		{"removed", func(lines [][]byte) [][]byte {
+			return append(lines[:1], lines[2:]...)
+		}, "record 3 follows record 1"},
+		{"reordered", func(lines [][]byte) [][]byte {
+			lines[1], lines[2] = lines[2], lines[1]
+			return lines
+		}, "record 3 follows record 1"},
+		{"rechained", func(lines [][]byte) [][]byte {
+			// A forged record with a correct hash that doesn't link.
+			forged := Chain{Seq: 2, Hash: strings.Repeat("1", 64)}
+			line, _ := forged.Append(nil, []byte(`{"msg":"x"}`))
+			lines[2] = line
+			return lines
+		}, "record 3 doesn't link"},
+		{"duplicate key", func(lines [][]byte) [][]byte {
+			// Only one of the values would be hashed.
+			lines[2] = bytes.Replace(lines[2], []byte(`{"msg":"c"`), []byte(`{"msg":"C","msg":"c"`), 1)
+			return lines
+		}, `duplicate key "msg"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var c Chain
+			lines := tt.tamper(chain(t, &c, records...))
+			err := verify(&Verifier{}, lines)
+			require.Error(t, err, "Expected tampering to be detected.")
+			assert.Contains(t, err.Error(), tt.want)
+		})
+	}
+}
+
+func TestCheckpoints(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signers := map[string]struct {
+		signer *Signer
+		good   Verifier
+		bad    Verifier
+	}{
+		HMACSHA256: {NewHMACSigner([]byte("secret"), "k1"), Verifier{HMACKey: []byte("secret")}, Verifier{HMACKey: []byte("other")}},
+		Ed25519:    {NewEd25519Signer(priv, "k2"), Verifier{PublicKey: pub}, Verifier{PublicKey: make(ed25519.PublicKey, ed25519.PublicKeySize)}},
+	}
+	for alg, tt := range signers {
+		t.Run(alg, func(t *testing.T) {
+			var c Chain
+			lines := chain(t, &c, `{"msg":"a"}`)
+			cp, err := json.Marshal(map[string]interface{}{CheckpointKey: tt.signer.Checkpoint(c)})
+			require.NoError(t, err)
+			lines = append(lines, chain(t, &c, string(cp))...)
+
+			good := tt.good
+			require.NoError(t, verify(&good, lines), "Expected a valid checkpoint.")
+			assert.Equal(t, 1, good.Checkpoints)
+
+			var unkeyed Verifier
+			require.NoError(t, verify(&unkeyed, lines))
+			assert.Equal(t, 1, unkeyed.Unverified, "Expected checkpoints without a key to be unverified.")
+
+			bad := tt.bad
+			assert.Error(t, verify(&bad, lines), "Expected a signature made with another key to fail.")
+
+			other := Verifier{HMACKey: []byte("secret")}
+			if alg == HMACSHA256 {
+				other = Verifier{PublicKey: pub}
+			}
+			assert.Error(t, verify(&other, lines), "Expected a checkpoint without a matching key to fail.")
+		})
+	}
+}
+
+func TestCheckpointAlgorithmNone(t *testing.T) {
+	var c Chain
+	lines := chain(t, &c, `{"msg":"a"}`)
+	cp, err := json.Marshal(map[string]interface{}{CheckpointKey: Checkpoint{Seq: c.Seq, Hash: c.Hash, Alg: "none"}})
+	require.NoError(t, err)
+	lines = append(lines, chain(t, &c, string(cp))...)
+
+	var unkeyed Verifier
+	require.NoError(t, verify(&unkeyed, lines))
+	assert.Equal(t, 1, unkeyed.Unverified)
+
+	keyed := Verifier{HMACKey: []byte("secret")}
+	err = verify(&keyed, lines)
+	require.Error(t, err, "Expected an unsigned checkpoint to fail under a key.")
+	assert.Contains(t, err.Error(), `algorithm "none"`)
+}
+
+func TestVerifyFiles(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "audit.log")
+	var c Chain
+	lines := chain(t, &c, `{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`, `{"n":5}`)
+
+	// An old backup, dropped from the chain by MaxBackups, is missing.
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write(bytes.Join(lines[1:3], nil))
+	require.NoError(t, zw.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "audit-2024-01-02T10-00-00.000.log.gz"), gz.Bytes(), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "audit-2024-01-02T11-00-00.000.log"), lines[3], 0o600))
+	require.NoError(t, os.WriteFile(filename, append(lines[4], "{\"torn"...), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.log"), []byte("x"), 0o600))
+
+	files, err := Files(filename)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "audit-2024-01-02T10-00-00.000.log.gz"),
+		filepath.Join(dir, "audit-2024-01-02T11-00-00.000.log"),
+		filename,
+	}, files, "Expected backups oldest first, then the current file.")
+
+	last, err := Last(filename)
+	require.NoError(t, err)
+	assert.Equal(t, c, last, "Expected the torn line to be skipped.")
+
+	var v Verifier
+	err = VerifyFiles(filename, &v)
+	var broken *BrokenLinkError
+	require.True(t, errors.As(err, &broken), "Expected the torn line to break the chain, have %v.", err)
+	assert.Equal(t, filename, broken.File)
+	assert.Equal(t, 2, broken.Line)
+	assert.Equal(t, uint64(2), v.First, "Expected the chain to start at the oldest backup.")
+	assert.Equal(t, 4, v.Records)
+
+	last, err = Last(filepath.Join(dir, "missing", "audit.log"))
+	require.NoError(t, err)
+	assert.Equal(t, Chain{}, last, "Expected a new chain without files.")
+}
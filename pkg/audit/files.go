@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hinha/zap-logger/internal/logfiles"
+)
+
+// maxRecordSize bounds the lines read from log files.
+const maxRecordSize = 16 << 20
+
+// Files returns the files of a log rotated by lumberjack, oldest first: the
+// backups of filename, gzipped or not, followed by filename itself if it
+// exists.
+func Files(filename string) ([]string, error) {
+	return logfiles.List(filename)
+}
+
+// BrokenLinkError reports the first record of a log that doesn't verify.
+type BrokenLinkError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *BrokenLinkError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+}
+
+func (e *BrokenLinkError) Unwrap() error { return e.Err }
+
+// VerifyFiles verifies the chain across the files of a rotated log, in the
+// order of Files, and returns a *BrokenLinkError for the first record that
+// doesn't verify. Empty lines are skipped.
+func VerifyFiles(filename string, v *Verifier) error {
+	files, err := Files(filename)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("audit: no log files for %s", filename)
+	}
+	for _, name := range files {
+		err := forEachLine(name, func(line int, record []byte) error {
+			if err := v.Verify(record); err != nil {
+				return &BrokenLinkError{File: name, Line: line, Err: err}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Last returns the state of the chain after the last record of a rotated
+// log, to continue it. Lines that aren't chained records, such as one torn
+// by a crash, are skipped.
+func Last(filename string) (Chain, error) {
+	files, err := Files(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Chain{}, nil
+		}
+		return Chain{}, err
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		var (
+			last  Chain
+			found bool
+		)
+		err := forEachLine(files[i], func(_ int, record []byte) error {
+			fields, err := decode(record)
+			if err != nil {
+				return nil
+			}
+			seq, err := numberField(fields, SeqKey)
+			hash, _ := fields[HashKey].(string)
+			if err == nil && hash != "" {
+				last, found = Chain{Seq: seq, Hash: hash}, true
+			}
+			return nil
+		})
+		if err != nil {
+			return Chain{}, err
+		}
+		if found {
+			return last, nil
+		}
+	}
+	return Chain{}, nil
+}
+
+// forEachLine calls fn with the non-empty lines of a log file, which is
+// decompressed if its name ends in ".gz".
+func forEachLine(name string, fn func(line int, record []byte) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		if err := fn(line, scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,427 @@
+// Package cryptlog encrypts log files at rest with AES-256-GCM.
+//
+// A file is a sequence of frames, each a type byte, a big-endian uint32
+// payload length and the payload; the five header bytes are authenticated
+// with the payload. A key frame holds a random data key sealed with the
+// master key, named by its KeyID, and the data frames after it hold chunks
+// of records sealed with that data key, each with a random nonce. A final
+// frame, written by Writer.Close, ends the frames of a data key. Data and
+// final frames also authenticate their position after the key frame, so
+// Reader rejects frames that are reordered, repeated or removed, and a log
+// cut after any frame. Writers start a new data key with every file, so a
+// file can be read on its own and files can be concatenated.
+package cryptlog
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Frame types.
+const (
+	keyFrame   = 'K'
+	dataFrame  = 'D'
+	finalFrame = 'F'
+
+	keyVersion  = 1
+	headerSize  = 5
+	seqSize     = 8
+	nonceSize   = 12
+	tagSize     = 16
+	keySize     = 32
+	maxFrameLen = 64 << 20
+)
+
+// DefaultChunkSize is the largest plaintext of a data frame; longer writes
+// are split.
+const DefaultChunkSize = 64 << 10
+
+// FinalFrameSize is the size of the final frame written by Writer.Close.
+const FinalFrameSize = headerSize + nonceSize + tagSize
+
+// Errors returned by Reader.
+var (
+	ErrNotEncrypted = errors.New("cryptlog: not an encrypted log")
+	ErrUnknownKey   = errors.New("cryptlog: data key sealed with an unknown master key")
+	ErrCorrupt      = errors.New("cryptlog: frame failed authentication")
+	ErrTruncated    = errors.New("cryptlog: data frames end without a final frame")
+)
+
+// KeySource provides the 32-byte master key.
+type KeySource interface {
+	Key() ([]byte, error)
+}
+
+// FileKey reads the master key from a file, see ParseKey.
+type FileKey string
+
+// Key implements KeySource.
+func (f FileKey) Key() ([]byte, error) {
+	b, err := os.ReadFile(string(f))
+	if err != nil {
+		return nil, fmt.Errorf("cryptlog: %w", err)
+	}
+	return ParseKey(b)
+}
+
+// EnvKey reads the master key from an environment variable, see ParseKey.
+type EnvKey string
+
+// Key implements KeySource.
+func (e EnvKey) Key() ([]byte, error) {
+	v, ok := os.LookupEnv(string(e))
+	if !ok {
+		return nil, fmt.Errorf("cryptlog: environment variable %s is not set", string(e))
+	}
+	return ParseKey([]byte(v))
+}
+
+// StaticKey is a master key held in memory.
+type StaticKey []byte
+
+// Key implements KeySource.
+func (k StaticKey) Key() ([]byte, error) {
+	return ParseKey(k)
+}
+
+// ParseKey decodes a 32-byte key given raw, in hex or in base64. Leading and
+// trailing whitespace of encoded keys is ignored.
+func ParseKey(b []byte) ([]byte, error) {
+	if len(b) == keySize {
+		return b, nil
+	}
+	s := strings.TrimSpace(string(b))
+	if k, err := hex.DecodeString(s); err == nil && len(k) == keySize {
+		return k, nil
+	}
+	if k, err := base64.StdEncoding.DecodeString(s); err == nil && len(k) == keySize {
+		return k, nil
+	}
+	return nil, errors.New("cryptlog: a key must be 32 bytes, raw, hex or base64")
+}
+
+// KeyID names a master key in key frames: the first 8 bytes of its
+// SHA-256, in hex.
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Writer encrypts what is written to it in frames. It isn't safe for
+// concurrent use.
+type Writer struct {
+	w      io.Writer
+	master cipher.AEAD
+	id     string
+	chunk  int
+
+	data cipher.AEAD
+	// seq is the position of the next frame after the key frame.
+	seq uint64
+	// sealedKey is the key frame to write before the next data frame, or
+	// nil once it's written.
+	sealedKey []byte
+	buf       []byte
+}
+
+// NewWriter returns a Writer encrypting to w with a data key sealed with the
+// master key of src. Writes longer than chunkSize are split into several
+// frames; zero uses DefaultChunkSize.
+func NewWriter(w io.Writer, src KeySource, chunkSize int) (*Writer, error) {
+	key, err := src.Key()
+	if err != nil {
+		return nil, err
+	}
+	master, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("cryptlog: %w", err)
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	cw := &Writer{w: w, master: master, id: KeyID(key), chunk: chunkSize}
+	if err := cw.NewKey(); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+// NewKey starts a new data key, written in a key frame before the next
+// data frame. Call it when the destination moves to a new file, after Close
+// if data frames were written with the current key.
+func (w *Writer) NewKey() error {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("cryptlog: %w", err)
+	}
+	data, err := newGCM(key)
+	if err != nil {
+		return fmt.Errorf("cryptlog: %w", err)
+	}
+
+	payload := make([]byte, 0, 2+len(w.id)+nonceSize+keySize+tagSize)
+	payload = append(payload, keyVersion, byte(len(w.id)))
+	payload = append(payload, w.id...)
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("cryptlog: %w", err)
+	}
+	payload = append(payload, nonce...)
+	header := frameHeader(keyFrame, len(payload)+keySize+tagSize)
+	// The key ID is authenticated along with the header.
+	aad := append(header[:], payload[:2+len(w.id)]...)
+	frame := append(header[:], w.master.Seal(payload, nonce, key, aad)...)
+
+	w.data, w.sealedKey, w.seq = data, frame, 0
+	return nil
+}
+
+func frameHeader(typ byte, n int) [headerSize]byte {
+	var h [headerSize]byte
+	h[0] = typ
+	binary.BigEndian.PutUint32(h[1:], uint32(n))
+	return h
+}
+
+// frameAAD returns the data authenticated with a data or final frame: its
+// header and its position after the key frame.
+func frameAAD(header [headerSize]byte, seq uint64) [headerSize + seqSize]byte {
+	var aad [headerSize + seqSize]byte
+	copy(aad[:], header[:])
+	binary.BigEndian.PutUint64(aad[headerSize:], seq)
+	return aad
+}
+
+// seal appends a frame of type typ sealing plain with the data key to buf.
+func (w *Writer) seal(buf []byte, typ byte, plain []byte) ([]byte, error) {
+	header := frameHeader(typ, nonceSize+len(plain)+tagSize)
+	buf = append(buf, header[:]...)
+	start := len(buf)
+	buf = append(buf, make([]byte, nonceSize)...)
+	nonce := buf[start:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("cryptlog: %w", err)
+	}
+	aad := frameAAD(header, w.seq)
+	w.seq++
+	return w.data.Seal(buf, nonce, plain, aad[:]), nil
+}
+
+// Size returns the bytes written to the destination for a write of n bytes.
+func (w *Writer) Size(n int) int {
+	frames := (n + w.chunk - 1) / w.chunk
+	if n == 0 {
+		frames = 0
+	}
+	return len(w.sealedKey) + n + frames*(headerSize+nonceSize+tagSize)
+}
+
+// Write encrypts p into data frames, preceded by a key frame after NewKey,
+// and writes them to the destination with a single Write.
+func (w *Writer) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	seq := w.seq
+	buf := append(w.buf[:0], w.sealedKey...)
+	for rest := p; len(rest) > 0; {
+		chunk := rest
+		if len(chunk) > w.chunk {
+			chunk = chunk[:w.chunk]
+		}
+		rest = rest[len(chunk):]
+
+		var err error
+		if buf, err = w.seal(buf, dataFrame, chunk); err != nil {
+			w.seq = seq
+			return 0, err
+		}
+	}
+	w.buf = buf
+	if _, err := w.w.Write(buf); err != nil {
+		w.seq = seq
+		return 0, err
+	}
+	w.sealedKey = nil
+	return len(p), nil
+}
+
+// Close writes the final frame after the data frames of the current data
+// key, if any, and starts a new data key for later writes. It doesn't close
+// the destination.
+func (w *Writer) Close() error {
+	if w.sealedKey != nil {
+		// Nothing was written with this key.
+		return nil
+	}
+	frame, err := w.seal(w.buf[:0], finalFrame, nil)
+	if err != nil {
+		return err
+	}
+	w.buf = frame
+	if _, err := w.w.Write(frame); err != nil {
+		w.seq--
+		return err
+	}
+	return w.NewKey()
+}
+
+// Reader decrypts an encrypted log.
+type Reader struct {
+	// AllowTruncated accepts data frames that end without a final frame, as
+	// the frames of a file still being written or of a process that crashed,
+	// instead of failing with ErrTruncated. Frames removed from their end
+	// then go unnoticed.
+	AllowTruncated bool
+
+	r       io.Reader
+	masters map[string]cipher.AEAD
+	data    cipher.AEAD
+	// seq is the position of the next frame after the key frame, and final
+	// is set once the final frame of the data key is read.
+	seq     uint64
+	final   bool
+	started bool
+	plain   []byte
+	frame   []byte
+	err     error
+}
+
+// NewReader returns a Reader decrypting r with any of the master keys.
+func NewReader(r io.Reader, keys ...[]byte) (*Reader, error) {
+	masters := make(map[string]cipher.AEAD, len(keys))
+	for _, k := range keys {
+		aead, err := newGCM(k)
+		if err != nil {
+			return nil, fmt.Errorf("cryptlog: %w", err)
+		}
+		masters[KeyID(k)] = aead
+	}
+	return &Reader{r: r, masters: masters}, nil
+}
+
+// Read implements io.Reader. A file cut in the middle of a frame, as by a
+// crash, ends with io.ErrUnexpectedEOF after the complete frames, and one cut
+// between frames with ErrTruncated, unless AllowTruncated is set.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.plain, r.err = r.next()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next reads frames until one holds records.
+func (r *Reader) next() ([]byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		if err == io.EOF && r.truncated() {
+			return nil, ErrTruncated
+		}
+		// An empty file is an empty log.
+		return nil, err
+	}
+	typ, n := header[0], int(binary.BigEndian.Uint32(header[1:]))
+	if (typ != keyFrame && typ != dataFrame && typ != finalFrame) || n > maxFrameLen || (!r.started && typ != keyFrame) {
+		if !r.started {
+			return nil, ErrNotEncrypted
+		}
+		return nil, ErrCorrupt
+	}
+	r.started = true
+	if cap(r.frame) < n {
+		r.frame = make([]byte, n)
+	}
+	frame := r.frame[:n]
+	if _, err := io.ReadFull(r.r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if typ == keyFrame {
+		if r.truncated() {
+			return nil, ErrTruncated
+		}
+		return nil, r.openKey(header, frame)
+	}
+	if r.data == nil || r.final || n < nonceSize+tagSize || (typ == finalFrame && n != nonceSize+tagSize) {
+		return nil, ErrCorrupt
+	}
+	aad := frameAAD(header, r.seq)
+	plain, err := r.data.Open(nil, frame[:nonceSize], frame[nonceSize:], aad[:])
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	r.seq++
+	r.final = typ == finalFrame
+	return plain, nil
+}
+
+// truncated reports whether the frames of the current data key are missing
+// their final frame, and that isn't allowed.
+func (r *Reader) truncated() bool {
+	return r.data != nil && !r.final && !r.AllowTruncated
+}
+
+func (r *Reader) openKey(header [headerSize]byte, frame []byte) error {
+	if len(frame) < 2 || frame[0] != keyVersion {
+		return ErrCorrupt
+	}
+	idEnd := 2 + int(frame[1])
+	if len(frame) != idEnd+nonceSize+keySize+tagSize {
+		return ErrCorrupt
+	}
+	master, ok := r.masters[string(frame[2:idEnd])]
+	if !ok {
+		return fmt.Errorf("%w %s", ErrUnknownKey, frame[2:idEnd])
+	}
+	aad := append(header[:], frame[:idEnd]...)
+	key, err := master.Open(nil, frame[idEnd:idEnd+nonceSize], frame[idEnd+nonceSize:], aad)
+	if err != nil {
+		return ErrCorrupt
+	}
+	if r.data, err = newGCM(key); err != nil {
+		return fmt.Errorf("cryptlog: %w", err)
+	}
+	r.seq, r.final = 0, false
+	return nil
+}
+
+// IsEncrypted reports whether the file at path starts like an encrypted log.
+// A missing or empty file reports true, since there is nothing to convert.
+func IsEncrypted(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+	var b [1]byte
+	if n, _ := f.Read(b[:]); n == 0 {
+		return true
+	}
+	return b[0] == keyFrame
+}
@@ -0,0 +1,204 @@
+package cryptlog
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = bytes.Repeat([]byte{0x42}, keySize)
+
+func decryptAll(data []byte, keys ...[]byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data), keys...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	var out bytes.Buffer
+	w, err := NewWriter(&out, StaticKey(testKey), 16)
+	require.NoError(t, err, "Unexpected error creating the writer.")
+
+	records := []string{"short\n", strings.Repeat("a long record ", 10) + "\n", "last\n"}
+	for _, rec := range records {
+		want := out.Len() + w.Size(len(rec))
+		n, err := w.Write([]byte(rec))
+		require.NoError(t, err)
+		assert.Equal(t, len(rec), n)
+		assert.Equal(t, want, out.Len(), "Expected Size to predict the bytes written.")
+	}
+	want := out.Len() + FinalFrameSize
+	require.NoError(t, w.Close(), "Unexpected error closing the writer.")
+	assert.Equal(t, want, out.Len(), "Expected Close to write the final frame.")
+	assert.NotContains(t, out.String(), "record", "Expected no plain text in the output.")
+	assert.Equal(t, byte(keyFrame), out.Bytes()[0], "Expected the file to start with a key frame.")
+
+	plain, err := decryptAll(out.Bytes(), testKey)
+	require.NoError(t, err, "Unexpected error decrypting.")
+	assert.Equal(t, strings.Join(records, ""), string(plain))
+
+	plain, err = decryptAll(nil, testKey)
+	require.NoError(t, err, "Expected an empty file to be an empty log.")
+	assert.Empty(t, plain)
+}
+
+func TestNewKeyPerFile(t *testing.T) {
+	var first, second bytes.Buffer
+	sw := &switchWriter{w: &first}
+	w, err := NewWriter(sw, StaticKey(testKey), 0)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("one\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	sw.w = &second
+	require.NoError(t, w.NewKey())
+	_, err = w.Write([]byte("two\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	for buf, want := range map[*bytes.Buffer]string{&first: "one\n", &second: "two\n"} {
+		plain, err := decryptAll(buf.Bytes(), testKey)
+		require.NoError(t, err, "Expected each file to be readable on its own.")
+		assert.Equal(t, want, string(plain))
+	}
+	assert.NotEqual(t, first.Bytes()[:40], second.Bytes()[:40], "Expected a new sealed data key.")
+
+	// Files can be concatenated, as when appending after a restart.
+	plain, err := decryptAll(append(first.Bytes(), second.Bytes()...), testKey)
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n", string(plain))
+}
+
+type switchWriter struct{ w io.Writer }
+
+func (s *switchWriter) Write(p []byte) (int, error) { return s.w.Write(p) }
+
+func TestReaderErrors(t *testing.T) {
+	var out bytes.Buffer
+	w, err := NewWriter(&out, StaticKey(testKey), 0)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("first\n"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("second\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	data := out.Bytes()
+
+	otherKey := bytes.Repeat([]byte{0x17}, keySize)
+	_, err = decryptAll(data, otherKey)
+	assert.True(t, errors.Is(err, ErrUnknownKey), "Expected an unknown key error, have %v.", err)
+
+	plain, err := decryptAll(data, otherKey, testKey)
+	require.NoError(t, err, "Expected any of the keys to be used.")
+	assert.Equal(t, "first\nsecond\n", string(plain))
+
+	plain, err = decryptAll(data[:len(data)-FinalFrameSize-3], testKey)
+	assert.Equal(t, io.ErrUnexpectedEOF, err, "Expected a truncated frame to be reported.")
+	assert.Equal(t, "first\n", string(plain), "Expected the complete frames before a truncated one.")
+
+	tampered := append([]byte(nil), data...)
+	tampered[len(tampered)-20] ^= 1
+	_, err = decryptAll(tampered, testKey)
+	assert.Equal(t, ErrCorrupt, err, "Expected a modified frame to fail authentication.")
+
+	_, err = decryptAll([]byte(`{"msg":"plain"}`+"\n"), testKey)
+	assert.Equal(t, ErrNotEncrypted, err)
+}
+
+// frames splits an encrypted log into its frames.
+func frames(t *testing.T, data []byte) [][]byte {
+	var out [][]byte
+	for len(data) > 0 {
+		require.GreaterOrEqual(t, len(data), headerSize)
+		n := headerSize + int(binary.BigEndian.Uint32(data[1:headerSize]))
+		out = append(out, data[:n])
+		data = data[n:]
+	}
+	return out
+}
+
+func TestFrameOrder(t *testing.T) {
+	var out bytes.Buffer
+	w, err := NewWriter(&out, StaticKey(testKey), 0)
+	require.NoError(t, err)
+	for _, rec := range []string{"one\n", "two\n", "three\n"} {
+		_, err := w.Write([]byte(rec))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	// The key frame, three data frames and the final frame.
+	f := frames(t, out.Bytes())
+	require.Len(t, f, 5)
+
+	join := func(frames ...[]byte) []byte { return bytes.Join(frames, nil) }
+	tests := []struct {
+		desc  string
+		data  []byte
+		plain string
+		err   error
+	}{
+		{"swapped", join(f[0], f[2], f[1], f[3], f[4]), "", ErrCorrupt},
+		{"duplicated", join(f[0], f[1], f[1], f[2], f[3], f[4]), "one\n", ErrCorrupt},
+		{"removed", join(f[0], f[1], f[3], f[4]), "one\n", ErrCorrupt},
+		{"final frame moved", join(f[0], f[1], f[4]), "one\n", ErrCorrupt},
+		{"appended after the final frame", join(f[0], f[1], f[2], f[3], f[4], f[3]), "one\ntwo\nthree\n", ErrCorrupt},
+		{"cut after a frame", join(f[0], f[1], f[2]), "one\ntwo\n", ErrTruncated},
+		{"cut before a new key", join(f[0], f[1], f[0], f[1], f[2], f[3], f[4]), "one\n", ErrTruncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			plain, err := decryptAll(tt.data, testKey)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.plain, string(plain), "Expected the frames before the bad one.")
+		})
+	}
+
+	// A log still being written, or cut by a crash, can be read on purpose.
+	r, err := NewReader(bytes.NewReader(join(f[0], f[1], f[2])), testKey)
+	require.NoError(t, err)
+	r.AllowTruncated = true
+	plain, err := io.ReadAll(r)
+	require.NoError(t, err, "Expected a missing final frame to be allowed.")
+	assert.Equal(t, "one\ntwo\n", string(plain))
+}
+
+func TestKeySources(t *testing.T) {
+	dir := t.TempDir()
+	hexFile := filepath.Join(dir, "hex.key")
+	require.NoError(t, os.WriteFile(hexFile, []byte(hex.EncodeToString(testKey)+"\n"), 0o600))
+	t.Setenv("CRYPTLOG_TEST_KEY", base64.StdEncoding.EncodeToString(testKey))
+
+	for _, src := range []KeySource{FileKey(hexFile), EnvKey("CRYPTLOG_TEST_KEY"), StaticKey(testKey)} {
+		key, err := src.Key()
+		require.NoError(t, err, "Unexpected error reading %T.", src)
+		assert.Equal(t, testKey, key)
+	}
+
+	for _, src := range []KeySource{FileKey(filepath.Join(dir, "missing")), EnvKey("CRYPTLOG_TEST_UNSET"), StaticKey("short")} {
+		_, err := src.Key()
+		assert.Error(t, err, "Expected an error reading %T.", src)
+	}
+	_, err := NewWriter(io.Discard, StaticKey("short"), 0)
+	assert.Error(t, err, "Expected an error creating a writer with a bad key.")
+}
+
+func TestIsEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.log")
+	require.NoError(t, os.WriteFile(plain, []byte("{}\n"), 0o600))
+	assert.False(t, IsEncrypted(plain))
+	assert.True(t, IsEncrypted(filepath.Join(dir, "missing.log")))
+}
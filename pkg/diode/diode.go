@@ -6,6 +6,7 @@ import (
 	"context"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hinha/zap-logger/pkg/diode/internal"
@@ -31,6 +32,13 @@ type Writer struct {
 	d    diodeFetcher
 	c    context.CancelFunc
 	done chan struct{}
+
+	// written and handled count the records accepted by Write and the ones
+	// the poller has written out; with the drops counted by the diode, Sync
+	// can tell when it's drained.
+	written *uint64
+	handled *uint64
+	ring    *internal.ManyToOne
 }
 
 // NewWriter creates a writer wrapping w with a many-to-one diode in order to
@@ -44,14 +52,19 @@ type Writer struct {
 func NewWriter(w io.Writer, size int, pollInterval time.Duration, f Alerter) Writer {
 	ctx, cancel := context.WithCancel(context.Background())
 	dw := Writer{
-		w:    w,
-		c:    cancel,
-		done: make(chan struct{}),
+		w:       w,
+		c:       cancel,
+		done:    make(chan struct{}),
+		written: new(uint64),
+		handled: new(uint64),
 	}
 	if f == nil {
 		f = func(int) {}
 	}
+	// The alerts may count sequence numbers skipped by write collisions,
+	// so the records done with are counted by the diode instead.
 	d := internal.NewManyToOne(size, internal.AlertFunc(f))
+	dw.ring = d
 	if pollInterval > 0 {
 		dw.d = internal.NewPoller(d,
 			internal.WithPollingInterval(pollInterval),
@@ -67,10 +80,55 @@ func (dw Writer) Write(p []byte) (n int, err error) {
 	// p is pooled in zap, so we can't hold it passed this call, hence the
 	// copy.
 	p = append(bufPool.Get().([]byte), p...)
+	atomic.AddUint64(dw.written, 1)
 	dw.d.Set(internal.GenericDataType(&p))
 	return len(p), nil
 }
 
+// Sync blocks until every record accepted by Write so far has been handed to
+// the wrapped writer or dropped by the diode. The wrapped writer itself is not
+// synced: os.Stdout, the usual target, rejects fsync on pipes and terminals.
+func (dw Writer) Sync() error {
+	return dw.Flush(context.Background())
+}
+
+// Flush is like Sync but gives up once ctx is done, returning its error.
+// A poller doesn't wait for its next interval.
+func (dw Writer) Flush(ctx context.Context) error {
+	target := atomic.LoadUint64(dw.written)
+	for dw.finished() < target {
+		if p, ok := dw.d.(*internal.Poller); ok {
+			p.Wake()
+		}
+		select {
+		case <-dw.done:
+			// The poller is gone, nothing else will be drained.
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// Pending reports the records accepted by Write that the wrapped writer
+// hasn't been handed yet.
+func (dw Writer) Pending() uint64 {
+	// The records done with are loaded first, as they can't pass written.
+	done := dw.finished()
+	written := atomic.LoadUint64(dw.written)
+	if done >= written {
+		return 0
+	}
+	return written - done
+}
+
+// finished returns the records written out or dropped.
+func (dw Writer) finished() uint64 {
+	return atomic.LoadUint64(dw.handled) + dw.ring.Dropped()
+}
+
 // Close releases the diode poller and call Close on the wrapped writer if
 // io.Closer is implemented.
 func (dw Writer) Close() error {
@@ -91,6 +149,7 @@ func (dw Writer) poll() {
 		}
 		p := *(*[]byte)(d)
 		_, _ = dw.w.Write(p)
+		atomic.AddUint64(dw.handled, 1)
 
 		// Proper usage of a sync.Pool requires each entry to have approximately
 		// the same memory cost. To obtain this property when the stored type
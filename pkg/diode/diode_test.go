@@ -0,0 +1,165 @@
+package diode
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+// sink records writes. Writes block while gate is set, and Close is counted.
+type sink struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	gate   chan struct{}
+	closed int32
+}
+
+func (s *sink) Write(p []byte) (int, error) {
+	if s.gate != nil {
+		<-s.gate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *sink) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+func (s *sink) Close() error {
+	atomic.AddInt32(&s.closed, 1)
+	return nil
+}
+
+// within fails the test if f doesn't return in time.
+func within(t *testing.T, d time.Duration, desc string, f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(d):
+		t.Fatalf("Timed out: %s.", desc)
+	}
+}
+
+func TestWriterDrains(t *testing.T) {
+	for _, interval := range []time.Duration{0, 10 * time.Millisecond} {
+		t.Run(fmt.Sprint(interval), func(t *testing.T) {
+			s := &sink{}
+			w := NewWriter(s, 100, interval, nil)
+			var want bytes.Buffer
+			for i := 0; i < 50; i++ {
+				line := fmt.Sprintf("record %d\n", i)
+				want.WriteString(line)
+				n, err := w.Write([]byte(line))
+				require.NoError(t, err)
+				assert.Equal(t, len(line), n)
+			}
+			require.NoError(t, w.Sync(), "Unexpected error syncing.")
+			assert.Equal(t, want.String(), s.String(), "Expected every record, in order, after Sync.")
+			assert.Zero(t, w.Pending())
+			require.NoError(t, w.Close())
+		})
+	}
+}
+
+func TestSyncWakesPoller(t *testing.T) {
+	s := &sink{}
+	w := NewWriter(s, 100, time.Hour, nil)
+	_, err := w.Write([]byte("now\n"))
+	require.NoError(t, err)
+	within(t, 5*time.Second, "Sync waited for the polling interval", func() {
+		assert.NoError(t, w.Sync())
+	})
+	assert.Equal(t, "now\n", s.String())
+	within(t, 5*time.Second, "Close waited for the polling interval", func() {
+		assert.NoError(t, w.Close())
+	})
+}
+
+func TestCloseAfterSync(t *testing.T) {
+	s := &sink{}
+	w := NewWriter(s, 100, 10*time.Millisecond, nil)
+	_, err := w.Write([]byte("last\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Sync())
+	require.NoError(t, w.Close())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&s.closed), "Expected Close to close the wrapped writer.")
+	assert.Equal(t, "last\n", s.String())
+
+	// The poller is gone, so Sync must not wait for it.
+	_, err = w.Write([]byte("late\n"))
+	require.NoError(t, err)
+	within(t, 5*time.Second, "Sync after Close", func() {
+		assert.NoError(t, w.Sync())
+	})
+}
+
+func TestPendingAndDrops(t *testing.T) {
+	s := &sink{gate: make(chan struct{})}
+	var dropped int64
+	w := NewWriter(s, 4, 0, func(missed int) {
+		atomic.AddInt64(&dropped, int64(missed))
+	})
+	const records = 20
+	for i := 0; i < records; i++ {
+		_, err := w.Write([]byte("x"))
+		require.NoError(t, err)
+	}
+	assert.NotZero(t, w.Pending(), "Expected records waiting behind the blocked writer.")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, w.Flush(ctx), "Expected Flush to give up with its context.")
+
+	close(s.gate)
+	require.NoError(t, w.Sync())
+	assert.Zero(t, w.Pending(), "Expected every record to be written or dropped.")
+	assert.NotZero(t, atomic.LoadInt64(&dropped), "Expected the full diode to drop records.")
+	assert.Equal(t, int64(records), int64(len(s.String()))+atomic.LoadInt64(&dropped),
+		"Expected written and dropped records to add up.")
+	require.NoError(t, w.Close())
+}
+
+func TestPendingConcurrentWriters(t *testing.T) {
+	s := &sink{}
+	w := NewWriter(s, 2, 0, nil)
+	const writers, records = 8, 500
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < records; j++ {
+				_, _ = w.Write([]byte("x"))
+				// Collisions skip sequence numbers the reader would count
+				// as dropped; Pending must never wrap below zero.
+				assert.LessOrEqual(t, w.Pending(), uint64(writers*records))
+			}
+		}()
+	}
+	wg.Wait()
+	within(t, 5*time.Second, "sync after concurrent writes", func() {
+		require.NoError(t, w.Sync())
+	})
+	assert.Zero(t, w.Pending(), "Expected every record to be written or dropped.")
+	require.NoError(t, w.Close())
+}
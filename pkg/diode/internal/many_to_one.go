@@ -11,8 +11,12 @@ import (
 type ManyToOne struct {
 	writeIndex uint64
 	readIndex  uint64
-	buffer     []unsafe.Pointer
-	alerter    Alerter
+	// dropped counts the values lost, either overwritten by a writer before
+	// being read or found stale by the reader. Unlike the alerts, it doesn't
+	// count the sequence numbers skipped by set collisions.
+	dropped uint64
+	buffer  []unsafe.Pointer
+	alerter Alerter
 }
 
 // NewManyToOne creates a new diode (ring buffer). The ManyToOne diode
@@ -61,10 +65,20 @@ func (d *ManyToOne) Set(data GenericDataType) {
 			continue
 		}
 
+		// The reader clears the buckets it takes, so old was never read.
+		if old != nil {
+			atomic.AddUint64(&d.dropped, 1)
+		}
 		return
 	}
 }
 
+// Dropped returns the number of values lost so far. It's safe to call from
+// any go-routine.
+func (d *ManyToOne) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
 // TryNext will attempt to read from the next slot of the ring buffer.
 // If there is no data available, it will return (nil, false).
 func (d *ManyToOne) TryNext() (data GenericDataType, ok bool) {
@@ -93,6 +107,7 @@ func (d *ManyToOne) TryNext() (data GenericDataType, ok bool) {
 	//    `| 4 | 5 | 2 | 3 |` r: 7, w: 6
 	//
 	if result.seq < d.readIndex {
+		atomic.AddUint64(&d.dropped, 1)
 		return nil, false
 	}
 
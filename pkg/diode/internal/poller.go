@@ -16,6 +16,7 @@ type Poller struct {
 	Diode
 	interval time.Duration
 	ctx      context.Context
+	wake     chan struct{}
 }
 
 // PollerConfigOption can be used to setup the poller.
@@ -44,6 +45,7 @@ func NewPoller(d Diode, opts ...PollerConfigOption) *Poller {
 		Diode:    d,
 		interval: 10 * time.Millisecond,
 		ctx:      context.Background(),
+		wake:     make(chan struct{}, 1),
 	}
 
 	for _, o := range opts {
@@ -63,13 +65,29 @@ func (p *Poller) Next() GenericDataType {
 				return nil
 			}
 
-			time.Sleep(p.interval)
+			timer := time.NewTimer(p.interval)
+			select {
+			case <-timer.C:
+			case <-p.wake:
+				timer.Stop()
+			case <-p.ctx.Done():
+				timer.Stop()
+			}
 			continue
 		}
 		return data
 	}
 }
 
+// Wake makes a waiting Next poll the diode again now, rather than after the
+// polling interval.
+func (p *Poller) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
 func (p *Poller) isDone() bool {
 	select {
 	case <-p.ctx.Done():
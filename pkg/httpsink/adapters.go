@@ -0,0 +1,184 @@
+package httpsink
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Loki posts JSON records to the Loki push API, /loki/api/v1/push. Records
+// are grouped into streams labeled with their level and logger name, read
+// from the LevelKey and NameKey fields, on top of the static Labels.
+type Loki struct {
+	// Labels are added to every stream, e.g. {"app": "api"}.
+	Labels map[string]string
+	// LevelKey and NameKey are the record fields holding the level and the
+	// logger name. They default to "level" and "logger", as in the
+	// production encoder config; records without them get no such label.
+	LevelKey string
+	NameKey  string
+}
+
+// ContentType implements Adapter.
+func (Loki) ContentType() string { return "application/json" }
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// Encode implements Adapter.
+func (l Loki) Encode(w io.Writer, records []Record) error {
+	levelKey, nameKey := l.LevelKey, l.NameKey
+	if levelKey == "" {
+		levelKey = "level"
+	}
+	if nameKey == "" {
+		nameKey = "logger"
+	}
+
+	var (
+		streams []*lokiStream
+		index   = make(map[[2]string]*lokiStream)
+	)
+	for _, rec := range records {
+		var fields map[string]json.RawMessage
+		_ = json.Unmarshal(rec.Data, &fields)
+		key := [2]string{jsonString(fields[levelKey]), jsonString(fields[nameKey])}
+
+		stream, ok := index[key]
+		if !ok {
+			stream = &lokiStream{Stream: make(map[string]string, len(l.Labels)+2)}
+			for k, v := range l.Labels {
+				stream.Stream[k] = v
+			}
+			if key[0] != "" {
+				stream.Stream["level"] = key[0]
+			}
+			if key[1] != "" {
+				stream.Stream["logger"] = key[1]
+			}
+			index[key] = stream
+			streams = append(streams, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{
+			strconv.FormatInt(rec.Time.UnixNano(), 10),
+			string(rec.Data),
+		})
+	}
+	return json.NewEncoder(w).Encode(struct {
+		Streams []*lokiStream `json:"streams"`
+	}{streams})
+}
+
+// jsonString returns the value of a JSON string, or "" for anything else.
+func jsonString(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return ""
+	}
+	return s
+}
+
+// Elasticsearch posts JSON records to the _bulk API, creating one document
+// per record. Item failures reported in the response are returned as
+// errors and the batch isn't retried.
+type Elasticsearch struct {
+	// Index is the index or data stream documents are created in. If it's
+	// empty the index must be part of the URL, as in /logs/_bulk.
+	Index string
+}
+
+// ContentType implements Adapter.
+func (Elasticsearch) ContentType() string { return "application/x-ndjson" }
+
+// Encode implements Adapter.
+func (e Elasticsearch) Encode(w io.Writer, records []Record) error {
+	action := []byte("{\"create\":{}}\n")
+	if e.Index != "" {
+		index, err := json.Marshal(e.Index)
+		if err != nil {
+			return err
+		}
+		action = []byte(`{"create":{"_index":` + string(index) + "}}\n")
+	}
+	for _, rec := range records {
+		if _, err := w.Write(action); err != nil {
+			return err
+		}
+		if _, err := w.Write(rec.Data); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckResponse implements ResponseChecker, reporting the reasons items of
+// a bulk request failed.
+func (Elasticsearch) CheckResponse(body io.Reader) error {
+	var resp struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(body).Decode(&resp); err != nil || !resp.Errors {
+		return nil
+	}
+
+	reasons := make(map[string]int)
+	failed := 0
+	for _, item := range resp.Items {
+		for _, result := range item {
+			if result.Status >= 300 {
+				failed++
+				reasons[result.Error.Type+": "+result.Error.Reason]++
+			}
+		}
+	}
+	keys := make([]string, 0, len(reasons))
+	for reason := range reasons {
+		keys = append(keys, reason)
+	}
+	sort.Strings(keys)
+	msg := "httpsink: elasticsearch rejected " + strconv.Itoa(failed) + " of " + strconv.Itoa(len(resp.Items)) + " records"
+	if len(keys) > 0 {
+		msg += " (" + keys[0] + ")"
+	}
+	return errors.New(msg)
+}
+
+// Webhook posts JSON records as a JSON array.
+type Webhook struct{}
+
+// ContentType implements Adapter.
+func (Webhook) ContentType() string { return "application/json" }
+
+// Encode implements Adapter.
+func (Webhook) Encode(w io.Writer, records []Record) error {
+	sep := []byte{'['}
+	for _, rec := range records {
+		if _, err := w.Write(sep); err != nil {
+			return err
+		}
+		if _, err := w.Write(rec.Data); err != nil {
+			return err
+		}
+		sep[0] = ','
+	}
+	if len(records) == 0 {
+		_, err := io.WriteString(w, "[]")
+		return err
+	}
+	_, err := io.WriteString(w, "]")
+	return err
+}
@@ -0,0 +1,468 @@
+// Package httpsink posts log records to HTTP endpoints in batches. Records
+// are batched by count, size and age, turned into a request body by an
+// Adapter, gzipped and posted with retries. Batches that still fail are
+// spooled to a directory and replayed once the endpoint is back.
+//
+// A Sink is an io.Writer receiving one encoded record per Write; put it
+// behind a diode so that a slow endpoint never blocks logging.
+package httpsink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults used when a Config leaves the field unset.
+const (
+	DefaultBatchSize     = 1000
+	DefaultBatchBytes    = 1 << 20
+	DefaultBatchWait     = time.Second
+	DefaultMaxRetries    = 5
+	DefaultMinBackoff    = 100 * time.Millisecond
+	DefaultMaxBackoff    = 10 * time.Second
+	DefaultSpoolMaxBytes = 64 << 20
+	DefaultTimeout       = 10 * time.Second
+)
+
+// queueSize is the number of batches waiting to be posted before new ones
+// are spooled or dropped.
+const queueSize = 16
+
+// Record is an encoded log record, without its line ending, and the time it
+// was written.
+type Record struct {
+	Time time.Time
+	Data []byte
+}
+
+// Adapter turns a batch of records into a request body for an endpoint.
+type Adapter interface {
+	// ContentType is the Content-Type of the request body.
+	ContentType() string
+	// Encode writes the request body for records to w.
+	Encode(w io.Writer, records []Record) error
+}
+
+// ResponseChecker is implemented by adapters for endpoints that report
+// failures in successful responses, such as Elasticsearch bulk requests.
+type ResponseChecker interface {
+	// CheckResponse reads the body of a 2xx response and returns an error
+	// if records were rejected.
+	CheckResponse(body io.Reader) error
+}
+
+// Config describes an endpoint and how records are batched for it.
+type Config struct {
+	// URL is the endpoint records are posted to.
+	URL string
+	// Adapter builds request bodies; see Loki, Elasticsearch and Webhook.
+	Adapter Adapter
+	// Header is added to every request, e.g. for authentication.
+	Header http.Header
+	// Client defaults to a client with a 10 second timeout.
+	Client *http.Client
+
+	// A batch is posted once it holds BatchSize records or BatchBytes bytes
+	// of records, or BatchWait after it was started.
+	BatchSize  int
+	BatchBytes int
+	BatchWait  time.Duration
+
+	// MaxRetries is the number of times a batch is retried after a network
+	// error, a 429 or a 5xx response. Retries back off exponentially from
+	// MinBackoff to MaxBackoff, with jitter. It defaults to 5; a negative
+	// value disables retries.
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// DisableCompression posts bodies without gzip.
+	DisableCompression bool
+	// RawRecords keeps records exactly as written instead of trimming their
+	// line ending, for binary encodings such as protobuf.
+	RawRecords bool
+
+	// SpoolDir, if set, is the directory batches that couldn't be posted are
+	// written to, up to SpoolMaxBytes. Spooled batches, including those left
+	// by a previous run, are replayed oldest first after the next successful
+	// post, so records may arrive out of order.
+	SpoolDir      string
+	SpoolMaxBytes int64
+
+	// ErrorHandler, if set, is called with errors posting or spooling
+	// batches. It's called from the Sink's goroutine or from Write.
+	ErrorHandler func(error)
+}
+
+// ErrClosed is returned by Write after Close.
+var ErrClosed = errors.New("httpsink: sink closed")
+
+var errQueueFull = errors.New("httpsink: queue full, batch dropped")
+
+// Sink batches and posts records. It's safe for concurrent use.
+type Sink struct {
+	cfg   Config
+	spool *spool
+
+	mu     sync.Mutex
+	batch  []Record
+	size   int
+	closed bool
+
+	queue chan queued
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// queued is a batch to post, or a flush marker closed once everything
+// queued before it was handled.
+type queued struct {
+	records []Record
+	flushed chan struct{}
+}
+
+// New returns a Sink for cfg and starts its goroutine. Close the Sink to
+// post the last records and stop it.
+func New(cfg Config) (*Sink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("httpsink: missing URL")
+	}
+	if cfg.Adapter == nil {
+		return nil, errors.New("httpsink: missing Adapter")
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: DefaultTimeout}
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.BatchBytes <= 0 {
+		cfg.BatchBytes = DefaultBatchBytes
+	}
+	if cfg.BatchWait <= 0 {
+		cfg.BatchWait = DefaultBatchWait
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.SpoolMaxBytes <= 0 {
+		cfg.SpoolMaxBytes = DefaultSpoolMaxBytes
+	}
+
+	s := &Sink{
+		cfg:   cfg,
+		queue: make(chan queued, queueSize),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if cfg.SpoolDir != "" {
+		sp, err := openSpool(cfg.SpoolDir, cfg.SpoolMaxBytes, !cfg.DisableCompression)
+		if err != nil {
+			return nil, err
+		}
+		s.spool = sp
+	}
+	go s.run()
+	return s, nil
+}
+
+// Write adds a copy of p, minus its line ending, to the current batch.
+func (s *Sink) Write(p []byte) (int, error) {
+	data := p
+	if !s.cfg.RawRecords {
+		data = bytes.TrimRight(p, "\r\n")
+	}
+	rec := Record{Time: time.Now(), Data: append([]byte(nil), data...)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	s.batch = append(s.batch, rec)
+	s.size += len(rec.Data)
+	if len(s.batch) >= s.cfg.BatchSize || s.size >= s.cfg.BatchBytes {
+		s.enqueue(s.cut())
+	}
+	return len(p), nil
+}
+
+// cut returns the current batch and starts a new one. s.mu must be held.
+func (s *Sink) cut() []Record {
+	batch := s.batch
+	s.batch, s.size = nil, 0
+	return batch
+}
+
+// enqueue hands a batch to the goroutine without blocking. When the queue is
+// full the batch is spooled, or dropped. s.mu must be held.
+func (s *Sink) enqueue(batch []Record) {
+	select {
+	case s.queue <- queued{records: batch}:
+	default:
+		if s.spool == nil {
+			s.report(errQueueFull)
+			return
+		}
+		body, err := s.encode(batch)
+		if err == nil {
+			err = s.spool.put(body)
+		}
+		if err != nil {
+			s.report(err)
+		}
+	}
+}
+
+// Sync posts the current batch and waits until every batch written before
+// was posted or spooled.
+func (s *Sink) Sync() error {
+	flushed := make(chan struct{})
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	batch := s.cut()
+	s.mu.Unlock()
+
+	if len(batch) > 0 {
+		select {
+		case s.queue <- queued{records: batch}:
+		case <-s.done:
+			return nil
+		}
+	}
+	select {
+	case s.queue <- queued{flushed: flushed}:
+	case <-s.done:
+		return nil
+	}
+	select {
+	case <-flushed:
+	case <-s.done:
+	}
+	return nil
+}
+
+// Close posts the remaining records and stops the Sink. Batches that can't
+// be posted without retrying are spooled.
+func (s *Sink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.BatchWait)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case q := <-s.queue:
+			s.handle(q)
+		case <-ticker.C:
+			s.mu.Lock()
+			batch := s.cut()
+			s.mu.Unlock()
+			if len(batch) > 0 {
+				s.deliver(batch)
+			}
+		case <-s.stop:
+			for {
+				select {
+				case q := <-s.queue:
+					s.handle(q)
+				default:
+					s.mu.Lock()
+					batch := s.cut()
+					s.mu.Unlock()
+					if len(batch) > 0 {
+						s.deliver(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Sink) handle(q queued) {
+	if len(q.records) > 0 {
+		s.deliver(q.records)
+	}
+	if q.flushed != nil {
+		close(q.flushed)
+	}
+}
+
+// deliver posts a batch, spooling it if that fails, and replays the spool
+// after a successful post.
+func (s *Sink) deliver(batch []Record) {
+	body, err := s.encode(batch)
+	if err != nil {
+		s.report(err)
+		return
+	}
+	if err := s.post(body); err != nil {
+		s.report(err)
+		var perm *permanentError
+		if s.spool != nil && !errors.As(err, &perm) {
+			if err := s.spool.put(body); err != nil {
+				s.report(err)
+			}
+		}
+		return
+	}
+	if s.spool != nil {
+		s.replay()
+	}
+}
+
+func (s *Sink) encode(batch []Record) ([]byte, error) {
+	var buf bytes.Buffer
+	if s.cfg.DisableCompression {
+		if err := s.cfg.Adapter.Encode(&buf, batch); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	zw := gzip.NewWriter(&buf)
+	if err := s.cfg.Adapter.Encode(zw, batch); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// replay posts spooled batches, oldest first, until one fails.
+func (s *Sink) replay() {
+	for {
+		name, body, gzipped, err := s.spool.oldest()
+		if err != nil {
+			s.report(err)
+			return
+		}
+		if name == "" {
+			return
+		}
+		if err := s.postOnce(body, gzipped); err != nil {
+			var perm *permanentError
+			if !errors.As(err, &perm) {
+				return
+			}
+			s.report(err)
+		}
+		if err := s.spool.remove(name); err != nil {
+			s.report(err)
+			return
+		}
+	}
+}
+
+// post sends body, retrying retriable failures with backoff. Retries stop
+// when the Sink is closed.
+func (s *Sink) post(body []byte) error {
+	for attempt := 0; ; attempt++ {
+		err := s.postOnce(body, !s.cfg.DisableCompression)
+		if err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) || attempt >= s.cfg.MaxRetries {
+			return err
+		}
+		select {
+		case <-time.After(s.backoff(attempt)):
+		case <-s.stop:
+			return err
+		}
+	}
+}
+
+// backoff returns the wait before retry attempt+1: MinBackoff doubled per
+// attempt, capped at MaxBackoff, with the upper half randomized.
+func (s *Sink) backoff(attempt int) time.Duration {
+	d := s.cfg.MaxBackoff
+	if attempt < 30 {
+		if b := s.cfg.MinBackoff << uint(attempt); b > 0 && b < d {
+			d = b
+		}
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// permanentError is a failure retrying won't fix, such as a 400 response.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func (s *Sink) postOnce(body []byte, gzipped bool) error {
+	req, err := http.NewRequest(http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	for k, v := range s.cfg.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", s.cfg.Adapter.ContentType())
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	defer io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		if c, ok := s.cfg.Adapter.(ResponseChecker); ok {
+			if err := c.CheckResponse(resp.Body); err != nil {
+				return &permanentError{err}
+			}
+		}
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("httpsink: %s: %s", s.cfg.URL, resp.Status)
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return &permanentError{fmt.Errorf("httpsink: %s: %s: %s", s.cfg.URL, resp.Status, bytes.TrimSpace(msg))}
+}
+
+func (s *Sink) report(err error) {
+	if s.cfg.ErrorHandler != nil {
+		s.cfg.ErrorHandler(err)
+	}
+}
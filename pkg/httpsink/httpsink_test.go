@@ -0,0 +1,323 @@
+package httpsink
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+// endpoint records the bodies posted to it and answers with the statuses
+// in replies, then 200.
+type endpoint struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	replies []int
+	bodies  []string
+	posted  chan struct{}
+}
+
+func newEndpoint(replies ...int) *endpoint {
+	e := &endpoint{replies: replies, posted: make(chan struct{}, 100)}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		b, _ := io.ReadAll(body)
+
+		e.mu.Lock()
+		status := http.StatusOK
+		if len(e.replies) > 0 {
+			status, e.replies = e.replies[0], e.replies[1:]
+		}
+		if status == http.StatusOK {
+			e.bodies = append(e.bodies, string(b))
+		}
+		e.mu.Unlock()
+		w.WriteHeader(status)
+		e.posted <- struct{}{}
+	}))
+	return e
+}
+
+func (e *endpoint) received() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.bodies...)
+}
+
+func (e *endpoint) wait(t *testing.T) {
+	select {
+	case <-e.posted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a request.")
+	}
+}
+
+func write(t *testing.T, s *Sink, records ...string) {
+	for _, rec := range records {
+		_, err := s.Write([]byte(rec + "\n"))
+		require.NoError(t, err, "Unexpected error writing a record.")
+	}
+}
+
+func TestSinkBatchSize(t *testing.T) {
+	e := newEndpoint()
+	defer e.Close()
+	s, err := New(Config{URL: e.URL, Adapter: Webhook{}, BatchSize: 2, BatchWait: time.Hour})
+	require.NoError(t, err)
+
+	write(t, s, `{"n":1}`, `{"n":2}`, `{"n":3}`)
+	e.wait(t)
+	assert.Equal(t, []string{`[{"n":1},{"n":2}]`}, e.received(), "Expected a full batch to be posted.")
+
+	require.NoError(t, s.Close())
+	assert.Equal(t, []string{`[{"n":1},{"n":2}]`, `[{"n":3}]`}, e.received(), "Expected Close to post the last batch.")
+}
+
+func TestSinkBatchWait(t *testing.T) {
+	e := newEndpoint()
+	defer e.Close()
+	s, err := New(Config{URL: e.URL, Adapter: Webhook{}, BatchWait: 10 * time.Millisecond, DisableCompression: true})
+	require.NoError(t, err)
+	defer s.Close()
+
+	write(t, s, `{"n":1}`)
+	e.wait(t)
+	assert.Equal(t, []string{`[{"n":1}]`}, e.received(), "Expected the batch to be posted after BatchWait.")
+}
+
+func TestSinkSync(t *testing.T) {
+	e := newEndpoint()
+	defer e.Close()
+	s, err := New(Config{URL: e.URL, Adapter: Webhook{}, BatchWait: time.Hour})
+	require.NoError(t, err)
+	defer s.Close()
+
+	write(t, s, `{"n":1}`)
+	require.NoError(t, s.Sync())
+	assert.Equal(t, []string{`[{"n":1}]`}, e.received(), "Expected Sync to post the batch.")
+}
+
+func TestSinkRetry(t *testing.T) {
+	e := newEndpoint(http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	defer e.Close()
+	s, err := New(Config{URL: e.URL, Adapter: Webhook{}, BatchWait: time.Hour, MinBackoff: time.Millisecond})
+	require.NoError(t, err)
+	defer s.Close()
+
+	write(t, s, `{"n":1}`)
+	require.NoError(t, s.Sync())
+	assert.Equal(t, []string{`[{"n":1}]`}, e.received(), "Expected the batch to be retried.")
+}
+
+func TestSinkPermanentError(t *testing.T) {
+	e := newEndpoint(http.StatusBadRequest)
+	defer e.Close()
+	var (
+		mu     sync.Mutex
+		errors []error
+	)
+	s, err := New(Config{
+		URL:        e.URL,
+		Adapter:    Webhook{},
+		BatchWait:  time.Hour,
+		MinBackoff: time.Millisecond,
+		SpoolDir:   t.TempDir(),
+		ErrorHandler: func(err error) {
+			mu.Lock()
+			errors = append(errors, err)
+			mu.Unlock()
+		},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	write(t, s, `{"n":1}`)
+	require.NoError(t, s.Sync())
+	write(t, s, `{"n":2}`)
+	require.NoError(t, s.Sync())
+
+	assert.Equal(t, []string{`[{"n":2}]`}, e.received(), "Expected a rejected batch to be dropped, not retried or spooled.")
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, errors, 1, "Expected the rejection to be reported.")
+	assert.Contains(t, errors[0].Error(), "400 Bad Request")
+}
+
+func TestSinkSpool(t *testing.T) {
+	e := newEndpoint(http.StatusBadGateway, http.StatusBadGateway)
+	defer e.Close()
+	dir := t.TempDir()
+	s, err := New(Config{URL: e.URL, Adapter: Webhook{}, BatchWait: time.Hour, MaxRetries: -1, SpoolDir: dir})
+	require.NoError(t, err)
+	defer s.Close()
+
+	write(t, s, `{"n":1}`)
+	require.NoError(t, s.Sync())
+	write(t, s, `{"n":2}`)
+	require.NoError(t, s.Sync())
+	assert.Empty(t, e.received(), "Expected the endpoint to be down.")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "Expected both batches to be spooled.")
+
+	write(t, s, `{"n":3}`)
+	require.NoError(t, s.Sync())
+	assert.Equal(t, []string{`[{"n":3}]`, `[{"n":1}]`, `[{"n":2}]`}, e.received(),
+		"Expected spooled batches to be replayed oldest first after a successful post.")
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "Expected the spool to be emptied.")
+}
+
+func TestSpoolMaxBytes(t *testing.T) {
+	sp, err := openSpool(t.TempDir(), 10, false)
+	require.NoError(t, err)
+
+	require.NoError(t, sp.put([]byte("aaaa")))
+	require.NoError(t, sp.put([]byte("bbbb")))
+	require.NoError(t, sp.put([]byte("cccc")))
+	assert.Error(t, sp.put([]byte("too large batch")), "Expected batches larger than the spool to be rejected.")
+
+	name, body, gzipped, err := sp.oldest()
+	require.NoError(t, err)
+	assert.Equal(t, "bbbb", string(body), "Expected the oldest batch to be evicted.")
+	assert.False(t, gzipped)
+	require.NoError(t, sp.remove(name))
+}
+
+func TestBackoff(t *testing.T) {
+	s := &Sink{cfg: Config{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}}
+	for attempt, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		d := s.backoff(attempt)
+		assert.True(t, d >= max/2 && d <= max, "Backoff %v for attempt %d not in [%v, %v].", d, attempt, max/2, max)
+	}
+	d := s.backoff(100)
+	assert.True(t, d >= time.Second/2 && d <= time.Second, "Expected large attempts to be capped, got %v.", d)
+}
+
+func TestLoki(t *testing.T) {
+	at := time.Unix(1700000000, 5)
+	records := []Record{
+		{Time: at, Data: []byte(`{"level":"info","logger":"http","msg":"a"}`)},
+		{Time: at, Data: []byte(`{"level":"error","msg":"b"}`)},
+		{Time: at, Data: []byte(`{"level":"info","logger":"http","msg":"c"}`)},
+		{Time: at, Data: []byte(`not json`)},
+	}
+
+	var buf strings.Builder
+	require.NoError(t, Loki{Labels: map[string]string{"app": "api"}}.Encode(&buf, records))
+	assert.JSONEq(t, `{"streams":[
+		{"stream":{"app":"api","level":"info","logger":"http"},"values":[
+			["1700000000000000005","{\"level\":\"info\",\"logger\":\"http\",\"msg\":\"a\"}"],
+			["1700000000000000005","{\"level\":\"info\",\"logger\":\"http\",\"msg\":\"c\"}"]]},
+		{"stream":{"app":"api","level":"error"},"values":[
+			["1700000000000000005","{\"level\":\"error\",\"msg\":\"b\"}"]]},
+		{"stream":{"app":"api"},"values":[["1700000000000000005","not json"]]}
+	]}`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, Loki{LevelKey: "L"}.Encode(&buf, []Record{{Time: at, Data: []byte(`{"L":"WARN"}`)}}))
+	assert.Contains(t, buf.String(), `"stream":{"level":"WARN"}`, "Expected a custom level key.")
+}
+
+func TestElasticsearch(t *testing.T) {
+	records := []Record{{Data: []byte(`{"n":1}`)}, {Data: []byte(`{"n":2}`)}}
+
+	var buf strings.Builder
+	require.NoError(t, Elasticsearch{Index: "logs"}.Encode(&buf, records))
+	assert.Equal(t, "{\"create\":{\"_index\":\"logs\"}}\n{\"n\":1}\n{\"create\":{\"_index\":\"logs\"}}\n{\"n\":2}\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, Elasticsearch{}.Encode(&buf, records[:1]))
+	assert.Equal(t, "{\"create\":{}}\n{\"n\":1}\n", buf.String())
+
+	ok := `{"took":3,"errors":false,"items":[{"create":{"status":201}}]}`
+	assert.NoError(t, Elasticsearch{}.CheckResponse(strings.NewReader(ok)))
+
+	failed := `{"took":3,"errors":true,"items":[
+		{"create":{"status":201}},
+		{"create":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field [n]"}}}]}`
+	err := Elasticsearch{}.CheckResponse(strings.NewReader(failed))
+	require.Error(t, err)
+	assert.Equal(t, "httpsink: elasticsearch rejected 1 of 2 records (mapper_parsing_exception: failed to parse field [n])", err.Error())
+}
+
+func TestElasticsearchSink(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("Authorization"))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"errors": true,
+			"items":  []interface{}{map[string]interface{}{"create": map[string]interface{}{"status": 400}}},
+		})
+	}))
+	defer srv.Close()
+
+	var reported error
+	s, err := New(Config{
+		URL:          srv.URL + "/logs/_bulk",
+		Adapter:      Elasticsearch{},
+		Header:       http.Header{"Authorization": {"secret"}},
+		BatchWait:    time.Hour,
+		ErrorHandler: func(err error) { reported = err },
+	})
+	require.NoError(t, err)
+	write(t, s, `{"n":1}`)
+	require.NoError(t, s.Close())
+
+	assert.Equal(t, 1, requests, "Expected item failures not to be retried.")
+	assert.EqualError(t, reported, "httpsink: elasticsearch rejected 1 of 1 records (: )")
+}
+
+func TestWebhook(t *testing.T) {
+	var buf strings.Builder
+	require.NoError(t, Webhook{}.Encode(&buf, nil))
+	assert.Equal(t, "[]", buf.String())
+}
+
+func TestNewErrors(t *testing.T) {
+	_, err := New(Config{Adapter: Webhook{}})
+	assert.Error(t, err, "Expected an error without a URL.")
+	_, err = New(Config{URL: "http://localhost"})
+	assert.Error(t, err, "Expected an error without an adapter.")
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	s, err := New(Config{URL: "http://localhost", Adapter: Webhook{}})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	_, err = s.Write([]byte("{}"))
+	assert.Equal(t, ErrClosed, err)
+	assert.NoError(t, s.Sync())
+	assert.NoError(t, s.Close())
+}
@@ -0,0 +1,126 @@
+package httpsink
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spool keeps request bodies that couldn't be posted in a directory, one
+// file per batch named after the time it was spooled. Gzipped bodies end in
+// ".gz", so batches left by a run with other settings are replayed right.
+type spool struct {
+	dir      string
+	maxBytes int64
+	ext      string
+
+	mu  sync.Mutex
+	seq uint64
+}
+
+func openSpool(dir string, maxBytes int64, gzipped bool) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("httpsink: spool: %w", err)
+	}
+	ext := ".batch"
+	if gzipped {
+		ext += ".gz"
+	}
+	return &spool{dir: dir, maxBytes: maxBytes, ext: ext}, nil
+}
+
+// put writes body to a new file, removing the oldest batches to stay within
+// maxBytes.
+func (s *spool) put(body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if int64(len(body)) > s.maxBytes {
+		return fmt.Errorf("httpsink: spool: batch of %d bytes exceeds the spool size", len(body))
+	}
+	files, total, err := s.list()
+	if err != nil {
+		return err
+	}
+	for len(files) > 0 && total+int64(len(body)) > s.maxBytes {
+		if err := os.Remove(filepath.Join(s.dir, files[0].name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("httpsink: spool: %w", err)
+		}
+		total -= files[0].size
+		files = files[1:]
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1e6, s.ext)
+	tmp := filepath.Join(s.dir, "."+name)
+	if err := os.WriteFile(tmp, body, 0o600); err != nil {
+		return fmt.Errorf("httpsink: spool: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("httpsink: spool: %w", err)
+	}
+	return nil
+}
+
+// oldest returns the name and body of the oldest spooled batch and whether
+// it's gzipped, or an empty name if there is none.
+func (s *spool) oldest() (name string, body []byte, gzipped bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, _, err := s.list()
+	if err != nil || len(files) == 0 {
+		return "", nil, false, err
+	}
+	name = files[0].name
+	body, err = os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return "", nil, false, fmt.Errorf("httpsink: spool: %w", err)
+	}
+	return name, body, strings.HasSuffix(name, ".gz"), nil
+}
+
+func (s *spool) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("httpsink: spool: %w", err)
+	}
+	return nil
+}
+
+type spoolFile struct {
+	name string
+	size int64
+}
+
+// list returns the spooled batches, oldest first, and their total size.
+func (s *spool) list() ([]spoolFile, int64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, 0, fmt.Errorf("httpsink: spool: %w", err)
+	}
+	var (
+		files []spoolFile
+		total int64
+	)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") || !strings.Contains(name, ".batch") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, spoolFile{name: name, size: info.Size()})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files, total, nil
+}
@@ -0,0 +1,532 @@
+package logdecode
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// maxDepth bounds the nesting of containers, so corrupt input can't exhaust
+// the stack.
+const maxDepth = 1000
+
+var errTooDeep = errors.New("logdecode: containers nested too deeply")
+
+// decoder converts one record to JSON.
+type decoder struct {
+	in  []byte
+	pos int
+	out []byte
+}
+
+func (d *decoder) next() (byte, error) {
+	if d.pos >= len(d.in) {
+		return 0, ErrUnexpectedEnd
+	}
+	b := d.in[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *decoder) take(n uint64) ([]byte, error) {
+	if n > uint64(len(d.in)-d.pos) {
+		return nil, ErrUnexpectedEnd
+	}
+	b := d.in[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *decoder) uint(size int) (uint64, error) {
+	b, err := d.take(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// key decodes a map key with value, quoting it if it isn't a string.
+func (d *decoder) key(value func() error) error {
+	start := len(d.out)
+	if err := value(); err != nil {
+		return err
+	}
+	if d.out[start] != '"' {
+		raw := string(d.out[start:])
+		d.out = appendString(d.out[:start], raw)
+	}
+	d.out = append(d.out, ':')
+	return nil
+}
+
+func appendFloat(out []byte, f float64, bitSize int) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(out, `"NaN"`...)
+	case math.IsInf(f, 1):
+		return append(out, `"+Inf"`...)
+	case math.IsInf(f, -1):
+		return append(out, `"-Inf"`...)
+	}
+	return strconv.AppendFloat(out, f, 'f', -1, bitSize)
+}
+
+func appendBytes(out []byte, b []byte) []byte {
+	out = append(out, '"')
+	out = append(out, base64.StdEncoding.EncodeToString(b)...)
+	return append(out, '"')
+}
+
+func appendTime(out []byte, t time.Time) []byte {
+	return appendString(out, t.UTC().Format(time.RFC3339Nano))
+}
+
+// appendString writes s as a JSON string, replacing invalid UTF-8.
+func appendString(out []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	out = append(out, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				out = append(out, '\\', c)
+			case c == '\n':
+				out = append(out, '\\', 'n')
+			case c == '\r':
+				out = append(out, '\\', 'r')
+			case c == '\t':
+				out = append(out, '\\', 't')
+			case c < ' ':
+				out = append(out, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			default:
+				out = append(out, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			out = append(out, "\ufffd"...)
+		} else {
+			out = append(out, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(out, '"')
+}
+
+// CBOR, see RFC 8949.
+
+const cborIndefinite = 31
+
+func (d *decoder) cborArg(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info <= 27:
+		return d.uint(1 << (info - 24))
+	}
+	return 0, fmt.Errorf("logdecode: invalid CBOR argument %d", info)
+}
+
+func (d *decoder) cborBreak() bool {
+	if d.pos < len(d.in) && d.in[d.pos] == 0xff {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *decoder) cbor(depth int) error {
+	if depth > maxDepth {
+		return errTooDeep
+	}
+	ib, err := d.next()
+	if err != nil {
+		return err
+	}
+	major, info := ib>>5, ib&0x1f
+
+	if major == 7 {
+		return d.cborSimple(info)
+	}
+	if info == cborIndefinite {
+		return d.cborIndefinite(major, depth)
+	}
+	n, err := d.cborArg(info)
+	if err != nil {
+		return err
+	}
+
+	switch major {
+	case 0:
+		d.out = strconv.AppendUint(d.out, n, 10)
+	case 1:
+		d.out = append(d.out, '-')
+		if n == math.MaxUint64 {
+			d.out = append(d.out, "18446744073709551616"...)
+		} else {
+			d.out = strconv.AppendUint(d.out, n+1, 10)
+		}
+	case 2:
+		b, err := d.take(n)
+		if err != nil {
+			return err
+		}
+		d.out = appendBytes(d.out, b)
+	case 3:
+		b, err := d.take(n)
+		if err != nil {
+			return err
+		}
+		d.out = appendString(d.out, string(b))
+	case 4:
+		d.out = append(d.out, '[')
+		for i := uint64(0); i < n; i++ {
+			if i > 0 {
+				d.out = append(d.out, ',')
+			}
+			if err := d.cbor(depth + 1); err != nil {
+				return err
+			}
+		}
+		d.out = append(d.out, ']')
+	case 5:
+		d.out = append(d.out, '{')
+		for i := uint64(0); i < n; i++ {
+			if i > 0 {
+				d.out = append(d.out, ',')
+			}
+			if err := d.cborPair(depth); err != nil {
+				return err
+			}
+		}
+		d.out = append(d.out, '}')
+	case 6:
+		return d.cborTag(n, depth)
+	}
+	return nil
+}
+
+func (d *decoder) cborPair(depth int) error {
+	if err := d.key(func() error { return d.cbor(depth + 1) }); err != nil {
+		return err
+	}
+	return d.cbor(depth + 1)
+}
+
+func (d *decoder) cborIndefinite(major byte, depth int) error {
+	switch major {
+	case 2, 3:
+		var chunks []byte
+		for !d.cborBreak() {
+			ib, err := d.next()
+			if err != nil {
+				return err
+			}
+			if ib>>5 != major || ib&0x1f == cborIndefinite {
+				return errors.New("logdecode: invalid CBOR string chunk")
+			}
+			n, err := d.cborArg(ib & 0x1f)
+			if err != nil {
+				return err
+			}
+			b, err := d.take(n)
+			if err != nil {
+				return err
+			}
+			chunks = append(chunks, b...)
+		}
+		if major == 2 {
+			d.out = appendBytes(d.out, chunks)
+		} else {
+			d.out = appendString(d.out, string(chunks))
+		}
+	case 4:
+		d.out = append(d.out, '[')
+		for i := 0; !d.cborBreak(); i++ {
+			if i > 0 {
+				d.out = append(d.out, ',')
+			}
+			if err := d.cbor(depth + 1); err != nil {
+				return err
+			}
+		}
+		d.out = append(d.out, ']')
+	case 5:
+		d.out = append(d.out, '{')
+		for i := 0; !d.cborBreak(); i++ {
+			if i > 0 {
+				d.out = append(d.out, ',')
+			}
+			if err := d.cborPair(depth); err != nil {
+				return err
+			}
+		}
+		d.out = append(d.out, '}')
+	default:
+		return fmt.Errorf("logdecode: invalid indefinite CBOR major type %d", major)
+	}
+	return nil
+}
+
+// cborTag decodes tagged values. Epoch times (tag 1) become RFC 3339 strings;
+// other tags, including RFC 3339 strings (tag 0), decode as their content.
+func (d *decoder) cborTag(tag uint64, depth int) error {
+	if tag != 1 {
+		return d.cbor(depth + 1)
+	}
+	start := len(d.out)
+	if err := d.cbor(depth + 1); err != nil {
+		return err
+	}
+	secs, err := strconv.ParseFloat(string(d.out[start:]), 64)
+	if err != nil {
+		return fmt.Errorf("logdecode: invalid CBOR epoch time: %v", err)
+	}
+	whole, frac := math.Modf(secs)
+	d.out = appendTime(d.out[:start], time.Unix(int64(whole), int64(frac*1e9)))
+	return nil
+}
+
+func (d *decoder) cborSimple(info byte) error {
+	switch info {
+	case 20:
+		d.out = append(d.out, "false"...)
+	case 21:
+		d.out = append(d.out, "true"...)
+	case 22, 23:
+		d.out = append(d.out, "null"...)
+	case 25:
+		v, err := d.uint(2)
+		if err != nil {
+			return err
+		}
+		d.out = appendFloat(d.out, halfToFloat(uint16(v)), 32)
+	case 26:
+		v, err := d.uint(4)
+		if err != nil {
+			return err
+		}
+		d.out = appendFloat(d.out, float64(math.Float32frombits(uint32(v))), 32)
+	case 27:
+		v, err := d.uint(8)
+		if err != nil {
+			return err
+		}
+		d.out = appendFloat(d.out, math.Float64frombits(v), 64)
+	default:
+		return fmt.Errorf("logdecode: unsupported CBOR simple value %d", info)
+	}
+	return nil
+}
+
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
+
+// MessagePack, see https://github.com/msgpack/msgpack/blob/master/spec.md.
+
+func (d *decoder) msgpack(depth int) error {
+	if depth > maxDepth {
+		return errTooDeep
+	}
+	b, err := d.next()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case b <= 0x7f:
+		d.out = strconv.AppendUint(d.out, uint64(b), 10)
+		return nil
+	case b >= 0xe0:
+		d.out = strconv.AppendInt(d.out, int64(int8(b)), 10)
+		return nil
+	case b&0xf0 == 0x80:
+		return d.msgpackMap(uint64(b&0x0f), depth)
+	case b&0xf0 == 0x90:
+		return d.msgpackArray(uint64(b&0x0f), depth)
+	case b&0xe0 == 0xa0:
+		return d.msgpackString(uint64(b & 0x1f))
+	}
+
+	switch b {
+	case 0xc0:
+		d.out = append(d.out, "null"...)
+	case 0xc2:
+		d.out = append(d.out, "false"...)
+	case 0xc3:
+		d.out = append(d.out, "true"...)
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (b - 0xc4))
+		if err != nil {
+			return err
+		}
+		data, err := d.take(n)
+		if err != nil {
+			return err
+		}
+		d.out = appendBytes(d.out, data)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.uint(1 << (b - 0xc7))
+		if err != nil {
+			return err
+		}
+		return d.msgpackExt(n)
+	case 0xca:
+		v, err := d.uint(4)
+		if err != nil {
+			return err
+		}
+		d.out = appendFloat(d.out, float64(math.Float32frombits(uint32(v))), 32)
+	case 0xcb:
+		v, err := d.uint(8)
+		if err != nil {
+			return err
+		}
+		d.out = appendFloat(d.out, math.Float64frombits(v), 64)
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		v, err := d.uint(1 << (b - 0xcc))
+		if err != nil {
+			return err
+		}
+		d.out = strconv.AppendUint(d.out, v, 10)
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		v, err := d.uint(size)
+		if err != nil {
+			return err
+		}
+		// Sign-extend from size bytes.
+		shift := uint(64 - 8*size)
+		d.out = strconv.AppendInt(d.out, int64(v<<shift)>>shift, 10)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.msgpackExt(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (b - 0xd9))
+		if err != nil {
+			return err
+		}
+		return d.msgpackString(n)
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (b - 0xdc))
+		if err != nil {
+			return err
+		}
+		return d.msgpackArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (b - 0xde))
+		if err != nil {
+			return err
+		}
+		return d.msgpackMap(n, depth)
+	default:
+		return fmt.Errorf("logdecode: invalid MessagePack type 0x%02x", b)
+	}
+	return nil
+}
+
+func (d *decoder) msgpackString(n uint64) error {
+	b, err := d.take(n)
+	if err != nil {
+		return err
+	}
+	d.out = appendString(d.out, string(b))
+	return nil
+}
+
+func (d *decoder) msgpackArray(n uint64, depth int) error {
+	d.out = append(d.out, '[')
+	for i := uint64(0); i < n; i++ {
+		if i > 0 {
+			d.out = append(d.out, ',')
+		}
+		if err := d.msgpack(depth + 1); err != nil {
+			return err
+		}
+	}
+	d.out = append(d.out, ']')
+	return nil
+}
+
+func (d *decoder) msgpackMap(n uint64, depth int) error {
+	d.out = append(d.out, '{')
+	for i := uint64(0); i < n; i++ {
+		if i > 0 {
+			d.out = append(d.out, ',')
+		}
+		if err := d.key(func() error { return d.msgpack(depth + 1) }); err != nil {
+			return err
+		}
+		if err := d.msgpack(depth + 1); err != nil {
+			return err
+		}
+	}
+	d.out = append(d.out, '}')
+	return nil
+}
+
+// msgpackExt decodes an extension of n data bytes. Timestamps (type -1)
+// become RFC 3339 strings; other types become {"type":T,"data":"base64"}.
+func (d *decoder) msgpackExt(n uint64) error {
+	typ, err := d.next()
+	if err != nil {
+		return err
+	}
+	data, err := d.take(n)
+	if err != nil {
+		return err
+	}
+
+	if int8(typ) == -1 {
+		switch n {
+		case 4:
+			d.out = appendTime(d.out, time.Unix(int64(binary.BigEndian.Uint32(data)), 0))
+			return nil
+		case 8:
+			v := binary.BigEndian.Uint64(data)
+			d.out = appendTime(d.out, time.Unix(int64(v&(1<<34-1)), int64(v>>34)))
+			return nil
+		case 12:
+			nsec := binary.BigEndian.Uint32(data)
+			sec := int64(binary.BigEndian.Uint64(data[4:]))
+			d.out = appendTime(d.out, time.Unix(sec, int64(nsec)))
+			return nil
+		}
+	}
+	d.out = append(d.out, `{"type":`...)
+	d.out = strconv.AppendInt(d.out, int64(int8(typ)), 10)
+	d.out = append(d.out, `,"data":`...)
+	d.out = appendBytes(d.out, data)
+	d.out = append(d.out, '}')
+	return nil
+}
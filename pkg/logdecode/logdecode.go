@@ -0,0 +1,147 @@
+// Package logdecode converts log files written by the CBOR and MessagePack
+// encoders back to JSON lines, so the usual JSON tooling keeps working.
+//
+// Both encoders write each entry as a map preceded by its length as a 4-byte
+// big-endian integer. The format of a record is detected from its first byte
+// unless one is given.
+package logdecode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Format selects how records are decoded.
+type Format int
+
+const (
+	// Auto detects the format of each record.
+	Auto Format = iota
+	// CBOR decodes records written by the "cbor" encoding.
+	CBOR
+	// Msgpack decodes records written by the "msgpack" encoding.
+	Msgpack
+)
+
+// MaxRecordSize is the size above which a record is considered corrupt.
+const MaxRecordSize = 64 << 20
+
+// ErrUnexpectedEnd is returned when a record ends in the middle of a value.
+var ErrUnexpectedEnd = errors.New("logdecode: unexpected end of record")
+
+// Reader reads records and converts them to JSON.
+type Reader struct {
+	r      *bufio.Reader
+	format Format
+	record []byte
+	out    []byte
+}
+
+// NewReader returns a Reader reading length-prefixed records from r.
+func NewReader(r io.Reader, format Format) *Reader {
+	return &Reader{r: bufio.NewReader(r), format: format}
+}
+
+// Next returns the next record as a JSON object terminated by a newline. The
+// returned slice is only valid until the next call. It returns io.EOF when
+// there are no more records, and io.ErrUnexpectedEOF if the input ends in
+// the middle of one.
+func (r *Reader) Next() ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxRecordSize {
+		return nil, fmt.Errorf("logdecode: record of %d bytes exceeds MaxRecordSize", n)
+	}
+	if cap(r.record) < int(n) {
+		r.record = make([]byte, n)
+	}
+	r.record = r.record[:n]
+	if _, err := io.ReadFull(r.r, r.record); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	out, err := Decode(r.out[:0], r.record, r.format)
+	if err != nil {
+		return nil, err
+	}
+	r.out = append(out, '\n')
+	return r.out, nil
+}
+
+// Convert writes every record read from r to w as a line of JSON.
+func Convert(w io.Writer, r io.Reader, format Format) error {
+	rd := NewReader(r, format)
+	for {
+		line, err := rd.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+}
+
+// Decode appends the JSON form of a single record, without its length
+// prefix, to dst.
+func Decode(dst, record []byte, format Format) ([]byte, error) {
+	out, n, err := DecodeValue(dst, record, format)
+	if err != nil {
+		return dst, err
+	}
+	if n != len(record) {
+		return dst, fmt.Errorf("logdecode: %d trailing bytes in record", len(record)-n)
+	}
+	return out, nil
+}
+
+// DecodeValue appends the JSON form of the first value in data to dst and
+// returns the number of bytes it took, so values can be read one by one from
+// a stream such as a Fluent forward connection. It returns ErrUnexpectedEnd
+// if data ends in the middle of the value.
+func DecodeValue(dst, data []byte, format Format) ([]byte, int, error) {
+	if len(data) == 0 {
+		return dst, 0, ErrUnexpectedEnd
+	}
+	if format == Auto {
+		format = detect(data[0])
+	}
+
+	d := &decoder{in: data, out: dst}
+	var err error
+	switch format {
+	case CBOR:
+		err = d.cbor(0)
+	case Msgpack:
+		err = d.msgpack(0)
+	default:
+		return dst, 0, fmt.Errorf("logdecode: unknown record type 0x%02x", data[0])
+	}
+	if err != nil {
+		return dst, 0, err
+	}
+	return d.out, d.pos, nil
+}
+
+// detect tells the formats apart by the map header records start with.
+func detect(b byte) Format {
+	switch {
+	case b == 0xbf || b>>5 == 5:
+		return CBOR
+	case b == 0xde || b == 0xdf || b&0xf0 == 0x80:
+		return Msgpack
+	}
+	return Format(-1)
+}
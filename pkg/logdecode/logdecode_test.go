@@ -0,0 +1,96 @@
+package logdecode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	// {"a":1} in each format.
+	cborRecord    = []byte{0xa1, 0x61, 'a', 0x01}
+	msgpackRecord = []byte{0x81, 0xa1, 'a', 0x01}
+)
+
+// framed prefixes record with its length.
+func framed(record []byte) []byte {
+	out := make([]byte, 4, 4+len(record))
+	binary.BigEndian.PutUint32(out, uint32(len(record)))
+	return append(out, record...)
+}
+
+func TestReaderNext(t *testing.T) {
+	in := append(framed(cborRecord), framed(msgpackRecord)...)
+	r := NewReader(bytes.NewReader(in), Auto)
+	for _, format := range []string{"CBOR", "MessagePack"} {
+		line, err := r.Next()
+		require.NoError(t, err, "Unexpected error reading the %s record.", format)
+		assert.Equal(t, `{"a":1}`+"\n", string(line))
+	}
+	_, err := r.Next()
+	assert.Equal(t, io.EOF, err, "Expected io.EOF after the last record.")
+}
+
+func TestReaderNextTruncated(t *testing.T) {
+	record := framed(cborRecord)
+	tests := map[string][]byte{
+		"length": record[:2],
+		"record": record[:len(record)-1],
+	}
+	for desc, in := range tests {
+		t.Run(desc, func(t *testing.T) {
+			_, err := NewReader(bytes.NewReader(in), Auto).Next()
+			assert.Equal(t, io.ErrUnexpectedEOF, err, "Expected a truncated record to be reported.")
+		})
+	}
+}
+
+func TestReaderNextOversized(t *testing.T) {
+	var in [4]byte
+	binary.BigEndian.PutUint32(in[:], MaxRecordSize+1)
+	_, err := NewReader(bytes.NewReader(in[:]), Auto).Next()
+	require.Error(t, err, "Expected an error for a record over MaxRecordSize.")
+	assert.Contains(t, err.Error(), "exceeds MaxRecordSize")
+}
+
+func TestConvertStopsOnError(t *testing.T) {
+	in := append(framed(msgpackRecord), framed([]byte{0x01})...)
+	var out bytes.Buffer
+	err := Convert(&out, bytes.NewReader(in), Auto)
+	require.Error(t, err, "Expected an error for an unknown record type.")
+	assert.Equal(t, `{"a":1}`+"\n", out.String(), "Expected the records before the bad one.")
+}
+
+func TestDecodeTrailingBytes(t *testing.T) {
+	out, err := Decode([]byte("prefix"), append(msgpackRecord, 0xc0, 0xc0), Msgpack)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2 trailing bytes")
+	assert.Equal(t, "prefix", string(out), "Expected dst unchanged on error.")
+}
+
+func TestDecodeValue(t *testing.T) {
+	stream := append(append([]byte{}, msgpackRecord...), msgpackRecord...)
+	out, n, err := DecodeValue(nil, stream, Msgpack)
+	require.NoError(t, err)
+	assert.Equal(t, len(msgpackRecord), n, "Expected the size of the first value only.")
+	assert.Equal(t, `{"a":1}`, string(out))
+
+	for format, data := range map[Format][]byte{
+		Auto:    nil,
+		CBOR:    cborRecord[:3],
+		Msgpack: msgpackRecord[:2],
+	} {
+		_, n, err := DecodeValue(nil, data, format)
+		assert.Equal(t, ErrUnexpectedEnd, err, "Expected ErrUnexpectedEnd for % x.", data)
+		assert.Zero(t, n)
+	}
+
+	_, _, err = DecodeValue(nil, []byte{0x01}, Auto)
+	require.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "logdecode: unknown record type"))
+}
@@ -0,0 +1,212 @@
+// Package netsink writes log records to network sockets. Each Write sends
+// one record, framed for the transport, and the connection is redialed when
+// it breaks, so a collector restart doesn't stop logging.
+package netsink
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Framing delimits records on stream transports.
+type Framing int
+
+const (
+	// NoFraming writes records as they are. Use it for datagram transports,
+	// where each write is a message, or for self-delimiting records.
+	NoFraming Framing = iota
+	// OctetCounting prefixes records with their length and a space, as in
+	// RFC 6587 syslog over TCP.
+	OctetCounting
+	// NullTerminated appends a NUL byte to records, as GELF over TCP expects.
+	NullTerminated
+	// NewlineTerminated appends a newline to records.
+	NewlineTerminated
+)
+
+// Defaults used when a Config leaves the field unset.
+const (
+	DefaultDialTimeout   = 5 * time.Second
+	DefaultWriteTimeout  = 5 * time.Second
+	DefaultRetryInterval = time.Second
+	maxRetryInterval     = 30 * time.Second
+)
+
+// ErrUnavailable is returned by Write while waiting to redial after a failed
+// dial, so a down collector doesn't stall every log call.
+var ErrUnavailable = errors.New("netsink: destination unavailable")
+
+// Config describes a destination.
+type Config struct {
+	// Network is "tcp", "tcp+tls", "udp", "unix" or "unixgram".
+	Network string
+	// Address is a host:port, or a socket path for unix networks.
+	Address string
+	// TLS configures "tcp+tls" connections; nil uses the defaults with the
+	// server name taken from Address.
+	TLS *tls.Config
+	// Framing delimits records.
+	Framing Framing
+	// DialTimeout and WriteTimeout bound connecting and writing a record.
+	DialTimeout  time.Duration
+	WriteTimeout time.Duration
+	// RetryInterval is the initial wait after a failed dial. It doubles with
+	// each failure, up to 30 seconds.
+	RetryInterval time.Duration
+}
+
+// Writer sends records to a destination. It's safe for concurrent use.
+type Writer struct {
+	cfg Config
+	// ctx is canceled by Close to interrupt a dial, which holds mu.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	conn    net.Conn
+	frame   []byte
+	retry   time.Duration
+	retryAt time.Time
+	closed  bool
+}
+
+// New returns a Writer for cfg. It connects on the first Write.
+func New(cfg Config) (*Writer, error) {
+	switch cfg.Network {
+	case "tcp", "tcp4", "tcp6", "tcp+tls", "udp", "udp4", "udp6", "unix", "unixgram":
+	default:
+		return nil, errors.New("netsink: unsupported network " + strconv.Quote(cfg.Network))
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = DefaultDialTimeout
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = DefaultWriteTimeout
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DefaultRetryInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Writer{cfg: cfg, ctx: ctx, cancel: cancel, retry: cfg.RetryInterval}, nil
+}
+
+// Write sends p as one record. If the connection is broken it redials and
+// sends p again once.
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.Exchange(p, nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Exchange sends p like Write and then, if reply isn't nil, calls it with
+// the connection to read the response, such as an acknowledgement. Reading
+// is bounded by the write timeout. If sending or reading fails, the
+// connection is closed and the exchange is tried once more on a new one.
+func (w *Writer) Exchange(p []byte, reply func(r io.Reader) error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return net.ErrClosed
+	}
+
+	frame := w.framed(p)
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if err = w.dial(); err != nil {
+				return err
+			}
+		}
+		deadline := time.Now().Add(w.cfg.WriteTimeout)
+		_ = w.conn.SetDeadline(deadline)
+		if _, err = w.conn.Write(frame); err == nil {
+			if reply == nil {
+				return nil
+			}
+			if err = reply(w.conn); err == nil {
+				return nil
+			}
+		}
+		_ = w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+func (w *Writer) framed(p []byte) []byte {
+	switch w.cfg.Framing {
+	case OctetCounting:
+		w.frame = strconv.AppendInt(w.frame[:0], int64(len(p)), 10)
+		w.frame = append(w.frame, ' ')
+		return append(w.frame, p...)
+	case NullTerminated:
+		return append(append(w.frame[:0], p...), 0)
+	case NewlineTerminated:
+		return append(append(w.frame[:0], p...), '\n')
+	}
+	return p
+}
+
+func (w *Writer) dial() error {
+	now := time.Now()
+	if now.Before(w.retryAt) {
+		return ErrUnavailable
+	}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	dialer := &net.Dialer{Timeout: w.cfg.DialTimeout}
+	if w.cfg.Network == "tcp+tls" {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: w.cfg.TLS}
+		conn, err = tlsDialer.DialContext(w.ctx, "tcp", w.cfg.Address)
+	} else {
+		conn, err = dialer.DialContext(w.ctx, w.cfg.Network, w.cfg.Address)
+	}
+	if w.ctx.Err() != nil {
+		// Close interrupted the dial.
+		if conn != nil {
+			_ = conn.Close()
+		}
+		return net.ErrClosed
+	}
+	if err != nil {
+		w.retryAt = now.Add(w.retry)
+		if w.retry *= 2; w.retry > maxRetryInterval {
+			w.retry = maxRetryInterval
+		}
+		return err
+	}
+	w.conn = conn
+	w.retry = w.cfg.RetryInterval
+	return nil
+}
+
+// Sync implements zapcore.WriteSyncer. Records are sent as they're written,
+// so there is nothing to flush.
+func (w *Writer) Sync() error {
+	return nil
+}
+
+// Close closes the connection, interrupting a dial in progress. Later writes
+// fail with net.ErrClosed.
+func (w *Writer) Close() error {
+	w.cancel()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
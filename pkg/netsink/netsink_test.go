@@ -0,0 +1,296 @@
+package netsink
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+// server accepts stream connections and sends what each one reads, once it
+// is closed, to received.
+type server struct {
+	net.Listener
+	received chan string
+}
+
+func newServer(t *testing.T, network, address string) *server {
+	ln, err := net.Listen(network, address)
+	require.NoError(t, err, "Failed to listen.")
+	s := &server{Listener: ln, received: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				b, _ := io.ReadAll(conn)
+				s.received <- string(b)
+			}()
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *server) next(t *testing.T) string {
+	select {
+	case b := <-s.received:
+		return b
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a connection to end.")
+		return ""
+	}
+}
+
+func TestFraming(t *testing.T) {
+	tests := []struct {
+		framing Framing
+		want    string
+	}{
+		{NoFraming, "a bc"},
+		{OctetCounting, "3 a b1 c"},
+		{NullTerminated, "a b\x00c\x00"},
+		{NewlineTerminated, "a b\nc\n"},
+	}
+	for _, tt := range tests {
+		s := newServer(t, "tcp", "127.0.0.1:0")
+		w, err := New(Config{Network: "tcp", Address: s.Addr().String(), Framing: tt.framing})
+		require.NoError(t, err)
+		for _, rec := range []string{"a b", "c"} {
+			n, err := w.Write([]byte(rec))
+			require.NoError(t, err, "Unexpected error writing.")
+			assert.Equal(t, len(rec), n, "Expected the length of the record, not of its frame.")
+		}
+		require.NoError(t, w.Close())
+		assert.Equal(t, tt.want, s.next(t), "Unexpected stream for framing %d.", tt.framing)
+	}
+}
+
+func TestUnix(t *testing.T) {
+	// Socket paths are limited to about 100 bytes, which t.TempDir can exceed.
+	dir, err := os.MkdirTemp("", "netsink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := newServer(t, "unix", filepath.Join(dir, "s"))
+	w, err := New(Config{Network: "unix", Address: s.Addr().String(), Framing: NewlineTerminated})
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, "hello\n", s.next(t))
+}
+
+func TestDatagrams(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen.")
+	defer conn.Close()
+
+	w, err := New(Config{Network: "udp", Address: conn.LocalAddr().String()})
+	require.NoError(t, err)
+	defer w.Close()
+	for _, rec := range []string{"first", "second"} {
+		_, err := w.Write([]byte(rec))
+		require.NoError(t, err)
+	}
+
+	buf := make([]byte, 64)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for _, want := range []string{"first", "second"} {
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err, "Expected a datagram.")
+		assert.Equal(t, want, string(buf[:n]), "Expected a datagram per record.")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen.")
+	defer ln.Close()
+	lines := make(chan string, 100)
+	go func() {
+		// The first connection is closed after one record, as by a
+		// collector restart.
+		for i := 0; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn, once bool) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					lines <- line
+					if once {
+						return
+					}
+				}
+			}(conn, i == 0)
+		}
+	}()
+
+	w, err := New(Config{Network: "tcp", Address: ln.Addr().String(), Framing: NewlineTerminated})
+	require.NoError(t, err)
+	defer w.Close()
+	_, err = w.Write([]byte("one"))
+	require.NoError(t, err)
+	assert.Equal(t, "one\n", <-lines)
+
+	// Writes to the closed connection fail once the peer resets it, and the
+	// failed record is sent again on a new connection.
+	deadline := time.After(5 * time.Second)
+	for {
+		_, err := w.Write([]byte("two"))
+		require.NoError(t, err, "Expected the writer to redial.")
+		select {
+		case line := <-lines:
+			assert.Equal(t, "two\n", line)
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("Timed out waiting for a record on a new connection.")
+		}
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen.")
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	w, err := New(Config{Network: "tcp", Address: addr, RetryInterval: 50 * time.Millisecond})
+	require.NoError(t, err)
+	defer w.Close()
+	_, err = w.Write([]byte("lost"))
+	require.Error(t, err, "Expected the dial to fail.")
+	assert.False(t, errors.Is(err, ErrUnavailable))
+	_, err = w.Write([]byte("lost"))
+	assert.True(t, errors.Is(err, ErrUnavailable), "Expected no redial before RetryInterval, have %v.", err)
+
+	s := newServer(t, "tcp", addr)
+	time.Sleep(100 * time.Millisecond)
+	_, err = w.Write([]byte("back"))
+	require.NoError(t, err, "Expected a redial after RetryInterval.")
+	require.NoError(t, w.Close())
+	assert.Equal(t, "back", s.next(t))
+}
+
+func TestExchange(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen.")
+	defer ln.Close()
+	go func() {
+		// The first connection closes without replying, the second one
+		// acknowledges each record.
+		for i := 0; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn, ack bool) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || !ack {
+						return
+					}
+					if _, err := conn.Write([]byte("ok " + line)); err != nil {
+						return
+					}
+				}
+			}(conn, i > 0)
+		}
+	}()
+
+	w, err := New(Config{Network: "tcp", Address: ln.Addr().String(), Framing: NewlineTerminated})
+	require.NoError(t, err)
+	defer w.Close()
+	var replies []string
+	reply := func(r io.Reader) error {
+		line, err := bufio.NewReader(r).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		replies = append(replies, line)
+		return nil
+	}
+	require.NoError(t, w.Exchange([]byte("hello"), reply), "Expected the exchange to be retried on a new connection.")
+	assert.Equal(t, []string{"ok hello\n"}, replies)
+}
+
+func TestCloseInterruptsDial(t *testing.T) {
+	// The server accepts connections but never answers the TLS handshake,
+	// so the dial blocks until DialTimeout.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen.")
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	w, err := New(Config{Network: "tcp+tls", Address: ln.Addr().String(), DialTimeout: time.Minute})
+	require.NoError(t, err)
+	closed := make(chan error, 1)
+	go func() {
+		conn := <-accepted
+		defer conn.Close()
+		closed <- w.Close()
+	}()
+
+	start := time.Now()
+	_, err = w.Write([]byte("hello"))
+	assert.True(t, errors.Is(err, net.ErrClosed), "Expected Close to interrupt the dial, have %v.", err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.NoError(t, <-closed)
+}
+
+func TestReplyTimeout(t *testing.T) {
+	s := newServer(t, "tcp", "127.0.0.1:0")
+	w, err := New(Config{Network: "tcp", Address: s.Addr().String(), WriteTimeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	defer w.Close()
+
+	start := time.Now()
+	err = w.Exchange([]byte("hello"), func(r io.Reader) error {
+		_, err := r.Read(make([]byte, 1))
+		return err
+	})
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr) && netErr.Timeout(), "Expected a timeout, have %v.", err)
+	assert.Less(t, time.Since(start), 2*time.Second, "Expected reading the reply to be bounded by WriteTimeout.")
+}
+
+func TestClosed(t *testing.T) {
+	_, err := New(Config{Network: "sctp"})
+	assert.Error(t, err, "Expected an error for an unsupported network.")
+
+	w, err := New(Config{Network: "tcp", Address: "127.0.0.1:1"})
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	_, err = w.Write([]byte("late"))
+	assert.True(t, errors.Is(err, net.ErrClosed), "Expected writes to fail after Close.")
+	assert.NoError(t, w.Sync())
+}
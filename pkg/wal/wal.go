@@ -0,0 +1,553 @@
+// Package wal is a disk-backed write-ahead queue of log records. It sits
+// between a diode and a network writer so records survive an unreachable
+// destination or a restart without growing memory:
+//
+//	l, err := wal.Open(wal.Options{Dir: "/var/lib/app/wal"})
+//	...
+//	w := wal.NewWriter(l, sink, 0)
+//	d := diode.NewWriter(w, 1000, 10*time.Millisecond, nil)
+//
+// Records are appended to segment files which are fsynced in batches,
+// rotated when they reach SegmentSize and removed once every record in them
+// has been acknowledged. A Reader returns the records after the last
+// acknowledged offset, so unshipped records are replayed in order when the
+// log is opened again. Delivery is at least once: records acknowledged
+// shortly before a crash may be read again.
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+// Defaults used when Options leave the field unset.
+const (
+	DefaultSegmentSize  = 16 << 20
+	DefaultSyncInterval = 100 * time.Millisecond
+)
+
+const (
+	segmentExt = ".wal"
+	ackFile    = "ack"
+	// headerSize is the length and CRC-32C of the data preceding each
+	// record.
+	headerSize = 8
+)
+
+var (
+	// ErrFull is returned by Append when the record would grow the log
+	// beyond MaxSize.
+	ErrFull = errors.New("wal: log full, record dropped")
+	// ErrClosed is returned by operations on a closed log.
+	ErrClosed = errors.New("wal: log closed")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// Options configure a log.
+type Options struct {
+	// Dir holds the segment files. It's created if needed and must not be
+	// shared with another log.
+	Dir string
+	// SegmentSize is the size at which the active segment is closed and a
+	// new one started. It defaults to DefaultSegmentSize.
+	SegmentSize int64
+	// SyncInterval is how often appended records and acknowledgements are
+	// fsynced. It defaults to DefaultSyncInterval; a negative interval
+	// syncs on every append.
+	SyncInterval time.Duration
+	// MaxSize, if positive, bounds the bytes of unacknowledged records;
+	// appends beyond it fail with ErrFull.
+	MaxSize int64
+}
+
+// Log is a segmented write-ahead log. Offsets are byte positions in the
+// sequence of all records ever appended; the offset after a record is the
+// one to acknowledge once it has been shipped. It's safe for concurrent use.
+type Log struct {
+	opts Options
+
+	mu       sync.Mutex
+	segments []int64 // base offsets, oldest first
+	active   *os.File
+	size     int64 // bytes in the active segment
+	end      int64 // offset of the next record
+	acked    int64
+	dirty    bool
+	ackDirty bool
+	closed   bool
+	header   [headerSize]byte
+
+	notify  chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// Open opens the log in opts.Dir, recovering from a crash by truncating the
+// newest segment after its last complete record.
+func Open(opts Options) (*Log, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("wal: %w", err)
+	}
+
+	l := &Log{
+		opts:    opts,
+		notify:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	if opts.SyncInterval > 0 {
+		go l.run()
+	} else {
+		close(l.stopped)
+	}
+	return l, nil
+}
+
+// load reads the segments and the acknowledged offset, and opens the active
+// segment.
+func (l *Log) load() error {
+	entries, err := os.ReadDir(l.opts.Dir)
+	if err != nil {
+		return fmt.Errorf("wal: %w", err)
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, base)
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+
+	var tail int64 = -1
+	if len(l.segments) > 0 {
+		last := l.segments[len(l.segments)-1]
+		size, err := recoverSegment(l.segmentPath(last))
+		if err != nil {
+			return err
+		}
+		l.end = last + size
+		if size < l.opts.SegmentSize {
+			tail = last
+		}
+	}
+	if l.acked, err = readAck(filepath.Join(l.opts.Dir, ackFile)); err != nil {
+		return err
+	}
+	if len(l.segments) > 0 && l.acked < l.segments[0] {
+		l.acked = l.segments[0]
+	}
+	if l.acked > l.end {
+		// Segments were removed by hand; start after what's left.
+		l.end, tail = l.acked, -1
+	}
+	if err := l.removeAcked(); err != nil {
+		return err
+	}
+
+	if tail >= 0 {
+		// Keep appending to the newest segment.
+		f, err := os.OpenFile(l.segmentPath(tail), os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("wal: %w", err)
+		}
+		l.active, l.size = f, l.end-tail
+		return nil
+	}
+	return l.rotate()
+}
+
+// recoverSegment scans a segment and truncates it after the last complete
+// record with a valid checksum, returning its size.
+func recoverSegment(path string) (int64, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0o600)
+	if err != nil {
+		return 0, fmt.Errorf("wal: %w", err)
+	}
+	defer f.Close()
+
+	var off int64
+	for {
+		n, err := readRecord(f, off, nil)
+		if err != nil {
+			break
+		}
+		off += n
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("wal: %w", err)
+	}
+	if info.Size() != off {
+		if err := f.Truncate(off); err != nil {
+			return 0, fmt.Errorf("wal: %w", err)
+		}
+		if err := f.Sync(); err != nil {
+			return 0, fmt.Errorf("wal: %w", err)
+		}
+	}
+	return off, nil
+}
+
+// errCorrupt reports a truncated record or one with a bad checksum.
+var errCorrupt = errors.New("wal: corrupt record")
+
+// readRecord reads the record at off in f, storing its data in dst if dst
+// isn't nil, and returns the size of the record with its header.
+func readRecord(f *os.File, off int64, dst *[]byte) (int64, error) {
+	var header [headerSize]byte
+	if _, err := f.ReadAt(header[:], off); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	n := int64(binary.BigEndian.Uint32(header[:4]))
+	// Check the record is complete before trusting a possibly torn length.
+	if n > 0 {
+		var last [1]byte
+		if _, err := f.ReadAt(last[:], off+headerSize+n-1); err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, errCorrupt
+			}
+			return 0, err
+		}
+	}
+	data := make([]byte, n)
+	if _, err := f.ReadAt(data, off+headerSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, errCorrupt
+		}
+		return 0, err
+	}
+	if crc32.Checksum(data, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return 0, errCorrupt
+	}
+	if dst != nil {
+		*dst = data
+	}
+	return headerSize + n, nil
+}
+
+func readAck(path string) (int64, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("wal: %w", err)
+	}
+	if len(b) != 12 || crc32.Checksum(b[:8], crcTable) != binary.BigEndian.Uint32(b[8:]) {
+		// A torn ack file replays more records, which is safe.
+		return 0, nil
+	}
+	return int64(binary.BigEndian.Uint64(b)), nil
+}
+
+func (l *Log) segmentPath(base int64) string {
+	return filepath.Join(l.opts.Dir, fmt.Sprintf("%020d%s", base, segmentExt))
+}
+
+// Append writes p as one record and returns the offset after it.
+func (l *Log) Append(p []byte) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+	n := int64(headerSize + len(p))
+	if l.opts.MaxSize > 0 && l.end-l.acked+n > l.opts.MaxSize {
+		return 0, ErrFull
+	}
+
+	binary.BigEndian.PutUint32(l.header[:4], uint32(len(p)))
+	binary.BigEndian.PutUint32(l.header[4:], crc32.Checksum(p, crcTable))
+	record := make([]byte, 0, n)
+	record = append(append(record, l.header[:]...), p...)
+	if _, err := l.active.Write(record); err != nil {
+		// Drop a partial record so the next one starts at a boundary.
+		_ = l.active.Truncate(l.size)
+		return 0, fmt.Errorf("wal: %w", err)
+	}
+	l.size += n
+	l.end += n
+	l.dirty = true
+
+	var err error
+	if l.opts.SyncInterval < 0 {
+		err = l.sync()
+	}
+	if l.size >= l.opts.SegmentSize {
+		err = multierr.Append(err, l.rotate())
+	}
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+	return l.end, err
+}
+
+// rotate syncs and closes the active segment and starts a new one at the
+// end of the log. l.mu must be held.
+func (l *Log) rotate() error {
+	if l.active != nil {
+		if err := l.sync(); err != nil {
+			return err
+		}
+		if err := l.active.Close(); err != nil {
+			return fmt.Errorf("wal: %w", err)
+		}
+		l.active = nil
+	}
+	f, err := os.OpenFile(l.segmentPath(l.end), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("wal: %w", err)
+	}
+	l.active, l.size = f, 0
+	l.segments = append(l.segments, l.end)
+	return syncDir(l.opts.Dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("wal: %w", err)
+	}
+	defer d.Close()
+	// Some file systems don't support syncing directories.
+	_ = d.Sync()
+	return nil
+}
+
+// Ack records that the records before off have been shipped. The segments
+// holding only such records are removed on the next sync.
+func (l *Log) Ack(off int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if off > l.acked && off <= l.end {
+		l.acked = off
+		l.ackDirty = true
+	}
+}
+
+// Acked returns the acknowledged offset.
+func (l *Log) Acked() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.acked
+}
+
+// End returns the offset of the next record.
+func (l *Log) End() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.end
+}
+
+// Sync fsyncs appended records and persists the acknowledged offset.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	return l.sync()
+}
+
+// sync is Sync with l.mu held.
+func (l *Log) sync() error {
+	if l.dirty {
+		if err := l.active.Sync(); err != nil {
+			return fmt.Errorf("wal: %w", err)
+		}
+		l.dirty = false
+	}
+	if !l.ackDirty {
+		return nil
+	}
+	var b [12]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(l.acked))
+	binary.BigEndian.PutUint32(b[8:], crc32.Checksum(b[:8], crcTable))
+	path := filepath.Join(l.opts.Dir, ackFile)
+	f, err := os.OpenFile(path+".tmp", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("wal: %w", err)
+	}
+	_, err = f.Write(b[:])
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		return fmt.Errorf("wal: %w", err)
+	}
+	l.ackDirty = false
+	return l.removeAcked()
+}
+
+// removeAcked removes the segments before the one holding the acknowledged
+// offset. l.mu must be held.
+func (l *Log) removeAcked() error {
+	for len(l.segments) > 1 && l.segments[1] <= l.acked {
+		if err := os.Remove(l.segmentPath(l.segments[0])); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("wal: %w", err)
+		}
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+func (l *Log) run() {
+	defer close(l.stopped)
+	ticker := time.NewTicker(l.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = l.Sync()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Close syncs the log and closes the active segment.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	l.mu.Unlock()
+
+	close(l.stop)
+	<-l.stopped
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.sync()
+	if cerr := l.active.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("wal: %w", cerr)
+	}
+	return err
+}
+
+// Reader reads records in order, starting at the acknowledged offset. A log
+// is meant to have a single reader.
+type Reader struct {
+	l    *Log
+	off  int64
+	base int64
+	f    *os.File
+}
+
+// NewReader returns a reader positioned at the acknowledged offset.
+func (l *Log) NewReader() *Reader {
+	return &Reader{l: l, off: l.Acked(), base: -1}
+}
+
+// Next returns the next record and the offset after it, to acknowledge once
+// the record is shipped. It returns io.EOF when there is no record to read
+// yet; Wait returns a channel to wait for one. A corrupt record, left by a
+// crash in a segment before the newest, skips the rest of its segment.
+func (r *Reader) Next() ([]byte, int64, error) {
+	for {
+		r.l.mu.Lock()
+		if r.l.closed {
+			r.l.mu.Unlock()
+			return nil, r.off, ErrClosed
+		}
+		end := r.l.end
+		if r.off < r.l.acked {
+			r.off = r.l.acked
+		}
+		base, next := r.segment()
+		r.l.mu.Unlock()
+
+		if r.off >= end {
+			return nil, r.off, io.EOF
+		}
+		if base != r.base {
+			if r.f != nil {
+				r.f.Close()
+			}
+			f, err := os.Open(r.l.segmentPath(base))
+			if err != nil {
+				return nil, r.off, fmt.Errorf("wal: %w", err)
+			}
+			r.f, r.base = f, base
+		}
+
+		var data []byte
+		n, err := readRecord(r.f, r.off-base, &data)
+		if err == nil {
+			r.off += n
+			return data, r.off, nil
+		}
+		if (err == errCorrupt || err == io.EOF) && next > r.off {
+			r.off = next
+			continue
+		}
+		return nil, r.off, fmt.Errorf("wal: %w", err)
+	}
+}
+
+// segment returns the base of the segment holding r.off and of the one
+// after it, or -1 if it's the last. r.l.mu must be held.
+func (r *Reader) segment() (int64, int64) {
+	segs := r.l.segments
+	i := sort.Search(len(segs), func(i int) bool { return segs[i] > r.off }) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i+1 < len(segs) {
+		return segs[i], segs[i+1]
+	}
+	return segs[i], -1
+}
+
+// Wait returns a channel that receives after records are appended.
+func (r *Reader) Wait() <-chan struct{} {
+	return r.l.notify
+}
+
+// Close releases the segment file held by the reader.
+func (r *Reader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f, r.base = nil, -1
+	return err
+}
@@ -0,0 +1,232 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+func openLog(t *testing.T, opts Options) *Log {
+	l, err := Open(opts)
+	require.NoError(t, err, "Unexpected error opening the log.")
+	return l
+}
+
+// readAll reads the records available to a new reader.
+func readAll(t *testing.T, l *Log) ([]string, int64) {
+	r := l.NewReader()
+	defer r.Close()
+	var (
+		records []string
+		off     = l.Acked()
+	)
+	for {
+		data, next, err := r.Next()
+		if err == io.EOF {
+			return records, off
+		}
+		require.NoError(t, err, "Unexpected error reading the log.")
+		records = append(records, string(data))
+		off = next
+	}
+}
+
+func segmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.NoError(t, err)
+	return files
+}
+
+func TestAppendReplay(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, Options{Dir: dir, SegmentSize: 64})
+	var offsets []int64
+	for i := 0; i < 10; i++ {
+		off, err := l.Append([]byte(fmt.Sprintf("record %d", i)))
+		require.NoError(t, err, "Unexpected error appending.")
+		offsets = append(offsets, off)
+	}
+	assert.Greater(t, len(segmentFiles(t, dir)), 1, "Expected segments to be rotated.")
+
+	records, end := readAll(t, l)
+	require.Len(t, records, 10)
+	assert.Equal(t, "record 0", records[0])
+	assert.Equal(t, "record 9", records[9])
+	assert.Equal(t, l.End(), end)
+
+	l.Ack(offsets[5])
+	require.NoError(t, l.Close(), "Unexpected error closing the log.")
+	assert.Less(t, len(segmentFiles(t, dir)), 4, "Expected acknowledged segments to be removed.")
+
+	l = openLog(t, Options{Dir: dir, SegmentSize: 64})
+	records, _ = readAll(t, l)
+	assert.Equal(t, []string{"record 6", "record 7", "record 8", "record 9"}, records,
+		"Expected the unacknowledged records to be replayed in order.")
+	_, err := l.Append([]byte("record 10"))
+	require.NoError(t, err)
+	records, _ = readAll(t, l)
+	assert.Equal(t, "record 10", records[len(records)-1])
+	require.NoError(t, l.Close())
+
+	_, err = l.Append([]byte("late"))
+	assert.Equal(t, ErrClosed, err, "Expected appends to fail after Close.")
+}
+
+func TestCrashRecovery(t *testing.T) {
+	tests := []struct {
+		desc    string
+		corrupt func(t *testing.T, path string, size int64)
+	}{
+		{"truncated data", func(t *testing.T, path string, size int64) {
+			require.NoError(t, os.Truncate(path, size-3))
+		}},
+		{"truncated header", func(t *testing.T, path string, size int64) {
+			require.NoError(t, os.Truncate(path, size-int64(len("three"))-headerSize+3))
+		}},
+		{"bad checksum", func(t *testing.T, path string, size int64) {
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			require.NoError(t, err)
+			_, err = f.WriteAt([]byte{'X'}, size-1)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+		}},
+		{"torn length", func(t *testing.T, path string, size int64) {
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			require.NoError(t, err)
+			_, err = f.WriteAt([]byte{0xff, 0xff}, size-int64(len("three"))-headerSize)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			l := openLog(t, Options{Dir: dir})
+			for _, rec := range []string{"one", "two", "three"} {
+				_, err := l.Append([]byte(rec))
+				require.NoError(t, err)
+			}
+			size := l.End()
+			require.NoError(t, l.Close())
+
+			files := segmentFiles(t, dir)
+			require.Len(t, files, 1)
+			tt.corrupt(t, files[0], size)
+
+			l = openLog(t, Options{Dir: dir})
+			defer l.Close()
+			records, _ := readAll(t, l)
+			assert.Equal(t, []string{"one", "two"}, records, "Expected the log to end at the last complete record.")
+
+			_, err := l.Append([]byte("four"))
+			require.NoError(t, err, "Unexpected error appending after recovery.")
+			records, _ = readAll(t, l)
+			assert.Equal(t, []string{"one", "two", "four"}, records, "Expected appends to follow the recovered records.")
+		})
+	}
+}
+
+func TestCorruptOlderSegment(t *testing.T) {
+	dir := t.TempDir()
+	l := openLog(t, Options{Dir: dir, SegmentSize: 20})
+	for _, rec := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+		_, err := l.Append([]byte(rec))
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.Close())
+
+	files := segmentFiles(t, dir)
+	require.Greater(t, len(files), 1)
+	require.NoError(t, os.Truncate(files[0], headerSize+2))
+
+	l = openLog(t, Options{Dir: dir, SegmentSize: 20})
+	defer l.Close()
+	records, _ := readAll(t, l)
+	assert.Equal(t, []string{"cccc", "dddd"}, records, "Expected the damaged segment to be skipped.")
+}
+
+func TestMaxSize(t *testing.T) {
+	l := openLog(t, Options{Dir: t.TempDir(), MaxSize: 3 * (headerSize + 4)})
+	defer l.Close()
+	var last int64
+	for i := 0; i < 3; i++ {
+		off, err := l.Append([]byte("abcd"))
+		require.NoError(t, err)
+		last = off
+	}
+	_, err := l.Append([]byte("abcd"))
+	assert.Equal(t, ErrFull, err, "Expected appends beyond MaxSize to fail.")
+
+	l.Ack(last)
+	_, err = l.Append([]byte("abcd"))
+	assert.NoError(t, err, "Expected acknowledged records to free space.")
+}
+
+// flaky accepts writes only while up is set.
+type flaky struct {
+	mu      sync.Mutex
+	up      bool
+	records []string
+	got     chan struct{}
+}
+
+func (f *flaky) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.up {
+		return 0, errors.New("destination down")
+	}
+	f.records = append(f.records, string(p))
+	f.got <- struct{}{}
+	return len(p), nil
+}
+
+func (f *flaky) setUp(up bool) {
+	f.mu.Lock()
+	f.up = up
+	f.mu.Unlock()
+}
+
+func TestWriter(t *testing.T) {
+	dir := t.TempDir()
+	dst := &flaky{got: make(chan struct{}, 100)}
+	w := NewWriter(openLog(t, Options{Dir: dir}), dst, time.Millisecond)
+	for _, rec := range []string{"a", "b"} {
+		_, err := w.Write([]byte(rec))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close(), "Unexpected error closing the writer.")
+	assert.Empty(t, dst.records, "Expected nothing shipped while the destination is down.")
+
+	dst.setUp(true)
+	w = NewWriter(openLog(t, Options{Dir: dir}), dst, time.Millisecond)
+	_, err := w.Write([]byte("c"))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-dst.got:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for records to be shipped.")
+		}
+	}
+	require.NoError(t, w.Close())
+	assert.Equal(t, []string{"a", "b", "c"}, dst.records, "Expected records left by the previous run first.")
+
+	l := openLog(t, Options{Dir: dir})
+	defer l.Close()
+	records, _ := readAll(t, l)
+	assert.Empty(t, records, "Expected shipped records to be acknowledged.")
+}
@@ -0,0 +1,120 @@
+package wal
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// DefaultRetryInterval is the initial wait after a failed write to the
+// destination. It doubles with each failure, up to maxRetryInterval.
+const (
+	DefaultRetryInterval = time.Second
+	maxRetryInterval     = 30 * time.Second
+)
+
+// Writer appends the records written to it to a log and ships them to a
+// destination from a goroutine, acknowledging each one the destination
+// accepts. Failed records are retried, with backoff, until they succeed, so
+// records are shipped in order and none are skipped.
+type Writer struct {
+	l       *Log
+	dst     io.Writer
+	r       *Reader
+	retry   time.Duration
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewWriter returns a Writer appending to l and shipping to dst, starting
+// with the records left unshipped by a previous run. retry is the initial
+// wait after a failure; zero uses DefaultRetryInterval.
+func NewWriter(l *Log, dst io.Writer, retry time.Duration) *Writer {
+	if retry <= 0 {
+		retry = DefaultRetryInterval
+	}
+	w := &Writer{
+		l:       l,
+		dst:     dst,
+		r:       l.NewReader(),
+		retry:   retry,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write appends p to the log.
+func (w *Writer) Write(p []byte) (int, error) {
+	if _, err := w.l.Append(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Sync fsyncs the log.
+func (w *Writer) Sync() error {
+	return w.l.Sync()
+}
+
+func (w *Writer) run() {
+	defer close(w.stopped)
+	wait := w.retry
+	for {
+		if err := w.ship(); err == nil || errors.Is(err, ErrClosed) {
+			wait = w.retry
+			select {
+			case <-w.r.Wait():
+			case <-w.stop:
+				return
+			}
+			continue
+		}
+		select {
+		case <-time.After(wait):
+		case <-w.stop:
+			return
+		}
+		if wait *= 2; wait > maxRetryInterval {
+			wait = maxRetryInterval
+		}
+	}
+}
+
+// ship writes the records read from the log to the destination until the
+// reader catches up or a write fails.
+func (w *Writer) ship() error {
+	for {
+		data, next, err := w.r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.dst.Write(data); err != nil {
+			// Read the record again on the next attempt.
+			w.r.off = next - int64(headerSize+len(data))
+			return err
+		}
+		w.l.Ack(next)
+	}
+}
+
+// Close ships what the destination still accepts, then closes the log and
+// the destination, if it's an io.Closer. Records that weren't shipped stay
+// in the log for the next run.
+func (w *Writer) Close() error {
+	close(w.stop)
+	<-w.stopped
+	_ = w.ship()
+	_ = w.r.Close()
+	err := w.l.Close()
+	if c, ok := w.dst.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
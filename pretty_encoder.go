@@ -0,0 +1,299 @@
+package zap_logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	zapbuffer "go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const colorReset = "\x1b[0m"
+
+// color indexes a colorPalette.
+type color int
+
+const (
+	colorKey color = iota
+	colorNumber
+	colorBool
+	colorTime
+	colorError
+	colorName
+	colorFaint
+	colorProject
+	colorCount
+)
+
+type colorPalette [colorCount]string
+
+var _defaultPalette = colorPalette{
+	colorKey:     "\x1b[2m",
+	colorNumber:  "\x1b[36m",
+	colorBool:    "\x1b[33m",
+	colorTime:    "\x1b[35m",
+	colorError:   "\x1b[31m",
+	colorName:    "\x1b[34m",
+	colorFaint:   "\x1b[2m",
+	colorProject: "\x1b[1m",
+}
+
+var _levelColors = map[zapcore.Level]string{
+	zapcore.DebugLevel:  "\x1b[35m",
+	zapcore.InfoLevel:   "\x1b[34m",
+	zapcore.WarnLevel:   "\x1b[33m",
+	zapcore.ErrorLevel:  "\x1b[31m",
+	zapcore.DPanicLevel: "\x1b[1;31m",
+	zapcore.PanicLevel:  "\x1b[1;31m",
+	zapcore.FatalLevel:  "\x1b[1;31m",
+}
+
+// Column widths of the "pretty" encoder; longer values push the following
+// columns right instead of being cut.
+const (
+	prettyLevelWidth   = 5
+	prettyCallerWidth  = 24
+	prettyMessageWidth = 40
+)
+
+// NewPrettyEncoder creates a human-friendly encoder for development. Each
+// entry is one line of aligned time, level, logger name, caller and message
+// columns followed by the fields as key=value pairs, flattened like the
+// logfmt encoder. The "context" object is shortened to its values, and stack
+// traces follow on indented lines with the frames of the program itself
+// highlighted.
+//
+// Levels and values are colored when standard output is a terminal, unless
+// the NO_COLOR environment variable is set. The log file written for the
+// "pretty" Encoding is never colored.
+func NewPrettyEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	return newPrettyEncoder(cfg, colorEnabled(os.Stdout))
+}
+
+func newPrettyEncoder(cfg zapcore.EncoderConfig, colored bool) *prettyEncoder {
+	enc := &prettyEncoder{&logfmtEncoder{
+		EncoderConfig: &cfg,
+		buf:           _logfmtPool.Get(),
+	}}
+	if colored {
+		enc.palette = &_defaultPalette
+	}
+	return enc
+}
+
+// colorEnabled reports whether output to f should be colored: f must be a
+// terminal, and NO_COLOR (https://no-color.org) must be unset or empty.
+func colorEnabled(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+type prettyEncoder struct {
+	*logfmtEncoder
+}
+
+// Clone implements zapcore.Encoder.
+func (enc *prettyEncoder) Clone() zapcore.Encoder {
+	return &prettyEncoder{enc.clone()}
+}
+
+// EncodeEntry implements zapcore.Encoder.
+func (enc *prettyEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*zapbuffer.Buffer, error) {
+	final := &prettyEncoder{&logfmtEncoder{
+		EncoderConfig: enc.EncoderConfig,
+		buf:           _logfmtPool.Get(),
+		palette:       enc.palette,
+	}}
+	buf := final.buf
+
+	if final.TimeKey != "" {
+		final.paint(colorFaint)
+		if final.EncodeTime != nil {
+			final.EncodeTime(ent.Time, logfmtValue{final.logfmtEncoder})
+		} else {
+			buf.AppendString(ent.Time.Format(time.RFC3339))
+		}
+		final.unpaint(colorFaint)
+		buf.AppendByte(' ')
+	}
+	if final.LevelKey != "" {
+		if final.palette != nil {
+			buf.AppendString(_levelColors[ent.Level])
+		}
+		level := ent.Level.CapitalString()
+		buf.AppendString(level)
+		if final.palette != nil {
+			buf.AppendString(colorReset)
+		}
+		final.pad(prettyLevelWidth - len(level))
+		buf.AppendByte(' ')
+	}
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.paint(colorName)
+		buf.AppendString(ent.LoggerName)
+		final.unpaint(colorName)
+		buf.AppendByte(' ')
+	}
+	if ent.Caller.Defined && final.CallerKey != "" {
+		final.paint(colorFaint)
+		start := buf.Len()
+		if final.EncodeCaller != nil {
+			final.EncodeCaller(ent.Caller, logfmtValue{final.logfmtEncoder})
+		} else {
+			buf.AppendString(ent.Caller.TrimmedPath())
+		}
+		width := buf.Len() - start
+		final.unpaint(colorFaint)
+		final.pad(prettyCallerWidth - width)
+		buf.AppendByte(' ')
+	}
+	if final.MessageKey != "" {
+		buf.AppendString(ent.Message)
+		if enc.buf.Len() > 0 || len(fields) > 0 {
+			final.pad(prettyMessageWidth - utf8.RuneCountInString(ent.Message))
+		}
+	}
+
+	if enc.buf.Len() > 0 {
+		final.separate()
+		buf.Write(enc.buf.Bytes())
+	}
+	final.prefix = enc.prefix
+	for i := range fields {
+		fields[i].AddTo(final)
+	}
+	final.prefix = ""
+
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		final.appendStack(ent.Stack)
+	}
+	if final.LineEnding != "" {
+		buf.AppendString(final.LineEnding)
+	} else {
+		buf.AppendString(zapcore.DefaultLineEnding)
+	}
+
+	return buf, nil
+}
+
+func (enc *prettyEncoder) pad(n int) {
+	for ; n > 0; n-- {
+		enc.buf.AppendByte(' ')
+	}
+}
+
+// appendStack writes a stack trace formatted by stackFormatter on indented
+// lines, highlighting the frames of the program and dimming the others.
+func (enc *prettyEncoder) appendStack(stack string) {
+	lines := strings.Split(strings.TrimRight(stack, "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		function, file := lines[i], ""
+		if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\t") {
+			file = strings.TrimPrefix(lines[i+1], "\t")
+			i++
+		}
+
+		c := colorFaint
+		if isProjectFrame(function, file) {
+			c = colorProject
+		}
+		enc.buf.AppendString("\n    ")
+		enc.paint(c)
+		enc.buf.AppendString(function)
+		enc.unpaint(c)
+		if file != "" {
+			enc.buf.AppendString("\n        ")
+			enc.paint(c)
+			enc.buf.AppendString(file)
+			enc.unpaint(c)
+		}
+	}
+}
+
+// isProjectFrame reports whether a frame belongs to the program rather than
+// to the standard library or a dependency. Standard library packages have no
+// dot in their first path element and dependencies live in the module cache.
+func isProjectFrame(function, file string) bool {
+	if strings.HasPrefix(function, "main.") {
+		return true
+	}
+	i := strings.IndexByte(function, '/')
+	if i < 0 || !strings.Contains(function[:i], ".") {
+		return false
+	}
+	return !strings.Contains(file, "/pkg/mod/")
+}
+
+// AddString implements zapcore.ObjectEncoder, coloring errors.
+func (enc *prettyEncoder) AddString(key, val string) {
+	enc.addKey(key)
+	if key == "error" {
+		enc.paint(colorError)
+		defer enc.unpaint(colorError)
+	}
+	appendLogfmtValue(enc.buf, val)
+}
+
+// AddObject implements zapcore.ObjectEncoder. The "context" object added by
+// the *Ctx methods is written compactly as context={key=value ...}, without
+// callers and with context keys shortened to their last element.
+func (enc *prettyEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	if key != "context" {
+		return enc.logfmtEncoder.AddObject(key, obj)
+	}
+
+	_, values, err := splitContext(obj, func(string) (string, bool) { return "", false })
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(values))
+	for ctxKey := range values {
+		keys = append(keys, ctxKey)
+	}
+	sort.Strings(keys)
+
+	enc.addKey(key)
+	enc.buf.AppendByte('{')
+	for i, ctxKey := range keys {
+		if i > 0 {
+			enc.buf.AppendByte(' ')
+		}
+		short := ctxKey
+		if i := strings.LastIndexByte(short, '.'); i >= 0 {
+			short = short[i+1:]
+		}
+		v := values[ctxKey]
+		if m, ok := v.(map[string]interface{}); ok {
+			v = m["value"]
+		}
+		enc.paint(colorKey)
+		appendLogfmtKey(enc.buf, short)
+		enc.buf.AppendByte('=')
+		enc.unpaint(colorKey)
+		appendLogfmtValue(enc.buf, prettyContextValue(v))
+	}
+	enc.buf.AppendByte('}')
+	return nil
+}
+
+func prettyContextValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
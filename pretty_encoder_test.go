@@ -0,0 +1,116 @@
+package zap_logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withPrettyLogger(colored bool, opts []Option, f func(*Logger, *bytes.Buffer)) {
+	buf := &bytes.Buffer{}
+	encCfg := NewDevelopmentEncoderConfig()
+	encCfg.TimeKey = ""
+	core := zapcore.NewCore(newPrettyEncoder(encCfg, colored), zapcore.AddSync(buf), zap.DebugLevel)
+	f(New(core, Config{}, opts...), buf)
+}
+
+func TestPrettyEncoderColumns(t *testing.T) {
+	withPrettyLogger(false, nil, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Named("api").Info("started", zap.Int("port", 80), zap.String("mode", "dev mode"))
+		logger.Warn("no fields")
+		assert.Equal(t,
+			"INFO  api started"+strings.Repeat(" ", prettyMessageWidth-len("started"))+` port=80 mode="dev mode"`+"\n"+
+				"WARN  no fields\n",
+			buf.String())
+	})
+}
+
+func TestPrettyEncoderColors(t *testing.T) {
+	withPrettyLogger(true, nil, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Error("failed",
+			zap.Int("n", 1),
+			zap.Bool("ok", false),
+			zap.Duration("took", time.Second),
+			zap.Error(errors.New("boom")),
+		)
+		out := buf.String()
+		assert.True(t, strings.HasPrefix(out, _levelColors[zap.ErrorLevel]+"ERROR"+colorReset), "Expected a colored level.")
+		assert.Contains(t, out, _defaultPalette[colorNumber]+"1"+colorReset)
+		assert.Contains(t, out, _defaultPalette[colorBool]+"false"+colorReset)
+		assert.Contains(t, out, _defaultPalette[colorTime]+"1s"+colorReset)
+		assert.Contains(t, out, _defaultPalette[colorError]+"boom"+colorReset)
+	})
+}
+
+func TestPrettyEncoderContext(t *testing.T) {
+	type ctxKey string
+	const (
+		traceID ctxKey = "traceid"
+		tenant  ctxKey = "tenant"
+	)
+
+	ctx := context.WithValue(context.TODO(), traceID, "abc")
+	ctx = context.WithValue(ctx, tenant, "acme corp")
+	fieldOpts := opts(AddContext(func(ctx context.Context, log *ZapLogger) {
+		log.Ctx.Set(traceID, ctx)
+		log.Ctx.Set(tenant, ctx)
+	}))
+
+	withPrettyLogger(false, fieldOpts, func(logger *Logger, buf *bytes.Buffer) {
+		logger.InfoCtx(ctx, "ctx")
+		assert.True(t, strings.HasSuffix(buf.String(), ` context={tenant="acme corp" traceid=abc}`+"\n"),
+			"Unexpected context rendering: %q.", buf.String())
+	})
+}
+
+func TestPrettyEncoderStacktrace(t *testing.T) {
+	withPrettyLogger(false, []Option{AddStacktrace(zap.ErrorLevel)}, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Error("failed")
+		lines := strings.Split(buf.String(), "\n")
+		require.True(t, len(lines) > 2, "Expected the stack trace on separate lines.")
+		assert.Equal(t, "    github.com/hinha/zap-logger.TestPrettyEncoderStacktrace.func1", lines[1])
+		assert.True(t, strings.HasPrefix(lines[2], "        /"), "Expected an indented file line.")
+	})
+}
+
+func TestIsProjectFrame(t *testing.T) {
+	tests := []struct {
+		function, file string
+		want           bool
+	}{
+		{"main.main", "/src/app/main.go:10", true},
+		{"github.com/acme/app/api.Serve", "/src/app/api/serve.go:3", true},
+		{"github.com/other/lib.Do", "/root/go/pkg/mod/github.com/other/lib@v1.0.0/do.go:5", false},
+		{"net/http.(*conn).serve", "/usr/local/go/src/net/http/server.go:1", false},
+		{"runtime.goexit", "/usr/local/go/src/runtime/asm_amd64.s:1", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, isProjectFrame(tt.function, tt.file), "Unexpected result for %s.", tt.function)
+	}
+}
+
+func TestColorEnabled(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	require.NoError(t, err)
+	defer f.Close()
+	assert.False(t, colorEnabled(f), "Expected no colors for a regular file.")
+
+	t.Setenv("NO_COLOR", "1")
+	assert.False(t, colorEnabled(os.Stdout), "Expected NO_COLOR to disable colors.")
+}
+
+func TestPrettyFileEncoderUncolored(t *testing.T) {
+	enc, ok := Config{EncoderConfig: NewDevelopmentEncoderConfig()}.fileEncoder("pretty").(*prettyEncoder)
+	require.True(t, ok, "Expected the pretty encoder.")
+	assert.Nil(t, enc.palette, "Expected no colors in the log file.")
+}
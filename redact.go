@@ -0,0 +1,763 @@
+package zap_logger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultRedactMask is the replacement written for values masked by a
+// Redactor without a custom mask.
+const DefaultRedactMask = "[REDACTED]"
+
+// Common patterns for secrets and personal data found in free-form strings.
+// RedactPattern only redacts the matches of CardNumberPattern that pass the
+// Luhn check, so timestamps, IDs and other long numbers are left alone.
+var (
+	EmailPattern      = regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`)
+	CardNumberPattern = regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`)
+	JWTPattern        = regexp.MustCompile(`\beyJ[a-zA-Z0-9_\-]+\.[a-zA-Z0-9_\-]+\.[a-zA-Z0-9_\-]*`)
+)
+
+// A RedactAction selects what happens to a value matched by a RedactRule.
+type RedactAction uint8
+
+const (
+	// RedactMask replaces the value with the redactor's mask.
+	RedactMask RedactAction = iota
+	// RedactHash replaces the value with a hex HMAC-SHA256 of it, keyed with
+	// the redactor's hash key, so equal secrets can still be correlated.
+	RedactHash
+	// RedactDrop removes the field altogether.
+	RedactDrop
+)
+
+// A RedactRule matches values to redact, either by field key or by a regular
+// expression over string values.
+type RedactRule struct {
+	keys    []string
+	pattern *regexp.Regexp
+	// valid, if set, tells the matches of pattern to redact from the others.
+	valid  func(string) bool
+	action RedactAction
+}
+
+// RedactKeys matches fields whose key equals one of keys, ignoring case. Keys
+// of the context extractor ("pkg.ctxKey.Name") also match on their last
+// segment.
+func RedactKeys(action RedactAction, keys ...string) RedactRule {
+	lower := make([]string, len(keys))
+	for i, k := range keys {
+		lower[i] = strings.ToLower(k)
+	}
+	return RedactRule{keys: lower, action: action}
+}
+
+// RedactPattern matches string values containing pattern. Masked and hashed
+// matches are replaced in place, keeping the rest of the string; dropping
+// removes the whole field.
+func RedactPattern(action RedactAction, pattern *regexp.Regexp) RedactRule {
+	rule := RedactRule{pattern: pattern, action: action}
+	if pattern == CardNumberPattern {
+		rule.valid = luhnValid
+	}
+	return rule
+}
+
+// luhnValid reports whether the digits of s pass the Luhn check used by
+// payment card numbers. Other characters, such as separators, are skipped.
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+// replaceAll replaces the matches of the rule in s with the result of f.
+func (rule RedactRule) replaceAll(s string, f func(string) string) string {
+	return rule.pattern.ReplaceAllStringFunc(s, func(m string) string {
+		if rule.valid != nil && !rule.valid(m) {
+			return m
+		}
+		return f(m)
+	})
+}
+
+// matches reports whether s contains a match of the rule.
+func (rule RedactRule) matches(s string) bool {
+	if rule.valid == nil {
+		return rule.pattern.MatchString(s)
+	}
+	for _, m := range rule.pattern.FindAllString(s, -1) {
+		if rule.valid(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// A Redactor removes secrets and personal data from fields before they reach
+// the encoder. Besides its rules, it honors `log:"-"` and `log:"redact"` struct
+// tags, or a redact option as in `log:"email,redact"`, on values logged by
+// reflection, including values of the context extractor.
+type Redactor struct {
+	keys      map[string]RedactAction
+	patterns  []RedactRule
+	mask      string
+	hashKey   []byte
+	tagAction RedactAction
+}
+
+// A RedactorOption configures a Redactor.
+type RedactorOption func(*Redactor)
+
+// RedactWithMask sets the replacement used by RedactMask.
+func RedactWithMask(mask string) RedactorOption {
+	return func(r *Redactor) {
+		r.mask = mask
+	}
+}
+
+// RedactWithHashKey sets the HMAC key used by RedactHash.
+func RedactWithHashKey(key []byte) RedactorOption {
+	return func(r *Redactor) {
+		r.hashKey = key
+	}
+}
+
+// RedactTagAction sets the action applied to struct fields tagged
+// `log:"redact"`. It defaults to RedactMask.
+func RedactTagAction(action RedactAction) RedactorOption {
+	return func(r *Redactor) {
+		r.tagAction = action
+	}
+}
+
+// NewRedactor builds a Redactor applying rules in order; the first key rule
+// registered for a key wins.
+func NewRedactor(rules []RedactRule, opts ...RedactorOption) *Redactor {
+	r := &Redactor{
+		keys: make(map[string]RedactAction),
+		mask: DefaultRedactMask,
+	}
+	for _, rule := range rules {
+		if rule.pattern != nil {
+			r.patterns = append(r.patterns, rule)
+		}
+		for _, k := range rule.keys {
+			if _, ok := r.keys[k]; !ok {
+				r.keys[k] = rule.action
+			}
+		}
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// WithRedactor makes the Logger run every field, including the ones added
+// later with With and the values of the context extractor, through r before
+// encoding. Fields already attached to the core are not revisited, so apply
+// this option before Fields.
+func WithRedactor(r *Redactor) Option {
+	return optionFunc(func(log *Logger) {
+		if r != nil {
+			log.core = &redactCore{Core: log.core, r: r}
+			log.redactor = r
+		}
+	})
+}
+
+func (r *Redactor) matchKey(key string) (RedactAction, bool) {
+	if len(r.keys) == 0 {
+		return 0, false
+	}
+	key = strings.ToLower(key)
+	if action, ok := r.keys[key]; ok {
+		return action, true
+	}
+	if i := strings.LastIndexByte(key, '.'); i >= 0 {
+		action, ok := r.keys[key[i+1:]]
+		return action, ok
+	}
+	return 0, false
+}
+
+func (r *Redactor) hash(s string) string {
+	mac := hmac.New(sha256.New, r.hashKey)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// replace returns the replacement for a whole value; ok is false when the
+// value must be dropped.
+func (r *Redactor) replace(action RedactAction, s string) (string, bool) {
+	switch action {
+	case RedactDrop:
+		return "", false
+	case RedactHash:
+		return r.hash(s), true
+	default:
+		return r.mask, true
+	}
+}
+
+// redactString applies the pattern rules to s; ok is false when the value
+// must be dropped.
+func (r *Redactor) redactString(s string) (string, bool) {
+	for _, rule := range r.patterns {
+		switch rule.action {
+		case RedactDrop:
+			if rule.matches(s) {
+				return "", false
+			}
+		case RedactHash:
+			s = rule.replaceAll(s, r.hash)
+		default:
+			s = rule.replaceAll(s, func(string) string { return r.mask })
+		}
+	}
+	return s, true
+}
+
+func (r *Redactor) fields(fields []zapcore.Field) []zapcore.Field {
+	if len(fields) == 0 {
+		return fields
+	}
+	out := make([]zapcore.Field, 0, len(fields))
+	for _, f := range fields {
+		if f, ok := r.field(f); ok {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// field returns f with its value redacted; ok is false when f must be
+// dropped. The key is kept, and so is the type unless the key rules replace
+// the value with a string, so encoders treating keys such as "error" or
+// "context" apart still recognize them.
+func (r *Redactor) field(f zapcore.Field) (zapcore.Field, bool) {
+	if _, hit := r.matchKey(f.Key); hit {
+		// The key rules of redactEncoder give the replacement.
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(&redactEncoder{ObjectEncoder: enc, r: r})
+		v, ok := enc.Fields[f.Key]
+		if !ok {
+			return f, false
+		}
+		return zap.Any(f.Key, v), true
+	}
+	if !r.mayContainText(f) {
+		return f, true
+	}
+
+	switch f.Type {
+	case zapcore.StringType:
+		s, ok := r.redactString(f.String)
+		f.String = s
+		return f, ok
+	case zapcore.ByteStringType:
+		s, ok := r.redactString(string(f.Interface.([]byte)))
+		f.Interface = []byte(s)
+		return f, ok
+	case zapcore.StringerType:
+		st := f.Interface.(fmt.Stringer)
+		s, ok := r.redactString(safeString(st, st.String))
+		return zap.String(f.Key, s), ok
+	case zapcore.ErrorType:
+		err, ok := r.redactError(f.Interface.(error), 0)
+		f.Interface = err
+		return f, ok
+	case zapcore.ObjectMarshalerType, zapcore.InlineMarshalerType:
+		f.Interface = redactObject{m: f.Interface.(zapcore.ObjectMarshaler), r: r}
+	case zapcore.ArrayMarshalerType:
+		f.Interface = redactArray{m: f.Interface.(zapcore.ArrayMarshaler), r: r}
+	case zapcore.ReflectType:
+		f.Interface = r.redacted(f.Interface)
+	}
+	return f, true
+}
+
+// mayContainText reports whether a field can carry strings that pattern or
+// struct tag rules have to look at.
+func (r *Redactor) mayContainText(f zapcore.Field) bool {
+	switch f.Type {
+	case zapcore.StringType, zapcore.ByteStringType, zapcore.StringerType, zapcore.ErrorType:
+		return len(r.patterns) > 0
+	case zapcore.ObjectMarshalerType, zapcore.ArrayMarshalerType, zapcore.InlineMarshalerType,
+		zapcore.ReflectType:
+		return true
+	}
+	return false
+}
+
+// redactedError stands in for an error with the pattern rules applied to its
+// messages, so that it's still encoded as an error.
+type redactedError struct {
+	msg, verbose string
+}
+
+func (e *redactedError) Error() string { return e.msg }
+
+// Format writes the redacted form of the error's %+v for %+v.
+func (e *redactedError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		io.WriteString(s, e.verbose)
+		return
+	}
+	io.WriteString(s, e.msg)
+}
+
+// redactedErrorGroup is a redactedError with the causes of an error group,
+// as made by multierr, redacted too.
+type redactedErrorGroup struct {
+	redactedError
+	causes []error
+}
+
+func (e *redactedErrorGroup) Errors() []error { return e.causes }
+
+// redactError applies the pattern rules to the messages of err, as zap
+// encodes them; ok is false when the error must be dropped.
+func (r *Redactor) redactError(err error, depth int) (error, bool) {
+	msg, ok := r.redactString(safeString(err, err.Error))
+	if !ok {
+		return nil, false
+	}
+	re := redactedError{msg: msg, verbose: msg}
+	if _, isFormatter := err.(fmt.Formatter); isFormatter {
+		verbose := safeString(err, func() string { return fmt.Sprintf("%+v", err) })
+		if re.verbose, ok = r.redactString(verbose); !ok {
+			return nil, false
+		}
+	}
+	group, isGroup := err.(interface{ Errors() []error })
+	if !isGroup || depth >= maxRedactDepth {
+		return &re, true
+	}
+	g := &redactedErrorGroup{redactedError: re}
+	for _, cause := range group.Errors() {
+		if cause == nil {
+			continue
+		}
+		if c, ok := r.redactError(cause, depth+1); ok {
+			g.causes = append(g.causes, c)
+		}
+	}
+	return g, true
+}
+
+// redacted returns a copy of a reflected value with struct tags and rules
+// applied. Structs are copied into maps keyed like encoding/json would, types
+// with their own JSON or text encoding are kept as they are.
+func (r *Redactor) redacted(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	out, _ := r.redactValue(reflect.ValueOf(value), 0)
+	return out
+}
+
+const maxRedactDepth = 16
+
+var (
+	_jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	_textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func (r *Redactor) redactValue(v reflect.Value, depth int) (interface{}, bool) {
+	if !v.IsValid() {
+		return nil, true
+	}
+	if depth > maxRedactDepth || !v.CanInterface() {
+		return nil, true
+	}
+	if v.Type().Implements(_jsonMarshalerType) || v.Type().Implements(_textMarshalerType) {
+		return v.Interface(), true
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, true
+		}
+		return r.redactValue(v.Elem(), depth+1)
+	case reflect.String:
+		return r.redactString(v.String())
+	case reflect.Struct:
+		return r.redactStruct(v, depth)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && (v.IsNil() || v.Type().Elem().Kind() == reflect.Uint8) {
+			return v.Interface(), true
+		}
+		out := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			if elem, ok := r.redactValue(v.Index(i), depth+1); ok {
+				out = append(out, elem)
+			}
+		}
+		return out, true
+	case reflect.Map:
+		if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+			return v.Interface(), true
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			k := iter.Key().String()
+			if elem, ok := r.redactEntry(k, iter.Value(), depth); ok {
+				out[k] = elem
+			}
+		}
+		return out, true
+	}
+	return v.Interface(), true
+}
+
+func (r *Redactor) redactStruct(v reflect.Value, depth int) (interface{}, bool) {
+	t := v.Type()
+	out := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		name := sf.Name
+		if tag, ok := sf.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+
+		tagName, redact, skip := parseLogTag(sf.Tag.Get("log"))
+		if skip {
+			continue
+		}
+		if tagName != "" {
+			name = tagName
+		}
+		if redact {
+			if s, ok := r.replace(r.tagAction, jsonString(v.Field(i))); ok {
+				out[name] = s
+			}
+			continue
+		}
+		if elem, ok := r.redactEntry(name, v.Field(i), depth); ok {
+			out[name] = elem
+		}
+	}
+	return out, true
+}
+
+// parseLogTag parses a `log:"name,omitempty,redact"` tag, as written for
+// cmd/zapmarshal. A bare `log:"redact"` redacts too.
+func parseLogTag(tag string) (name string, redact, skip bool) {
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	if len(parts) == 1 && parts[0] == "redact" {
+		return "", true, false
+	}
+	for _, opt := range parts[1:] {
+		if opt == "redact" {
+			redact = true
+		}
+	}
+	return parts[0], redact, false
+}
+
+func (r *Redactor) redactEntry(key string, v reflect.Value, depth int) (interface{}, bool) {
+	if action, hit := r.matchKey(key); hit {
+		return r.replace(action, jsonString(v))
+	}
+	return r.redactValue(v, depth+1)
+}
+
+func jsonString(v reflect.Value) string {
+	if !v.CanInterface() {
+		return ""
+	}
+	b, _ := json.Marshal(v.Interface())
+	return string(b)
+}
+
+// redactCore applies a Redactor to the fields of a wrapped core.
+type redactCore struct {
+	zapcore.Core
+	r *Redactor
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(c.r.fields(fields)), r: c.r}
+}
+
+// Check asks the wrapped core, so samplers and the level of each core of a
+// tee still decide, and stands in for the cores it picked to redact the
+// fields on their way to them.
+func (c *redactCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	inner := c.Core.Check(ent, nil)
+	if inner == nil {
+		return ce
+	}
+	e := &redactedEntry{Core: c.Core, inner: inner, r: c.r}
+	ce = ce.AddCore(ent, e)
+	e.outer = ce
+	return ce
+}
+
+// redactedEntry writes a checked entry of the wrapped core with redacted
+// fields. It's added to a single CheckedEntry and written once.
+type redactedEntry struct {
+	zapcore.Core
+	inner *zapcore.CheckedEntry
+	// outer is the CheckedEntry it was added to, whose error output the
+	// wrapped cores report to.
+	outer *zapcore.CheckedEntry
+	r     *Redactor
+}
+
+func (e *redactedEntry) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	// The logger sets the caller, the stack and the error output after
+	// checking.
+	e.inner.Entry = ent
+	e.inner.ErrorOutput = e.outer.ErrorOutput
+	e.inner.Write(e.r.fields(fields)...)
+	return nil
+}
+
+func (e *redactedEntry) Sync() error { return nil }
+
+type redactObject struct {
+	m zapcore.ObjectMarshaler
+	r *Redactor
+}
+
+func (ro redactObject) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	return ro.m.MarshalLogObject(&redactEncoder{ObjectEncoder: enc, r: ro.r})
+}
+
+type redactArray struct {
+	m zapcore.ArrayMarshaler
+	r *Redactor
+}
+
+func (ra redactArray) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	return ra.m.MarshalLogArray(&redactArrayEncoder{ArrayEncoder: enc, r: ra.r})
+}
+
+// redactEncoder is an ObjectEncoder applying a Redactor to every key and
+// value before handing them to the wrapped encoder.
+type redactEncoder struct {
+	zapcore.ObjectEncoder
+	r *Redactor
+}
+
+// keyHit applies the key rules to key, encoding the replacement itself.
+// value is only called when the value has to be hashed.
+func (e *redactEncoder) keyHit(key string, value func() string) bool {
+	action, hit := e.r.matchKey(key)
+	if !hit {
+		return false
+	}
+	var s string
+	if action == RedactHash {
+		s = value()
+	}
+	if s, ok := e.r.replace(action, s); ok {
+		e.ObjectEncoder.AddString(key, s)
+	}
+	return true
+}
+
+func (e *redactEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	if e.keyHit(key, func() string { return encodeForHash(zap.Array(key, arr)) }) {
+		return nil
+	}
+	return e.ObjectEncoder.AddArray(key, redactArray{m: arr, r: e.r})
+}
+
+func (e *redactEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	if action, hit := e.r.matchKey(key); hit {
+		// Keep the caller of context values, only the value is secret.
+		if cf, ok := obj.(contextFieldValue); ok {
+			s, keep := e.r.replace(action, encodeForHash(zap.Any("", cf.value)))
+			if !keep {
+				return nil
+			}
+			cf.value = s
+			return e.ObjectEncoder.AddObject(key, cf)
+		}
+	}
+	if e.keyHit(key, func() string { return encodeForHash(zap.Object(key, obj)) }) {
+		return nil
+	}
+	return e.ObjectEncoder.AddObject(key, redactObject{m: obj, r: e.r})
+}
+
+func (e *redactEncoder) AddBinary(key string, value []byte) {
+	if !e.keyHit(key, func() string { return string(value) }) {
+		e.ObjectEncoder.AddBinary(key, value)
+	}
+}
+
+func (e *redactEncoder) AddByteString(key string, value []byte) {
+	if e.keyHit(key, func() string { return string(value) }) {
+		return
+	}
+	if s, ok := e.r.redactString(string(value)); ok {
+		e.ObjectEncoder.AddString(key, s)
+	}
+}
+
+func (e *redactEncoder) AddBool(key string, value bool) {
+	if !e.keyHit(key, func() string { return strconv.FormatBool(value) }) {
+		e.ObjectEncoder.AddBool(key, value)
+	}
+}
+
+func (e *redactEncoder) AddComplex128(key string, value complex128) {
+	if !e.keyHit(key, func() string { return strconv.FormatComplex(value, 'g', -1, 128) }) {
+		e.ObjectEncoder.AddComplex128(key, value)
+	}
+}
+
+func (e *redactEncoder) AddComplex64(key string, value complex64) {
+	if !e.keyHit(key, func() string { return strconv.FormatComplex(complex128(value), 'g', -1, 64) }) {
+		e.ObjectEncoder.AddComplex64(key, value)
+	}
+}
+
+func (e *redactEncoder) AddDuration(key string, value time.Duration) {
+	if !e.keyHit(key, value.String) {
+		e.ObjectEncoder.AddDuration(key, value)
+	}
+}
+
+func (e *redactEncoder) AddFloat64(key string, value float64) {
+	if !e.keyHit(key, func() string { return strconv.FormatFloat(value, 'g', -1, 64) }) {
+		e.ObjectEncoder.AddFloat64(key, value)
+	}
+}
+
+func (e *redactEncoder) AddFloat32(key string, value float32) {
+	if !e.keyHit(key, func() string { return strconv.FormatFloat(float64(value), 'g', -1, 32) }) {
+		e.ObjectEncoder.AddFloat32(key, value)
+	}
+}
+
+func (e *redactEncoder) AddInt(key string, value int) { e.AddInt64(key, int64(value)) }
+
+func (e *redactEncoder) AddInt64(key string, value int64) {
+	if !e.keyHit(key, func() string { return strconv.FormatInt(value, 10) }) {
+		e.ObjectEncoder.AddInt64(key, value)
+	}
+}
+
+func (e *redactEncoder) AddInt32(key string, value int32) { e.AddInt64(key, int64(value)) }
+func (e *redactEncoder) AddInt16(key string, value int16) { e.AddInt64(key, int64(value)) }
+func (e *redactEncoder) AddInt8(key string, value int8)   { e.AddInt64(key, int64(value)) }
+
+func (e *redactEncoder) AddString(key, value string) {
+	if e.keyHit(key, func() string { return value }) {
+		return
+	}
+	if s, ok := e.r.redactString(value); ok {
+		e.ObjectEncoder.AddString(key, s)
+	}
+}
+
+func (e *redactEncoder) AddTime(key string, value time.Time) {
+	if !e.keyHit(key, func() string { return value.Format(time.RFC3339Nano) }) {
+		e.ObjectEncoder.AddTime(key, value)
+	}
+}
+
+func (e *redactEncoder) AddUint(key string, value uint) { e.AddUint64(key, uint64(value)) }
+
+func (e *redactEncoder) AddUint64(key string, value uint64) {
+	if !e.keyHit(key, func() string { return strconv.FormatUint(value, 10) }) {
+		e.ObjectEncoder.AddUint64(key, value)
+	}
+}
+
+func (e *redactEncoder) AddUint32(key string, value uint32)   { e.AddUint64(key, uint64(value)) }
+func (e *redactEncoder) AddUint16(key string, value uint16)   { e.AddUint64(key, uint64(value)) }
+func (e *redactEncoder) AddUint8(key string, value uint8)     { e.AddUint64(key, uint64(value)) }
+func (e *redactEncoder) AddUintptr(key string, value uintptr) { e.AddUint64(key, uint64(value)) }
+
+func (e *redactEncoder) AddReflected(key string, value interface{}) error {
+	if e.keyHit(key, func() string { return encodeForHash(zap.Reflect(key, value)) }) {
+		return nil
+	}
+	return e.ObjectEncoder.AddReflected(key, e.r.redacted(value))
+}
+
+// redactArrayEncoder is the ArrayEncoder counterpart of redactEncoder. Array
+// elements have no keys, so only pattern and struct tag rules apply.
+type redactArrayEncoder struct {
+	zapcore.ArrayEncoder
+	r *Redactor
+}
+
+func (e *redactArrayEncoder) AppendArray(arr zapcore.ArrayMarshaler) error {
+	return e.ArrayEncoder.AppendArray(redactArray{m: arr, r: e.r})
+}
+
+func (e *redactArrayEncoder) AppendObject(obj zapcore.ObjectMarshaler) error {
+	return e.ArrayEncoder.AppendObject(redactObject{m: obj, r: e.r})
+}
+
+func (e *redactArrayEncoder) AppendReflected(value interface{}) error {
+	return e.ArrayEncoder.AppendReflected(e.r.redacted(value))
+}
+
+func (e *redactArrayEncoder) AppendByteString(value []byte) {
+	if s, ok := e.r.redactString(string(value)); ok {
+		e.ArrayEncoder.AppendString(s)
+	}
+}
+
+func (e *redactArrayEncoder) AppendString(value string) {
+	if s, ok := e.r.redactString(value); ok {
+		e.ArrayEncoder.AppendString(s)
+	}
+}
+
+// encodeForHash renders a field as JSON so that structured values can be
+// hashed deterministically.
+func encodeForHash(f zapcore.Field) string {
+	enc := zapcore.NewMapObjectEncoder()
+	f.AddTo(enc)
+	b, _ := json.Marshal(enc.Fields[f.Key])
+	return string(b)
+}
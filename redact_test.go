@@ -0,0 +1,222 @@
+package zap_logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withRedactedLogger(r *Redactor, opts []Option, f func(*Logger, *bytes.Buffer)) {
+	buf := &bytes.Buffer{}
+	encCfg := NewProductionEncoderConfig()
+	encCfg.TimeKey = ""
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encCfg), zapcore.AddSync(buf), zap.DebugLevel)
+	f(New(core, Config{}, append([]Option{WithRedactor(r)}, opts...)...), buf)
+}
+
+func TestRedactKeys(t *testing.T) {
+	r := NewRedactor([]RedactRule{
+		RedactKeys(RedactMask, "password"),
+		RedactKeys(RedactHash, "Authorization"),
+		RedactKeys(RedactDrop, "token"),
+	}, RedactWithHashKey([]byte("k")))
+
+	withRedactedLogger(r, nil, func(logger *Logger, buf *bytes.Buffer) {
+		logger.With(zap.String("password", "hunter2")).Info("login",
+			zap.String("authorization", "Bearer abc"),
+			zap.Int("token", 42),
+			zap.String("user", "jane"),
+		)
+		assert.Equal(t,
+			`{"level":"info","msg":"login","password":"[REDACTED]",`+
+				`"authorization":"`+r.hash("Bearer abc")+`","user":"jane"}`+"\n",
+			buf.String())
+	})
+}
+
+func TestRedactPatterns(t *testing.T) {
+	r := NewRedactor([]RedactRule{
+		RedactPattern(RedactMask, EmailPattern),
+		RedactPattern(RedactMask, CardNumberPattern),
+		RedactPattern(RedactDrop, JWTPattern),
+	})
+
+	withRedactedLogger(r, nil, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Info("payment",
+			zap.String("note", "mail jane@test.com, card 4111 1111 1111 1111"),
+			zap.Error(errors.New("user jane@test.com not found")),
+			zap.Strings("to", []string{"a@b.io", "ops"}),
+			zap.String("session", "eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.sig"),
+		)
+		assert.Equal(t,
+			`{"level":"info","msg":"payment","note":"mail [REDACTED], card [REDACTED]",`+
+				`"error":"user [REDACTED] not found","to":["[REDACTED]","ops"]}`+"\n",
+			buf.String())
+	})
+}
+
+func TestRedactCardNumbersLuhn(t *testing.T) {
+	r := NewRedactor([]RedactRule{
+		RedactPattern(RedactMask, CardNumberPattern),
+		RedactPattern(RedactDrop, CardNumberPattern),
+	})
+
+	withRedactedLogger(r, nil, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Info("batch",
+			zap.String("ts", "1760774400123456789"),
+			zap.String("order", "order 4000123412341235 paid"),
+			zap.String("card", "5500-0055-5555-5559"),
+		)
+		assert.Equal(t,
+			`{"level":"info","msg":"batch","ts":"1760774400123456789",`+
+				`"order":"order 4000123412341235 paid","card":"[REDACTED]"}`+"\n",
+			buf.String(), "Expected only numbers passing the Luhn check to be redacted.")
+	})
+	assert.True(t, luhnValid("4111 1111 1111 1111"))
+	assert.False(t, luhnValid("1760774400123456789"))
+}
+
+func TestRedactStructTags(t *testing.T) {
+	type account struct {
+		Name     string `json:"name"`
+		Password string `log:"redact"`
+		Internal string `log:"-"`
+		Email    string `json:"email"`
+		Contact  string `log:"contact,omitempty,redact"`
+	}
+	r := NewRedactor([]RedactRule{RedactPattern(RedactMask, EmailPattern)})
+
+	withRedactedLogger(r, nil, func(logger *Logger, buf *bytes.Buffer) {
+		logger.Info("account", zap.Any("account", &account{
+			Name:     "jane",
+			Password: "hunter2",
+			Internal: "x",
+			Email:    "jane@test.com",
+			Contact:  "+1 555 0100",
+		}))
+		assert.Equal(t,
+			`{"level":"info","msg":"account","account":{"Password":"[REDACTED]","contact":"[REDACTED]","email":"[REDACTED]","name":"jane"}}`+"\n",
+			buf.String())
+	})
+}
+
+func TestRedactContext(t *testing.T) {
+	type ctxKey string
+	const (
+		Password ctxKey = "Password"
+		Session  ctxKey = "Session"
+	)
+	type session struct {
+		ID    string
+		Token string `log:"redact"`
+	}
+
+	ctx := context.WithValue(context.TODO(), Password, "hunter2")
+	ctx = context.WithValue(ctx, Session, &session{ID: "s1", Token: "t1"})
+	fieldOpts := opts(AddContext(func(ctx context.Context, log *ZapLogger) {
+		log.Ctx.Set(Password, ctx)
+		log.Ctx.Set(Session, ctx)
+	}))
+
+	r := NewRedactor([]RedactRule{RedactKeys(RedactMask, "password")})
+	withRedactedLogger(r, fieldOpts, func(logger *Logger, buf *bytes.Buffer) {
+		logger.InfoCtx(ctx, "ctx")
+		out := buf.String()
+		assert.NotContains(t, out, "hunter2", "Expected context value to be masked by key.")
+		assert.NotContains(t, out, "t1", "Expected tagged struct field to be masked.")
+		assert.Contains(t, out, `"value":"[REDACTED]"`)
+		assert.Contains(t, out, `"ID":"s1"`)
+	})
+}
+
+func TestRedactorWithHooksMetricsAndSampling(t *testing.T) {
+	r := NewRedactor([]RedactRule{RedactKeys(RedactMask, "password")})
+	m := NewMetrics()
+	var seen []string
+	hook := func(ent zapcore.Entry, fields []zapcore.Field) error {
+		enc := zapcore.NewMapObjectEncoder()
+		for _, f := range fields {
+			f.AddTo(enc)
+		}
+		seen = append(seen, fmt.Sprint(enc.Fields["password"]))
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	encCfg := NewProductionEncoderConfig()
+	encCfg.TimeKey = ""
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(encCfg), zapcore.AddSync(buf), zap.DebugLevel)
+	sampled := zapcore.NewSamplerWithOptions(core, time.Hour, 1, 0, zapcore.SamplerHook(m.samplerHook(nil)))
+	// As NewLogger with Config.Metrics and Config.Sampling, then the caller's
+	// options.
+	log := New(sampled, Config{}, Hooks(m.countEntry), WithRedactor(r), Hooks(hook))
+
+	log.Info("login", zap.String("password", "secret"))
+	log.Info("login", zap.String("password", "secret"))
+	log.With(zap.String("password", "secret")).Warn("retry")
+
+	out := buf.String()
+	assert.NotContains(t, out, "secret")
+	assert.Equal(t, 2, strings.Count(out, `"password":"[REDACTED]"`), "Expected the sampled entries to be written, have %s.", out)
+	assert.Equal(t, []string{"[REDACTED]", "[REDACTED]"}, seen, "Expected hooks after the redactor to see redacted fields.")
+
+	var text bytes.Buffer
+	require.NoError(t, m.WriteText(&text))
+	assert.Contains(t, text.String(), `zap_logger_entries_total{level="info",logger=""} 1`)
+	assert.Contains(t, text.String(), `zap_logger_sampling_dropped_total{level="info"} 1`)
+}
+
+type failingCore struct{ zapcore.Core }
+
+func (c failingCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, c)
+}
+
+func (failingCore) Write(zapcore.Entry, []zapcore.Field) error { return errors.New("disk full") }
+
+func TestRedactorReportsWriteErrors(t *testing.T) {
+	errOut := &lockedBuffer{}
+	core := failingCore{zapcore.NewCore(zapcore.NewJSONEncoder(NewProductionEncoderConfig()), &Discarder{}, zap.DebugLevel)}
+	log := New(core, Config{}, WithRedactor(NewRedactor(nil)), ErrorOutput(errOut), WithCaller(true))
+	log.Info("hello")
+	assert.Regexp(t, `write error: disk full\n$`, errOut.String())
+	assert.Equal(t, 1, strings.Count(errOut.String(), "write error"))
+}
+
+func TestRedactKeepsKeysAndTypes(t *testing.T) {
+	type ctxKey string
+	const (
+		traceID ctxKey = "trace_id"
+		tenant  ctxKey = "tenant"
+	)
+	ctx := context.WithValue(context.TODO(), traceID, "4bf92f3577b34da6")
+	ctx = context.WithValue(ctx, tenant, "jane@test.com")
+	r := NewRedactor([]RedactRule{RedactPattern(RedactMask, EmailPattern)})
+	options := append([]Option{WithRedactor(r)}, opts(AddContext(func(ctx context.Context, log *ZapLogger) {
+		log.Ctx.Set(traceID, ctx)
+		log.Ctx.Set(tenant, ctx)
+	}))...)
+
+	withECSLogger(options, func(logger *Logger, buf *bytes.Buffer) {
+		logger.ErrorCtx(ctx, "failed", zap.Error(errors.New("user jane@test.com not found")))
+
+		record := decodeECS(t, buf)
+		assert.Equal(t, map[string]interface{}{"message": "user [REDACTED] not found"}, record["error"],
+			"Expected a redacted error to stay an error.")
+		assert.Equal(t, "4bf92f3577b34da6", record["trace.id"], "Expected the context to still be split.")
+		rest, ok := record["context"].(map[string]interface{})
+		require.True(t, ok, "Expected the context object to keep its key.")
+		require.Contains(t, rest, "zap_logger.ctxKey.tenant")
+		assert.Equal(t, "[REDACTED]", rest["zap_logger.ctxKey.tenant"].(map[string]interface{})["value"])
+	})
+}
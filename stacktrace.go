@@ -1,9 +1,17 @@
 package zap_logger
 
 import (
-	"github.com/hinha/zap-logger/buffer"
+	"go/build"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"runtime/debug"
+	"strings"
 	"sync"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/buffer"
 )
 
 var _stacktracePool = sync.Pool{
@@ -85,6 +93,18 @@ func captureStacktrace(skip int, depth stacktraceDepth) *stacktrace {
 	return stack
 }
 
+// newStacktrace returns a pooled stacktrace over the program counters pcs,
+// as returned by runtime.Callers, such as those recorded by an error.
+//
+// The caller must call Free on the returned stacktrace after using it.
+func newStacktrace(pcs []uintptr) *stacktrace {
+	stack := _stacktracePool.Get().(*stacktrace)
+	stack.storage = append(stack.storage[:0], pcs...)
+	stack.pcs = stack.storage
+	stack.frames = runtime.CallersFrames(stack.pcs)
+	return stack
+}
+
 // Free releases resources associated with this stacktrace
 // and returns it back to the pool.
 func (st *stacktrace) Free() {
@@ -152,3 +172,157 @@ func (sf *stackFormatter) FormatFrame(frame runtime.Frame) {
 	sf.b.AppendByte(':')
 	sf.b.AppendInt(int64(frame.Line))
 }
+
+// StackFrameOptions configures the structured stack traces added by
+// WithStackFrames.
+type StackFrameOptions struct {
+	// Key is the field holding the frames. It defaults to "stacktrace".
+	Key string
+	// Runtime keeps the frames of the runtime and testing packages, which are
+	// dropped by default.
+	Runtime bool
+	// TrimPrefixes are removed from the start of file paths. It defaults to
+	// GOROOT/src and the src and pkg/mod directories of GOPATH.
+	TrimPrefixes []string
+	// MaxDepth caps the number of frames; zero keeps them all.
+	MaxDepth int
+	// Module marks the frames of packages under this import path with
+	// "in_module". It defaults to the main module of the binary.
+	Module string
+}
+
+// StackFrame is a frame of a structured stack trace.
+type StackFrame struct {
+	Function string
+	Package  string
+	File     string
+	Line     int
+	InModule bool
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler.
+func (f StackFrame) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("function", f.Function)
+	enc.AddString("file", f.File)
+	enc.AddInt("line", f.Line)
+	enc.AddString("package", f.Package)
+	if f.InModule {
+		enc.AddBool("in_module", true)
+	}
+	return nil
+}
+
+type stackFrames []StackFrame
+
+func (fs stackFrames) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, f := range fs {
+		if err := enc.AppendObject(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// _loggerPackage is the import path of this package.
+var _loggerPackage = reflect.TypeOf(Logger{}).PkgPath()
+
+// stackFramer turns captured stack traces into structured frames.
+type stackFramer struct {
+	key      string
+	runtime  bool
+	trim     []string
+	maxDepth int
+	module   string
+}
+
+func newStackFramer(opts StackFrameOptions) *stackFramer {
+	sf := &stackFramer{
+		key:      opts.Key,
+		runtime:  opts.Runtime,
+		trim:     opts.TrimPrefixes,
+		maxDepth: opts.MaxDepth,
+		module:   opts.Module,
+	}
+	if sf.key == "" {
+		sf.key = "stacktrace"
+	}
+	if sf.trim == nil {
+		sf.trim = defaultTrimPrefixes()
+	}
+	if sf.module == "" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			sf.module = info.Main.Path
+		}
+	}
+	return sf
+}
+
+func defaultTrimPrefixes() []string {
+	var prefixes []string
+	if root := runtime.GOROOT(); root != "" {
+		prefixes = append(prefixes, filepath.ToSlash(filepath.Join(root, "src"))+"/")
+	}
+	for _, dir := range filepath.SplitList(build.Default.GOPATH) {
+		dir = filepath.ToSlash(dir)
+		prefixes = append(prefixes, dir+"/pkg/mod/", dir+"/src/")
+	}
+	return prefixes
+}
+
+// Frames returns the remaining frames of stack, after first, filtered and
+// trimmed. Like FormatStack, it drops the final runtime.main/runtime.goexit
+// frame.
+func (sf *stackFramer) Frames(first runtime.Frame, more bool, stack *stacktrace) stackFrames {
+	frames := make(stackFrames, 0, stack.Count())
+	if f, ok := sf.frame(first); ok {
+		frames = append(frames, f)
+	}
+	for more && (sf.maxDepth <= 0 || len(frames) < sf.maxDepth) {
+		var frame runtime.Frame
+		if frame, more = stack.Next(); !more {
+			break
+		}
+		if f, ok := sf.frame(frame); ok {
+			frames = append(frames, f)
+		}
+	}
+	return frames
+}
+
+func (sf *stackFramer) frame(frame runtime.Frame) (StackFrame, bool) {
+	pkg, fn := splitFuncName(frame.Function)
+	if !sf.runtime && (pkg == "runtime" || pkg == "testing") {
+		return StackFrame{}, false
+	}
+	// The logger's own frames, such as its check, hooks and redaction, say
+	// nothing about the caller. Only its tests log from this package.
+	if pkg == _loggerPackage && !strings.HasSuffix(frame.File, "_test.go") {
+		return StackFrame{}, false
+	}
+	file := frame.File
+	for _, prefix := range sf.trim {
+		if strings.HasPrefix(file, prefix) {
+			file = file[len(prefix):]
+			break
+		}
+	}
+	return StackFrame{
+		Function: fn,
+		Package:  pkg,
+		File:     file,
+		Line:     frame.Line,
+		InModule: sf.module != "" && (pkg == sf.module || strings.HasPrefix(pkg, sf.module+"/")),
+	}, true
+}
+
+// splitFuncName splits a qualified function name such as
+// "github.com/a/b.(*T).M" into its package path and the rest.
+func splitFuncName(name string) (pkg, fn string) {
+	slash := strings.LastIndexByte(name, '/')
+	dot := strings.IndexByte(name[slash+1:], '.')
+	if dot < 0 {
+		return "", name
+	}
+	dot += slash + 1
+	return name[:dot], name[dot+1:]
+}
@@ -2,11 +2,15 @@ package zap_logger
 
 import (
 	"bytes"
+	"encoding/json"
+	"runtime"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestTakeStacktrace(t *testing.T) {
@@ -84,3 +88,123 @@ func withStackDepth(depth int, f func()) {
 	}
 	recurse(rune(depth))
 }
+
+func TestStackFrames(t *testing.T) {
+	var buf bytes.Buffer
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg", StacktraceKey: "stacktrace"})
+	core := zapcore.NewCore(enc, zapcore.AddSync(&buf), zapcore.DebugLevel)
+
+	logLine := func(opts StackFrameOptions) map[string]interface{} {
+		buf.Reset()
+		log := New(core, Config{}, AddStacktrace(zapcore.ErrorLevel), WithCaller(true), WithStackFrames(opts))
+		log.Info("no stack")
+		log.Error("with stack")
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 2)
+		assert.NotContains(t, lines[0], "stacktrace", "Expected no stack below the level.")
+		var m map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(lines[1]), &m))
+		return m
+	}
+
+	m := logLine(StackFrameOptions{Module: "github.com/hinha/zap-logger"})
+	frames, ok := m["stacktrace"].([]interface{})
+	require.True(t, ok, "Expected the stack as an array, have %v.", m["stacktrace"])
+	require.Len(t, frames, 2, "Expected the runtime and testing frames to be dropped.")
+	assert.Equal(t, map[string]interface{}{
+		"function":  "TestStackFrames.func1",
+		"package":   "github.com/hinha/zap-logger",
+		"file":      frames[0].(map[string]interface{})["file"],
+		"line":      frames[0].(map[string]interface{})["line"],
+		"in_module": true,
+	}, frames[0])
+	assert.True(t, strings.HasSuffix(frames[0].(map[string]interface{})["file"].(string), "/stacktrace_test.go"))
+	assert.Equal(t, "TestStackFrames", frames[1].(map[string]interface{})["function"])
+
+	m = logLine(StackFrameOptions{Key: "frames", Runtime: true, MaxDepth: 3, Module: "example.com/other"})
+	frames, ok = m["frames"].([]interface{})
+	require.True(t, ok, "Expected the stack under the configured key.")
+	require.Len(t, frames, 3, "Expected MaxDepth to cap the frames.")
+	assert.NotContains(t, frames[0], "in_module")
+	assert.Equal(t, "testing", frames[2].(map[string]interface{})["package"])
+	assert.True(t, strings.HasPrefix(frames[2].(map[string]interface{})["file"].(string), "testing/"),
+		"Expected GOROOT to be trimmed from %v.", frames[2])
+}
+
+// withCounter counts the calls to With.
+type withCounter struct {
+	zapcore.Core
+	with *int
+}
+
+func (c withCounter) With(fields []zapcore.Field) zapcore.Core {
+	*c.with++
+	return withCounter{c.Core.With(fields), c.with}
+}
+
+func (c withCounter) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func TestStackFramesWithoutWith(t *testing.T) {
+	var (
+		buf  bytes.Buffer
+		with int
+	)
+	enc := zapcore.NewJSONEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	core := withCounter{zapcore.NewCore(enc, zapcore.AddSync(&buf), zapcore.InfoLevel), &with}
+	log := New(core, Config{}, AddStacktrace(zapcore.ErrorLevel), WithStackFrames(StackFrameOptions{}))
+	log.Error("first", zap.String("k", "v"))
+	log.Error("second")
+	log.Debug("disabled")
+	assert.Zero(t, with, "Expected the frames to be added without cloning the core.")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	for _, line := range lines {
+		var m map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &m))
+		frames, ok := m["stacktrace"].([]interface{})
+		require.True(t, ok, "Expected the stack as an array in %s.", line)
+		assert.Equal(t, "TestStackFramesWithoutWith", frames[0].(map[string]interface{})["function"])
+	}
+	assert.Contains(t, lines[0], `"k":"v"`)
+}
+
+func TestStackFramerDropsLoggerFrames(t *testing.T) {
+	sf := newStackFramer(StackFrameOptions{})
+	for _, frame := range []runtime.Frame{
+		{Function: _loggerPackage + ".(*ZapLogger).check", File: "/src/zap-logger/options.go"},
+		{Function: _loggerPackage + ".(*hookCore).Write", File: "/src/zap-logger/hooks.go"},
+		{Function: _loggerPackage + ".(*redactedEntry).Write", File: "/src/zap-logger/redact.go"},
+	} {
+		_, ok := sf.frame(frame)
+		assert.False(t, ok, "Expected %s to be dropped.", frame.Function)
+	}
+	for _, frame := range []runtime.Frame{
+		{Function: _loggerPackage + "/pkg/httpsink.(*Writer).Write", File: "/src/zap-logger/pkg/httpsink/httpsink.go"},
+		{Function: _loggerPackage + ".TestX", File: "/src/zap-logger/x_test.go"},
+		{Function: "example.com/app.main", File: "/src/app/main.go"},
+	} {
+		_, ok := sf.frame(frame)
+		assert.True(t, ok, "Expected %s to be kept.", frame.Function)
+	}
+}
+
+func TestSplitFuncName(t *testing.T) {
+	tests := []struct{ name, pkg, fn string }{
+		{"github.com/a/b.(*T).M", "github.com/a/b", "(*T).M"},
+		{"github.com/a/b.v2.F.func1", "github.com/a/b", "v2.F.func1"},
+		{"runtime.goexit", "runtime", "goexit"},
+		{"main.main", "main", "main"},
+		{"weird", "", "weird"},
+	}
+	for _, tt := range tests {
+		pkg, fn := splitFuncName(tt.name)
+		assert.Equal(t, tt.pkg, pkg, tt.name)
+		assert.Equal(t, tt.fn, fn, tt.name)
+	}
+}
@@ -0,0 +1,364 @@
+package zap_logger
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	_oddNumberErrMsg    = "Ignored key without a value."
+	_nonStringKeyErrMsg = "Ignored key-value pairs with non-string keys."
+	_multipleErrMsg     = "Multiple errors without a key."
+)
+
+// A SugaredLogger wraps the base ZapLogger functionality in a slower, but less
+// verbose, API. Any ZapLogger can be converted to a SugaredLogger with its
+// Sugar method.
+//
+// Unlike the ZapLogger, the SugaredLogger doesn't insist on structured
+// logging. For each log level, it exposes four methods:
+//
+//   - methods named after the log level for log.Print-style logging
+//   - methods ending in "w" for loosely-typed structured logging
+//   - methods ending in "f" for log.Printf-style logging
+//   - methods ending in "wCtx" and "fCtx" which also read the context values
+//     selected by AddContext
+//
+// The SugaredLogger shares its core, context function and rotation with the
+// ZapLogger it was built from.
+type SugaredLogger struct {
+	base *ZapLogger
+}
+
+// Sugar wraps the ZapLogger to provide a more ergonomic, but slightly slower,
+// API. Sugaring a ZapLogger is quite inexpensive, so it's reasonable for a
+// single application to use both ZapLoggers and SugaredLoggers, converting
+// between them on the boundaries of performance-sensitive code.
+func (log *ZapLogger) Sugar() *SugaredLogger {
+	core := log.clone()
+	core.callerSkip += 2
+	return &SugaredLogger{core}
+}
+
+// Desugar unwraps a SugaredLogger, exposing the original ZapLogger.
+func (s *SugaredLogger) Desugar() *ZapLogger {
+	base := s.base.clone()
+	base.callerSkip -= 2
+	return base
+}
+
+// Named adds a sub-scope to the logger's name. See ZapLogger.Named for details.
+func (s *SugaredLogger) Named(name string) *SugaredLogger {
+	return &SugaredLogger{base: s.base.Named(name)}
+}
+
+// With adds a variadic number of fields to the logging context. It accepts a
+// mix of strongly-typed zap.Field objects and loosely-typed key-value pairs.
+// When processing pairs, the first element of the pair is used as the field
+// key and the second as the field value.
+//
+// Keys in key-value pairs should be strings. Odd number of arguments and
+// non-string keys are reported to the error output and skipped.
+func (s *SugaredLogger) With(args ...interface{}) *SugaredLogger {
+	return &SugaredLogger{base: s.base.With(s.sweetenFields(args)...)}
+}
+
+// Level reports the minimum enabled level for this logger.
+func (s *SugaredLogger) Level() zapcore.Level {
+	return s.base.Level()
+}
+
+// Debug uses fmt.Sprint to construct and log a message.
+func (s *SugaredLogger) Debug(args ...interface{}) {
+	s.log(nil, zap.DebugLevel, "", args, nil)
+}
+
+// Info uses fmt.Sprint to construct and log a message.
+func (s *SugaredLogger) Info(args ...interface{}) {
+	s.log(nil, zap.InfoLevel, "", args, nil)
+}
+
+// Warn uses fmt.Sprint to construct and log a message.
+func (s *SugaredLogger) Warn(args ...interface{}) {
+	s.log(nil, zap.WarnLevel, "", args, nil)
+}
+
+// Error uses fmt.Sprint to construct and log a message.
+func (s *SugaredLogger) Error(args ...interface{}) {
+	s.log(nil, zap.ErrorLevel, "", args, nil)
+}
+
+// DPanic uses fmt.Sprint to construct and log a message. In development, the
+// logger then panics. (See DPanicLevel for details.)
+func (s *SugaredLogger) DPanic(args ...interface{}) {
+	s.log(nil, zap.DPanicLevel, "", args, nil)
+}
+
+// Panic uses fmt.Sprint to construct and log a message, then panics.
+func (s *SugaredLogger) Panic(args ...interface{}) {
+	s.log(nil, zap.PanicLevel, "", args, nil)
+}
+
+// Fatal uses fmt.Sprint to construct and log a message, then calls os.Exit.
+func (s *SugaredLogger) Fatal(args ...interface{}) {
+	s.log(nil, zap.FatalLevel, "", args, nil)
+}
+
+// Debugf uses fmt.Sprintf to log a templated message.
+func (s *SugaredLogger) Debugf(template string, args ...interface{}) {
+	s.log(nil, zap.DebugLevel, template, args, nil)
+}
+
+// Infof uses fmt.Sprintf to log a templated message.
+func (s *SugaredLogger) Infof(template string, args ...interface{}) {
+	s.log(nil, zap.InfoLevel, template, args, nil)
+}
+
+// Warnf uses fmt.Sprintf to log a templated message.
+func (s *SugaredLogger) Warnf(template string, args ...interface{}) {
+	s.log(nil, zap.WarnLevel, template, args, nil)
+}
+
+// Errorf uses fmt.Sprintf to log a templated message.
+func (s *SugaredLogger) Errorf(template string, args ...interface{}) {
+	s.log(nil, zap.ErrorLevel, template, args, nil)
+}
+
+// DPanicf uses fmt.Sprintf to log a templated message. In development, the
+// logger then panics. (See DPanicLevel for details.)
+func (s *SugaredLogger) DPanicf(template string, args ...interface{}) {
+	s.log(nil, zap.DPanicLevel, template, args, nil)
+}
+
+// Panicf uses fmt.Sprintf to log a templated message, then panics.
+func (s *SugaredLogger) Panicf(template string, args ...interface{}) {
+	s.log(nil, zap.PanicLevel, template, args, nil)
+}
+
+// Fatalf uses fmt.Sprintf to log a templated message, then calls os.Exit.
+func (s *SugaredLogger) Fatalf(template string, args ...interface{}) {
+	s.log(nil, zap.FatalLevel, template, args, nil)
+}
+
+// Debugw logs a message with some additional context. The variadic key-value
+// pairs are treated as they are in With.
+func (s *SugaredLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	s.log(nil, zap.DebugLevel, msg, nil, keysAndValues)
+}
+
+// Infow logs a message with some additional context. The variadic key-value
+// pairs are treated as they are in With.
+func (s *SugaredLogger) Infow(msg string, keysAndValues ...interface{}) {
+	s.log(nil, zap.InfoLevel, msg, nil, keysAndValues)
+}
+
+// Warnw logs a message with some additional context. The variadic key-value
+// pairs are treated as they are in With.
+func (s *SugaredLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	s.log(nil, zap.WarnLevel, msg, nil, keysAndValues)
+}
+
+// Errorw logs a message with some additional context. The variadic key-value
+// pairs are treated as they are in With.
+func (s *SugaredLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	s.log(nil, zap.ErrorLevel, msg, nil, keysAndValues)
+}
+
+// DPanicw logs a message with some additional context. In development, the
+// logger then panics. (See DPanicLevel for details.) The variadic key-value
+// pairs are treated as they are in With.
+func (s *SugaredLogger) DPanicw(msg string, keysAndValues ...interface{}) {
+	s.log(nil, zap.DPanicLevel, msg, nil, keysAndValues)
+}
+
+// Panicw logs a message with some additional context, then panics. The
+// variadic key-value pairs are treated as they are in With.
+func (s *SugaredLogger) Panicw(msg string, keysAndValues ...interface{}) {
+	s.log(nil, zap.PanicLevel, msg, nil, keysAndValues)
+}
+
+// Fatalw logs a message with some additional context, then calls os.Exit. The
+// variadic key-value pairs are treated as they are in With.
+func (s *SugaredLogger) Fatalw(msg string, keysAndValues ...interface{}) {
+	s.log(nil, zap.FatalLevel, msg, nil, keysAndValues)
+}
+
+// DebugfCtx with context uses fmt.Sprintf to log a templated message.
+func (s *SugaredLogger) DebugfCtx(ctx context.Context, template string, args ...interface{}) {
+	s.log(ctx, zap.DebugLevel, template, args, nil)
+}
+
+// InfofCtx with context uses fmt.Sprintf to log a templated message.
+func (s *SugaredLogger) InfofCtx(ctx context.Context, template string, args ...interface{}) {
+	s.log(ctx, zap.InfoLevel, template, args, nil)
+}
+
+// WarnfCtx with context uses fmt.Sprintf to log a templated message.
+func (s *SugaredLogger) WarnfCtx(ctx context.Context, template string, args ...interface{}) {
+	s.log(ctx, zap.WarnLevel, template, args, nil)
+}
+
+// ErrorfCtx with context uses fmt.Sprintf to log a templated message.
+func (s *SugaredLogger) ErrorfCtx(ctx context.Context, template string, args ...interface{}) {
+	s.log(ctx, zap.ErrorLevel, template, args, nil)
+}
+
+// DPanicfCtx with context uses fmt.Sprintf to log a templated message.
+func (s *SugaredLogger) DPanicfCtx(ctx context.Context, template string, args ...interface{}) {
+	s.log(ctx, zap.DPanicLevel, template, args, nil)
+}
+
+// PanicfCtx with context uses fmt.Sprintf to log a templated message, then
+// panics.
+func (s *SugaredLogger) PanicfCtx(ctx context.Context, template string, args ...interface{}) {
+	s.log(ctx, zap.PanicLevel, template, args, nil)
+}
+
+// FatalfCtx with context uses fmt.Sprintf to log a templated message, then
+// calls os.Exit.
+func (s *SugaredLogger) FatalfCtx(ctx context.Context, template string, args ...interface{}) {
+	s.log(ctx, zap.FatalLevel, template, args, nil)
+}
+
+// DebugwCtx with context logs a message with some additional context.
+func (s *SugaredLogger) DebugwCtx(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	s.log(ctx, zap.DebugLevel, msg, nil, keysAndValues)
+}
+
+// InfowCtx with context logs a message with some additional context.
+func (s *SugaredLogger) InfowCtx(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	s.log(ctx, zap.InfoLevel, msg, nil, keysAndValues)
+}
+
+// WarnwCtx with context logs a message with some additional context.
+func (s *SugaredLogger) WarnwCtx(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	s.log(ctx, zap.WarnLevel, msg, nil, keysAndValues)
+}
+
+// ErrorwCtx with context logs a message with some additional context.
+func (s *SugaredLogger) ErrorwCtx(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	s.log(ctx, zap.ErrorLevel, msg, nil, keysAndValues)
+}
+
+// DPanicwCtx with context logs a message with some additional context.
+func (s *SugaredLogger) DPanicwCtx(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	s.log(ctx, zap.DPanicLevel, msg, nil, keysAndValues)
+}
+
+// PanicwCtx with context logs a message with some additional context, then
+// panics.
+func (s *SugaredLogger) PanicwCtx(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	s.log(ctx, zap.PanicLevel, msg, nil, keysAndValues)
+}
+
+// FatalwCtx with context logs a message with some additional context, then
+// calls os.Exit.
+func (s *SugaredLogger) FatalwCtx(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	s.log(ctx, zap.FatalLevel, msg, nil, keysAndValues)
+}
+
+// Sync flushes any buffered log entries.
+func (s *SugaredLogger) Sync() error {
+	return s.base.Sync()
+}
+
+// log message with Sprint, Sprintf, or neither.
+func (s *SugaredLogger) log(ctx context.Context, lvl zapcore.Level, template string, fmtArgs []interface{}, keysAndValues []interface{}) {
+	// If logging at this level is completely disabled, skip the overhead of
+	// string formatting.
+	if lvl < zap.DPanicLevel && !s.base.Core().Enabled(lvl) {
+		return
+	}
+
+	base := s.base.generateCtxFields(ctx)
+	msg := getMessage(template, fmtArgs)
+	if ce := base.Check(lvl, msg); ce != nil {
+		ce.Write(s.sweetenFields(keysAndValues)...)
+	}
+}
+
+// getMessage format with Sprint, Sprintf, or neither.
+func getMessage(template string, fmtArgs []interface{}) string {
+	if len(fmtArgs) == 0 {
+		return template
+	}
+
+	if template != "" {
+		return fmt.Sprintf(template, fmtArgs...)
+	}
+
+	if len(fmtArgs) == 1 {
+		if str, ok := fmtArgs[0].(string); ok {
+			return str
+		}
+	}
+	return fmt.Sprint(fmtArgs...)
+}
+
+func (s *SugaredLogger) sweetenFields(args []interface{}) []zap.Field {
+	if len(args) == 0 {
+		return nil
+	}
+
+	var (
+		// Allocate enough space for the worst case; if users pass only structured
+		// fields, we shouldn't penalize them with extra allocations.
+		fields    = make([]zap.Field, 0, len(args))
+		invalid   []interface{}
+		seenError bool
+	)
+
+	for i := 0; i < len(args); {
+		// This is a strongly-typed field. Consume it and move on.
+		if f, ok := args[i].(zap.Field); ok {
+			fields = append(fields, f)
+			i++
+			continue
+		}
+
+		// If it is an error, consume it and move on.
+		if err, ok := args[i].(error); ok {
+			if !seenError {
+				seenError = true
+				fields = append(fields, zap.Error(err))
+			} else {
+				s.reportError("%s %v", _multipleErrMsg, err)
+			}
+			i++
+			continue
+		}
+
+		// Make sure this element isn't a dangling key.
+		if i == len(args)-1 {
+			s.reportError("%s ignored=%v", _oddNumberErrMsg, args[i])
+			break
+		}
+
+		// Consume this value and the next, treating them as a key-value pair. If the
+		// key isn't a string, add this pair to the slice of invalid pairs.
+		key, val := args[i], args[i+1]
+		if keyStr, ok := key.(string); !ok {
+			invalid = append(invalid, key, val)
+		} else {
+			fields = append(fields, zap.Any(keyStr, val))
+		}
+		i += 2
+	}
+
+	// If we encountered any invalid key-value pairs, report them once.
+	if len(invalid) > 0 {
+		s.reportError("%s invalid=%v", _nonStringKeyErrMsg, invalid)
+	}
+	return fields
+}
+
+// reportError writes a sugaring problem to the logger's error output, the same
+// way ZapLogger.check reports a missing caller.
+func (s *SugaredLogger) reportError(format string, args ...interface{}) {
+	fmt.Fprintf(s.base.errorOutput, "%v SugaredLogger error: "+format+"\n",
+		append([]interface{}{s.base.clock.Now().UTC()}, args...)...)
+	s.base.errorOutput.Sync()
+}
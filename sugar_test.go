@@ -0,0 +1,108 @@
+package zap_logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type syncBuffer struct {
+	bytes.Buffer
+	Syncer
+}
+
+func TestSugarTemplatedLogging(t *testing.T) {
+	withLogger(t, zap.DebugLevel, nil, func(logger *Logger, logs *observer.ObservedLogs) {
+		sugar := logger.Sugar()
+		sugar.Infof("hello %s, %d", "world", 42)
+		sugar.Info("hello ", "world")
+		sugar.Errorf("no args")
+
+		output := logs.AllUntimed()
+		require.Equal(t, 3, len(output), "Unexpected number of logs.")
+		assert.Equal(t, "hello world, 42", output[0].Message)
+		assert.Equal(t, "hello world", output[1].Message)
+		assert.Equal(t, "no args", output[2].Message)
+		assert.Equal(t, zap.ErrorLevel, output[2].Level)
+	})
+}
+
+func TestSugarKeyValueLogging(t *testing.T) {
+	withLogger(t, zap.DebugLevel, nil, func(logger *Logger, logs *observer.ObservedLogs) {
+		err := errors.New("egad")
+		logger.Sugar().With("foo", 42).Infow("msg", "bar", "baz", zap.Bool("ok", true), err)
+
+		assert.Equal(t, []observer.LoggedEntry{{
+			Entry: zapcore.Entry{Level: zap.InfoLevel, Message: "msg"},
+			Context: []zap.Field{
+				zap.Any("foo", 42),
+				zap.Any("bar", "baz"),
+				zap.Bool("ok", true),
+				zap.Error(err),
+			},
+		}}, logs.AllUntimed(), "Unexpected sugared fields.")
+	})
+}
+
+func TestSugarInvalidPairsReportToErrorOutput(t *testing.T) {
+	errOut := &syncBuffer{}
+	withLogger(t, zap.DebugLevel, opts(ErrorOutput(errOut)), func(logger *Logger, logs *observer.ObservedLogs) {
+		sugar := logger.Sugar()
+		sugar.Infow("odd", "foo", 1, "dangling")
+		sugar.Infow("non-string", 42, "bar")
+
+		output := logs.AllUntimed()
+		require.Equal(t, 2, len(output), "Invalid pairs must not drop the entry.")
+		assert.Equal(t, []zap.Field{zap.Any("foo", 1)}, output[0].Context)
+		assert.Empty(t, output[1].Context)
+
+		assert.Contains(t, errOut.String(), _oddNumberErrMsg)
+		assert.Contains(t, errOut.String(), _nonStringKeyErrMsg)
+		assert.True(t, errOut.Called(), "Expected error output to be synced.")
+	})
+}
+
+func TestSugarContext(t *testing.T) {
+	type ctxID string
+	const RequestID ctxID = "RequestID"
+
+	ctx := context.WithValue(context.TODO(), RequestID, "c99c2ca0")
+	fieldOpts := opts(AddContext(func(ctx context.Context, log *ZapLogger) {
+		log.Ctx.Set(RequestID, ctx)
+	}))
+
+	withLogger(t, zap.DebugLevel, fieldOpts, func(logger *Logger, logs *observer.ObservedLogs) {
+		logger.Sugar().InfowCtx(ctx, "msg", "foo", "bar")
+
+		assert.Equal(t, []observer.LoggedEntry{{
+			Entry: zapcore.Entry{Level: zap.InfoLevel, Message: "msg"},
+			Context: []zap.Field{
+				zap.Object("context", logger.Ctx.data()),
+				zap.Any("foo", "bar"),
+			},
+		}}, logs.AllUntimed(), "Unexpected context from sugared logger.")
+	})
+}
+
+func TestSugarAddCaller(t *testing.T) {
+	withLogger(t, zap.DebugLevel, opts(AddCaller()), func(logger *Logger, logs *observer.ObservedLogs) {
+		sugar := logger.Sugar()
+		sugar.Infof("")
+		sugar.Infow("")
+		sugar.InfowCtx(context.TODO(), "")
+		sugar.Desugar().Info("")
+
+		for _, entry := range logs.AllUntimed() {
+			assert.Regexp(t, `.+/sugar_test.go:[\d]+$`, entry.Caller.String(),
+				"Expected the sugared logger to report its caller.")
+		}
+	})
+}
@@ -0,0 +1,404 @@
+package zap_logger
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	zapbuffer "go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/hinha/zap-logger/buffer"
+	"github.com/hinha/zap-logger/pkg/netsink"
+	"github.com/hinha/zap-logger/pkg/wal"
+)
+
+// Syslog facilities, see RFC 5424 section 6.2.1.
+const (
+	FacilityKern   = 0
+	FacilityUser   = 1
+	FacilityDaemon = 3
+	FacilityAuth   = 4
+	FacilityLocal0 = 16
+	FacilityLocal1 = 17
+	FacilityLocal2 = 18
+	FacilityLocal3 = 19
+	FacilityLocal4 = 20
+	FacilityLocal5 = 21
+	FacilityLocal6 = 22
+	FacilityLocal7 = 23
+)
+
+// DefaultSyslogSDID is the SD-ID of the structured data element carrying
+// fields. 32473 is the private enterprise number reserved for examples.
+const DefaultSyslogSDID = "fields@32473"
+
+// _syslogPaths are the local syslog sockets tried when no address is given.
+var _syslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+var _syslogPool = zapbuffer.NewPool()
+
+// SyslogConfig configures the syslog output.
+type SyslogConfig struct {
+	// Network is "unix", "unixgram", "udp", "tcp" or "tcp+tls". If it's empty
+	// the local syslog socket is used. Records are octet-counted on TCP
+	// (RFC 6587), newline-terminated on unix stream sockets, as local
+	// daemons expect, and sent one per datagram otherwise.
+	Network string
+	// Address is the collector's host:port or socket path.
+	Address string
+	// TLS configures "tcp+tls" connections.
+	TLS *tls.Config
+	// Facility defaults to FacilityUser; FacilityKern is reserved for the
+	// kernel and can't be used.
+	Facility int
+	// Hostname and AppName default to the host name and the executable name.
+	Hostname string
+	AppName  string
+	// RFC3164 writes the legacy BSD format instead of RFC 5424. Fields are
+	// then appended to the message as a structured data element.
+	RFC3164 bool
+	// SDID is the SD-ID fields are logged under. It defaults to
+	// DefaultSyslogSDID.
+	SDID string
+	// WAL, if set, keeps records in a write-ahead log on disk between the
+	// diode and the connection until they are sent, so they survive an
+	// unreachable collector or a restart. See package wal.
+	WAL *wal.Options
+	// DiodeSize is the number of records held while the collector is slow or
+	// unreachable, DefaultDiodeSize if zero.
+	DiodeSize int
+}
+
+// syslogSeverity maps zap levels to syslog severities.
+func syslogSeverity(l zapcore.Level) int {
+	switch l {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	case zapcore.DPanicLevel:
+		return 2
+	case zapcore.PanicLevel:
+		return 1
+	case zapcore.FatalLevel:
+		return 0
+	}
+	return 5
+}
+
+// NewSyslogEncoder creates an encoder writing syslog messages without
+// framing. The logger name is the MSGID, fields are the parameters of a
+// structured data element, with nested objects and arrays flattened into
+// dotted keys, and the caller and stack trace are added as parameters too.
+func NewSyslogEncoder(cfg SyslogConfig) zapcore.Encoder {
+	h := &syslogHeader{
+		facility: cfg.Facility,
+		hostname: cfg.Hostname,
+		appName:  cfg.AppName,
+		procID:   strconv.Itoa(os.Getpid()),
+		sdID:     cfg.SDID,
+		rfc3164:  cfg.RFC3164,
+	}
+	if h.facility == FacilityKern {
+		h.facility = FacilityUser
+	}
+	if h.hostname == "" {
+		h.hostname, _ = os.Hostname()
+	}
+	if h.appName == "" {
+		h.appName = filepath.Base(os.Args[0])
+	}
+	if h.sdID == "" {
+		h.sdID = DefaultSyslogSDID
+	}
+	h.hostname = syslogToken(h.hostname, 255)
+	h.appName = syslogToken(h.appName, 48)
+	return &syslogEncoder{syslogHeader: h, sdEncoder: &sdEncoder{buf: buffer.Get()}}
+}
+
+type syslogHeader struct {
+	facility int
+	hostname string
+	appName  string
+	procID   string
+	sdID     string
+	rfc3164  bool
+}
+
+type syslogEncoder struct {
+	*syslogHeader
+	*sdEncoder
+}
+
+// Clone implements zapcore.Encoder.
+func (enc *syslogEncoder) Clone() zapcore.Encoder {
+	return &syslogEncoder{syslogHeader: enc.syslogHeader, sdEncoder: enc.sdEncoder.clone()}
+}
+
+// EncodeEntry implements zapcore.Encoder.
+func (enc *syslogEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*zapbuffer.Buffer, error) {
+	sd := enc.sdEncoder.clone()
+	defer sd.buf.Free()
+	for i := range fields {
+		fields[i].AddTo(sd)
+	}
+	sd.prefix = ""
+	if ent.Caller.Defined {
+		sd.AddString("caller", ent.Caller.TrimmedPath())
+	}
+	if ent.Stack != "" {
+		sd.AddString("stacktrace", ent.Stack)
+	}
+
+	out := _syslogPool.Get()
+	out.AppendByte('<')
+	out.AppendInt(int64(enc.facility*8 + syslogSeverity(ent.Level)))
+	out.AppendByte('>')
+
+	if enc.rfc3164 {
+		out.AppendTime(ent.Time, time.Stamp)
+		out.AppendByte(' ')
+		out.AppendString(enc.hostname)
+		out.AppendByte(' ')
+		out.AppendString(enc.appName)
+		out.AppendByte('[')
+		out.AppendString(enc.procID)
+		out.AppendString("]: ")
+		out.AppendString(ent.Message)
+		if sd.buf.Len() > 0 {
+			out.AppendByte(' ')
+			enc.appendSD(out, sd)
+		}
+		return out, nil
+	}
+
+	out.AppendString("1 ")
+	out.AppendTime(ent.Time, "2006-01-02T15:04:05.000000Z07:00")
+	out.AppendByte(' ')
+	out.AppendString(syslogNil(enc.hostname))
+	out.AppendByte(' ')
+	out.AppendString(syslogNil(enc.appName))
+	out.AppendByte(' ')
+	out.AppendString(enc.procID)
+	out.AppendByte(' ')
+	out.AppendString(syslogNil(syslogToken(ent.LoggerName, 32)))
+	out.AppendByte(' ')
+	if sd.buf.Len() > 0 {
+		enc.appendSD(out, sd)
+	} else {
+		out.AppendByte('-')
+	}
+	if ent.Message != "" {
+		out.AppendByte(' ')
+		out.AppendString(ent.Message)
+	}
+	return out, nil
+}
+
+func (enc *syslogEncoder) appendSD(out *zapbuffer.Buffer, sd *sdEncoder) {
+	out.AppendByte('[')
+	out.AppendString(enc.sdID)
+	out.Write(sd.buf.Bytes())
+	out.AppendByte(']')
+}
+
+func syslogNil(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// syslogToken keeps the printable US-ASCII characters allowed in header
+// fields and truncates s to max bytes.
+func syslogToken(s string, max int) string {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s) && len(b) < max; i++ {
+		if c := s[i]; c > ' ' && c < 0x7f {
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+// sdEncoder writes fields as the PARAM-NAME="PARAM-VALUE" pairs of a
+// structured data element, flattening nested objects and arrays like the
+// logfmt encoder.
+type sdEncoder struct {
+	buf    *buffer.Buffer
+	prefix string
+}
+
+func (e *sdEncoder) clone() *sdEncoder {
+	clone := &sdEncoder{buf: buffer.Get(), prefix: e.prefix}
+	clone.buf.Write(e.buf.Bytes())
+	return clone
+}
+
+// param writes a pair. Names are limited to 32 printable characters other
+// than '=', ']' and '"'; values escape '"', '\' and ']'.
+func (e *sdEncoder) param(key, val string) {
+	e.buf.AppendByte(' ')
+	name := e.prefix + key
+	for i := 0; i < len(name) && i < 32; i++ {
+		c := name[i]
+		if c <= ' ' || c >= 0x7f || c == '=' || c == ']' || c == '"' {
+			c = '_'
+		}
+		e.buf.AppendByte(c)
+	}
+	e.buf.AppendString(`="`)
+	for i := 0; i < len(val); i++ {
+		switch c := val[i]; c {
+		case '"', '\\', ']':
+			e.buf.AppendByte('\\')
+			e.buf.AppendByte(c)
+		default:
+			e.buf.AppendByte(c)
+		}
+	}
+	e.buf.AppendByte('"')
+}
+
+func (e *sdEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	prefix := e.prefix
+	defer func() { e.prefix = prefix }()
+	return arr.MarshalLogArray(&sdArrayEncoder{e: e, prefix: prefix + key + "."})
+}
+
+func (e *sdEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	prefix := e.prefix
+	defer func() { e.prefix = prefix }()
+	e.prefix = prefix + key + "."
+	return obj.MarshalLogObject(e)
+}
+
+func (e *sdEncoder) AddReflected(key string, obj interface{}) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	e.param(key, string(b))
+	return nil
+}
+
+func (e *sdEncoder) OpenNamespace(key string) { e.prefix += key + "." }
+
+func (e *sdEncoder) AddBinary(k string, v []byte)          { e.param(k, base64.StdEncoding.EncodeToString(v)) }
+func (e *sdEncoder) AddByteString(k string, v []byte)      { e.param(k, string(v)) }
+func (e *sdEncoder) AddBool(k string, v bool)              { e.param(k, strconv.FormatBool(v)) }
+func (e *sdEncoder) AddComplex128(k string, v complex128)  { e.param(k, fmt.Sprint(v)) }
+func (e *sdEncoder) AddComplex64(k string, v complex64)    { e.param(k, fmt.Sprint(v)) }
+func (e *sdEncoder) AddDuration(k string, v time.Duration) { e.param(k, v.String()) }
+func (e *sdEncoder) AddFloat64(k string, v float64)        { e.param(k, strconv.FormatFloat(v, 'f', -1, 64)) }
+func (e *sdEncoder) AddFloat32(k string, v float32) {
+	e.param(k, strconv.FormatFloat(float64(v), 'f', -1, 32))
+}
+func (e *sdEncoder) AddInt(k string, v int)         { e.AddInt64(k, int64(v)) }
+func (e *sdEncoder) AddInt64(k string, v int64)     { e.param(k, strconv.FormatInt(v, 10)) }
+func (e *sdEncoder) AddInt32(k string, v int32)     { e.AddInt64(k, int64(v)) }
+func (e *sdEncoder) AddInt16(k string, v int16)     { e.AddInt64(k, int64(v)) }
+func (e *sdEncoder) AddInt8(k string, v int8)       { e.AddInt64(k, int64(v)) }
+func (e *sdEncoder) AddString(k, v string)          { e.param(k, v) }
+func (e *sdEncoder) AddTime(k string, v time.Time)  { e.param(k, v.Format(time.RFC3339Nano)) }
+func (e *sdEncoder) AddUint(k string, v uint)       { e.AddUint64(k, uint64(v)) }
+func (e *sdEncoder) AddUint64(k string, v uint64)   { e.param(k, strconv.FormatUint(v, 10)) }
+func (e *sdEncoder) AddUint32(k string, v uint32)   { e.AddUint64(k, uint64(v)) }
+func (e *sdEncoder) AddUint16(k string, v uint16)   { e.AddUint64(k, uint64(v)) }
+func (e *sdEncoder) AddUint8(k string, v uint8)     { e.AddUint64(k, uint64(v)) }
+func (e *sdEncoder) AddUintptr(k string, v uintptr) { e.AddUint64(k, uint64(v)) }
+
+// sdArrayEncoder flattens array elements into indexed keys.
+type sdArrayEncoder struct {
+	e      *sdEncoder
+	prefix string
+	i      int
+}
+
+func (a *sdArrayEncoder) key() string {
+	a.e.prefix = a.prefix
+	k := strconv.Itoa(a.i)
+	a.i++
+	return k
+}
+
+func (a *sdArrayEncoder) AppendArray(v zapcore.ArrayMarshaler) error { return a.e.AddArray(a.key(), v) }
+func (a *sdArrayEncoder) AppendObject(v zapcore.ObjectMarshaler) error {
+	return a.e.AddObject(a.key(), v)
+}
+func (a *sdArrayEncoder) AppendReflected(v interface{}) error { return a.e.AddReflected(a.key(), v) }
+func (a *sdArrayEncoder) AppendBool(v bool)                   { a.e.AddBool(a.key(), v) }
+func (a *sdArrayEncoder) AppendByteString(v []byte)           { a.e.AddByteString(a.key(), v) }
+func (a *sdArrayEncoder) AppendComplex128(v complex128)       { a.e.AddComplex128(a.key(), v) }
+func (a *sdArrayEncoder) AppendComplex64(v complex64)         { a.e.AddComplex64(a.key(), v) }
+func (a *sdArrayEncoder) AppendFloat64(v float64)             { a.e.AddFloat64(a.key(), v) }
+func (a *sdArrayEncoder) AppendFloat32(v float32)             { a.e.AddFloat32(a.key(), v) }
+func (a *sdArrayEncoder) AppendInt(v int)                     { a.e.AddInt(a.key(), v) }
+func (a *sdArrayEncoder) AppendInt64(v int64)                 { a.e.AddInt64(a.key(), v) }
+func (a *sdArrayEncoder) AppendInt32(v int32)                 { a.e.AddInt32(a.key(), v) }
+func (a *sdArrayEncoder) AppendInt16(v int16)                 { a.e.AddInt16(a.key(), v) }
+func (a *sdArrayEncoder) AppendInt8(v int8)                   { a.e.AddInt8(a.key(), v) }
+func (a *sdArrayEncoder) AppendString(v string)               { a.e.AddString(a.key(), v) }
+func (a *sdArrayEncoder) AppendUint(v uint)                   { a.e.AddUint(a.key(), v) }
+func (a *sdArrayEncoder) AppendUint64(v uint64)               { a.e.AddUint64(a.key(), v) }
+func (a *sdArrayEncoder) AppendUint32(v uint32)               { a.e.AddUint32(a.key(), v) }
+func (a *sdArrayEncoder) AppendUint16(v uint16)               { a.e.AddUint16(a.key(), v) }
+func (a *sdArrayEncoder) AppendUint8(v uint8)                 { a.e.AddUint8(a.key(), v) }
+func (a *sdArrayEncoder) AppendUintptr(v uintptr)             { a.e.AddUintptr(a.key(), v) }
+func (a *sdArrayEncoder) AppendDuration(v time.Duration)      { a.e.AddDuration(a.key(), v) }
+func (a *sdArrayEncoder) AppendTime(v time.Time)              { a.e.AddTime(a.key(), v) }
+
+// writer returns the connection to the configured collector, or to the
+// local syslog socket.
+func (c *SyslogConfig) writer() (*netsink.Writer, error) {
+	network, address, framing := c.Network, c.Address, netsink.NoFraming
+	if network == "" {
+		for _, path := range _syslogPaths {
+			if _, err := os.Stat(path); err == nil {
+				network, address = "unixgram", path
+				break
+			}
+		}
+		if network == "" {
+			return nil, fmt.Errorf("no local syslog socket found in %v", _syslogPaths)
+		}
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "tcp+tls":
+		framing = netsink.OctetCounting
+	case "unix":
+		framing = netsink.NewlineTerminated
+	}
+	return netsink.New(netsink.Config{
+		Network: network,
+		Address: address,
+		TLS:     c.TLS,
+		Framing: framing,
+	})
+}
+
+// core returns a core writing to syslog through a diode, so a slow collector
+// doesn't block logging, and the diode to close when the logger is closed.
+func (c *SyslogConfig) core(lvl zap.AtomicLevel, interval time.Duration, o *sinkObserver) (zapcore.Core, io.Closer, error) {
+	w, err := c.writer()
+	if err != nil {
+		return nil, nil, err
+	}
+	d, err := newNetworkDiode(w, c.WAL, c.DiodeSize, interval, "syslog", o)
+	if err != nil {
+		return nil, nil, err
+	}
+	return zapcore.NewCore(NewSyslogEncoder(*c), zapcore.AddSync(d), lvl), d, nil
+}
@@ -0,0 +1,254 @@
+package zap_logger
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _syslogTime = time.Date(2023, 3, 4, 5, 6, 7, 890000000, time.UTC)
+
+func encodeSyslog(t *testing.T, cfg SyslogConfig, ent zapcore.Entry, fields ...zap.Field) string {
+	cfg.Hostname, cfg.AppName = "host", "app"
+	buf, err := NewSyslogEncoder(cfg).EncodeEntry(ent, fields)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buf.Free()
+	return buf.String()
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	tests := []struct {
+		level zapcore.Level
+		want  int
+	}{
+		{zapcore.DebugLevel, 7},
+		{zapcore.InfoLevel, 6},
+		{zapcore.WarnLevel, 4},
+		{zapcore.ErrorLevel, 3},
+		{zapcore.DPanicLevel, 2},
+		{zapcore.PanicLevel, 1},
+		{zapcore.FatalLevel, 0},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, syslogSeverity(tt.level), "Unexpected severity for %v.", tt.level)
+	}
+}
+
+func TestSyslogEncoderRFC5424(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	ent := zapcore.Entry{Level: zapcore.WarnLevel, Time: _syslogTime, LoggerName: "db", Message: "slow query"}
+
+	tests := []struct {
+		desc   string
+		cfg    SyslogConfig
+		ent    zapcore.Entry
+		fields []zap.Field
+		want   string
+	}{
+		{
+			desc: "no fields",
+			ent:  ent,
+			want: "<12>1 2023-03-04T05:06:07.890000Z host app " + pid + " db - slow query",
+		},
+		{
+			desc:   "fields",
+			cfg:    SyslogConfig{Facility: FacilityLocal0},
+			ent:    ent,
+			fields: []zap.Field{zap.Int("ms", 1200), zap.Bool("cached", false)},
+			want:   "<132>1 2023-03-04T05:06:07.890000Z host app " + pid + ` db [fields@32473 ms="1200" cached="false"] slow query`,
+		},
+		{
+			desc:   "custom SD-ID",
+			cfg:    SyslogConfig{SDID: "app@1234"},
+			ent:    zapcore.Entry{Level: zapcore.InfoLevel, Time: _syslogTime},
+			fields: []zap.Field{zap.String("k", "v")},
+			want:   "<14>1 2023-03-04T05:06:07.890000Z host app " + pid + ` - [app@1234 k="v"]`,
+		},
+		{
+			desc: "caller and stack",
+			ent: zapcore.Entry{
+				Level:   zapcore.ErrorLevel,
+				Time:    _syslogTime,
+				Message: "failed",
+				Caller:  zapcore.NewEntryCaller(0, "/src/pkg/file.go", 42, true),
+				Stack:   "main.main\n\t/src/main.go:7",
+			},
+			want: "<11>1 2023-03-04T05:06:07.890000Z host app " + pid + ` - [fields@32473 caller="pkg/file.go:42" stacktrace="main.main` + "\n\t" + `/src/main.go:7"] failed`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			assert.Equal(t, tt.want, encodeSyslog(t, tt.cfg, tt.ent, tt.fields...))
+		})
+	}
+}
+
+func TestSyslogEncoderRFC3164(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: _syslogTime, Message: "started"}
+
+	assert.Equal(t, "<30>Mar  4 05:06:07 host app["+pid+"]: started",
+		encodeSyslog(t, SyslogConfig{RFC3164: true, Facility: FacilityDaemon}, ent))
+	assert.Equal(t, "<14>Mar  4 05:06:07 host app["+pid+`]: started [fields@32473 port="80"]`,
+		encodeSyslog(t, SyslogConfig{RFC3164: true}, ent, zap.Int("port", 80)))
+}
+
+func TestSyslogStructuredData(t *testing.T) {
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: _syslogTime}
+
+	tests := []struct {
+		desc  string
+		field zap.Field
+		want  string
+	}{
+		{"escaped value", zap.String("q", `say "hi" \ [x]`), `q="say \"hi\" \\ [x\]"`},
+		{"sanitized name", zap.String(`a b="c]`, "v"), `a_b__c_="v"`},
+		{"truncated name", zap.String(strings.Repeat("k", 40), "v"), strings.Repeat("k", 32) + `="v"`},
+		{"object", zap.Object("user", zapcore.ObjectMarshalerFunc(func(enc zapcore.ObjectEncoder) error {
+			enc.AddString("name", "jane")
+			enc.AddInt("age", 30)
+			return nil
+		})), `user.name="jane" user.age="30"`},
+		{"array", zap.Strings("tags", []string{"a", "b"}), `tags.0="a" tags.1="b"`},
+		{"namespace", zap.Namespace("http"), ``},
+		{"binary", zap.Binary("raw", []byte{0xff, 0x00}), `raw="/wA="`},
+		{"reflected", zap.Reflect("m", map[string]int{"a": 1}), `m="{\"a\":1}"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			out := encodeSyslog(t, SyslogConfig{}, ent, tt.field)
+			if tt.want == "" {
+				assert.True(t, strings.HasSuffix(out, " - -"), "Expected no structured data, got %q.", out)
+				return
+			}
+			assert.True(t, strings.HasSuffix(out, "[fields@32473 "+tt.want+"]"), "Unexpected structured data in %q.", out)
+		})
+	}
+}
+
+func TestSyslogEncoderWith(t *testing.T) {
+	enc := NewSyslogEncoder(SyslogConfig{Hostname: "host", AppName: "app"})
+	enc.OpenNamespace("req")
+	enc.AddString("id", "42")
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{Time: _syslogTime}, []zap.Field{zap.Int("status", 200)})
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buf.Free()
+	assert.True(t, strings.HasSuffix(buf.String(), `[fields@32473 req.id="42" req.status="200"]`),
+		"Expected namespaced fields, got %q.", buf.String())
+
+	clone := enc.Clone()
+	clone.AddString("extra", "x")
+	buf2, err := enc.EncodeEntry(zapcore.Entry{Time: _syslogTime}, nil)
+	require.NoError(t, err, "Unexpected error encoding entry.")
+	defer buf2.Free()
+	assert.NotContains(t, buf2.String(), "extra", "Clone shouldn't change the original encoder.")
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err, "Failed to listen.")
+	defer ln.Close()
+
+	records := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			n, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			size, _ := strconv.Atoi(strings.TrimSpace(n))
+			msg := make([]byte, size)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				return
+			}
+			records <- string(msg)
+		}
+	}()
+
+	cfg := &SyslogConfig{Network: "tcp", Address: ln.Addr().String(), Hostname: "host", AppName: "app"}
+	core, closer, err := cfg.core(zap.NewAtomicLevelAt(zap.InfoLevel), time.Millisecond, nil)
+	require.NoError(t, err, "Unexpected error creating the syslog core.")
+	logger := New(core, Config{}, addCloser(closer))
+
+	logger.Info("first", zap.String("k", "v"))
+	logger.Debug("dropped")
+	logger.Warn("second")
+	require.NoError(t, logger.Close(), "Unexpected error closing the logger.")
+
+	for _, want := range []string{`app [0-9]+ - \[fields@32473 k="v"\] first$`, `app [0-9]+ - - second$`} {
+		select {
+		case got := <-records:
+			assert.Regexp(t, want, got)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for a record matching %q.", want)
+		}
+	}
+}
+
+func TestSyslogUnixStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err, "Failed to listen.")
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	cfg := &SyslogConfig{Network: "unix", Address: path, Hostname: "host", AppName: "app"}
+	core, closer, err := cfg.core(zap.NewAtomicLevelAt(zap.InfoLevel), time.Millisecond, nil)
+	require.NoError(t, err, "Unexpected error creating the syslog core.")
+	logger := New(core, Config{}, addCloser(closer))
+	logger.Info("first")
+	logger.Info("second")
+	require.NoError(t, logger.Close(), "Unexpected error closing the logger.")
+
+	select {
+	case got := <-received:
+		assert.Regexp(t, `^<14>1 \S+ host app [0-9]+ - - first\n<14>1 \S+ host app [0-9]+ - - second\n$`, got,
+			"Expected newline-terminated records, as local daemons read them.")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the records.")
+	}
+}
+
+func TestSyslogUnavailable(t *testing.T) {
+	cfg := &SyslogConfig{Network: "bogus", Address: "nowhere"}
+	_, _, err := cfg.core(zap.NewAtomicLevel(), time.Millisecond, nil)
+	assert.Error(t, err, "Expected an error for an unknown network.")
+
+	saved := _syslogPaths
+	defer func() { _syslogPaths = saved }()
+	_syslogPaths = []string{"/nonexistent/log"}
+	_, err = (&SyslogConfig{}).writer()
+	assert.Error(t, err, "Expected an error without a local syslog socket.")
+}